@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+// appInfo mirrors the JSON of core.GoApp as served by go-runner.
+type appInfo struct {
+	Name        string     `json:"name" yaml:"name"`
+	GitURL      string     `json:"gitUrl" yaml:"gitUrl"`
+	Branch      string     `json:"branch" yaml:"branch"`
+	Config      appConfig  `json:"config" yaml:"config"`
+	GitHash     string     `json:"gitHash" yaml:"gitHash"`
+	GitCommit   string     `json:"gitCommit" yaml:"gitCommit"`
+	State       string     `json:"state" yaml:"state"`
+	StateSince  *time.Time `json:"stateSince,omitempty" yaml:"stateSince,omitempty"`
+	TimeInState int64      `json:"timeInState" yaml:"timeInState"`
+	Events      []event    `json:"events" yaml:"events"`
+	AppDir      string     `json:"appDir" yaml:"appDir"`
+	LastErr     string     `json:"lastError" yaml:"lastError"`
+	PID         int        `json:"pid" yaml:"pid"`
+	Exit        int        `json:"exit" yaml:"exit"`
+	Release     *release   `json:"release,omitempty" yaml:"release,omitempty"`
+	StartedAt   *time.Time `json:"startedAt,omitempty" yaml:"startedAt,omitempty"`
+	Uptime      int64      `json:"uptime" yaml:"uptime"`
+	Restarts    int        `json:"restarts" yaml:"restarts"`
+	Poll        *pollInfo  `json:"poll,omitempty" yaml:"poll,omitempty"`
+	Usage       *usage     `json:"usage,omitempty" yaml:"usage,omitempty"`
+	Cgroup      *cgroup    `json:"cgroup,omitempty" yaml:"cgroup,omitempty"`
+	Process     *process   `json:"process,omitempty" yaml:"process,omitempty"`
+}
+
+// event mirrors the JSON of core.Event
+type event struct {
+	From   string    `json:"from,omitempty" yaml:"from,omitempty"`
+	To     string    `json:"to" yaml:"to"`
+	At     time.Time `json:"at" yaml:"at"`
+	Reason string    `json:"reason" yaml:"reason"`
+}
+
+// release mirrors the JSON of core.Release
+type release struct {
+	ID          int       `json:"id" yaml:"id"`
+	Source      string    `json:"source" yaml:"source"`
+	Branch      string    `json:"branch,omitempty" yaml:"branch,omitempty"`
+	GitHash     string    `json:"gitHash,omitempty" yaml:"gitHash,omitempty"`
+	GitCommit   string    `json:"gitCommit,omitempty" yaml:"gitCommit,omitempty"`
+	Uncommitted bool      `json:"uncommitted,omitempty" yaml:"uncommitted,omitempty"`
+	Subdir      string    `json:"subdir,omitempty" yaml:"subdir,omitempty"`
+	CreatedAt   time.Time `json:"createdAt" yaml:"createdAt"`
+}
+
+// appConfig mirrors the JSON of core.AppConfig
+type appConfig struct {
+	Subdir       string      `json:"subdir,omitempty" yaml:"subdir,omitempty"`
+	Submodules   bool        `json:"submodules,omitempty" yaml:"submodules,omitempty"`
+	PollInterval string      `json:"pollInterval,omitempty" yaml:"pollInterval,omitempty"`
+	Gates        *gateConfig `json:"gates,omitempty" yaml:"gates,omitempty"`
+	Limits       *limits     `json:"limits,omitempty" yaml:"limits,omitempty"`
+	Isolate      bool        `json:"isolate,omitempty" yaml:"isolate,omitempty"`
+	User         *appUser    `json:"user,omitempty" yaml:"user,omitempty"`
+	Rlimits      *rlimits    `json:"rlimits,omitempty" yaml:"rlimits,omitempty"`
+	Sandbox      *sandbox    `json:"sandbox,omitempty" yaml:"sandbox,omitempty"`
+	Network      *network    `json:"network,omitempty" yaml:"network,omitempty"`
+}
+
+// appUser mirrors the JSON of core.AppUser
+type appUser struct {
+	UID int `json:"uid" yaml:"uid"`
+	GID int `json:"gid,omitempty" yaml:"gid,omitempty"`
+}
+
+// rlimits mirrors the JSON of core.Rlimits
+type rlimits struct {
+	NoFile *uint64 `json:"nofile,omitempty" yaml:"nofile,omitempty"`
+	NProc  *uint64 `json:"nproc,omitempty" yaml:"nproc,omitempty"`
+	Core   string  `json:"core,omitempty" yaml:"core,omitempty"`
+	AS     string  `json:"as,omitempty" yaml:"as,omitempty"`
+	CPU    *uint64 `json:"cpu,omitempty" yaml:"cpu,omitempty"`
+}
+
+// sandbox mirrors the JSON of core.Sandbox
+type sandbox struct {
+	Seccomp    string   `json:"seccomp,omitempty" yaml:"seccomp,omitempty"`
+	Syscalls   []string `json:"syscalls,omitempty" yaml:"syscalls,omitempty"`
+	Landlock   bool     `json:"landlock,omitempty" yaml:"landlock,omitempty"`
+	ReadPaths  []string `json:"readPaths,omitempty" yaml:"readPaths,omitempty"`
+	WritePaths []string `json:"writePaths,omitempty" yaml:"writePaths,omitempty"`
+}
+
+// network mirrors the JSON of core.Network
+type network struct {
+	Policy string   `json:"policy" yaml:"policy"`
+	Allow  []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+}
+
+// process mirrors the JSON of core.ProcIdentity
+type process struct {
+	PID        int               `json:"pid" yaml:"pid"`
+	UID        int               `json:"uid" yaml:"uid"`
+	GID        int               `json:"gid" yaml:"gid"`
+	NoNewPrivs bool              `json:"noNewPrivs" yaml:"noNewPrivs"`
+	Seccomp    bool              `json:"seccomp" yaml:"seccomp"`
+	Network    string            `json:"network" yaml:"network"`
+	Rlimits    map[string]string `json:"rlimits" yaml:"rlimits"`
+}
+
+// limits mirrors the JSON of core.Limits
+type limits struct {
+	CPU        float64 `json:"cpu,omitempty" yaml:"cpu,omitempty"`
+	Memory     string  `json:"memory,omitempty" yaml:"memory,omitempty"`
+	MemoryHigh string  `json:"memoryHigh,omitempty" yaml:"memoryHigh,omitempty"`
+	Pids       int     `json:"pids,omitempty" yaml:"pids,omitempty"`
+	IOWeight   int     `json:"ioWeight,omitempty" yaml:"ioWeight,omitempty"`
+}
+
+// cgroup mirrors the JSON of core.CgroupUsage
+type cgroup struct {
+	Path             string  `json:"path" yaml:"path"`
+	CPUSeconds       float64 `json:"cpuSeconds" yaml:"cpuSeconds"`
+	ThrottledSeconds float64 `json:"throttledSeconds" yaml:"throttledSeconds"`
+	Memory           int64   `json:"memory" yaml:"memory"`
+	MemoryMax        string  `json:"memoryMax,omitempty" yaml:"memoryMax,omitempty"`
+	Pids             int     `json:"pids" yaml:"pids"`
+	MemoryHighEvents int64   `json:"memoryHighEvents" yaml:"memoryHighEvents"`
+	MemoryMaxEvents  int64   `json:"memoryMaxEvents" yaml:"memoryMaxEvents"`
+	OOMKills         int64   `json:"oomKills" yaml:"oomKills"`
+}
+
+// gateConfig mirrors the JSON of core.GateConfig
+type gateConfig struct {
+	Vet      bool     `json:"vet,omitempty" yaml:"vet,omitempty"`
+	Test     bool     `json:"test,omitempty" yaml:"test,omitempty"`
+	Commands []string `json:"commands,omitempty" yaml:"commands,omitempty"`
+	Timeout  string   `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// pollInfo mirrors the JSON of core.PollStatus
+type pollInfo struct {
+	Interval   string     `json:"interval" yaml:"interval"`
+	LastCheck  *time.Time `json:"lastCheck,omitempty" yaml:"lastCheck,omitempty"`
+	LastResult string     `json:"lastResult,omitempty" yaml:"lastResult,omitempty"`
+	LastError  string     `json:"lastError,omitempty" yaml:"lastError,omitempty"`
+	RemoteHead string     `json:"remoteHead,omitempty" yaml:"remoteHead,omitempty"`
+	Failures   int        `json:"failures" yaml:"failures"`
+	NextCheck  *time.Time `json:"nextCheck,omitempty" yaml:"nextCheck,omitempty"`
+}
+
+// usage mirrors the JSON of core.Usage
+type usage struct {
+	At         time.Time `json:"at" yaml:"at"`
+	PID        int       `json:"pid" yaml:"pid"`
+	CPU        float64   `json:"cpu" yaml:"cpu"`
+	CPUSeconds float64   `json:"cpuSeconds" yaml:"cpuSeconds"`
+	RSS        int64     `json:"rss" yaml:"rss"`
+	FDs        int       `json:"fds" yaml:"fds"`
+	Threads    int       `json:"threads" yaml:"threads"`
+	ReadBytes  uint64    `json:"readBytes" yaml:"readBytes"`
+	WriteBytes uint64    `json:"writeBytes" yaml:"writeBytes"`
+	ReadRate   float64   `json:"readRate" yaml:"readRate"`
+	WriteRate  float64   `json:"writeRate" yaml:"writeRate"`
+}
+
+func (a appInfo) IsRunning() bool {
+	return a.State == "running"
+}
+
+// errInfo mirrors the JSON of web.errStatus
+type errInfo struct {
+	App *appInfo `json:"app"`
+	Err string   `json:"err"`
+}
+
+type healthInfo struct {
+	Status string    `json:"status"`
+	Apps   []appInfo `json:"apps"`
+}
+
+// errNotRunning is returned by commands that report on apps when any of them is not running,
+// so that scripts can rely on the exit code.
+var errNotRunning = errors.New("app not running")
+
+// appNameFromArgs returns the app name given on the command line, or the app of the context
+// if there is none, or else the basename of the current working dir.
+func appNameFromArgs(args []string, verbose bool) (string, error) {
+	if len(args) == 1 {
+		return args[0], nil
+	}
+
+	if target.App != "" {
+		if verbose {
+			fmt.Printf("verbose: use the app of the context as appName\n")
+		}
+
+		return target.App, nil
+	}
+
+	if verbose {
+		fmt.Printf("verbose: use basename as appName\n")
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		fmt.Printf("failed to get current working dir: %q\n", err)
+		return "", err
+	}
+
+	return path.Base(wd), nil
+}
+
+func fetchApp(appName string) (*appInfo, error) {
+	req, err := newRequest("GET", "/api/"+appName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	app := new(appInfo)
+	err = doJSON(req, app)
+	if err != nil {
+		var herr *httpError
+		if errors.As(err, &herr) && herr.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("app %s is not registered to %s", appName, target.Server)
+		}
+
+		return nil, err
+	}
+
+	return app, nil
+}
+
+func fetchApps() ([]appInfo, error) {
+	req, err := newRequest("GET", "/api/health", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	health := new(healthInfo)
+	err = doJSON(req, health)
+	if err != nil {
+		return nil, err
+	}
+
+	return health.Apps, nil
+}
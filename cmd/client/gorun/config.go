@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultServer  = "http://localhost:8080"
+	repoConfigName = ".gorun.yaml"
+)
+
+// cliConfig is the content of ~/.config/gorun/config.yaml
+type cliConfig struct {
+	CurrentContext string                 `yaml:"current-context,omitempty"`
+	Contexts       map[string]*cliContext `yaml:"contexts,omitempty"`
+}
+
+// cliContext is a named go-runner instance and how to talk to it.
+type cliContext struct {
+	Server string `yaml:"server,omitempty"`
+	Token  string `yaml:"token,omitempty"`
+	CA     string `yaml:"ca,omitempty"`
+	App    string `yaml:"app,omitempty"`
+}
+
+// repoConfig is the content of .gorun.yaml in a repo. It picks a context and overrides parts of it.
+type repoConfig struct {
+	Context string `yaml:"context,omitempty"`
+	Server  string `yaml:"server,omitempty"`
+	App     string `yaml:"app,omitempty"`
+}
+
+// target is the go-runner instance the current command talks to.
+// It is resolved by resolveTarget before any command runs.
+var target = &cliContext{Server: defaultServer}
+
+// client is the http client for target
+var client = http.DefaultClient
+
+func configPath() (string, error) {
+	if p := viper.GetString("config"); p != "" {
+		return p, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "gorun", "config.yaml"), nil
+}
+
+func loadConfig() (*cliConfig, error) {
+	conf := &cliConfig{Contexts: map[string]*cliContext{}}
+
+	p, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return conf, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(data, conf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", p, err)
+	}
+
+	if conf.Contexts == nil {
+		conf.Contexts = map[string]*cliContext{}
+	}
+
+	return conf, nil
+}
+
+func saveConfig(conf *cliConfig) error {
+	p, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(conf)
+	if err != nil {
+		return err
+	}
+
+	// it holds tokens
+	return ioutil.WriteFile(p, data, 0600)
+}
+
+// findRepoConfig looks for .gorun.yaml from the current dir up to the root of the git repo.
+func findRepoConfig() (*repoConfig, string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, "", err
+	}
+
+	for {
+		p := filepath.Join(dir, repoConfigName)
+		data, err := ioutil.ReadFile(p)
+		if err == nil {
+			conf := new(repoConfig)
+			err = yaml.Unmarshal(data, conf)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid repo config file %s: %w", p, err)
+			}
+
+			return conf, p, nil
+		}
+
+		if !os.IsNotExist(err) {
+			return nil, "", err
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return nil, "", nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, "", nil
+		}
+
+		dir = parent
+	}
+}
+
+// resolveTarget works out which go-runner to talk to, in order of precedence:
+// --server flag or GO_RUNNER_SERVER, .gorun.yaml in the repo, the context picked by
+// --context, GO_RUNNER_CONTEXT, .gorun.yaml or current-context of the config file.
+func resolveTarget(cmd *cobra.Command, args []string) error {
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	repoConf, repoConfPath, err := findRepoConfig()
+	if err != nil {
+		return err
+	}
+
+	verbose := viper.GetBool("verbose")
+	contextName := conf.CurrentContext
+	if repoConf != nil && repoConf.Context != "" {
+		contextName = repoConf.Context
+	}
+
+	if viper.IsSet("context") {
+		contextName = viper.GetString("context")
+	}
+
+	resolved := &cliContext{}
+	if contextName != "" {
+		ctx, ok := conf.Contexts[contextName]
+		if !ok {
+			return fmt.Errorf("context %q not found. see `gorun context ls`", contextName)
+		}
+
+		*resolved = *ctx
+		if verbose {
+			fmt.Printf("verbose: using context %s\n", contextName)
+		}
+	}
+
+	if repoConf != nil {
+		if verbose {
+			fmt.Printf("verbose: using repo config %s\n", repoConfPath)
+		}
+
+		if repoConf.Server != "" {
+			resolved.Server = repoConf.Server
+		}
+
+		if repoConf.App != "" {
+			resolved.App = repoConf.App
+		}
+	}
+
+	if viper.IsSet("server") {
+		resolved.Server = viper.GetString("server")
+	}
+
+	if viper.IsSet("token") {
+		resolved.Token = viper.GetString("token")
+	}
+
+	if resolved.Server == "" {
+		resolved.Server = defaultServer
+	}
+
+	resolved.Server = strings.TrimRight(resolved.Server, "/")
+
+	httpClient, err := newHTTPClient(resolved)
+	if err != nil {
+		return err
+	}
+
+	target = resolved
+	client = httpClient
+
+	return nil
+}
+
+func newHTTPClient(ctx *cliContext) (*http.Client, error) {
+	if ctx.CA == "" {
+		return http.DefaultClient, nil
+	}
+
+	pem, err := ioutil.ReadFile(ctx.CA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in CA file " + ctx.CA)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// newRequest creates a request to apiPath on target with the auth token if there is one.
+func newRequest(method, apiPath string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, target.Server+apiPath, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+target.Token)
+	}
+
+	return req, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var contextCmd = &cobra.Command{
+	Use:     "context",
+	Aliases: []string{"ctx"},
+	Short:   "Manage the go-runner instances gorun talks to",
+	// contexts are managed here, so they don't need to resolve
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+}
+
+var contextLsCmd = &cobra.Command{
+	Use:     "ls",
+	Aliases: []string{"list"},
+	Args:    cobra.NoArgs,
+	Short:   "List contexts. The current one is marked with *",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conf, err := loadConfig()
+		if err != nil {
+			fmt.Printf("failed to load config: %q\n", err)
+			return err
+		}
+
+		names := make([]string, 0, len(conf.Contexts))
+		for name := range conf.Contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "CURRENT\tNAME\tSERVER\tAPP\tTOKEN\tCA")
+		for _, name := range names {
+			ctx := conf.Contexts[name]
+			current := ""
+			if name == conf.CurrentContext {
+				current = "*"
+			}
+
+			token := "-"
+			if ctx.Token != "" {
+				token = "<set>"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", current, name, orDash(ctx.Server), orDash(ctx.App), token, orDash(ctx.CA))
+		}
+
+		return w.Flush()
+	},
+}
+
+var contextUseCmd = &cobra.Command{
+	Use:   "use [name]",
+	Args:  cobra.ExactArgs(1),
+	Short: "Switch the current context",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conf, err := loadConfig()
+		if err != nil {
+			fmt.Printf("failed to load config: %q\n", err)
+			return err
+		}
+
+		if _, ok := conf.Contexts[args[0]]; !ok {
+			return fmt.Errorf("context %q not found. see `gorun context ls`", args[0])
+		}
+
+		conf.CurrentContext = args[0]
+		err = saveConfig(conf)
+		if err != nil {
+			fmt.Printf("failed to save config: %q\n", err)
+			return err
+		}
+
+		fmt.Printf("switched to context %s\n", args[0])
+
+		return nil
+	},
+}
+
+var contextAddCmd = &cobra.Command{
+	Use:   "add [name]",
+	Args:  cobra.ExactArgs(1),
+	Short: "Add a context, or update an existing one",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conf, err := loadConfig()
+		if err != nil {
+			fmt.Printf("failed to load config: %q\n", err)
+			return err
+		}
+
+		ctx, ok := conf.Contexts[args[0]]
+		if !ok {
+			ctx = &cliContext{}
+			conf.Contexts[args[0]] = ctx
+		}
+
+		flags := cmd.Flags()
+		if flags.Changed("server") {
+			ctx.Server, _ = flags.GetString("server")
+		}
+
+		if flags.Changed("token") {
+			ctx.Token, _ = flags.GetString("token")
+		}
+
+		if flags.Changed("ca") {
+			ctx.CA, _ = flags.GetString("ca")
+		}
+
+		if flags.Changed("app") {
+			ctx.App, _ = flags.GetString("app")
+		}
+
+		if ctx.Server == "" {
+			return fmt.Errorf("context %q needs a --server", args[0])
+		}
+
+		if conf.CurrentContext == "" {
+			conf.CurrentContext = args[0]
+		}
+
+		err = saveConfig(conf)
+		if err != nil {
+			fmt.Printf("failed to save config: %q\n", err)
+			return err
+		}
+
+		fmt.Printf("context %s saved\n", args[0])
+
+		return nil
+	},
+}
+
+func init() {
+	contextAddCmd.Flags().String("token", "", "auth token sent as a bearer token")
+	contextAddCmd.Flags().String("ca", "", "path to the PEM CA certificates to verify the server with")
+	contextAddCmd.Flags().String("app", "", "default app name, instead of the basename of the current dir")
+
+	contextCmd.AddCommand(contextLsCmd)
+	contextCmd.AddCommand(contextUseCmd)
+	contextCmd.AddCommand(contextAddCmd)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var curlCmd = &cobra.Command{
+	Use:   "curl [appName] /path",
+	Args:  cobra.RangeArgs(1, 2),
+	Short: "Send a request to an app through go-runner and print the status, headers and body",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		verbose, err := cmd.Flags().GetBool("verbose")
+		if err != nil {
+			fmt.Printf("failed to get verbose flag: %q\n", err)
+			return err
+		}
+
+		appPath := args[len(args)-1]
+		if !strings.HasPrefix(appPath, "/") {
+			appPath = "/" + appPath
+		}
+
+		appName, err := appNameFromArgs(args[:len(args)-1], verbose)
+		if err != nil {
+			return err
+		}
+
+		method, _ := cmd.Flags().GetString("request")
+		data, _ := cmd.Flags().GetString("data")
+		headers, _ := cmd.Flags().GetStringArray("header")
+
+		var body io.Reader
+		if data != "" {
+			body, err = dataReader(data)
+			if err != nil {
+				fmt.Printf("failed to read request data: %q\n", err)
+				return err
+			}
+
+			if method == "" {
+				method = "POST"
+			}
+		}
+
+		if method == "" {
+			method = "GET"
+		}
+
+		req, err := newRequest(strings.ToUpper(method), "/"+appName+appPath, body)
+		if err != nil {
+			fmt.Printf("failed to create request: %q\n", err)
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+		defer cancel()
+		req = req.WithContext(ctx)
+
+		if data != "" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+
+		for _, h := range headers {
+			kv := strings.SplitN(h, ":", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid header %q. expected: 'Name: value'", h)
+			}
+
+			name, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+			if strings.EqualFold(name, "Host") {
+				req.Host = value
+			} else {
+				req.Header.Set(name, value)
+			}
+		}
+
+		if verbose {
+			fmt.Printf("verbose: sending request: %s %s\n", req.Method, req.URL)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			fmt.Printf("failed to complete request: %q\n", err)
+			return err
+		}
+		defer resp.Body.Close()
+
+		fmt.Printf("%s %s\n", resp.Proto, resp.Status)
+		names := make([]string, 0, len(resp.Header))
+		for name := range resp.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, v := range resp.Header[name] {
+				fmt.Printf("%s: %s\n", name, v)
+			}
+		}
+		fmt.Println()
+
+		_, err = io.Copy(os.Stdout, resp.Body)
+		if err != nil {
+			fmt.Printf("failed to read response body: %q\n", err)
+			return err
+		}
+
+		return nil
+	},
+}
+
+// dataReader reads data like curl does: @file reads from a file, @- from stdin.
+func dataReader(data string) (io.Reader, error) {
+	if !strings.HasPrefix(data, "@") {
+		return strings.NewReader(data), nil
+	}
+
+	if data == "@-" {
+		return os.Stdin, nil
+	}
+
+	return os.Open(data[1:])
+}
+
+func init() {
+	curlCmd.Flags().StringP("request", "X", "", "request method. default to GET, or POST if --data is given")
+	curlCmd.Flags().StringP("data", "d", "", "request body. use @file to read from a file, or @- from stdin")
+	curlCmd.Flags().StringArrayP("header", "H", nil, "extra request header 'Name: value', can be repeated")
+}
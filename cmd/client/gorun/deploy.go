@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/web"
+	"github.com/spf13/cobra"
+)
+
+var deployCmd = &cobra.Command{
+	Use:     "deploy",
+	Aliases: []string{"push"},
+	Args:    cobra.NoArgs,
+	Short:   "Push the current branch to remote origin and deploy it to an app already registered in go-runner",
+	Long: `Push the current branch to remote origin and deploy it to an app already registered in go-runner.
+
+With --local, the working tree is uploaded as is instead, leaving out what .gitignore ignores.
+The app doesn't need a git remote or to be registered for that.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		verbose, err := cmd.Flags().GetBool("verbose")
+		if err != nil {
+			fmt.Printf("failed to get verbose flag: %q\n", err)
+			return err
+		}
+
+		timeout, err := cmd.Flags().GetDuration("timeout")
+		if err != nil {
+			fmt.Printf("failed to get timeout flag: %q\n", err)
+			return err
+		}
+
+		local, err := cmd.Flags().GetBool("local")
+		if err != nil {
+			fmt.Printf("failed to get local flag: %q\n", err)
+			return err
+		}
+
+		wd, err := os.Getwd()
+		if err != nil {
+			fmt.Printf("failed to get current working dir: %q\n", err)
+			return err
+		}
+
+		appName, err := appNameFromArgs(nil, verbose)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		if local {
+			return deployLocal(ctx, wd, appName, verbose)
+		}
+
+		if _, err := fetchApp(appName); err != nil {
+			fmt.Printf("%s. use `gorun register` for the 1st deploy.\n", err)
+			return err
+		}
+
+		pushed, err := pushCurrentBranch(wd, verbose)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+
+		if verbose {
+			fmt.Printf("verbose: deploying to %s... app=%s, branch=%s\n",
+				target.Server, appName, pushed.Branch)
+		}
+
+		params := web.UpdateAppParams{
+			App:    appName,
+			Action: "deploy",
+			Branch: pushed.Branch,
+		}
+
+		reqBody, err := json.Marshal(params)
+		if err != nil {
+			fmt.Printf("failed to create request params: %q\n", err)
+			return err
+		}
+
+		req, err := newRequest("PUT", "/api/"+appName, bytes.NewBuffer(reqBody))
+		if err != nil {
+			fmt.Printf("failed to create request: %q\n", err)
+			return err
+		}
+
+		fmt.Printf("deploying %s@%s to %s...\n", pushed.Branch, pushed.Hash.String()[:7], appName)
+
+		app, err := waitForDeploy(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+
+		if app.GitHash != pushed.Hash.String() {
+			return fmt.Errorf("deployed commit %s does not match local %s", shortCommit(app.GitCommit), pushed.Hash.String()[:7])
+		}
+
+		if !app.IsRunning() {
+			return errNotRunning
+		}
+
+		return nil
+	},
+}
+
+func deployLocal(ctx context.Context, wd, appName string, verbose bool) error {
+	src, err := findLocalSource(wd)
+	if err != nil {
+		fmt.Printf("failed to read local source: %q\n", err)
+		return err
+	}
+
+	tarball := src.Tarball()
+	defer tarball.Close()
+
+	apiPath := fmt.Sprintf("/api/%s/source", appName)
+	if src.BaseCommit != "" {
+		apiPath += "?commit=" + url.QueryEscape(src.BaseCommit)
+	}
+
+	req, err := newRequest("POST", apiPath, tarball)
+	if err != nil {
+		fmt.Printf("failed to create request: %q\n", err)
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/gzip")
+
+	if verbose {
+		fmt.Printf("verbose: uploading to %s... app=%s\n", target.Server, appName)
+	}
+
+	fmt.Printf("deploying %s to %s...\n", src, appName)
+
+	app, err := waitForDeploy(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	if !app.IsRunning() {
+		return errNotRunning
+	}
+
+	return nil
+}
+
+// waitForDeploy sends the deploy request, waits for the response and prints the outcome.
+func waitForDeploy(req *http.Request) (*appInfo, error) {
+	app := new(appInfo)
+	err := doJSON(req, app)
+	if err != nil {
+		var herr *httpError
+		var failed errInfo
+		if errors.As(err, &herr) && json.Unmarshal([]byte(herr.Body), &failed) == nil && failed.Err != "" {
+			err = fmt.Errorf("deploy failed: %s", failed.Err)
+		}
+
+		fmt.Println(err)
+		return nil, err
+	}
+
+	if app.Release != nil {
+		fmt.Printf("release: %d\n", app.Release.ID)
+	}
+
+	fmt.Printf("commit: %s\n", app.GitCommit)
+	fmt.Printf("state: %s\n", app.State)
+
+	return app, nil
+}
+
+func init() {
+	deployCmd.Flags().Duration("timeout", 10*time.Minute, "how long to wait for the deploy to finish")
+	deployCmd.Flags().Bool("local", false, "upload the working tree instead of pushing to git")
+}
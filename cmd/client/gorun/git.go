@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// pushedBranch is the result of pushCurrentBranch
+type pushedBranch struct {
+	GitURL string
+	Branch string
+	Hash   plumbing.Hash
+}
+
+// pushCurrentBranch pushes the checked out branch of the repo containing wd to the same branch on origin,
+// and verifies that origin has caught up with the local branch.
+func pushCurrentBranch(wd string, verbose bool) (*pushedBranch, error) {
+	repo, err := git.PlainOpenWithOptions(wd, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open current git repo: %w", err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get HEAD of current git repo: %w", err)
+	}
+
+	if !head.Name().IsBranch() {
+		return nil, errors.New("HEAD is detached. checkout a branch to push")
+	}
+
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote origin: %w", err)
+	}
+
+	pushed := &pushedBranch{
+		GitURL: remote.Config().URLs[0],
+		Branch: head.Name().Short(),
+		Hash:   head.Hash(),
+	}
+
+	if verbose {
+		fmt.Printf("verbose: pushing %s to remote origin: %s\n", pushed.Branch, pushed.GitURL)
+	}
+
+	auth, err := util.GetGitAuthFor(pushed.GitURL)
+	if err != nil {
+		return nil, err
+	}
+
+	refSpec := config.RefSpec(fmt.Sprintf("%s:%s", head.Name(), head.Name()))
+	err = remote.Push(&git.PushOptions{
+		RefSpecs: []config.RefSpec{refSpec},
+		Auth:     auth,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return nil, fmt.Errorf("failed to push current git repo: %w", err)
+	}
+
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs of remote origin: %w", err)
+	}
+
+	for _, ref := range refs {
+		if ref.Name() == head.Name() {
+			if ref.Hash() != head.Hash() {
+				return nil, fmt.Errorf("local branch %s is ahead of origin: local=%s, origin=%s",
+					pushed.Branch, head.Hash().String()[:7], ref.Hash().String()[:7])
+			}
+
+			return pushed, nil
+		}
+	}
+
+	return nil, fmt.Errorf("branch %s not found on origin after push", pushed.Branch)
+}
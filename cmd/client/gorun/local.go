@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+// localSource is the working tree to upload for `gorun deploy --local`
+type localSource struct {
+	Root       string
+	BaseCommit string
+	ignore     gitignore.Matcher
+}
+
+// findLocalSource uses the root of the git repo containing wd if there is one, or else wd itself.
+func findLocalSource(wd string) (*localSource, error) {
+	src := &localSource{Root: wd}
+
+	repo, err := git.PlainOpenWithOptions(wd, &git.PlainOpenOptions{DetectDotGit: true})
+	if err == nil {
+		worktree, err := repo.Worktree()
+		if err != nil {
+			return nil, err
+		}
+
+		src.Root = worktree.Filesystem.Root()
+		if head, err := repo.Head(); err == nil {
+			src.BaseCommit = head.Hash().String()
+		}
+	} else if err != git.ErrRepositoryNotExists {
+		return nil, err
+	}
+
+	patterns, err := gitignore.ReadPatterns(osfs.New(src.Root), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .gitignore: %w", err)
+	}
+
+	src.ignore = gitignore.NewMatcher(patterns)
+
+	return src, nil
+}
+
+func (s *localSource) skip(relPath string, isDir bool) bool {
+	if relPath == ".git" {
+		return true
+	}
+
+	return s.ignore.Match(strings.Split(relPath, "/"), isDir)
+}
+
+// Tarball streams a gzipped tarball of the working tree, leaving out what .gitignore ignores.
+func (s *localSource) Tarball() io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(util.TarGz(w, s.Root, s.skip))
+	}()
+
+	return r
+}
+
+func (s *localSource) String() string {
+	if s.BaseCommit == "" {
+		return s.Root
+	}
+
+	return fmt.Sprintf("%s on %s", s.Root, s.BaseCommit[:7])
+}
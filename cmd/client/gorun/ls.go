@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+var lsCmd = &cobra.Command{
+	Use:          "ls",
+	Aliases:      []string{"list"},
+	Args:         cobra.NoArgs,
+	Short:        "List all apps in go-runner. Exits non-zero if any app is not running",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		apps, err := fetchApps()
+		if err != nil {
+			fmt.Printf("failed to list apps: %q\n", err)
+			return err
+		}
+
+		sort.Slice(apps, func(i, j int) bool {
+			return apps[i].Name < apps[j].Name
+		})
+
+		err = printOutput(cmd, apps, func(w io.Writer) {
+			fmt.Fprintln(w, "NAME\tSTATE\tCOMMIT\tPID\tUPTIME\tRESTARTS\tLAST ERROR")
+			for _, a := range apps {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+					a.Name, formatState(a), shortCommit(a.GitCommit), formatPID(a),
+					formatUptime(a), a.Restarts, orDash(a.LastErr))
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, a := range apps {
+			if !a.IsRunning() {
+				return errNotRunning
+			}
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	addOutputFlag(lsCmd)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -9,21 +10,32 @@ import (
 )
 
 var rootCmd = &cobra.Command{
-	Use:   "gorun",
-	Short: "gorun is a cli for go-runner to do CRUD for apps.",
+	Use:               "gorun",
+	Short:             "gorun is a cli for go-runner to do CRUD for apps.",
+	PersistentPreRunE: resolveTarget,
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
 
-	rootCmd.PersistentFlags().String("server", "http://localhost:8080", "base url to go-runner server.")
+	rootCmd.PersistentFlags().String("server", defaultServer, "base url to go-runner server. overrides the context.")
+	rootCmd.PersistentFlags().String("context", "", "name of the context to use instead of the current one")
+	rootCmd.PersistentFlags().String("config", "", "path to the config file. default to ~/.config/gorun/config.yaml")
 	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "verbose output")
 	viper.BindPFlag("server", rootCmd.PersistentFlags().Lookup("server"))
+	viper.BindPFlag("context", rootCmd.PersistentFlags().Lookup("context"))
+	viper.BindPFlag("config", rootCmd.PersistentFlags().Lookup("config"))
+	viper.BindPFlag("verbose", rootCmd.PersistentFlags().Lookup("verbose"))
 
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(registerCmd)
-	//rootCmd.AddCommand(pushCmd)
+	rootCmd.AddCommand(deployCmd)
 	rootCmd.AddCommand(deleteCmd)
+	rootCmd.AddCommand(lsCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(topCmd)
+	rootCmd.AddCommand(curlCmd)
+	rootCmd.AddCommand(contextCmd)
 }
 
 func initConfig() {
@@ -34,6 +46,11 @@ func initConfig() {
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, errNotRunning) {
+			// same as the LSB init script status code for "program is not running"
+			os.Exit(3)
+		}
+
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+func addOutputFlag(cmd *cobra.Command) {
+	cmd.Flags().StringP("output", "o", "table", "output format: json|yaml|table")
+}
+
+// printOutput writes v in the format given by the --output flag.
+// table is used to render the table format.
+func printOutput(cmd *cobra.Command, v interface{}, table func(w io.Writer)) error {
+	format, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		out, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		_, err = os.Stdout.Write(out)
+		return err
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		table(w)
+		return w.Flush()
+	}
+
+	return fmt.Errorf("unknown output format: %s. expected: json|yaml|table", format)
+}
+
+func formatUptime(a appInfo) string {
+	if !a.IsRunning() {
+		return "-"
+	}
+
+	return (time.Duration(a.Uptime) * time.Second).String()
+}
+
+// formatState returns the state of the app and how long it has been in it.
+func formatState(a appInfo) string {
+	if a.StateSince == nil {
+		return a.State
+	}
+
+	return fmt.Sprintf("%s for %s", a.State, time.Duration(a.TimeInState)*time.Second)
+}
+
+// formatEvents lists the transitions of an app, the latest first, one per line.
+func formatEvents(events []event) string {
+	if len(events) == 0 {
+		return "-"
+	}
+
+	lines := make([]string, 0, len(events))
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		lines = append(lines, fmt.Sprintf("%s %s: %s", e.At.Local().Format("2006-01-02 15:04:05"), e.To, e.Reason))
+	}
+
+	return strings.Join(lines, "\n\t")
+}
+
+func formatPID(a appInfo) string {
+	if a.PID <= 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%d", a.PID)
+}
+
+// shortCommit returns the abbreviated hash of a gitCommit description.
+func shortCommit(gitCommit string) string {
+	if gitCommit == "" {
+		return "-"
+	}
+
+	return strings.SplitN(gitCommit, " ", 2)[0]
+}
+
+// formatBytes returns a size in bytes in the unit that fits it, e.g. 12.3M.
+func formatBytes(b float64) string {
+	const units = "KMGT"
+	if b < 1024 {
+		return fmt.Sprintf("%.0fB", b)
+	}
+
+	i := -1
+	for b >= 1024 && i < len(units)-1 {
+		b /= 1024
+		i++
+	}
+
+	return fmt.Sprintf("%.1f%c", b, units[i])
+}
+
+func formatLimits(l limits) string {
+	var parts []string
+	if l.CPU > 0 {
+		parts = append(parts, fmt.Sprintf("cpu %g", l.CPU))
+	}
+	if l.Memory != "" {
+		parts = append(parts, "memory "+l.Memory)
+	}
+	if l.MemoryHigh != "" {
+		parts = append(parts, "memory high "+l.MemoryHigh)
+	}
+	if l.Pids > 0 {
+		parts = append(parts, fmt.Sprintf("pids %d", l.Pids))
+	}
+	if l.IOWeight > 0 {
+		parts = append(parts, fmt.Sprintf("io weight %d", l.IOWeight))
+	}
+
+	return orDash(strings.Join(parts, ", "))
+}
+
+// formatCgroup returns the usage of an app from its cgroup.
+func formatCgroup(c cgroup) string {
+	max := c.MemoryMax
+	if max != "" && max != "max" {
+		if n, err := strconv.ParseFloat(max, 64); err == nil {
+			max = formatBytes(n)
+		}
+	}
+
+	return fmt.Sprintf("%s, memory %s of %s, %d pids, throttled for %.1fs, %d OOM kills",
+		c.Path, formatBytes(float64(c.Memory)), orDash(max), c.Pids, c.ThrottledSeconds, c.OOMKills)
+}
+
+// formatUser returns who the process of an app runs as.
+func formatUser(p process) string {
+	s := fmt.Sprintf("uid %d, gid %d", p.UID, p.GID)
+	if p.NoNewPrivs {
+		s += ", no new privileges"
+	}
+
+	if p.Seccomp {
+		s += ", syscalls filtered"
+	}
+
+	return s
+}
+
+// formatSandbox returns the sandbox of an app as configured.
+func formatSandbox(s sandbox) string {
+	var parts []string
+	switch s.Seccomp {
+	case "default":
+		profile := "default seccomp profile"
+		if len(s.Syscalls) > 0 {
+			profile += " plus " + strings.Join(s.Syscalls, ", ")
+		}
+		parts = append(parts, profile)
+	case "custom":
+		parts = append(parts, "custom seccomp profile of "+strings.Join(s.Syscalls, ", "))
+	}
+
+	if s.Landlock {
+		landlock := "landlock"
+		if paths := append(append([]string{}, s.ReadPaths...), s.WritePaths...); len(paths) > 0 {
+			landlock += " also allowing " + strings.Join(paths, ", ")
+		}
+		parts = append(parts, landlock)
+	}
+
+	return orDash(strings.Join(parts, "; "))
+}
+
+// formatNetwork returns what of the network an app may reach: as its process is under, if it is running, or else
+// as configured.
+func formatNetwork(n *network, p *process) string {
+	policy := "host"
+	if n != nil {
+		policy = n.Policy
+	}
+
+	if p != nil && p.Network != "" {
+		policy = p.Network
+	}
+
+	switch policy {
+	case "none":
+		return "none, loopback only"
+	case "allowlist":
+		var allow []string
+		if n != nil {
+			allow = n.Allow
+		}
+
+		return "allowlist of loopback and " + orDash(strings.Join(allow, ", "))
+	}
+
+	return policy
+}
+
+// formatRlimits returns the rlimits of the process of an app, in the order of the config.
+func formatRlimits(p process) string {
+	var parts []string
+	for _, name := range []string{"nofile", "nproc", "core", "as", "cpu"} {
+		if v, ok := p.Rlimits[name]; ok {
+			parts = append(parts, name+" "+v)
+		}
+	}
+
+	return orDash(strings.Join(parts, ", "))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+func formatPoll(p pollInfo) string {
+	if p.LastCheck == nil {
+		return "not checked yet"
+	}
+
+	s := fmt.Sprintf("last checked at %s: %s", p.LastCheck.Local().Format(time.RFC3339), p.LastResult)
+	if p.LastError != "" {
+		s += fmt.Sprintf(" (%s)", p.LastError)
+	}
+
+	return s
+}
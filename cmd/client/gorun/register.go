@@ -4,20 +4,20 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"os"
-	"path"
 
-	"github.com/JackKCWong/go-runner/internal/util"
 	"github.com/JackKCWong/go-runner/internal/web"
-	"github.com/go-git/go-git/v5"
 	"github.com/spf13/cobra"
 )
 
 var registerCmd = &cobra.Command{
-	Use:     "register",
-	Aliases: []string{"reg", "pub", "push"},
-	Short:   "Push the the current git repo to remote origin and register it to go-runner",
+	Use:     "register [appName]",
+	Aliases: []string{"reg", "pub"},
+	Args:    cobra.MaximumNArgs(1),
+	Short:   "Push the current branch to remote origin and register it to go-runner",
+	Long: `Push the current branch to remote origin and register it to go-runner.
+
+The app name defaults to the app of the context, or else to the current dir, which may be anywhere in the repo.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		verbose, err := cmd.Flags().GetBool("verbose")
 		if err != nil {
@@ -31,49 +31,44 @@ var registerCmd = &cobra.Command{
 			return err
 		}
 
-		repo, err := git.PlainOpen(wd)
+		appName, err := appNameFromArgs(args, verbose)
 		if err != nil {
-			fmt.Printf("failed to open current git repo: %q\n", err)
 			return err
 		}
 
-		remote, err := repo.Remote("origin")
+		pushed, err := pushCurrentBranch(wd, verbose)
 		if err != nil {
-			fmt.Printf("failed to open current git repo: %q\n", err)
+			fmt.Println(err)
 			return err
 		}
 
-		params := web.DeployAppParams{
-			App:    path.Base(wd),
-			GitUrl: remote.Config().URLs[0],
-		}
-
-		if verbose {
-			fmt.Printf("verbose: pushing to remote origin: %s\n", params.GitUrl)
-		}
-
-		sshAuth, err := util.GetGitAuth()
+		subdir, err := cmd.Flags().GetString("subdir")
 		if err != nil {
 			return err
 		}
 
-		err = remote.Push(&git.PushOptions{Auth: sshAuth})
-		if err != git.NoErrAlreadyUpToDate {
-			fmt.Printf("failed to push current git repo: %q\n", err)
+		submodules, err := cmd.Flags().GetBool("submodules")
+		if err != nil {
 			return err
 		}
 
-		serverURL, err := cmd.Flags().GetString("server")
+		poll, err := cmd.Flags().GetString("poll")
 		if err != nil {
-			fmt.Printf("failed to get server URL: %q\n", err)
 			return err
 		}
 
-		endpoint := fmt.Sprintf("%s/api/apps", serverURL)
+		params := web.DeployAppParams{
+			App:          appName,
+			GitUrl:       pushed.GitURL,
+			Branch:       pushed.Branch,
+			Subdir:       subdir,
+			Submodules:   submodules,
+			PollInterval: poll,
+		}
 
 		if verbose {
-			fmt.Printf("verbose: register to %s... app=%s, gitUrl=%s\n",
-				endpoint, params.App, params.GitUrl)
+			fmt.Printf("verbose: register to %s... app=%s, gitUrl=%s, branch=%s, subdir=%s\n",
+				target.Server, params.App, params.GitUrl, params.Branch, params.Subdir)
 		}
 
 		reqPayload, err := json.Marshal(params)
@@ -82,7 +77,7 @@ var registerCmd = &cobra.Command{
 			return err
 		}
 
-		req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(reqPayload))
+		req, err := newRequest("POST", "/api/apps", bytes.NewBuffer(reqPayload))
 		if err != nil {
 			fmt.Printf("failed to create request: %q\n", err)
 			return err
@@ -99,3 +94,9 @@ var registerCmd = &cobra.Command{
 		return nil
 	},
 }
+
+func init() {
+	registerCmd.Flags().String("subdir", "", "dir of the app in a monorepo, relative to the repo root")
+	registerCmd.Flags().Bool("submodules", false, "check out the git submodules with the app")
+	registerCmd.Flags().String("poll", "", "check the branch for new commits and deploy them at this interval, e.g. 1m")
+}
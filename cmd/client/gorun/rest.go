@@ -15,9 +15,11 @@ func doREST(req *http.Request) (string, error) {
 	defer cancel()
 
 	req = req.WithContext(ctx)
-	req.Header.Add("Content-Type", "application/json")
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		fmt.Printf("failed to get http response: %q\n", err)
 		return "", err
@@ -36,6 +38,48 @@ func doREST(req *http.Request) (string, error) {
 	return prettyJSON.String(), nil
 }
 
+// doJSON sends the request and decodes a 2xx JSON response into out.
+// Non-2xx responses are returned as *httpError with the response body attached.
+// The request times out after 1 minute unless its context has a deadline already.
+func doJSON(req *http.Request, out interface{}) error {
+	if _, ok := req.Context().Deadline(); !ok {
+		ctx, cancel := context.WithTimeout(req.Context(), 1*time.Minute)
+		defer cancel()
+
+		req = req.WithContext(ctx)
+	}
+
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	body, err := readAll(resp.Body)
+	if err != io.EOF {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &httpError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(body))}
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+type httpError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("server responded %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
 func readAll(in io.ReadCloser) ([]byte, error) {
 	var out bytes.Buffer
 	var buf = make([]byte, 4*1024)
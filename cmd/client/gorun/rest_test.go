@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+)
+
+func TestRequestsTrustTheCAOfTheContext(t *testing.T) {
+	expect := util.NewExpect(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer server.Close()
+
+	saved := client
+	defer func() { client = saved }()
+
+	// not trusted without the CA of the server
+	client = http.DefaultClient
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/health", nil)
+	expect.Nil(err)
+	_, err = doREST(req)
+	expect.True(err != nil)
+
+	ca := path.Join(t.TempDir(), "ca.pem")
+	expect.Nil(ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	client, err = newHTTPClient(&cliContext{Server: server.URL, CA: ca})
+	expect.Nil(err)
+
+	req, err = http.NewRequest(http.MethodGet, server.URL+"/api/health", nil)
+	expect.Nil(err)
+	body, err := doREST(req)
+	expect.Nil(err)
+	expect.Equal("{\n  \"status\": \"ok\"\n}", body)
+
+	var health struct {
+		Status string `json:"status"`
+	}
+	req, err = http.NewRequest(http.MethodGet, server.URL+"/api/health", nil)
+	expect.Nil(err)
+	expect.Nil(doJSON(req, &health))
+	expect.Equal("ok", health.Status)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:          "status [appName]",
+	Args:         cobra.MaximumNArgs(1),
+	Short:        "Show the status of an app. Exits non-zero if the app is not running",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		verbose, err := cmd.Flags().GetBool("verbose")
+		if err != nil {
+			fmt.Printf("failed to get verbose flag: %q\n", err)
+			return err
+		}
+
+		appName, err := appNameFromArgs(args, verbose)
+		if err != nil {
+			return err
+		}
+
+		app, err := fetchApp(appName)
+		if err != nil {
+			fmt.Printf("failed to get app status: %q\n", err)
+			return err
+		}
+
+		err = printOutput(cmd, app, func(w io.Writer) {
+			started := "-"
+			if app.StartedAt != nil {
+				started = app.StartedAt.Local().String()
+			}
+
+			fmt.Fprintf(w, "Name:\t%s\n", app.Name)
+			fmt.Fprintf(w, "State:\t%s\n", formatState(*app))
+			fmt.Fprintf(w, "Git URL:\t%s\n", orDash(app.GitURL))
+			if app.Config.Subdir != "" {
+				fmt.Fprintf(w, "Subdir:\t%s\n", app.Config.Subdir)
+			}
+			fmt.Fprintf(w, "Git commit:\t%s\n", orDash(app.GitCommit))
+			if app.Release != nil {
+				fmt.Fprintf(w, "Release:\t%d (%s) created at %s\n", app.Release.ID, app.Release.Source, app.Release.CreatedAt.Local())
+			}
+			fmt.Fprintf(w, "App dir:\t%s\n", orDash(app.AppDir))
+			fmt.Fprintf(w, "PID:\t%s\n", formatPID(*app))
+			fmt.Fprintf(w, "Started at:\t%s\n", started)
+			fmt.Fprintf(w, "Uptime:\t%s\n", formatUptime(*app))
+			fmt.Fprintf(w, "Restarts:\t%d\n", app.Restarts)
+			if app.Config.Limits != nil {
+				fmt.Fprintf(w, "Limits:\t%s\n", formatLimits(*app.Config.Limits))
+			}
+			if app.Process != nil {
+				fmt.Fprintf(w, "User:\t%s\n", formatUser(*app.Process))
+				fmt.Fprintf(w, "Rlimits:\t%s\n", formatRlimits(*app.Process))
+			}
+			if app.Config.Sandbox != nil {
+				fmt.Fprintf(w, "Sandbox:\t%s\n", formatSandbox(*app.Config.Sandbox))
+			}
+			if app.Config.Network != nil || (app.Process != nil && app.Process.Network != "host") {
+				fmt.Fprintf(w, "Network:\t%s\n", formatNetwork(app.Config.Network, app.Process))
+			}
+			if app.Config.Isolate {
+				fmt.Fprintf(w, "Isolated:\tin namespaces of its own, data dir %s/data\n", app.AppDir)
+			}
+			if app.Cgroup != nil {
+				fmt.Fprintf(w, "Cgroup:\t%s\n", formatCgroup(*app.Cgroup))
+			}
+			fmt.Fprintf(w, "Last error:\t%s\n", orDash(app.LastErr))
+			if app.Poll != nil {
+				fmt.Fprintf(w, "Polling:\tevery %s, %s\n", app.Poll.Interval, formatPoll(*app.Poll))
+			}
+			fmt.Fprintf(w, "Events:\t%s\n", formatEvents(app.Events))
+		})
+		if err != nil {
+			return err
+		}
+
+		if !app.IsRunning() {
+			return errNotRunning
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	addOutputFlag(statusCmd)
+}
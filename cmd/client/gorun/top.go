@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var topCmd = &cobra.Command{
+	Use:          "top",
+	Args:         cobra.NoArgs,
+	Short:        "Show the live cpu, memory, fds, threads and I/O of all apps in go-runner",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		interval, err := cmd.Flags().GetDuration("interval")
+		if err != nil {
+			return err
+		}
+
+		once, err := cmd.Flags().GetBool("once")
+		if err != nil {
+			return err
+		}
+
+		sortBy, err := cmd.Flags().GetString("sort")
+		if err != nil {
+			return err
+		}
+
+		if sortBy != "name" && sortBy != "cpu" && sortBy != "rss" {
+			return fmt.Errorf("unknown sort: %s. expected: name|cpu|rss", sortBy)
+		}
+
+		for {
+			apps, err := fetchApps()
+			if err != nil {
+				fmt.Printf("failed to list apps: %q\n", err)
+				return err
+			}
+
+			if !once {
+				// clear the screen
+				fmt.Print("\033[H\033[2J")
+				fmt.Printf("%s every %s, sorted by %s\n\n", target.Server, interval, sortBy)
+			}
+
+			sortApps(apps, sortBy)
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			printTop(w, apps)
+			w.Flush()
+
+			if once {
+				return nil
+			}
+
+			time.Sleep(interval)
+		}
+	},
+}
+
+func init() {
+	topCmd.Flags().DurationP("interval", "n", 2*time.Second, "how often to refresh")
+	topCmd.Flags().Bool("once", false, "print once and exit")
+	topCmd.Flags().String("sort", "name", "sort by: name|cpu|rss")
+}
+
+// sortApps sorts apps by name, or the busiest first by cpu or rss, with the apps not running last.
+func sortApps(apps []appInfo, by string) {
+	sort.Slice(apps, func(i, j int) bool {
+		if by != "name" {
+			ui, uj := apps[i].Usage, apps[j].Usage
+			switch {
+			case ui == nil || uj == nil:
+				if (ui == nil) != (uj == nil) {
+					return ui != nil
+				}
+			case by == "cpu" && ui.CPU != uj.CPU:
+				return ui.CPU > uj.CPU
+			case by == "rss" && ui.RSS != uj.RSS:
+				return ui.RSS > uj.RSS
+			}
+		}
+
+		return apps[i].Name < apps[j].Name
+	})
+}
+
+func printTop(w io.Writer, apps []appInfo) {
+	fmt.Fprintln(w, "NAME\tSTATE\tPID\tCPU%\tRSS\tFDS\tTHREADS\tREAD/S\tWRITE/S")
+	for _, a := range apps {
+		u := a.Usage
+		if u == nil {
+			fmt.Fprintf(w, "%s\t%s\t%s\t-\t-\t-\t-\t-\t-\n", a.Name, a.State, formatPID(a))
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.1f\t%s\t%d\t%d\t%s\t%s\n",
+			a.Name, a.State, formatPID(a), u.CPU*100, formatBytes(float64(u.RSS)), u.FDs, u.Threads,
+			formatBytes(u.ReadRate), formatBytes(u.WriteRate))
+	}
+}
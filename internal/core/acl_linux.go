@@ -0,0 +1,197 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"sort"
+	"syscall"
+)
+
+// the POSIX ACL of a file, in the xattr of the kernel, see acl(5) and linux/posix_acl_xattr.h
+const (
+	XATTR_POSIX_ACL_ACCESS  = "system.posix_acl_access"
+	POSIX_ACL_XATTR_VERSION = 2
+
+	ACL_USER_OBJ  = 0x01
+	ACL_USER      = 0x02
+	ACL_GROUP_OBJ = 0x04
+	ACL_GROUP     = 0x08
+	ACL_MASK      = 0x10
+	ACL_OTHER     = 0x20
+
+	ACL_EXECUTE = 0x01
+)
+
+type aclEntry struct {
+	tag  uint16
+	perm uint16
+	id   uint32
+}
+
+// grantTraverse lets uid pass through dir, with an entry of its own in the ACL of dir, so that nobody else can.
+func grantTraverse(dir string, uid int) error {
+	entries, err := readACL(dir)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	var groupObj, named uint16
+	for i := range entries {
+		switch e := &entries[i]; e.tag {
+		case ACL_USER:
+			if e.id == uint32(uid) {
+				e.perm |= ACL_EXECUTE
+				found = true
+			}
+			named |= e.perm
+		case ACL_GROUP:
+			named |= e.perm
+		case ACL_GROUP_OBJ:
+			groupObj = e.perm
+		}
+	}
+
+	if !found {
+		entries = append(entries, aclEntry{tag: ACL_USER, perm: ACL_EXECUTE, id: uint32(uid)})
+		named |= ACL_EXECUTE
+	}
+
+	hasMask := false
+	for i := range entries {
+		if entries[i].tag == ACL_MASK {
+			entries[i].perm |= ACL_EXECUTE
+			hasMask = true
+		}
+	}
+
+	if !hasMask {
+		// what the group of the file had, and what the named entries need
+		entries = append(entries, aclEntry{tag: ACL_MASK, perm: groupObj | named})
+	}
+
+	return writeACL(dir, entries)
+}
+
+// revokeTraverse removes the entry of uid from the ACL of dir, and the ACL with it if no other one is left.
+func revokeTraverse(dir string, uid int) error {
+	entries, err := readACL(dir)
+	if errors.Is(err, syscall.EOPNOTSUPP) {
+		// nothing was granted
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var kept []aclEntry
+	found, named := false, false
+	for _, e := range entries {
+		if e.tag == ACL_USER && e.id == uint32(uid) {
+			found = true
+			continue
+		}
+
+		if e.tag == ACL_USER || e.tag == ACL_GROUP {
+			named = true
+		}
+
+		kept = append(kept, e)
+	}
+
+	if !found {
+		return nil
+	}
+
+	if named {
+		return writeACL(dir, kept)
+	}
+
+	// without named entries the ACL is just the mode, which the mask is not part of
+	var mode []aclEntry
+	for _, e := range kept {
+		if e.tag != ACL_MASK {
+			mode = append(mode, e)
+		}
+	}
+
+	return writeACL(dir, mode)
+}
+
+// getXattr reads the xattr name of file.
+func getXattr(file, name string) (string, error) {
+	buf := make([]byte, 256)
+	n, err := syscall.Getxattr(file, name, buf)
+	if err != nil {
+		return "", err
+	}
+
+	return string(buf[:n]), nil
+}
+
+// readACL reads the ACL of file, or makes the one of its mode if it has none.
+func readACL(file string) ([]aclEntry, error) {
+	buf := make([]byte, 4096)
+	n, err := syscall.Getxattr(file, XATTR_POSIX_ACL_ACCESS, buf)
+	if errors.Is(err, syscall.ENODATA) {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+
+		perm := uint16(fi.Mode().Perm())
+		return []aclEntry{
+			{tag: ACL_USER_OBJ, perm: perm >> 6 & 7},
+			{tag: ACL_GROUP_OBJ, perm: perm >> 3 & 7},
+			{tag: ACL_OTHER, perm: perm & 7},
+		}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 4 || binary.LittleEndian.Uint32(buf) != POSIX_ACL_XATTR_VERSION || (n-4)%8 != 0 {
+		return nil, errors.New("unknown ACL format of " + file)
+	}
+
+	var entries []aclEntry
+	for p := 4; p < n; p += 8 {
+		entries = append(entries, aclEntry{
+			tag:  binary.LittleEndian.Uint16(buf[p:]),
+			perm: binary.LittleEndian.Uint16(buf[p+2:]),
+			id:   binary.LittleEndian.Uint32(buf[p+4:]),
+		})
+	}
+
+	return entries, nil
+}
+
+// writeACL sets the ACL of file, in the order of the tags and ids the kernel expects.
+func writeACL(file string, entries []aclEntry) error {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].tag != entries[j].tag {
+			return entries[i].tag < entries[j].tag
+		}
+
+		return entries[i].id < entries[j].id
+	})
+
+	buf := make([]byte, 4+8*len(entries))
+	binary.LittleEndian.PutUint32(buf, POSIX_ACL_XATTR_VERSION)
+	for i, e := range entries {
+		p := 4 + 8*i
+		id := e.id
+		if e.tag != ACL_USER && e.tag != ACL_GROUP {
+			id = 0xffffffff
+		}
+
+		binary.LittleEndian.PutUint16(buf[p:], e.tag)
+		binary.LittleEndian.PutUint16(buf[p+2:], e.perm)
+		binary.LittleEndian.PutUint32(buf[p+4:], id)
+	}
+
+	return syscall.Setxattr(file, XATTR_POSIX_ACL_ACCESS, buf, 0)
+}
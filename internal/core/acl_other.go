@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package core
+
+import "errors"
+
+func grantTraverse(dir string, uid int) error {
+	return errors.New("ACLs are only supported on Linux")
+}
+
+func revokeTraverse(dir string, uid int) error {
+	return nil
+}
+
+func getXattr(file, name string) (string, error) {
+	return "", errors.New("xattrs are only supported on Linux")
+}
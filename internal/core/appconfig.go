@@ -0,0 +1,134 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var ErrInvalidConfig = errors.New("invalid app config")
+
+// AppConfig is how an app is built from its source. Changes take effect on the next deploy.
+type AppConfig struct {
+	// Subdir is the dir of the app in a monorepo, relative to the repo root
+	Subdir string `json:"subdir,omitempty"`
+	// Submodules checks out the git submodules with the app
+	Submodules bool `json:"submodules,omitempty"`
+	// PollInterval is how often to check the tracked branch for new commits and deploy them, e.g. "1m".
+	// The app is not polled if it is empty.
+	PollInterval string `json:"pollInterval,omitempty"`
+	// Gates check a new release before it becomes the current release
+	Gates *GateConfig `json:"gates,omitempty"`
+	// Limits are the resources the app may use, applied when it starts
+	Limits *Limits `json:"limits,omitempty"`
+	// Isolate runs the app in namespaces of its own, seeing only the system dirs, its release, its data dir and /tmp
+	Isolate bool `json:"isolate,omitempty"`
+	// User is who the app runs as. It runs as a user allocated to it if go-runner has a range of uids for the apps,
+	// or else as go-runner.
+	User *AppUser `json:"user,omitempty"`
+	// Rlimits are the resource limits of the process of the app, applied when it starts
+	Rlimits *Rlimits `json:"rlimits,omitempty"`
+	// Sandbox is the syscalls and the files the app may use, applied when it starts
+	Sandbox *Sandbox `json:"sandbox,omitempty"`
+	// Network is what of the network the app may reach, enforced when it starts. It shares the network of the host
+	// if it is nil.
+	Network *Network `json:"network,omitempty"`
+}
+
+// Config returns the config of the app.
+func (a *GoApp) Config() AppConfig {
+	a.Lock()
+	defer a.Unlock()
+
+	return a.config
+}
+
+// SetConfig validates and saves the config of the app.
+func (a *GoApp) SetConfig(config AppConfig) error {
+	err := config.validate()
+	if err != nil {
+		return err
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if !reflect.DeepEqual(a.config, config) {
+		a.publish(EVENT_CONFIG_CHANGED, 0, "")
+	}
+
+	a.config = config
+	a.restartPolling()
+
+	// even with nothing deployed yet, for the config to outlive a failed first deploy
+	err = os.MkdirAll(a.AppDir, 0770)
+	if err != nil {
+		return err
+	}
+
+	return a.save()
+}
+
+func (c *AppConfig) validate() error {
+	err := c.Gates.validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.Limits.validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.User.validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.Rlimits.validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.Sandbox.validate()
+	if err != nil {
+		return err
+	}
+
+	err = c.Network.validate()
+	if err != nil {
+		return err
+	}
+
+	if c.PollInterval != "" {
+		interval, err := time.ParseDuration(c.PollInterval)
+		if err != nil {
+			return fmt.Errorf("%w: pollInterval: %s", ErrInvalidConfig, err)
+		}
+
+		if interval < MIN_POLL_INTERVAL {
+			return fmt.Errorf("%w: pollInterval must be at least %s", ErrInvalidConfig, MIN_POLL_INTERVAL)
+		}
+	}
+
+	if c.Subdir == "" {
+		return nil
+	}
+
+	subdir := path.Clean(c.Subdir)
+	if path.IsAbs(subdir) || subdir == ".." || strings.HasPrefix(subdir, "../") {
+		return fmt.Errorf("%w: subdir must be a relative path in the repo: %s", ErrInvalidConfig, c.Subdir)
+	}
+
+	if subdir == "." {
+		subdir = ""
+	}
+
+	c.Subdir = subdir
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package core
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/metrics"
+)
+
+const (
+	STREAM_STDOUT = "stdout"
+	STREAM_STDERR = "stderr"
+)
+
+// Outcomes of deploys, as counted in AppMetrics.Deploys.
+const (
+	DEPLOY_SUCCEEDED = "succeeded"
+	DEPLOY_UNCHANGED = "unchanged"
+	DEPLOY_REJECTED  = "rejected"
+	DEPLOY_FAILED    = "failed"
+)
+
+// AppMetrics are the counters of an app since go-runner started, and the usage of its process if it is running.
+type AppMetrics struct {
+	State State
+	PID   int
+	// Proc is nil if the app is not running, or its usage can't be read. It sums up the children of the process
+	Proc     *ProcStat
+	Restarts int
+	// Requests proxied to the app, by status code
+	Requests        map[int]uint64
+	RequestDuration metrics.HistogramSnapshot
+	BytesIn         uint64
+	BytesOut        uint64
+	BuildDuration   metrics.HistogramSnapshot
+	// Deploys by outcome, i.e. DEPLOY_SUCCEEDED, DEPLOY_UNCHANGED, DEPLOY_REJECTED or DEPLOY_FAILED
+	Deploys map[string]uint64
+	// LogLines and LogDropped by stream. A line is dropped for every client streaming the logs that can't keep up.
+	LogLines   map[string]uint64
+	LogDropped map[string]uint64
+}
+
+// appMetrics counts what goes on with an app. It has its own lock, so that proxied requests don't wait for the app.
+type appMetrics struct {
+	sync.Mutex
+	requests   map[int]uint64
+	bytesIn    uint64
+	bytesOut   uint64
+	deploys    map[string]uint64
+	logLines   map[string]uint64
+	logDropped map[string]uint64
+	latency    *metrics.Histogram
+	builds     *metrics.Histogram
+}
+
+func (m *appMetrics) init() {
+	if m.requests == nil {
+		m.requests = make(map[int]uint64)
+		m.deploys = make(map[string]uint64)
+		m.logLines = make(map[string]uint64)
+		m.logDropped = make(map[string]uint64)
+		m.latency = metrics.NewHistogram(metrics.LATENCY_BUCKETS)
+		m.builds = metrics.NewHistogram(metrics.BUILD_BUCKETS)
+	}
+}
+
+func (m *appMetrics) request(code int, in, out uint64, d time.Duration) {
+	m.Lock()
+	m.init()
+	m.requests[code]++
+	m.bytesIn += in
+	m.bytesOut += out
+	latency := m.latency
+	m.Unlock()
+
+	latency.Observe(d.Seconds())
+}
+
+func (m *appMetrics) build(d time.Duration) {
+	m.Lock()
+	m.init()
+	builds := m.builds
+	m.Unlock()
+
+	builds.Observe(d.Seconds())
+}
+
+func (m *appMetrics) deploy(outcome string) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.init()
+	m.deploys[outcome]++
+}
+
+func (m *appMetrics) logLine(stream string, dropped int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.init()
+	m.logLines[stream]++
+	m.logDropped[stream] += uint64(dropped)
+}
+
+func (m *appMetrics) snapshot(s *AppMetrics) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.init()
+	s.Requests = make(map[int]uint64, len(m.requests))
+	for code, n := range m.requests {
+		s.Requests[code] = n
+	}
+
+	s.Deploys = copyCounts(m.deploys)
+	s.LogLines = copyCounts(m.logLines)
+	s.LogDropped = copyCounts(m.logDropped)
+	s.BytesIn, s.BytesOut = m.bytesIn, m.bytesOut
+	s.RequestDuration = m.latency.Snapshot()
+	s.BuildDuration = m.builds.Snapshot()
+}
+
+func copyCounts(counts map[string]uint64) map[string]uint64 {
+	c := make(map[string]uint64, len(counts))
+	for k, n := range counts {
+		c[k] = n
+	}
+
+	return c
+}
+
+// Metrics returns the counters of the app, and the usage of its process read from /proc.
+func (a *GoApp) Metrics() AppMetrics {
+	a.Lock()
+	m := AppMetrics{State: a.currentState(), Restarts: a.restarts}
+	if a.proc != nil && m.State == STATE_RUNNING {
+		m.PID = a.proc.Status().PID
+	}
+	a.Unlock()
+
+	a.metrics.snapshot(&m)
+
+	if m.PID > 0 {
+		stat, err := readProcTreeStat(m.PID)
+		if err == nil {
+			m.Proc = &stat
+		}
+	}
+
+	return m
+}
+
+// responseRecorder records the status code and the size of a proxied response.
+type responseRecorder struct {
+	http.ResponseWriter
+	code    int
+	written uint64
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.written += uint64(n)
+
+	return n, err
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets websockets through the proxy.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	return h.Hijack()
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// countingReader counts the bytes of a request body read by the proxy.
+type countingReader struct {
+	io.ReadCloser
+	read uint64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.read += uint64(n)
+
+	return n, err
+}
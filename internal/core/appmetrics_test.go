@@ -0,0 +1,98 @@
+package core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+func TestProxiedRequestsAreCounted(t *testing.T) {
+	expect := util.NewExpect(t)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Path != "/greeting" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write([]byte("hello, " + string(body)))
+	}))
+	defer backend.Close()
+
+	target, _ := url.Parse(backend.URL)
+	app := &GoApp{Name: "hello", AppDir: t.TempDir(), log: &log.Logger}
+	app.proxy.Store(httputil.NewSingleHostReverseProxy(target))
+
+	for _, path := range []string{"/hello/greeting", "/hello/greeting", "/hello/nope"} {
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader("world")))
+	}
+
+	m := app.Metrics()
+	expect.Equal(map[int]uint64{200: 2, 404: 1}, m.Requests)
+	expect.Equal(uint64(15), m.BytesIn)
+	expect.Equal(uint64(2*len("hello, world")+len("404 page not found\n")), m.BytesOut)
+	expect.Equal(uint64(3), m.RequestDuration.Count)
+	expect.Nil(m.Proc)
+}
+
+func TestReadProcStat(t *testing.T) {
+	expect := util.NewExpect(t)
+
+	stat, err := parseProcStat("42 (my (odd) app) S 1 42 42 0 -1 4194560 100 0 0 0 250 50 0 0 20 0 8 0 1234 1000000 300 18446744073709551615")
+	expect.Nil(err)
+	expect.Equal(3.0, stat.CPUSeconds)
+	expect.Equal(int64(300*os.Getpagesize()), stat.RSS)
+	expect.Equal(8, stat.Threads)
+
+	_, err = parseProcStat("42 (truncated) S 1")
+	expect.True(err != nil)
+
+	stat, err = ReadProcStat(os.Getpid())
+	expect.Nil(err)
+	expect.True(stat.RSS > 0)
+}
+
+// TestProxyDoesNotWaitForTheLock proxies while the app is locked, as it is for the whole of a clone or a build.
+func TestProxyDoesNotWaitForTheLock(t *testing.T) {
+	expect := util.NewExpect(t)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer backend.Close()
+
+	target, _ := url.Parse(backend.URL)
+	app := &GoApp{Name: "hello", AppDir: t.TempDir(), log: &log.Logger}
+	app.proxy.Store(httputil.NewSingleHostReverseProxy(target))
+	app.register("registered for test")
+	expect.Nil(app.transition(STATE_STARTING, "starting for test"))
+	expect.Nil(app.transition(STATE_RUNNING, "running for test"))
+
+	app.Lock()
+	defer app.Unlock()
+
+	done := make(chan string)
+	go func() {
+		rec := httptest.NewRecorder()
+		if app.State() == STATE_RUNNING {
+			app.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hello/greeting", nil))
+		}
+		done <- rec.Body.String()
+	}()
+
+	select {
+	case body := <-done:
+		expect.Equal("hello", body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request waited for the lock of the app")
+	}
+}
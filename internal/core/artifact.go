@@ -0,0 +1,161 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+const artifactUploadFile = ".artifact"
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	elfMagic  = []byte{0x7f, 'E', 'L', 'F'}
+)
+
+// UnpackArtifact stores a prebuilt Linux binary, or a gzipped tarball of a binary and its assets, as a new release
+// and makes it the current release. checksum is the sha256 of the upload in hex, optionally prefixed with "sha256:".
+// binary is the path of the executable in the tarball, default to the app name. It is ignored for a plain binary.
+// Like Rebuild, the app is not locked while the upload is stored.
+func (a *GoApp) UnpackArtifact(src io.Reader, checksum, binary string) error {
+	a.Lock()
+	a.preparing(STATE_CLONING, "unpacking uploaded artifact")
+
+	expected := strings.ToLower(strings.TrimPrefix(checksum, "sha256:"))
+	if expected == "" {
+		defer a.Unlock()
+		return a.releaseFailed("artifact", errors.New("sha256 checksum is required"))
+	}
+
+	rel, err := a.newRelease(SOURCE_ARTIFACT)
+	if err != nil {
+		defer a.Unlock()
+		return a.releaseFailed("release", err)
+	}
+	a.Unlock()
+
+	err = a.unpackArtifact(rel, src, expected, binary)
+	if err != nil {
+		return a.dropRelease(rel, "artifact", err)
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	err = a.addRelease(rel)
+	if err != nil {
+		return a.releaseFailed("release", err)
+	}
+
+	return nil
+}
+
+func (a *GoApp) unpackArtifact(rel *Release, src io.Reader, expected, binary string) error {
+	dir := a.releaseDir(rel)
+	uploaded := path.Join(dir, artifactUploadFile)
+
+	actual, err := saveWithSha256(uploaded, src)
+	if err != nil {
+		return err
+	}
+
+	if actual != expected {
+		return fmt.Errorf("%w: expected=%s, actual=%s", ErrChecksumMismatch, expected, actual)
+	}
+
+	rel.Checksum = "sha256:" + actual
+
+	f, err := os.Open(uploaded)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header, err := bufio.NewReader(f).Peek(len(elfMagic))
+	if err != nil {
+		return fmt.Errorf("artifact too small: %w", err)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, elfMagic):
+		rel.Binary = a.Name
+		err = os.Rename(uploaded, path.Join(dir, rel.Binary))
+	case bytes.HasPrefix(header, gzipMagic):
+		if binary == "" {
+			binary = a.Name
+		}
+
+		rel.Binary = filepath.Clean(binary)
+		if filepath.IsAbs(rel.Binary) || strings.HasPrefix(rel.Binary, "..") {
+			return fmt.Errorf("binary must be a relative path in the tarball: %s", binary)
+		}
+
+		err = util.UntarGz(f, dir)
+		if err == nil {
+			err = os.Remove(uploaded)
+		}
+	default:
+		return errors.New("artifact is neither a Linux binary nor a gzipped tarball")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return checkBinary(path.Join(dir, rel.Binary))
+}
+
+func saveWithSha256(file string, src io.Reader) (string, error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0660)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), src)
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// checkBinary makes sure exe is a Linux binary and is executable.
+func checkBinary(exe string) error {
+	f, err := os.Open(exe)
+	if err != nil {
+		return fmt.Errorf("binary not found in artifact: %w", err)
+	}
+	defer f.Close()
+
+	header := make([]byte, len(elfMagic))
+	_, err = io.ReadFull(f, header)
+	if err != nil || !bytes.Equal(header, elfMagic) {
+		return fmt.Errorf("%s is not a Linux binary", path.Base(exe))
+	}
+
+	return os.Chmod(exe, 0750)
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+// anElf returns the test binary itself, which is as good a Linux binary as any.
+func anElf(t *testing.T) []byte {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return content
+}
+
+func sha256Of(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUnpackArtifactBinary(t *testing.T) {
+	expect := util.NewExpect(t)
+	goapp := &GoApp{Name: "prebuilt", AppDir: path.Join(t.TempDir(), "prebuilt"), log: &log.Logger}
+	binary := anElf(t)
+
+	err := goapp.UnpackArtifact(bytes.NewReader(binary), "sha256:"+sha256Of([]byte("something else")), "")
+	expect.True(errors.Is(err, ErrChecksumMismatch))
+	expect.Equal(0, len(goapp.Releases()))
+
+	err = goapp.UnpackArtifact(bytes.NewReader(binary), sha256Of(binary), "")
+	expect.Nil(err)
+	expect.Equal(SOURCE_ARTIFACT, goapp.current.Source)
+	expect.Equal("prebuilt", goapp.current.Binary)
+	expect.Equal("sha256:"+sha256Of(binary), goapp.current.Checksum)
+
+	info, err := os.Stat(path.Join(goapp.releaseDir(goapp.current), "prebuilt"))
+	expect.Nil(err)
+	expect.True(info.Mode()&0100 != 0, "binary should be executable")
+}
+
+func TestUnpackArtifactTarballAndRollback(t *testing.T) {
+	expect := util.NewExpect(t)
+	goapp := &GoApp{Name: "prebuilt", AppDir: path.Join(t.TempDir(), "prebuilt"), log: &log.Logger}
+
+	src := t.TempDir()
+	expect.Nil(os.MkdirAll(path.Join(src, "bin"), 0755))
+	expect.Nil(ioutil.WriteFile(path.Join(src, "bin", "server"), anElf(t), 0644))
+	expect.Nil(ioutil.WriteFile(path.Join(src, "index.html"), []byte("hello"), 0644))
+
+	var tarball bytes.Buffer
+	expect.Nil(util.TarGz(&tarball, src, nil))
+	checksum := sha256Of(tarball.Bytes())
+
+	expect.Nil(goapp.UnpackArtifact(bytes.NewReader(tarball.Bytes()), checksum, "bin/server"))
+	expect.Equal("bin/server", goapp.current.Binary)
+	_, err := os.Stat(path.Join(goapp.releaseDir(goapp.current), "index.html"))
+	expect.Nil(err)
+
+	err = goapp.UnpackArtifact(bytes.NewReader(tarball.Bytes()), checksum, "bin/missing")
+	expect.True(err != nil)
+
+	expect.Nil(goapp.UnpackArtifact(bytes.NewReader(tarball.Bytes()), checksum, "bin/server"))
+	expect.Equal(2, goapp.current.ID)
+
+	expect.Nil(goapp.Rollback(0))
+	expect.Equal(1, goapp.current.ID)
+	expect.True(goapp.Rollback(0) != nil)
+	expect.Nil(goapp.Rollback(2))
+	expect.Equal(2, goapp.current.ID)
+}
@@ -0,0 +1,559 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// CGROUP_AUTO puts the apps under the cgroup of go-runner, which moves itself into a leaf of it to delegate
+	// the controllers. The cgroup has to be delegated to go-runner, e.g. with Delegate=yes in its systemd unit.
+	CGROUP_AUTO = "auto"
+	// CGROUP_SELF is the leaf go-runner moves itself into with CGROUP_AUTO.
+	CGROUP_SELF = "go-runner"
+	// CPU_PERIOD is the period of cpu.max in microseconds.
+	CPU_PERIOD = 100000
+)
+
+// CGROUP_CONTROLLERS are the controllers the limits of the apps need.
+var CGROUP_CONTROLLERS = []string{"cpu", "memory", "pids", "io"}
+
+// Limits are the resources an app may use, applied with cgroup v2. Empty ones are not limited.
+type Limits struct {
+	// CPU is how many cores the app may use, e.g. 0.5, written to cpu.max
+	CPU float64 `json:"cpu,omitempty"`
+	// Memory is the most memory the app may use, e.g. "512M", beyond which it is OOM killed, written to memory.max
+	Memory string `json:"memory,omitempty"`
+	// MemoryHigh is the memory beyond which the app is throttled and reclaimed from, written to memory.high
+	MemoryHigh string `json:"memoryHigh,omitempty"`
+	// Pids is how many processes and threads the app may have, written to pids.max
+	Pids int `json:"pids,omitempty"`
+	// IOWeight is the share of storage I/O of the app against the others, 1 to 10000, 100 by default,
+	// written to io.weight
+	IOWeight int `json:"ioWeight,omitempty"`
+}
+
+func (l *Limits) validate() error {
+	if l == nil {
+		return nil
+	}
+
+	if l.CPU < 0 || (l.CPU > 0 && l.CPU*CPU_PERIOD < 1000) {
+		return fmt.Errorf("%w: limits.cpu must be at least 0.01", ErrInvalidConfig)
+	}
+
+	for name, size := range map[string]string{"memory": l.Memory, "memoryHigh": l.MemoryHigh} {
+		if size == "" {
+			continue
+		}
+
+		if _, err := parseSize(size); err != nil {
+			return fmt.Errorf("%w: limits.%s: %s", ErrInvalidConfig, name, err)
+		}
+	}
+
+	if l.Pids < 0 {
+		return fmt.Errorf("%w: limits.pids must not be negative", ErrInvalidConfig)
+	}
+
+	if l.IOWeight < 0 || l.IOWeight > 10000 {
+		return fmt.Errorf("%w: limits.ioWeight must be 1 to 10000", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// parseSize parses a size in bytes with an optional K, M, G or T suffix, powers of 1024.
+func parseSize(s string) (int64, error) {
+	// 512M, 512MB and 512MiB are all the same
+	n := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+
+	multiplier := int64(1)
+	if unit := strings.IndexAny(n, "KMGT"); unit >= 0 && unit == len(n)-1 {
+		multiplier = 1 << (10 * (strings.IndexByte("KMGT", n[unit]) + 1))
+		n = n[:unit]
+	}
+
+	size, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 512M", s)
+	}
+
+	return size * multiplier, nil
+}
+
+// CgroupsStatus tells if the processes of apps are put into cgroups, and why not.
+type CgroupsStatus struct {
+	Enabled     bool     `json:"enabled"`
+	Parent      string   `json:"parent,omitempty"`
+	Controllers []string `json:"controllers,omitempty"`
+	Reason      string   `json:"reason,omitempty"`
+}
+
+// Cgroups puts the process of every app into a cgroup v2 of its own, <parent>/<app>/<run>, with the limits of the app
+// applied to <parent>/<app>. A nil or disabled Cgroups puts no process anywhere, so go-runner works where cgroups
+// are not delegated to it.
+type Cgroups struct {
+	sync.Mutex
+	parent      string
+	controllers map[string]bool
+	// err is why cgroups are disabled
+	err  error
+	runs int
+	log  *zerolog.Logger
+}
+
+// setupCgroups prepares parent, a cgroup dir or a path relative to the cgroup v2 mount, or CGROUP_AUTO,
+// to hold the cgroups of the apps. Cgroups are disabled if parent is empty or can't be set up.
+func setupCgroups(parent string, log *zerolog.Logger) *Cgroups {
+	cg := &Cgroups{log: log}
+	if parent == "" {
+		cg.err = errors.New("no cgroup parent given")
+		return cg
+	}
+
+	cg.parent, cg.controllers, cg.err = prepareParent(parent)
+	if cg.err != nil {
+		log.Warn().Err(cg.err).Msg("cgroups disabled, the limits of the apps are not applied")
+		return cg
+	}
+
+	log.Info().Msgf("apps run in cgroups. parent=%s, controllers=%v", cg.parent, cg.Status().Controllers)
+
+	return cg
+}
+
+func prepareParent(parent string) (string, map[string]bool, error) {
+	mount, err := cgroup2Mount()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if parent == CGROUP_AUTO {
+		parent, err = autoParent(mount)
+		if err != nil {
+			return "", nil, err
+		}
+	} else if !strings.HasPrefix(parent, mount+"/") {
+		parent = path.Join(mount, parent)
+	}
+
+	_, err = os.Stat(parent)
+	existed := err == nil
+	err = os.MkdirAll(parent, 0755)
+	if err != nil {
+		return "", nil, err
+	}
+
+	controllers := enableControllers(parent)
+	if len(controllers) == 0 {
+		if !existed {
+			_ = os.Remove(parent)
+		}
+
+		return "", nil, fmt.Errorf("none of the controllers %v is available in %s", CGROUP_CONTROLLERS, parent)
+	}
+
+	return parent, controllers, nil
+}
+
+// cgroup2Mount finds where the cgroup v2 hierarchy is mounted, e.g. /sys/fs/cgroup, or /sys/fs/cgroup/unified on
+// a hybrid host.
+func cgroup2Mount() (string, error) {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[2] == "cgroup2" {
+			return fields[1], nil
+		}
+	}
+
+	return "", errors.New("cgroup v2 is not mounted")
+}
+
+// autoParent moves go-runner into the CGROUP_SELF leaf of its cgroup, so that the controllers can be delegated to
+// the sibling cgroup of the apps, which it returns.
+func autoParent(mount string) (string, error) {
+	content, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+
+	var own string
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			own = strings.TrimPrefix(line, "0::")
+		}
+	}
+
+	if own == "" {
+		return "", errors.New("go-runner is not in a cgroup v2")
+	}
+
+	base, moved := path.Join(mount, own), false
+	if path.Base(base) == CGROUP_SELF {
+		// moved already, e.g. by a go-runner before a restart
+		base, moved = path.Dir(base), true
+	}
+
+	if base != mount {
+		// not to move go-runner around in a cgroup its manager keeps to itself
+		err = delegated(base)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if !moved && base != mount {
+		// a cgroup with processes can't delegate controllers, except the root one
+		self := path.Join(base, CGROUP_SELF)
+		err = os.MkdirAll(self, 0755)
+		if err != nil {
+			return "", err
+		}
+
+		err = writeCgroupFile(self, "cgroup.procs", strconv.Itoa(os.Getpid()))
+		if err != nil {
+			return "", fmt.Errorf("failed to move go-runner into %s: %w", self, err)
+		}
+	}
+
+	enableControllers(base)
+
+	return path.Join(base, APPS_DIRNAME), nil
+}
+
+// delegated makes sure the cgroup dir is delegated to go-runner: marked so by systemd, which sets the delegate xattr
+// with Delegate=yes, or owned by the user go-runner runs as along with the files that delegation gives.
+func delegated(dir string) error {
+	for _, name := range []string{"trusted.delegate", "user.delegate"} {
+		if value, err := getXattr(dir, name); err == nil && value == "1" {
+			return nil
+		}
+	}
+
+	owned := os.Geteuid() != 0
+	for _, file := range []string{dir, path.Join(dir, "cgroup.procs"), path.Join(dir, "cgroup.subtree_control")} {
+		var st syscall.Stat_t
+		if syscall.Stat(file, &st) != nil || int(st.Uid) != os.Geteuid() {
+			owned = false
+		}
+	}
+
+	if !owned {
+		return fmt.Errorf("the cgroup %s of go-runner is not delegated to it, e.g. with Delegate=yes in its systemd unit", dir)
+	}
+
+	return nil
+}
+
+// enableControllers enables what it can of CGROUP_CONTROLLERS for the children of dir, and returns which it did.
+func enableControllers(dir string) map[string]bool {
+	available, _ := ioutil.ReadFile(path.Join(dir, "cgroup.controllers"))
+	enabled := make(map[string]bool)
+	for _, c := range strings.Fields(string(available)) {
+		for _, wanted := range CGROUP_CONTROLLERS {
+			if c == wanted && writeCgroupFile(dir, "cgroup.subtree_control", "+"+c) == nil {
+				enabled[c] = true
+			}
+		}
+	}
+
+	return enabled
+}
+
+func writeCgroupFile(dir, name, content string) error {
+	return ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644)
+}
+
+func (cg *Cgroups) Status() CgroupsStatus {
+	if cg == nil {
+		return CgroupsStatus{Reason: "no cgroup parent given"}
+	}
+
+	if cg.err != nil {
+		return CgroupsStatus{Reason: cg.err.Error()}
+	}
+
+	var controllers []string
+	for _, c := range CGROUP_CONTROLLERS {
+		if cg.controllers[c] {
+			controllers = append(controllers, c)
+		}
+	}
+
+	return CgroupsStatus{Enabled: true, Parent: cg.parent, Controllers: controllers}
+}
+
+// enabled tells if the processes of apps are put into cgroups.
+func (cg *Cgroups) enabled() bool {
+	return cg != nil && cg.err == nil
+}
+
+func (cg *Cgroups) appDir(app string) string {
+	return path.Join(cg.parent, app)
+}
+
+// prepare applies limits to the cgroup of app, and creates the cgroup for a new process of it, which it returns.
+// The limits that can't be applied, for want of their controller, are returned in skipped.
+func (cg *Cgroups) prepare(app string, limits *Limits) (run string, skipped []string, err error) {
+	if !cg.enabled() {
+		if limits != nil && *limits != (Limits{}) {
+			skipped = append(skipped, "all, cgroups are disabled")
+		}
+
+		return "", skipped, nil
+	}
+
+	dir := cg.appDir(app)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// the runs of processes gone when go-runner was, if any
+	runs, _ := ioutil.ReadDir(dir)
+	for _, r := range runs {
+		if r.IsDir() {
+			_ = os.Remove(path.Join(dir, r.Name()))
+		}
+	}
+
+	for c := range enableControllers(dir) {
+		if !cg.controllers[c] {
+			cg.log.Warn().Msgf("controller %s is enabled for app %s but not the parent", c, app)
+		}
+	}
+
+	if limits == nil {
+		limits = &Limits{}
+	}
+
+	for _, l := range []struct {
+		controller, file, value string
+		limited                 bool
+	}{
+		{"cpu", "cpu.max", cpuMax(limits.CPU), limits.CPU > 0},
+		{"memory", "memory.max", sizeOrMax(limits.Memory), limits.Memory != ""},
+		{"memory", "memory.high", sizeOrMax(limits.MemoryHigh), limits.MemoryHigh != ""},
+		{"pids", "pids.max", countOrMax(limits.Pids), limits.Pids > 0},
+		{"io", "io.weight", ioWeight(limits.IOWeight), limits.IOWeight > 0},
+	} {
+		if !cg.controllers[l.controller] {
+			if l.limited {
+				skipped = append(skipped, fmt.Sprintf("%s, no %s controller", l.file, l.controller))
+			}
+
+			continue
+		}
+
+		err = writeCgroupFile(dir, l.file, l.value)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to limit %s of app %s: %w", l.file, app, err)
+		}
+	}
+
+	cg.Lock()
+	cg.runs++
+	run = path.Join(dir, fmt.Sprintf("run-%d-%d", time.Now().Unix(), cg.runs))
+	cg.Unlock()
+
+	return run, skipped, os.Mkdir(run, 0755)
+}
+
+func cpuMax(cpu float64) string {
+	if cpu <= 0 {
+		return fmt.Sprintf("max %d", CPU_PERIOD)
+	}
+
+	return fmt.Sprintf("%d %d", int64(cpu*CPU_PERIOD), CPU_PERIOD)
+}
+
+func sizeOrMax(size string) string {
+	bytes, err := parseSize(size)
+	if err != nil {
+		return "max"
+	}
+
+	return strconv.FormatInt(bytes, 10)
+}
+
+func countOrMax(n int) string {
+	if n <= 0 {
+		return "max"
+	}
+
+	return strconv.Itoa(n)
+}
+
+func ioWeight(weight int) string {
+	if weight <= 0 {
+		weight = 100
+	}
+
+	return fmt.Sprintf("default %d", weight)
+}
+
+// oomKilled tells if the OOM killer killed a process in the cgroup run.
+func oomKilled(run string) bool {
+	if run == "" {
+		return false
+	}
+
+	events, err := readKeyValues(path.Join(run, "memory.events"))
+
+	return err == nil && events["oom_kill"] > 0
+}
+
+// release removes the cgroup run of a process that exited. Its leftover processes, if any, are killed first.
+func (cg *Cgroups) release(run string) {
+	if run == "" {
+		return
+	}
+
+	err := os.Remove(run)
+	if err == nil || os.IsNotExist(err) {
+		return
+	}
+
+	// cgroup.kill is there since linux 5.14
+	_ = writeCgroupFile(run, "cgroup.kill", "1")
+	for i := 0; i < 10; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if os.Remove(run) == nil {
+			return
+		}
+	}
+
+	cg.log.Warn().Err(err).Msgf("failed to remove cgroup %s", run)
+}
+
+// remove removes the cgroup of a deleted app.
+func (cg *Cgroups) remove(app string) {
+	if !cg.enabled() {
+		return
+	}
+
+	dir := cg.appDir(app)
+	runs, _ := ioutil.ReadDir(dir)
+	for _, r := range runs {
+		if r.IsDir() {
+			cg.release(path.Join(dir, r.Name()))
+		}
+	}
+
+	_ = os.Remove(dir)
+}
+
+// CgroupUsage is the usage of an app read from its cgroup. The counters are since the cgroup was created.
+type CgroupUsage struct {
+	Path             string  `json:"path"`
+	CPUSeconds       float64 `json:"cpuSeconds"`
+	ThrottledSeconds float64 `json:"throttledSeconds"`
+	Memory           int64   `json:"memory"`
+	MemoryMax        string  `json:"memoryMax,omitempty"`
+	Pids             int     `json:"pids"`
+	// MemoryHighEvents is how many times the app was throttled over memory.high,
+	// MemoryMaxEvents how many times it hit memory.max
+	MemoryHighEvents int64 `json:"memoryHighEvents"`
+	MemoryMaxEvents  int64 `json:"memoryMaxEvents"`
+	OOMKills         int64 `json:"oomKills"`
+}
+
+// usage reads the usage of the cgroup of app. The files of the controllers not enabled are skipped.
+func (cg *Cgroups) usage(app string) (*CgroupUsage, error) {
+	if !cg.enabled() {
+		return nil, nil
+	}
+
+	dir := cg.appDir(app)
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	u := &CgroupUsage{Path: dir}
+	if stat, err := readKeyValues(path.Join(dir, "cpu.stat")); err == nil {
+		u.CPUSeconds = float64(stat["usage_usec"]) / 1e6
+		u.ThrottledSeconds = float64(stat["throttled_usec"]) / 1e6
+	}
+
+	u.Memory, _ = readCgroupInt(path.Join(dir, "memory.current"))
+	if max, err := ioutil.ReadFile(path.Join(dir, "memory.max")); err == nil {
+		u.MemoryMax = strings.TrimSpace(string(max))
+	}
+
+	pids, _ := readCgroupInt(path.Join(dir, "pids.current"))
+	u.Pids = int(pids)
+
+	if events, err := readKeyValues(path.Join(dir, "memory.events")); err == nil {
+		u.MemoryHighEvents, u.MemoryMaxEvents, u.OOMKills = events["high"], events["max"], events["oom_kill"]
+	}
+
+	return u, nil
+}
+
+// CgroupUsage returns the usage of the app read from its cgroup, or nil if it has none.
+func (a *GoApp) CgroupUsage() *CgroupUsage {
+	a.Lock()
+	defer a.Unlock()
+
+	return a.cgroupUsage()
+}
+
+func (a *GoApp) cgroupUsage() *CgroupUsage {
+	u, err := a.cgroups.usage(a.Name)
+	if err != nil {
+		return nil
+	}
+
+	return u
+}
+
+func readCgroupInt(file string) (int64, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+}
+
+// readKeyValues reads a flat keyed cgroup file like memory.events, one "key value" per line.
+func readKeyValues(file string) (map[string]int64, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	kv := make(map[string]int64)
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		v, err := strconv.ParseInt(fields[1], 10, 64)
+		if err == nil {
+			kv[fields[0]] = v
+		}
+	}
+
+	return kv, nil
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+func TestLimitsAreValidated(t *testing.T) {
+	expect := util.NewExpect(t)
+
+	for size, bytes := range map[string]int64{"512": 512, "64k": 64 << 10, "512M": 512 << 20, "1GiB": 1 << 30, "2GB": 2 << 30} {
+		n, err := parseSize(size)
+		expect.Nil(err)
+		expect.Equal(bytes, n)
+	}
+
+	for _, invalid := range []Limits{{CPU: 0.001}, {CPU: -1}, {Memory: "lots"}, {MemoryHigh: "M"}, {Pids: -1}, {IOWeight: 10001}} {
+		config := AppConfig{Limits: &invalid}
+		expect.True(errors.Is(config.validate(), ErrInvalidConfig), invalid)
+	}
+
+	config := AppConfig{Limits: &Limits{CPU: 1.5, Memory: "1G", MemoryHigh: "800M", Pids: 64, IOWeight: 200}}
+	expect.Nil(config.validate())
+}
+
+func readCgroupFile(t *testing.T, dir, name string) string {
+	content, err := ioutil.ReadFile(path.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.TrimSpace(string(content))
+}
+
+// TestAppRunsInItsCgroup launches an app into a dir standing in for a cgroup v2 parent with no io controller.
+func TestAppRunsInItsCgroup(t *testing.T) {
+	expect := util.NewExpect(t)
+	parent := t.TempDir()
+	cgroups := &Cgroups{parent: parent, controllers: map[string]bool{"cpu": true, "memory": true, "pids": true}, log: &log.Logger}
+	goapp := &GoApp{Name: "prebuilt", AppDir: path.Join(t.TempDir(), "prebuilt"), cgroups: cgroups, log: &log.Logger}
+	binary := anElf(t)
+
+	expect.Nil(goapp.SetConfig(AppConfig{Limits: &Limits{CPU: 0.5, Memory: "64M", Pids: 100, IOWeight: 50}}))
+	expect.Nil(goapp.UnpackArtifact(bytes.NewReader(binary), sha256Of(binary), ""))
+	expect.Nil(goapp.Start())
+
+	dir := path.Join(parent, "prebuilt")
+	expect.Equal("50000 100000", readCgroupFile(t, dir, "cpu.max"))
+	expect.Equal("67108864", readCgroupFile(t, dir, "memory.max"))
+	expect.Equal("max", readCgroupFile(t, dir, "memory.high"))
+	expect.Equal("100", readCgroupFile(t, dir, "pids.max"))
+
+	events := goapp.Events()
+	expect.True(strings.HasSuffix(events[len(events)-1].Reason, "limits not applied: io.weight, no io controller"))
+
+	runs, err := filepath.Glob(path.Join(dir, "run-*"))
+	expect.Nil(err)
+	expect.Equal(1, len(runs))
+
+	pid := goapp.proc.Status().PID
+	expect.Equal(strconv.Itoa(pid), readCgroupFile(t, runs[0], "cgroup.procs"))
+	expect.Equal(dir, goapp.CgroupUsage().Path)
+
+	// as the kernel does when the app goes over memory.max
+	expect.Nil(ioutil.WriteFile(path.Join(runs[0], "memory.events"), []byte("oom 1\noom_kill 1\n"), 0644))
+	expect.Nil(syscall.Kill(pid, syscall.SIGKILL))
+	for i := 0; i < 500 && goapp.State() != STATE_CRASHED; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	expect.Equal(STATE_CRASHED, goapp.State())
+	events = goapp.Events()
+	expect.Equal("killed by the OOM killer, exited: signal: killed", events[len(events)-1].Reason)
+}
+
+func TestCgroupsDegrade(t *testing.T) {
+	expect := util.NewExpect(t)
+
+	cgroups := setupCgroups("", &log.Logger)
+	expect.True(!cgroups.Status().Enabled)
+
+	run, skipped, err := cgroups.prepare("hello", &Limits{Memory: "1G"})
+	expect.Nil(err)
+	expect.Equal("", run)
+	expect.Equal([]string{"all, cgroups are disabled"}, skipped)
+
+	run, skipped, err = cgroups.prepare("hello", nil)
+	expect.Nil(err)
+	expect.Equal("", run)
+	expect.Equal(0, len(skipped))
+
+	var none *Cgroups
+	expect.True(!none.Status().Enabled)
+	none.release("")
+	none.remove("hello")
+}
+
+func TestCgroupOfGoRunnerMustBeDelegated(t *testing.T) {
+	expect := util.NewExpect(t)
+	dir := t.TempDir()
+	for _, file := range []string{"cgroup.procs", "cgroup.subtree_control"} {
+		expect.Nil(ioutil.WriteFile(path.Join(dir, file), nil, 0644))
+	}
+
+	if os.Geteuid() != 0 {
+		// owned by go-runner, as delegated to a user
+		expect.Nil(delegated(dir))
+		return
+	}
+
+	err := delegated(dir)
+	expect.True(err != nil && strings.Contains(err.Error(), "Delegate=yes"), err)
+
+	// as marked by systemd
+	if err := syscall.Setxattr(dir, "user.delegate", []byte("1"), 0); err != nil {
+		t.Skip(err)
+	}
+	expect.Nil(delegated(dir))
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// RLIMIT_NPROC is missing from package syscall.
+const RLIMIT_NPROC = 0x6
+
+// PR_SET_NO_NEW_PRIVS is the prctl that keeps a process and its children from gaining privileges, see prctl(2).
+const PR_SET_NO_NEW_PRIVS = 38
+
+var rlimitResources = map[string]int{
+	"nofile": syscall.RLIMIT_NOFILE,
+	"nproc":  RLIMIT_NPROC,
+	"core":   syscall.RLIMIT_CORE,
+	"as":     syscall.RLIMIT_AS,
+	"cpu":    syscall.RLIMIT_CPU,
+}
+
+// confine applies the rlimits and no_new_privs of spec to the process, which the app inherits.
+func confine(spec launchSpec) error {
+	for name, value := range spec.Rlimits {
+		resource, ok := rlimitResources[name]
+		if !ok {
+			return fmt.Errorf("unknown rlimit %s", name)
+		}
+
+		err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: value, Max: value})
+		if err != nil {
+			return fmt.Errorf("failed to set rlimit %s to %d: %w", name, value, err)
+		}
+	}
+
+	if spec.NoNewPrivs {
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0, 0)
+		if errno != 0 {
+			return fmt.Errorf("failed to set no_new_privs: %w", errno)
+		}
+	}
+
+	return nil
+}
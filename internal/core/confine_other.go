@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package core
+
+import "errors"
+
+// confine does nothing where there is no no_new_privs, and fails if there are rlimits, which are only applied on Linux.
+func confine(spec launchSpec) error {
+	if len(spec.Rlimits) > 0 {
+		return errors.New("rlimits are only supported on Linux")
+	}
+
+	return nil
+}
@@ -0,0 +1,488 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	CREDENTIALS_FILE = "credentials.json"
+	KNOWN_HOSTS_FILE = "known_hosts"
+)
+
+const (
+	CREDENTIAL_SSH     = "ssh"
+	CREDENTIAL_TOKEN   = "token"
+	CREDENTIAL_WEBHOOK = "webhook"
+)
+
+var (
+	ErrCredentialNotFound = errors.New("credential not found")
+	ErrCredentialExists   = errors.New("credential with the same name already exists")
+	ErrInvalidCredential  = errors.New("invalid credential")
+)
+
+// Credential authenticates the git clones of one app, or of all the apps cloning from a host.
+// A webhook credential is the secret of the push webhooks of an app instead.
+// It never carries the secrets, so it is safe to show.
+type Credential struct {
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	App       string    `json:"app,omitempty"`
+	Host      string    `json:"host,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	PublicKey string    `json:"publicKey,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CredentialSpec is what it takes to add a Credential.
+// An ssh credential without a PrivateKey gets a newly generated ed25519 deploy key.
+type CredentialSpec struct {
+	Name       string
+	Type       string
+	App        string
+	Host       string
+	Username   string
+	PrivateKey string
+	Passphrase string
+	Token      string
+}
+
+// storedCredential is a Credential with its secrets, as persisted in CREDENTIALS_FILE.
+type storedCredential struct {
+	Credential
+	PrivateKey string `json:"privateKey,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+	Token      string `json:"token,omitempty"`
+}
+
+// CredentialStore keeps the credentials in the working dir. The file is only readable by go-runner.
+// ssh host keys are verified against the KNOWN_HOSTS_FILE in the working dir, and the usual known_hosts files.
+type CredentialStore struct {
+	sync.Mutex
+	file       string
+	knownHosts []string
+	creds      []*storedCredential
+	loaded     bool
+}
+
+func NewCredentialStore(wd string) *CredentialStore {
+	knownHosts := []string{path.Join(wd, KNOWN_HOSTS_FILE)}
+	if home, err := os.UserHomeDir(); err == nil {
+		knownHosts = append(knownHosts, path.Join(home, ".ssh", "known_hosts"))
+	}
+
+	knownHosts = append(knownHosts, "/etc/ssh/ssh_known_hosts")
+
+	return &CredentialStore{
+		file:       path.Join(wd, CREDENTIALS_FILE),
+		knownHosts: knownHosts,
+	}
+}
+
+// List returns all the credentials ordered by name.
+func (s *CredentialStore) List() ([]Credential, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Credential, 0, len(s.creds))
+	for _, c := range s.creds {
+		list = append(list, c.Credential)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list, nil
+}
+
+func (s *CredentialStore) Get(name string) (Credential, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	err := s.load()
+	if err != nil {
+		return Credential{}, err
+	}
+
+	c := s.find(name)
+	if c == nil {
+		return Credential{}, fmt.Errorf("%w: %s", ErrCredentialNotFound, name)
+	}
+
+	return c.Credential, nil
+}
+
+// Add validates and saves a new credential.
+func (s *CredentialStore) Add(spec CredentialSpec) (Credential, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	err := s.load()
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if s.find(spec.Name) != nil {
+		return Credential{}, fmt.Errorf("%w: %s", ErrCredentialExists, spec.Name)
+	}
+
+	c, err := newStoredCredential(spec)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	s.creds = append(s.creds, c)
+	err = s.save()
+	if err != nil {
+		s.creds = s.creds[:len(s.creds)-1]
+		return Credential{}, err
+	}
+
+	return c.Credential, nil
+}
+
+func (s *CredentialStore) Delete(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	err := s.load()
+	if err != nil {
+		return err
+	}
+
+	kept := make([]*storedCredential, 0, len(s.creds))
+	for _, c := range s.creds {
+		if c.Name != name {
+			kept = append(kept, c)
+		}
+	}
+
+	if len(kept) == len(s.creds) {
+		return fmt.Errorf("%w: %s", ErrCredentialNotFound, name)
+	}
+
+	s.creds = kept
+
+	return s.save()
+}
+
+// DeleteApp deletes the credentials of app, so that an app registered later with the same name doesn't get them.
+// Those of hosts are kept.
+func (s *CredentialStore) DeleteApp(app string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	err := s.load()
+	if err != nil {
+		return err
+	}
+
+	kept := make([]*storedCredential, 0, len(s.creds))
+	for _, c := range s.creds {
+		if c.App != app {
+			kept = append(kept, c)
+		}
+	}
+
+	if len(kept) == len(s.creds) {
+		return nil
+	}
+
+	s.creds = kept
+
+	return s.save()
+}
+
+// AuthFor returns the auth for app to clone gitURL.
+// A credential for the app comes before one for the host of gitURL, and only those of the right type for the protocol count.
+// ssh urls without a credential fall back to the keys in ~/.ssh or the ssh agent. Other urls without one need no auth.
+func (s *CredentialStore) AuthFor(app, gitURL string) (transport.AuthMethod, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	ep, err := transport.NewEndpoint(gitURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ep.Protocol {
+	case "ssh":
+		return s.sshAuth(app, ep)
+	case "http", "https":
+		c := s.match(app, ep.Host, CREDENTIAL_TOKEN)
+		if c == nil {
+			return nil, nil
+		}
+
+		return &githttp.BasicAuth{Username: orDefault(c.Username, "git"), Password: c.Token}, nil
+	}
+
+	return nil, nil
+}
+
+// WebhookSecret returns the secret to verify the push webhooks of app with. It is false if the app has none.
+func (s *CredentialStore) WebhookSecret(app string) (string, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	err := s.load()
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, c := range s.creds {
+		if c.Type == CREDENTIAL_WEBHOOK && c.App == app {
+			return c.Token, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+func (s *CredentialStore) sshAuth(app string, ep *transport.Endpoint) (transport.AuthMethod, error) {
+	hostKeyCallback, err := gitssh.NewKnownHostsCallback(s.knownHosts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot verify ssh host keys, add them to %s: %w", s.knownHosts[0], err)
+	}
+
+	c := s.match(app, ep.Host, CREDENTIAL_SSH)
+	if c == nil {
+		auth, err := util.GetGitAuth()
+		if err != nil {
+			return nil, err
+		}
+
+		switch auth := auth.(type) {
+		case *gitssh.PublicKeys:
+			auth.HostKeyCallback = hostKeyCallback
+		case *gitssh.PublicKeysCallback:
+			auth.HostKeyCallback = hostKeyCallback
+		}
+
+		return auth, nil
+	}
+
+	user := ep.User
+	if user == "" {
+		user = orDefault(c.Username, "git")
+	}
+
+	auth, err := gitssh.NewPublicKeys(user, []byte(c.PrivateKey), c.Passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("cannot use ssh key of credential %s: %w", c.Name, err)
+	}
+
+	auth.HostKeyCallback = hostKeyCallback
+
+	return auth, nil
+}
+
+func (s *CredentialStore) match(app, host, credType string) *storedCredential {
+	var forHost *storedCredential
+	for _, c := range s.creds {
+		if c.Type != credType {
+			continue
+		}
+
+		if c.App != "" && c.App == app {
+			return c
+		}
+
+		if forHost == nil && c.Host != "" && strings.EqualFold(c.Host, host) {
+			forHost = c
+		}
+	}
+
+	return forHost
+}
+
+func (s *CredentialStore) find(name string) *storedCredential {
+	for _, c := range s.creds {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func (s *CredentialStore) load() error {
+	if s.loaded {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.loaded = true
+			return nil
+		}
+
+		return err
+	}
+
+	err = json.Unmarshal(data, &s.creds)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", CREDENTIALS_FILE, err)
+	}
+
+	s.loaded = true
+
+	return nil
+}
+
+func (s *CredentialStore) save() error {
+	data, err := json.MarshalIndent(s.creds, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(path.Dir(s.file), 0770)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.file + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.file)
+}
+
+func newStoredCredential(spec CredentialSpec) (*storedCredential, error) {
+	if spec.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidCredential)
+	}
+
+	if (spec.App == "") == (spec.Host == "") {
+		return nil, fmt.Errorf("%w: either app or host is required, but not both", ErrInvalidCredential)
+	}
+
+	c := &storedCredential{
+		Credential: Credential{
+			Name:      spec.Name,
+			Type:      spec.Type,
+			App:       spec.App,
+			Host:      spec.Host,
+			Username:  spec.Username,
+			CreatedAt: time.Now(),
+		},
+	}
+
+	switch spec.Type {
+	case CREDENTIAL_SSH:
+		if spec.Token != "" {
+			return nil, fmt.Errorf("%w: an ssh credential takes no token", ErrInvalidCredential)
+		}
+
+		privateKey := spec.PrivateKey
+		if privateKey == "" {
+			if spec.Passphrase != "" {
+				return nil, fmt.Errorf("%w: passphrase is only for a given privateKey", ErrInvalidCredential)
+			}
+
+			var err error
+			privateKey, err = newDeployKey()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		signer, err := parsePrivateKey(privateKey, spec.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCredential, err)
+		}
+
+		c.PrivateKey = privateKey
+		c.Passphrase = spec.Passphrase
+		c.PublicKey = fmt.Sprintf("%s go-runner:%s",
+			strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), spec.Name)
+	case CREDENTIAL_TOKEN:
+		if spec.Token == "" {
+			return nil, fmt.Errorf("%w: token is required", ErrInvalidCredential)
+		}
+
+		if spec.PrivateKey != "" || spec.Passphrase != "" {
+			return nil, fmt.Errorf("%w: a token credential takes no privateKey or passphrase", ErrInvalidCredential)
+		}
+
+		c.Token = spec.Token
+	case CREDENTIAL_WEBHOOK:
+		if spec.App == "" || spec.Token == "" {
+			return nil, fmt.Errorf("%w: a webhook credential takes an app and its secret as token", ErrInvalidCredential)
+		}
+
+		if spec.PrivateKey != "" || spec.Passphrase != "" {
+			return nil, fmt.Errorf("%w: a webhook credential takes no privateKey or passphrase", ErrInvalidCredential)
+		}
+
+		c.Token = spec.Token
+	default:
+		return nil, fmt.Errorf("%w: type must be %s, %s or %s", ErrInvalidCredential, CREDENTIAL_SSH, CREDENTIAL_TOKEN, CREDENTIAL_WEBHOOK)
+	}
+
+	return c, nil
+}
+
+// newDeployKey generates an ed25519 key in PEM.
+func newDeployKey() (string, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+func parsePrivateKey(privateKey, passphrase string) (ssh.Signer, error) {
+	if passphrase == "" {
+		return ssh.ParsePrivateKey([]byte(privateKey))
+	}
+
+	return ssh.ParsePrivateKeyWithPassphrase([]byte(privateKey), []byte(passphrase))
+}
+
+func orDefault(s, defaultValue string) string {
+	if s == "" {
+		return defaultValue
+	}
+
+	return s
+}
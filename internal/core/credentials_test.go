@@ -0,0 +1,193 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCredentialsForAppComeBeforeHost(t *testing.T) {
+	expect := util.NewExpect(t)
+	wd := t.TempDir()
+	store := NewCredentialStore(wd)
+
+	forHost, err := store.Add(CredentialSpec{Name: "github", Type: CREDENTIAL_SSH, Host: "github.com"})
+	expect.Nil(err)
+	expect.True(strings.HasPrefix(forHost.PublicKey, "ssh-ed25519 "))
+	expect.True(strings.HasSuffix(forHost.PublicKey, " go-runner:github"))
+
+	forApp, err := store.Add(CredentialSpec{Name: "hello", Type: CREDENTIAL_SSH, App: "hello"})
+	expect.Nil(err)
+
+	_, err = store.Add(CredentialSpec{Name: "hello", Type: CREDENTIAL_TOKEN, Host: "github.com", Token: "t"})
+	expect.True(errors.Is(err, ErrCredentialExists))
+
+	_, err = store.Add(CredentialSpec{Name: "gitlab", Type: CREDENTIAL_TOKEN, Host: "gitlab.com", Token: "secret"})
+	expect.Nil(err)
+
+	hostKey := newHostKey(t)
+	expect.Nil(ioutil.WriteFile(path.Join(wd, KNOWN_HOSTS_FILE),
+		[]byte("github.com "+string(ssh.MarshalAuthorizedKey(hostKey))), 0600))
+
+	auth, err := store.AuthFor("hello", "git@github.com:JackKCWong/private.git")
+	expect.Nil(err)
+	expect.Equal(publicKeyOf(forApp), publicKeyOf(auth))
+
+	auth, err = store.AuthFor("other", "ssh://git@github.com/JackKCWong/private.git")
+	expect.Nil(err)
+	expect.Equal(publicKeyOf(forHost), publicKeyOf(auth))
+
+	auth, err = store.AuthFor("other", "https://gitlab.com/JackKCWong/private.git")
+	expect.Nil(err)
+	expect.Equal(&githttp.BasicAuth{Username: "git", Password: "secret"}, auth)
+
+	auth, err = store.AuthFor("other", "https://github.com/JackKCWong/public.git")
+	expect.Nil(err)
+	expect.Nil(auth)
+
+	list, err := store.List()
+	expect.Nil(err)
+	expect.Equal(3, len(list))
+	listed, _ := json.Marshal(list)
+	expect.True(!strings.Contains(string(listed), "PRIVATE KEY"))
+	expect.True(!strings.Contains(string(listed), "secret"))
+
+	info, err := os.Stat(path.Join(wd, CREDENTIALS_FILE))
+	expect.Nil(err)
+	expect.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	reloaded := NewCredentialStore(wd)
+	cred, err := reloaded.Get("github")
+	expect.Nil(err)
+	expect.Equal(forHost.PublicKey, cred.PublicKey)
+
+	expect.Nil(reloaded.Delete("hello"))
+	expect.True(errors.Is(reloaded.Delete("hello"), ErrCredentialNotFound))
+
+	auth, err = reloaded.AuthFor("hello", "git@github.com:JackKCWong/private.git")
+	expect.Nil(err)
+	expect.Equal(publicKeyOf(forHost), publicKeyOf(auth))
+}
+
+func TestAppRegisteredAgainDoesNotGetTheCredentialsOfTheDeletedOne(t *testing.T) {
+	expect := util.NewExpect(t)
+	runner := NewGoRunner(t.TempDir())
+	store := runner.Credentials()
+
+	goapp, err := runner.NewApp("hello", "git@github.com:JackKCWong/hello.git")
+	expect.Nil(err)
+	_, err = store.Add(CredentialSpec{Name: "hello-key", Type: CREDENTIAL_SSH, App: "hello"})
+	expect.Nil(err)
+	_, err = store.Add(CredentialSpec{Name: "hello-hook", Type: CREDENTIAL_WEBHOOK, App: "hello", Token: "secret"})
+	expect.Nil(err)
+	_, err = store.Add(CredentialSpec{Name: "github", Type: CREDENTIAL_TOKEN, Host: "github.com", Token: "t"})
+	expect.Nil(err)
+
+	expect.Nil(goapp.Delete())
+	runner.DeleteApp("hello")
+
+	_, err = runner.NewApp("hello", "git@github.com:someone/else.git")
+	expect.Nil(err)
+	_, ok, err := store.WebhookSecret("hello")
+	expect.Nil(err)
+	expect.True(!ok)
+
+	list, err := NewCredentialStore(runner.wd).List()
+	expect.Nil(err)
+	expect.Equal(1, len(list))
+	expect.Equal("github", list[0].Name)
+}
+
+func TestCredentialsVerifyKnownHosts(t *testing.T) {
+	expect := util.NewExpect(t)
+	wd := t.TempDir()
+	store := NewCredentialStore(wd)
+	store.knownHosts = store.knownHosts[:1]
+
+	_, err := store.Add(CredentialSpec{Name: "github", Type: CREDENTIAL_SSH, Host: "github.com"})
+	expect.Nil(err)
+
+	_, err = store.AuthFor("hello", "git@github.com:JackKCWong/private.git")
+	expect.True(err != nil, "no known_hosts to verify against")
+
+	hostKey := newHostKey(t)
+	otherKey := newHostKey(t)
+	expect.Nil(ioutil.WriteFile(path.Join(wd, KNOWN_HOSTS_FILE),
+		[]byte("github.com "+string(ssh.MarshalAuthorizedKey(hostKey))), 0600))
+
+	auth, err := store.AuthFor("hello", "git@github.com:JackKCWong/private.git")
+	expect.Nil(err)
+
+	verify := auth.(*gitssh.PublicKeys).HostKeyCallback
+	addr := &net.TCPAddr{IP: net.ParseIP("140.82.121.3"), Port: 22}
+	expect.Nil(verify("github.com:22", addr, hostKey))
+	expect.True(verify("github.com:22", addr, otherKey) != nil, "host key mismatch")
+	expect.True(verify("evil.com:22", addr, hostKey) != nil, "unknown host")
+}
+
+func TestCredentialsWithPassphrase(t *testing.T) {
+	expect := util.NewExpect(t)
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not found")
+	}
+
+	keyFile := path.Join(t.TempDir(), "id_ed25519")
+	out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "open sesame", "-f", keyFile).CombinedOutput()
+	expect.Nilf(err, "ssh-keygen: %s", string(out))
+
+	privateKey, err := ioutil.ReadFile(keyFile)
+	expect.Nil(err)
+	publicKey, err := ioutil.ReadFile(keyFile + ".pub")
+	expect.Nil(err)
+
+	store := NewCredentialStore(t.TempDir())
+	_, err = store.Add(CredentialSpec{Name: "wrong", Type: CREDENTIAL_SSH, Host: "github.com",
+		PrivateKey: string(privateKey), Passphrase: "wrong"})
+	expect.True(errors.Is(err, ErrInvalidCredential))
+
+	_, err = store.Add(CredentialSpec{Name: "missing", Type: CREDENTIAL_SSH, Host: "github.com",
+		PrivateKey: string(privateKey)})
+	expect.True(errors.Is(err, ErrInvalidCredential))
+
+	cred, err := store.Add(CredentialSpec{Name: "github", Type: CREDENTIAL_SSH, Host: "github.com",
+		PrivateKey: string(privateKey), Passphrase: "open sesame"})
+	expect.Nil(err)
+	expect.True(strings.HasPrefix(string(publicKey), cred.PublicKey[:len(cred.PublicKey)-len(" go-runner:github")]))
+}
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	key, err := newDeployKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.ParsePrivateKey([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer.PublicKey()
+}
+
+// publicKeyOf returns the public key of a credential, or of the ssh auth, in authorized_keys format without comment.
+func publicKeyOf(v interface{}) string {
+	switch v := v.(type) {
+	case Credential:
+		return strings.Join(strings.Fields(v.PublicKey)[:2], " ")
+	case *gitssh.PublicKeys:
+		return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(v.Signer.PublicKey())))
+	}
+
+	return ""
+}
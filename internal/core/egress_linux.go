@@ -0,0 +1,239 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// the cgroup BPF programs that filter the addresses sockets connect and send to, see bpf(2)
+const (
+	BPF_PROG_LOAD   = 5
+	BPF_PROG_ATTACH = 8
+	BPF_PROG_QUERY  = 16
+
+	BPF_PROG_TYPE_CGROUP_SOCK_ADDR = 18
+
+	BPF_CGROUP_INET4_CONNECT = 10
+	BPF_CGROUP_INET6_CONNECT = 11
+	BPF_CGROUP_UDP4_SENDMSG  = 14
+	BPF_CGROUP_UDP6_SENDMSG  = 15
+
+	BPF_F_QUERY_EFFECTIVE = 1
+)
+
+// the offsets of the destination in struct bpf_sock_addr, in network byte order
+const (
+	bpfSockAddrIP4  = 4
+	bpfSockAddrIP6  = 8
+	bpfSockAddrPort = 24
+)
+
+// the opcodes of the eBPF instructions the filters are made of, see linux/bpf.h
+const (
+	// w4 = *(u32 *)(r6 + off)
+	bpfLdxMemW = 0x61
+	// w4 &= imm
+	bpfAnd32Imm = 0x54
+	// r6 = r1
+	bpfMovReg = 0xbf
+	// r0 = imm
+	bpfMovImm = 0xb7
+	// if w4 != imm goto +off, comparing 32 bits, as the immediates of the 64 bit jumps are sign extended
+	bpfJne32Imm = 0x56
+	bpfExit     = 0x95
+)
+
+// bpfInsn is struct bpf_insn, whose dst_reg is the lower half of regs on little endian platforms.
+type bpfInsn struct {
+	code uint8
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+func insn(code uint8, dst, src uint8, off int16, imm uint32) bpfInsn {
+	return bpfInsn{code: code, regs: dst | src<<4, off: off, imm: int32(imm)}
+}
+
+// egressCheck is a word of struct bpf_sock_addr at off that has to be value once masked.
+type egressCheck struct {
+	off   int16
+	mask  uint32
+	value uint32
+}
+
+// compileEgress compiles the program that allows the sockets of the family to connect or send to the destinations
+// of the rules only. IPv4 rules apply to the IPv4-mapped IPv6 addresses too.
+func compileEgress(rules []egressRule, ipv6 bool) []bpfInsn {
+	// r6 is the context
+	prog := []bpfInsn{insn(bpfMovReg, 6, 1, 0, 0)}
+
+	for _, rule := range rules {
+		ip, mask := rule.Net.IP.To4(), net.IP(rule.Net.Mask)
+		if ipv6 {
+			ip = rule.Net.IP.To16()
+			if len(mask) == net.IPv4len {
+				mask = append(net.IP(net.CIDRMask(96, 128)[:12]), mask...)
+			}
+		} else if ip == nil {
+			continue
+		}
+
+		base := int16(bpfSockAddrIP4)
+		if ipv6 {
+			base = bpfSockAddrIP6
+		}
+
+		var checks []egressCheck
+		for i := 0; i < len(ip); i += 4 {
+			// the words are loaded in the byte order of the platform, and so are compared
+			m, v := binary.LittleEndian.Uint32(mask[i:]), binary.LittleEndian.Uint32(ip[i:])
+			if m != 0 {
+				checks = append(checks, egressCheck{off: base + int16(i), mask: m, value: v & m})
+			}
+		}
+
+		if rule.Port != 0 {
+			// user_port holds the port in network byte order in its first two bytes
+			port := uint32(rule.Port>>8) | uint32(rule.Port&0xff)<<8
+			checks = append(checks, egressCheck{off: bpfSockAddrPort, mask: 0xffffffff, value: port})
+		}
+
+		var block []bpfInsn
+		for _, c := range checks {
+			block = append(block, insn(bpfLdxMemW, 4, 6, c.off, 0))
+			if c.mask != 0xffffffff {
+				block = append(block, insn(bpfAnd32Imm, 4, 0, 0, c.mask))
+			}
+			// patched to skip to the next rule
+			block = append(block, insn(bpfJne32Imm, 4, 0, 0, c.value))
+		}
+		block = append(block, insn(bpfMovImm, 0, 0, 0, 1), insn(bpfExit, 0, 0, 0, 0))
+
+		for i := range block {
+			if block[i].code == bpfJne32Imm {
+				block[i].off = int16(len(block) - 1 - i)
+			}
+		}
+
+		prog = append(prog, block...)
+	}
+
+	// denied, which fails the syscall with EPERM
+	return append(prog, insn(bpfMovImm, 0, 0, 0, 0), insn(bpfExit, 0, 0, 0, 0))
+}
+
+// bpfProgLoadAttr is the head of union bpf_attr for BPF_PROG_LOAD, the rest of which is left zero.
+type bpfProgLoadAttr struct {
+	progType           uint32
+	insnCnt            uint32
+	insns              uint64
+	license            uint64
+	logLevel           uint32
+	logSize            uint32
+	logBuf             uint64
+	kernVersion        uint32
+	progFlags          uint32
+	progName           [16]byte
+	progIfindex        uint32
+	expectedAttachType uint32
+}
+
+// bpfProgAttachAttr is union bpf_attr for BPF_PROG_ATTACH.
+type bpfProgAttachAttr struct {
+	targetFd    uint32
+	attachBpfFd uint32
+	attachType  uint32
+	attachFlags uint32
+}
+
+// bpfProgQueryAttr is the head of union bpf_attr for BPF_PROG_QUERY.
+type bpfProgQueryAttr struct {
+	targetFd    uint32
+	attachType  uint32
+	queryFlags  uint32
+	attachFlags uint32
+	progIds     uint64
+	progCnt     uint32
+	_           uint32
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	r, _, errno := syscall.Syscall(SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return 0, errno
+	}
+
+	return int(r), nil
+}
+
+// attachEgressFilter attaches the programs that allow the rules only to the cgroup dir, for TCP connects and UDP
+// connects and sends. They are detached when the cgroup is removed.
+func attachEgressFilter(cgroup string, rules []egressRule) error {
+	dir, err := syscall.Open(cgroup, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(dir)
+
+	license := []byte("GPL\x00")
+	for _, hook := range []struct {
+		attachType uint32
+		ipv6       bool
+	}{
+		{BPF_CGROUP_INET4_CONNECT, false},
+		{BPF_CGROUP_INET6_CONNECT, true},
+		{BPF_CGROUP_UDP4_SENDMSG, false},
+		{BPF_CGROUP_UDP6_SENDMSG, true},
+	} {
+		prog := compileEgress(rules, hook.ipv6)
+		load := bpfProgLoadAttr{
+			progType:           BPF_PROG_TYPE_CGROUP_SOCK_ADDR,
+			insnCnt:            uint32(len(prog)),
+			insns:              uint64(uintptr(unsafe.Pointer(&prog[0]))),
+			license:            uint64(uintptr(unsafe.Pointer(&license[0]))),
+			expectedAttachType: hook.attachType,
+		}
+		copy(load.progName[:], "gorunner_egress")
+
+		fd, err := bpf(BPF_PROG_LOAD, unsafe.Pointer(&load), unsafe.Sizeof(load))
+		runtime.KeepAlive(prog)
+		runtime.KeepAlive(license)
+		if err != nil {
+			return fmt.Errorf("failed to load the egress filter: %w", err)
+		}
+
+		attach := bpfProgAttachAttr{targetFd: uint32(dir), attachBpfFd: uint32(fd), attachType: hook.attachType}
+		_, err = bpf(BPF_PROG_ATTACH, unsafe.Pointer(&attach), unsafe.Sizeof(attach))
+		// the cgroup holds on to the program
+		_ = syscall.Close(fd)
+		if err != nil {
+			return fmt.Errorf("failed to attach the egress filter to %s: %w", cgroup, err)
+		}
+	}
+
+	return nil
+}
+
+// egressFiltered tells if the connects of the processes in the cgroup dir are filtered, by its own programs or
+// the ones of its parents.
+func egressFiltered(cgroup string) bool {
+	dir, err := syscall.Open(cgroup, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return false
+	}
+	defer syscall.Close(dir)
+
+	query := bpfProgQueryAttr{targetFd: uint32(dir), attachType: BPF_CGROUP_INET4_CONNECT, queryFlags: BPF_F_QUERY_EFFECTIVE}
+	_, err = bpf(BPF_PROG_QUERY, unsafe.Pointer(&query), unsafe.Sizeof(query))
+
+	return err == nil && query.progCnt > 0
+}
@@ -0,0 +1,17 @@
+//go:build !linux || (!amd64 && !arm64)
+// +build !linux !amd64,!arm64
+
+package core
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func attachEgressFilter(cgroup string, rules []egressRule) error {
+	return fmt.Errorf("the egress filter is not supported on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+func egressFiltered(cgroup string) bool {
+	return false
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// EVENT_BUS_SIZE is how many of the last events the EventBus keeps for clients to catch up with.
+const EVENT_BUS_SIZE = 1000
+
+const (
+	EVENT_APP_REGISTERED   = "app.registered"
+	EVENT_APP_DELETED      = "app.deleted"
+	EVENT_CONFIG_CHANGED   = "config.changed"
+	EVENT_DEPLOY_STARTED   = "deploy.started"
+	EVENT_DEPLOY_SUCCEEDED = "deploy.succeeded"
+	EVENT_DEPLOY_FAILED    = "deploy.failed"
+	EVENT_PROCESS_STARTED  = "process.started"
+	EVENT_PROCESS_EXITED   = "process.exited"
+	EVENT_PROCESS_CRASHED  = "process.crashed"
+	// EVENT_SANDBOX_VIOLATION is published before EVENT_PROCESS_CRASHED when an app is killed for a syscall
+	// its seccomp profile doesn't allow
+	EVENT_SANDBOX_VIOLATION = "sandbox.violation"
+)
+
+// AppEvent is something that happened to an app, published on the EventBus of the GoRunner.
+type AppEvent struct {
+	// Seq goes up by 1 with every event since go-runner started.
+	Seq     int64     `json:"seq"`
+	Type    string    `json:"type"`
+	App     string    `json:"app"`
+	At      time.Time `json:"at"`
+	Release int       `json:"release,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// EventBus numbers the events of all apps, and keeps the last EVENT_BUS_SIZE of them.
+type EventBus struct {
+	sync.Mutex
+	seq    int64
+	events []AppEvent
+	// next is closed on the next event, for the clients waiting for it
+	next chan struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{next: make(chan struct{})}
+}
+
+// Publish numbers e and hands it to the clients waiting for events. It does nothing on a nil EventBus.
+func (b *EventBus) Publish(e AppEvent) {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.seq++
+	e.Seq = b.seq
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+
+	b.events = append(b.events, e)
+	if len(b.events) > EVENT_BUS_SIZE {
+		b.events = b.events[len(b.events)-EVENT_BUS_SIZE:]
+	}
+
+	close(b.next)
+	b.next = make(chan struct{})
+}
+
+// Since returns the events kept after seq, and a chan closed when the next event is published.
+// A seq ahead of the last event is taken as one from before go-runner restarted, and gets all the events kept.
+func (b *EventBus) Since(seq int64) ([]AppEvent, <-chan struct{}) {
+	b.Lock()
+	defer b.Unlock()
+
+	if seq > b.seq {
+		seq = 0
+	}
+
+	i := len(b.events)
+	for i > 0 && b.events[i-1].Seq > seq {
+		i--
+	}
+
+	return append([]AppEvent{}, b.events[i:]...), b.next
+}
+
+// publish publishes an event of the app. The app must be locked.
+func (a *GoApp) publish(eventType string, release int, message string) {
+	a.bus.Publish(AppEvent{Type: eventType, App: a.Name, Release: release, Message: message})
+}
+
+// announce is publish for when the app is not locked.
+func (a *GoApp) announce(eventType string, release int, message string) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.publish(eventType, release, message)
+}
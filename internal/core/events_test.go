@@ -0,0 +1,100 @@
+package core
+
+import (
+	"bytes"
+	"path"
+	"testing"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+func typesOf(events []AppEvent) []string {
+	types := make([]string, 0, len(events))
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+
+	return types
+}
+
+func TestEventBusResumesFromSeq(t *testing.T) {
+	expect := util.NewExpect(t)
+	bus := NewEventBus()
+
+	events, next := bus.Since(0)
+	expect.Equal(0, len(events))
+
+	bus.Publish(AppEvent{Type: EVENT_APP_REGISTERED, App: "a"})
+	select {
+	case <-next:
+	default:
+		t.Fatal("waiting clients should be woken up by an event")
+	}
+
+	for i := 0; i < EVENT_BUS_SIZE+10; i++ {
+		bus.Publish(AppEvent{Type: EVENT_CONFIG_CHANGED, App: "a"})
+	}
+
+	events, _ = bus.Since(0)
+	expect.Equal(EVENT_BUS_SIZE, len(events))
+	expect.Equal(int64(12), events[0].Seq)
+
+	events, next = bus.Since(int64(EVENT_BUS_SIZE + 9))
+	expect.Equal(2, len(events))
+	expect.Equal(int64(EVENT_BUS_SIZE+11), events[1].Seq)
+	expect.True(!events[1].At.IsZero())
+
+	events, _ = bus.Since(int64(EVENT_BUS_SIZE + 11))
+	expect.Equal(0, len(events))
+	select {
+	case <-next:
+		t.Fatal("no event since")
+	default:
+	}
+
+	// a seq from before a restart
+	events, _ = bus.Since(5000)
+	expect.Equal(EVENT_BUS_SIZE, len(events))
+
+	var none *EventBus
+	none.Publish(AppEvent{})
+}
+
+func TestDeployPublishesEvents(t *testing.T) {
+	expect := util.NewExpect(t)
+	bus := NewEventBus()
+	goapp := &GoApp{Name: "prebuilt", AppDir: path.Join(t.TempDir(), "prebuilt"), bus: bus, log: &log.Logger}
+	binary := anElf(t)
+
+	deploy := func(checksum string) error {
+		return goapp.Deploy(func() error {
+			return goapp.UnpackArtifact(bytes.NewReader(binary), checksum, "")
+		})
+	}
+
+	expect.Nil(deploy(sha256Of(binary)))
+	expect.Nil(deploy(sha256Of(binary)))
+	expect.True(deploy("") != nil)
+	expect.Nil(goapp.SetConfig(AppConfig{Subdir: "cmd"}))
+	expect.Nil(goapp.SetConfig(AppConfig{Subdir: "cmd"}))
+	expect.Nil(goapp.Delete())
+
+	events, _ := bus.Since(0)
+	expect.Equal([]string{
+		EVENT_DEPLOY_STARTED, EVENT_PROCESS_STARTED, EVENT_DEPLOY_SUCCEEDED,
+		EVENT_DEPLOY_STARTED, EVENT_PROCESS_EXITED, EVENT_PROCESS_STARTED, EVENT_DEPLOY_SUCCEEDED,
+		EVENT_DEPLOY_STARTED, EVENT_DEPLOY_FAILED,
+		EVENT_CONFIG_CHANGED,
+		EVENT_PROCESS_EXITED, EVENT_APP_DELETED,
+	}, typesOf(events))
+
+	expect.Equal(2, events[6].Release)
+	expect.Equal("prebuilt", events[8].App)
+	expect.Equal("sha256 checksum is required", events[8].Message)
+	expect.Equal(int64(12), events[11].Seq)
+
+	m := goapp.Metrics()
+	expect.Equal(map[string]uint64{DEPLOY_SUCCEEDED: 2, DEPLOY_FAILED: 1}, m.Deploys)
+	expect.Equal(1, m.Restarts)
+}
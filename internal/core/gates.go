@@ -0,0 +1,201 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-cmd/cmd"
+)
+
+var ErrGateFailed = errors.New("deploy gate failed")
+
+const (
+	DEFAULT_GATE_TIMEOUT = 10 * time.Minute
+	// GATE_OUTPUT_LINES is how many of the last lines of output of a gate are kept with the release.
+	GATE_OUTPUT_LINES = 200
+)
+
+// GateConfig is what to check in a new release before it becomes the current release.
+// The gates run in the dir of the app, in the order of vet, test, then the commands.
+type GateConfig struct {
+	Vet  bool `json:"vet,omitempty"`
+	Test bool `json:"test,omitempty"`
+	// Commands are run with sh -c, and fail the gate with a non-zero exit code
+	Commands []string `json:"commands,omitempty"`
+	// Timeout of each gate, e.g. "5m". Default to DEFAULT_GATE_TIMEOUT.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// GateResult is what a gate had to say about a release.
+type GateResult struct {
+	Name     string   `json:"name"`
+	Command  []string `json:"command"`
+	Passed   bool     `json:"passed"`
+	Exit     int      `json:"exit"`
+	Error    string   `json:"error,omitempty"`
+	Duration string   `json:"duration"`
+	// Output is the last GATE_OUTPUT_LINES lines of stdout, then stderr
+	Output []string `json:"output,omitempty"`
+}
+
+func (g *GateConfig) empty() bool {
+	return g == nil || !g.Vet && !g.Test && len(g.Commands) == 0
+}
+
+func (g *GateConfig) validate() error {
+	if g == nil {
+		return nil
+	}
+
+	for _, c := range g.Commands {
+		if strings.TrimSpace(c) == "" {
+			return fmt.Errorf("%w: gates.commands must not be empty", ErrInvalidConfig)
+		}
+	}
+
+	if g.Timeout == "" {
+		return nil
+	}
+
+	timeout, err := time.ParseDuration(g.Timeout)
+	if err != nil {
+		return fmt.Errorf("%w: gates.timeout: %s", ErrInvalidConfig, err)
+	}
+
+	if timeout <= 0 {
+		return fmt.Errorf("%w: gates.timeout must be positive", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+func (g *GateConfig) timeout() time.Duration {
+	timeout, err := time.ParseDuration(g.Timeout)
+	if err != nil || timeout <= 0 {
+		return DEFAULT_GATE_TIMEOUT
+	}
+
+	return timeout
+}
+
+func (g *GateConfig) commands() [][]string {
+	var commands [][]string
+	if g.Vet {
+		commands = append(commands, []string{"go", "vet", "./..."})
+	}
+
+	if g.Test {
+		commands = append(commands, []string{"go", "test", "./..."})
+	}
+
+	for _, c := range g.Commands {
+		commands = append(commands, []string{"sh", "-c", c})
+	}
+
+	return commands
+}
+
+// runGates runs the gates against rel, and records their results in rel, which is not a release of the app yet.
+// It stops at the first gate that fails, and returns ErrGateFailed. The app must not be locked.
+func (a *GoApp) runGates(rel *Release, gates *GateConfig) error {
+	if gates.empty() {
+		return nil
+	}
+
+	a.Lock()
+	a.preparing(STATE_BUILDING, fmt.Sprintf("running the gates of release %d", rel.ID))
+	a.Unlock()
+
+	releaseDir := a.releaseDir(rel)
+	dir := path.Join(releaseDir, rel.Subdir)
+	env := a.goCmdEnv(releaseDir, rel.Subdir)
+	commands := gates.commands()
+
+	// each gate has its own timeout, the job is only there to take a build slot
+	return a.sched.Run(a.Name, JOB_GATES, gates.timeout()*time.Duration(len(commands)+1), func(ctx context.Context) error {
+		done := a.goenv.use()
+		defer done()
+
+		for _, command := range commands {
+			result := runGate(ctx, dir, env, command, gates.timeout())
+			rel.Gates = append(rel.Gates, result)
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if !result.Passed {
+				a.log.Warn().Msgf("gate failed. app=%s, release=%d, gate=%s, error=%s", a.Name, rel.ID, result.Name, result.Error)
+				return fmt.Errorf("%w: %s: %s", ErrGateFailed, result.Name, result.Error)
+			}
+
+			a.log.Info().Msgf("gate passed. app=%s, release=%d, gate=%s, duration=%s", a.Name, rel.ID, result.Name, result.Duration)
+		}
+
+		return nil
+	})
+}
+
+// gatesFailed rejects rel if it failed a gate. If the gates didn't finish, e.g. canceled, rel is dropped.
+func (a *GoApp) gatesFailed(rel *Release, err error) error {
+	if errors.Is(err, ErrGateFailed) {
+		return a.rejectRelease(rel, err)
+	}
+
+	_ = os.RemoveAll(a.releaseDir(rel))
+
+	return a.releaseFailed("gates", err)
+}
+
+func runGate(ctx context.Context, dir string, env []string, command []string, timeout time.Duration) GateResult {
+	name := strings.Join(command, " ")
+	if command[0] == "sh" {
+		name = command[2]
+	}
+
+	gateCmd := cmd.NewCmd(command[0], command[1:]...)
+	gateCmd.Dir = dir
+	gateCmd.Env = env
+
+	gateCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	started := time.Now()
+	status := runCmd(gateCtx, gateCmd)
+
+	result := GateResult{
+		Name:     name,
+		Command:  command,
+		Exit:     status.Exit,
+		Duration: time.Since(started).Round(time.Millisecond).String(),
+		Output:   lastLines(append(status.Stdout, status.Stderr...), GATE_OUTPUT_LINES),
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		result.Error = "stopped"
+	case gateCtx.Err() != nil:
+		result.Error = fmt.Sprintf("timed out after %s", timeout)
+	case status.Error != nil:
+		result.Error = status.Error.Error()
+	case status.Exit != 0:
+		result.Error = fmt.Sprintf("exited with %d", status.Exit)
+	default:
+		result.Passed = true
+	}
+
+	return result
+}
+
+func lastLines(lines []string, n int) []string {
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+
+	return lines
+}
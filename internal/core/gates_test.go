@@ -0,0 +1,133 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+func filesTarball(t *testing.T, files map[string]string) *bytes.Buffer {
+	src := t.TempDir()
+	for name, content := range files {
+		err := ioutil.WriteFile(path.Join(src, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := util.TarGz(&buf, src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &buf
+}
+
+func TestFailedGateKeepsTheCurrentRelease(t *testing.T) {
+	expect := util.NewExpect(t)
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+
+	goapp := &GoApp{Name: "hello", AppDir: path.Join(t.TempDir(), "hello"), log: &log.Logger}
+	expect.Nil(goapp.SetConfig(AppConfig{Gates: &GateConfig{Vet: true, Test: true, Commands: []string{"test -f main.go"}}}))
+
+	app := map[string]string{
+		"go.mod":       "module example.com/hello\n\ngo 1.17\n",
+		"main.go":      "package main\n\nfunc add(a, b int) int { return a + b }\n\nfunc main() {}\n",
+		"main_test.go": "package main\n\nimport \"testing\"\n\nfunc TestAdd(t *testing.T) {\n\tif add(1, 2) != 3 {\n\t\tt.Fatal(\"bad add\")\n\t}\n}\n",
+	}
+	expect.Nil(goapp.Unpack(filesTarball(t, app), ""))
+	expect.Equal(1, goapp.current.ID)
+	expect.Equal(3, len(goapp.current.Gates))
+	for _, gate := range goapp.current.Gates {
+		expect.True(gate.Passed, gate)
+	}
+
+	app["main.go"] = "package main\n\nfunc add(a, b int) int { return a - b }\n\nfunc main() {}\n"
+	err := goapp.Unpack(filesTarball(t, app), "")
+	expect.True(errors.Is(err, ErrGateFailed))
+	expect.Equal(1, goapp.current.ID)
+
+	releases := goapp.Releases()
+	expect.Equal(2, len(releases))
+	rejected := releases[1]
+	expect.True(rejected.Rejected)
+	expect.Equal(2, len(rejected.Gates))
+	expect.Equal("go test ./...", rejected.Gates[1].Name)
+	expect.True(!rejected.Gates[1].Passed)
+	expect.True(strings.Contains(strings.Join(rejected.Gates[1].Output, "\n"), "bad add"))
+
+	_, err = os.Stat(goapp.releaseDir(&rejected))
+	expect.True(os.IsNotExist(err))
+
+	app["main.go"] = "package main\n\nfunc add(a, b int) int { return b + a }\n\nfunc main() {}\n"
+	expect.Nil(goapp.Unpack(filesTarball(t, app), ""))
+	expect.Equal(3, goapp.current.ID)
+	expect.True(goapp.Rollback(2) != nil)
+	expect.Nil(goapp.Rollback(0))
+	expect.Equal(1, goapp.current.ID)
+}
+
+func TestGateTimesOut(t *testing.T) {
+	expect := util.NewExpect(t)
+	goapp := &GoApp{Name: "hello", AppDir: path.Join(t.TempDir(), "hello"), log: &log.Logger}
+	expect.Nil(goapp.SetConfig(AppConfig{Gates: &GateConfig{Commands: []string{"echo started; sleep 10"}, Timeout: "200ms"}}))
+
+	err := goapp.Unpack(sourceTarball(t, "package main"), "")
+	expect.True(errors.Is(err, ErrGateFailed))
+
+	gate := goapp.Releases()[0].Gates[0]
+	expect.Equal("timed out after 200ms", gate.Error)
+	expect.Equal([]string{"started"}, gate.Output)
+	expect.Equal(STATE_FAILED, goapp.State())
+
+	expect.True(errors.Is(goapp.SetConfig(AppConfig{Gates: &GateConfig{Timeout: "soon"}}), ErrInvalidConfig))
+	expect.True(errors.Is(goapp.SetConfig(AppConfig{Gates: &GateConfig{Commands: []string{" "}}}), ErrInvalidConfig))
+}
+
+func TestAppIsNotLockedWhileItsGatesRun(t *testing.T) {
+	expect := util.NewExpect(t)
+	goapp := &GoApp{Name: "hello", AppDir: path.Join(t.TempDir(), "hello"), log: &log.Logger}
+	done := path.Join(t.TempDir(), "done")
+	expect.Nil(goapp.SetConfig(AppConfig{Gates: &GateConfig{Commands: []string{"while [ ! -f " + done + " ]; do sleep 0.01; done"}}}))
+
+	unpacked := make(chan error, 1)
+	go func() {
+		unpacked <- goapp.Unpack(sourceTarball(t, "package main"), "")
+	}()
+
+	for i := 0; i < 500 && goapp.State() != STATE_BUILDING; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	expect.Equal(STATE_BUILDING, goapp.State())
+
+	marshaled := make(chan error, 1)
+	go func() {
+		_, err := json.Marshal(goapp)
+		marshaled <- err
+	}()
+
+	select {
+	case err := <-marshaled:
+		expect.Nil(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the app is locked while its gates run")
+	}
+
+	expect.Nil(ioutil.WriteFile(done, nil, 0644))
+	expect.Nil(<-unpacked)
+	expect.Equal(1, goapp.current.ID)
+	expect.Equal(1, len(goapp.current.Gates))
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/config"
+)
+
+// GITCONFIG_FILE is go-runner's own git config in the working dir, on top of the system and global git config.
+const GITCONFIG_FILE = "gitconfig"
+
+// urlRewrite is a url.<base>.insteadOf in git config.
+type urlRewrite struct {
+	base      string
+	insteadOf string
+}
+
+// gitConfigFiles returns the git config files to read: go-runner's own first, so that its insteadOf wins over one of
+// the same length in the others, then the system and global ones in git's order.
+func gitConfigFiles(own string) []string {
+	var files []string
+	if own != "" {
+		files = append(files, own)
+	}
+
+	files = append(files, "/etc/gitconfig")
+
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		files = append(files, path.Join(xdg, "git", "config"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, path.Join(home, ".config", "git", "config"))
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, path.Join(home, ".gitconfig"))
+	}
+
+	return files
+}
+
+// loadURLRewrites reads all the insteadOf from the files. pushInsteadOf is ignored, go-runner never pushes.
+func loadURLRewrites(files []string) ([]urlRewrite, error) {
+	var rewrites []urlRewrite
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		cfg := config.New()
+		err = config.NewDecoder(f).Decode(cfg)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid git config %s: %w", file, err)
+		}
+
+		if !cfg.HasSection("url") {
+			continue
+		}
+
+		for _, sub := range cfg.Section("url").Subsections {
+			for _, insteadOf := range sub.OptionAll("insteadOf") {
+				rewrites = append(rewrites, urlRewrite{base: sub.Name, insteadOf: insteadOf})
+			}
+		}
+	}
+
+	return rewrites, nil
+}
+
+// rewriteURL applies the insteadOf with the longest match to gitURL, like git does.
+// Among matches of the same length, the one read first wins, as in git, which is go-runner's own by gitConfigFiles.
+func rewriteURL(gitURL string, rewrites []urlRewrite) string {
+	var longest *urlRewrite
+	for i, r := range rewrites {
+		if r.insteadOf == "" || !strings.HasPrefix(gitURL, r.insteadOf) {
+			continue
+		}
+
+		if longest == nil || len(r.insteadOf) > len(longest.insteadOf) {
+			longest = &rewrites[i]
+		}
+	}
+
+	if longest == nil {
+		return gitURL
+	}
+
+	return longest.base + strings.TrimPrefix(gitURL, longest.insteadOf)
+}
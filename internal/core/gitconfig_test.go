@@ -0,0 +1,90 @@
+package core
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/rs/zerolog/log"
+)
+
+func TestRewriteURLTakesLongestInsteadOf(t *testing.T) {
+	expect := util.NewExpect(t)
+	global := path.Join(t.TempDir(), "gitconfig")
+	expect.Nil(ioutil.WriteFile(global, []byte(`
+[url "https://global.local/"]
+	insteadOf = https://github.com/JackKCWong/
+`), 0644))
+
+	own := path.Join(t.TempDir(), "gitconfig")
+	expect.Nil(ioutil.WriteFile(own, []byte(`
+[url "https://mirror.local/github/"]
+	insteadOf = https://github.com/
+	insteadOf = git@github.com:
+[url "https://mirror.local/jack/"]
+	insteadOf = https://github.com/JackKCWong/
+	pushInsteadOf = https://github.com/JackKCWong/go-runner
+[url "https://push.local/"]
+	pushInsteadOf = https://gitlab.com/
+`), 0644))
+
+	files := gitConfigFiles(own)
+	expect.Equal(own, files[0])
+	rewrites, err := loadURLRewrites([]string{own, global, path.Join(t.TempDir(), "missing")})
+	expect.Nil(err)
+
+	// as long as the global one, and read first
+	expect.Equal("https://mirror.local/jack/go-runner.git", rewriteURL("https://github.com/JackKCWong/go-runner.git", rewrites))
+	expect.Equal("https://global.local/go-runner.git", rewriteURL("https://github.com/JackKCWong/go-runner.git", rewrites[3:]))
+	expect.Equal("https://mirror.local/github/golang/go.git", rewriteURL("https://github.com/golang/go.git", rewrites))
+	expect.Equal("https://mirror.local/github/golang/go.git", rewriteURL("git@github.com:golang/go.git", rewrites))
+	expect.Equal("https://gitlab.com/JackKCWong/app.git", rewriteURL("https://gitlab.com/JackKCWong/app.git", rewrites))
+}
+
+func TestRebuildClonesFromRewrittenURL(t *testing.T) {
+	expect := util.NewExpect(t)
+	if _, err := exec.LookPath("git-upload-pack"); err != nil {
+		t.Skip("git-upload-pack not found")
+	}
+
+	repoDir := path.Join(t.TempDir(), "hello")
+	repo, err := git.PlainInit(repoDir, false)
+	expect.Nil(err)
+	expect.Nil(ioutil.WriteFile(path.Join(repoDir, "main.go"), []byte("package main"), 0644))
+	tree, err := repo.Worktree()
+	expect.Nil(err)
+	_, err = tree.Add("main.go")
+	expect.Nil(err)
+	_, err = tree.Commit("hello", &git.CommitOptions{
+		Author: &object.Signature{Name: "t", Email: "t@t", When: time.Now()},
+	})
+	expect.Nil(err)
+
+	gitConfig := path.Join(t.TempDir(), "gitconfig")
+	expect.Nil(ioutil.WriteFile(gitConfig, []byte(`
+[url "`+repoDir+`"]
+	insteadOf = https://offline.invalid/hello
+`), 0644))
+
+	goapp := &GoApp{
+		Name:   "hello",
+		GitURL: "https://offline.invalid/hello",
+		AppDir: path.Join(t.TempDir(), "hello"),
+		opts:   Options{GitConfig: gitConfig},
+		log:    &log.Logger,
+	}
+
+	expect.Nil(goapp.Rebuild())
+	expect.Nil(goapp.Rebuild())
+	expect.Equal("https://offline.invalid/hello", goapp.GitURL)
+	expect.Equal(2, goapp.current.ID)
+
+	content, err := ioutil.ReadFile(path.Join(goapp.releaseDir(goapp.current), "main.go"))
+	expect.Nil(err)
+	expect.Equal("package main", string(content))
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/rs/zerolog"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -14,220 +15,831 @@ import (
 	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/JackKCWong/go-runner/internal/util"
 	"github.com/go-cmd/cmd"
 	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 )
 
 type GoApp struct {
 	_ struct{}
 	sync.Mutex
-	Name        string
-	GitURL      string
-	Status      string
-	AppDir      string
-	gitCommit   string
-	lastErr     error
-	buildStatus cmd.Status
-	proc        *cmd.Cmd
-	proxy       *httputil.ReverseProxy
-	stdout      *topic
-	stderr      *topic
-	log         *zerolog.Logger
-}
-
-func (a *GoApp) Purge() error {
+	Name       string
+	GitURL     string
+	Branch     string
+	config     AppConfig
+	state      State
+	stateNow   atomic.Value // State, for the readers that don't take the lock
+	stateSince time.Time
+	events     []Event
+	AppDir     string
+	releases   []*Release
+	current    *Release
+	running    *Release
+	built      *Release
+	lastErr    error
+	startedAt  time.Time
+	restarts   int
+	proc       *cmd.Cmd
+	proxy      atomic.Value // *httputil.ReverseProxy, used without the lock
+	stdout     *topic
+	stderr     *topic
+	opts       Options
+	creds      *CredentialStore
+	goenv      *GoEnv
+	sched      *Scheduler
+	cgroups    *Cgroups
+	users      *Users
+	uid        int
+	bus        *EventBus
+	poller     *poller
+	metrics    appMetrics
+	usage      usageHistory
+	sampling   chan struct{}
+	// opLock guards op and pendingDeploy. It is not the lock of the app, which is not held for a whole operation.
+	opLock        sync.Mutex
+	op            *operation
+	pendingDeploy bool
+	log           *zerolog.Logger
+}
+
+// Rebuild clones the app's git repo into a new release and makes it the current release. The app is only locked
+// to add the release, not while it is cloned and checked by the gates, so it keeps serving meanwhile. Like the other
+// steps of preparing a release, it is run by an operation, e.g. Deploy, which keeps the others out.
+func (a *GoApp) Rebuild() error {
+	a.Lock()
+	a.preparing(STATE_CLONING, "cloning "+a.GitURL)
+	gitURL, branch, config := a.GitURL, a.Branch, a.config
+	if gitURL == "" {
+		defer a.Unlock()
+		return a.releaseFailed("git clone", errors.New("app has no gitUrl to rebuild from"))
+	}
+
+	rel, err := a.newRelease(SOURCE_GIT)
+	if err != nil {
+		defer a.Unlock()
+		return a.releaseFailed("release", err)
+	}
+	a.Unlock()
+
+	step, err := a.clone(rel, gitURL, branch, config)
+	if err != nil {
+		return a.dropRelease(rel, step, err)
+	}
+
+	if rel.Subdir != "" {
+		a.Lock()
+		if cur := a.current; cur != nil && cur.Source == SOURCE_GIT && cur.Subdir == rel.Subdir && cur.Fingerprint == rel.Fingerprint {
+			defer a.Unlock()
+
+			// same as the current release as far as the app is concerned, which now stands for the new commit.
+			_ = os.RemoveAll(a.releaseDir(rel))
+			cur.Branch, cur.GitHash, cur.GitCommit = rel.Branch, rel.GitHash, rel.GitCommit
+			err = a.save()
+			if err != nil {
+				return a.releaseFailed("release", err)
+			}
+
+			return fmt.Errorf("%w under %s or its dependencies since release %d", ErrNoChanges, rel.Subdir, cur.ID)
+		}
+		a.Unlock()
+	}
+
+	return a.stage(rel, config.Gates)
+}
+
+// clone clones the branch of gitURL into rel as per config, and returns the step that failed, if any. The app must
+// not be locked.
+func (a *GoApp) clone(rel *Release, gitURL, branch string, config AppConfig) (string, error) {
+	rewrites, err := loadURLRewrites(gitConfigFiles(a.opts.GitConfig))
+	if err != nil {
+		return "git config", err
+	}
+
+	cloneURL := rewriteURL(gitURL, rewrites)
+
+	if cloneURL != gitURL {
+		a.log.Info().Msgf("cloning with url rewritten by git config. app=%s, gitUrl=%s, cloneUrl=%s", a.Name, gitURL, cloneURL)
+	}
+
+	auth, err := a.gitAuth(cloneURL)
+	if err != nil {
+		return "git auth", err
+	}
+
+	cloneOpts := &git.CloneOptions{
+		URL:   cloneURL,
+		Depth: 1,
+		Auth:  auth,
+	}
+
+	if branch != "" {
+		cloneOpts.ReferenceName = plumbing.NewBranchReferenceName(branch)
+		cloneOpts.SingleBranch = true
+	}
+
+	repo, err := git.PlainClone(a.releaseDir(rel), false, cloneOpts)
+	if err != nil {
+		return "git clone", err
+	}
+
+	err = a.attach(repo, rel)
+	if err != nil {
+		return "git log", err
+	}
+
+	if config.Submodules {
+		err = a.checkoutSubmodules(repo, rewrites)
+		if err != nil {
+			return "submodule checkout", err
+		}
+	}
+
+	rel.Subdir = config.Subdir
+	err = a.checkSubdir(rel)
+	if err != nil {
+		return "subdir", err
+	}
+
+	if rel.Subdir != "" {
+		err = a.fingerprint(repo, rel)
+		if err != nil {
+			return "git log", err
+		}
+	}
+
+	return "", nil
+}
+
+// stage runs the gates against rel, then makes it the current release. The app is only locked for the latter.
+func (a *GoApp) stage(rel *Release, gates *GateConfig) error {
+	err := a.runGates(rel, gates)
+
 	a.Lock()
 	defer a.Unlock()
 
-	a.Status = "DELETED"
+	if err != nil {
+		return a.gatesFailed(rel, err)
+	}
+
+	err = a.addRelease(rel)
+	if err != nil {
+		return a.releaseFailed("release", err)
+	}
 
-	return os.RemoveAll(a.AppDir)
+	return nil
 }
 
-func (a *GoApp) Rebuild() error {
+// dropRelease removes the files of rel, which failed to be prepared in step. The app must not be locked.
+func (a *GoApp) dropRelease(rel *Release, step string, err error) error {
+	_ = os.RemoveAll(a.releaseDir(rel))
+
 	a.Lock()
 	defer a.Unlock()
 
-	err := os.RemoveAll(a.AppDir)
+	return a.releaseFailed(step, err)
+}
+
+// gitAuth returns the auth to clone gitURL with the credentials go-runner has for the app.
+func (a *GoApp) gitAuth(gitURL string) (transport.AuthMethod, error) {
+	if a.creds == nil {
+		return util.GetGitAuthFor(gitURL)
+	}
+
+	return a.creds.AuthFor(a.Name, gitURL)
+}
+
+// checkoutSubmodules checks out the submodules of the repo recursively, with the git config and credentials applied
+// to the submodule urls.
+func (a *GoApp) checkoutSubmodules(repo *git.Repository, rewrites []urlRewrite) error {
+	tree, err := repo.Worktree()
 	if err != nil {
-		a.Status = "ERR:PURGE"
-		a.lastErr = err
 		return err
 	}
 
-	gitConfig, err := config.LoadConfig(config.GlobalScope)
+	subs, err := tree.Submodules()
 	if err != nil {
-		a.log.Warn().Err(err).Msg("failed to load git global config")
-	} else {
-		for _, u := range gitConfig.URLs {
-			if strings.HasPrefix(a.GitURL, u.InsteadOf) {
-				// should be longest match really.
-				// but let's take a shortcut for now.
-				a.GitURL = u.ApplyInsteadOf(a.GitURL)
-				break
-			}
+		return err
+	}
+
+	for _, sub := range subs {
+		cfg := sub.Config()
+		cfg.URL = rewriteURL(cfg.URL, rewrites)
+
+		auth, err := a.gitAuth(cfg.URL)
+		if err != nil {
+			return fmt.Errorf("submodule %s: %w", cfg.Name, err)
+		}
+
+		err = sub.Update(&git.SubmoduleUpdateOptions{
+			Init:              true,
+			RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+			Auth:              auth,
+		})
+		if err != nil {
+			return fmt.Errorf("submodule %s: %w", cfg.Name, err)
 		}
 	}
 
-	//sshAuth, err := util.GetGitAuth()
-	//if err != nil {
-	//	a.Status = "ERR:SSHKEY"
-	//	a.lastErr = err
-	//	return err
-	//}
+	return nil
+}
 
-	repo, err := git.PlainClone(a.AppDir, false, &git.CloneOptions{
-		URL:   a.GitURL,
-		Depth: 1,
-		//SingleBranch: true,
-		//Auth: sshAuth,
-	})
+// checkSubdir makes sure the subdir of the app is in the release.
+func (a *GoApp) checkSubdir(rel *Release) error {
+	if rel.Subdir == "" {
+		return nil
+	}
+
+	info, err := os.Stat(path.Join(a.releaseDir(rel), rel.Subdir))
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("subdir %s not found in the source", rel.Subdir)
+	}
+
+	return nil
+}
 
+// fingerprint records what the app is built from in the commit checked out for the release.
+func (a *GoApp) fingerprint(repo *git.Repository, rel *Release) error {
+	commit, err := repo.CommitObject(plumbing.NewHash(rel.GitHash))
 	if err != nil {
-		a.Status = "ERR:GITCLONE"
-		a.lastErr = err
 		return err
 	}
 
-	err = a.attach(repo)
+	tree, err := commit.Tree()
 	if err != nil {
-		a.Status = "ERR:GITOPEN"
-		a.lastErr = err
 		return err
 	}
 
-	a.Status = "NEW"
+	deps, err := moduleDeps(a.releaseDir(rel), rel.Subdir)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	rel.Fingerprint, err = fingerprint(tree, deps)
+
+	return err
+}
+
+// Unpack extracts a gzipped tarball of source code into a new release and makes it the current release.
+// baseCommit is the commit the source is based on, if known. Like Rebuild, the app is not locked meanwhile.
+func (a *GoApp) Unpack(src io.Reader, baseCommit string) error {
+	a.Lock()
+	a.preparing(STATE_CLONING, "unpacking uploaded source")
+	config := a.config
+	rel, err := a.newRelease(SOURCE_UPLOAD)
+	if err != nil {
+		defer a.Unlock()
+		return a.releaseFailed("release", err)
+	}
+	a.Unlock()
+
+	rel.Uncommitted = true
+	rel.GitHash = baseCommit
+	rel.GitCommit = "uncommitted changes"
+	if len(baseCommit) >= 7 {
+		rel.GitCommit = fmt.Sprintf("uncommitted changes on %s", baseCommit[0:7])
+	}
+
+	err = util.UntarGz(src, a.releaseDir(rel))
+	if err != nil {
+		return a.dropRelease(rel, "unpack", err)
+	}
+
+	rel.Subdir = config.Subdir
+	err = a.checkSubdir(rel)
+	if err != nil {
+		return a.dropRelease(rel, "subdir", err)
+	}
+
+	return a.stage(rel, config.Gates)
+}
+
+// Deploy prepares a new current release with prepare, e.g. Rebuild. If that works, it restarts the app from the new
+// release. Otherwise the app keeps running the release it has. A running app is not restarted for ErrNoChanges.
+// It fails with ErrBusy if another operation is in progress on the app.
+func (a *GoApp) Deploy(prepare func() error) error {
+	end, err := a.begin(OP_DEPLOY)
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	return a.deploy(prepare)
+}
+
+func (a *GoApp) deploy(prepare func() error) error {
+	a.announce(EVENT_DEPLOY_STARTED, 0, "")
+
+	err := a.rollout(prepare)
+	switch {
+	case err == nil:
+		a.metrics.deploy(DEPLOY_SUCCEEDED)
+		a.Lock()
+		a.publish(EVENT_DEPLOY_SUCCEEDED, a.current.ID, "")
+		a.Unlock()
+	case errors.Is(err, ErrNoChanges):
+		a.metrics.deploy(DEPLOY_UNCHANGED)
+		a.announce(EVENT_DEPLOY_SUCCEEDED, 0, err.Error())
+	case errors.Is(err, ErrGateFailed), errors.Is(err, ErrChecksumMismatch):
+		a.metrics.deploy(DEPLOY_REJECTED)
+		a.announce(EVENT_DEPLOY_FAILED, 0, err.Error())
+	default:
+		a.metrics.deploy(DEPLOY_FAILED)
+		a.announce(EVENT_DEPLOY_FAILED, 0, err.Error())
+	}
+
+	return err
+}
+
+// rollout prepares a new release with prepare, then builds it and restarts the app from it.
+func (a *GoApp) rollout(prepare func() error) error {
+	err := prepare()
+	if errors.Is(err, ErrNoChanges) {
+		if a.IsRunning() {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	// build before stopping, so the app keeps running while the build waits for a slot, or if it fails
+	err = a.Build()
+	if err != nil {
+		return err
+	}
+
+	err = a.Stop()
+	if err != nil {
+		a.log.Info().Err(err).Msgf("failed to stop, continue anyway. app=%s", a.Name)
+	}
+
+	return a.Start()
+}
+
+// IsRunning tells if the app is started.
+func (a *GoApp) IsRunning() bool {
+	return a.State() == STATE_RUNNING
 }
 
 func (a *GoApp) Start() error {
+	a.Lock()
+	switch {
+	case a.currentState() == STATE_RUNNING:
+		a.Unlock()
+		return errors.New("app already started")
+	case a.current == nil:
+		defer a.Unlock()
+		return a.releaseFailed("start", errors.New("app has no release to start"))
+	}
+	a.Unlock()
+
+	err := a.Build()
+	if err != nil {
+		return err
+	}
+
 	a.Lock()
 	defer a.Unlock()
 
-	if a.Status == "STARTED" {
-		err := errors.New("app already started")
-		a.Status = "ERR:START"
+	if a.currentState() == STATE_RUNNING {
+		return errors.New("app already started")
+	}
+
+	if a.currentState() == STATE_CRASHED {
+		// the subscribers of the crashed process are done before the new one streams
+		a.exited()
+	}
+
+	err = a.transition(STATE_STARTING, fmt.Sprintf("starting release %d", a.current.ID))
+	if err != nil {
 		a.lastErr = err
 		return err
 	}
 
-	// buildCmd := exec.Command("go", "build", "-o", a.Name)
-	buildCmd := cmd.NewCmd("go", "build", "-o", a.Name)
-	buildCmd.Dir = a.AppDir
+	releaseDir := a.releaseDir(a.current)
+	appDir := path.Join(releaseDir, a.current.Subdir)
+	exePath := path.Join(appDir, a.Name)
 
-	a.buildStatus = <-buildCmd.Start()
+	if a.current.Source == SOURCE_ARTIFACT {
+		exePath = path.Join(releaseDir, a.current.Binary)
+	}
 
-	if a.buildStatus.Error != nil {
-		a.Status = "ERR:BUILD"
-		a.lastErr = a.buildStatus.Error
-		return a.buildStatus.Error
+	dataDir, runDir := path.Join(a.AppDir, DATA_DIRNAME), path.Join(a.AppDir, RUN_DIRNAME)
+	for _, dir := range []string{dataDir, runDir} {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return a.releaseFailed("start", err)
+		}
 	}
 
-	exePath := path.Join(a.AppDir, a.Name)
-	sockPath := path.Join(a.AppDir, "sock")
+	// where the app sees its dirs
+	appRelease, appData, appRun := releaseDir, dataDir, runDir
+	var iso *isolation
+	if a.config.Isolate {
+		iso, exePath, err = a.isolate(releaseDir, exePath, appDir)
+		if err != nil {
+			return a.releaseFailed("start", err)
+		}
+
+		appRelease, appData, appRun = SANDBOX_APP, SANDBOX_DATA, SANDBOX_RUN
+	}
+
+	user, err := a.user()
+	if err == nil && user != nil {
+		err = a.grantDirs(user, releaseDir, iso != nil)
+	}
+
+	if err != nil {
+		return a.releaseFailed("start", err)
+	}
+
+	rlimits, err := a.config.Rlimits.values()
+	if err != nil {
+		return a.releaseFailed("start", err)
+	}
+
+	syscalls, files, unsandboxed := a.sandbox(appRelease, appData, appRun)
+
+	noNetwork, egress, err := a.config.Network.enforcement(iso != nil)
+	if err != nil {
+		return a.releaseFailed("start", err)
+	}
+
+	cgroup, skipped, err := a.cgroups.prepare(a.Name, a.config.Limits)
+	if err != nil {
+		// better running without limits than not at all
+		a.log.Warn().Err(err).Msgf("failed to prepare the cgroup, starting without. app=%s", a.Name)
+		cgroup, skipped = "", []string{err.Error()}
+	}
+
+	if egress != nil {
+		err = a.filterEgress(cgroup, egress)
+		if err != nil {
+			a.cgroups.release(cgroup)
+			return a.releaseFailed("start", err)
+		}
+	}
+
+	spec := launchSpec{
+		Cgroup:     cgroup,
+		Isolation:  iso,
+		User:       user,
+		Rlimits:    rlimits,
+		NoNewPrivs: true,
+		Seccomp:    syscalls,
+		Landlock:   files,
+		NoNetwork:  noNetwork,
+		Report:     path.Join(a.AppDir, LAUNCH_REPORT_FILE),
+	}
+	_ = os.Remove(spec.Report)
+
+	exe, args, env, err := launcher(spec, exePath, "-unixsock", path.Join(appRun, "sock"))
+	if err != nil {
+		a.cgroups.release(cgroup)
+		return a.releaseFailed("start", err)
+	}
+
+	env = append(env, DATA_DIR_ENV+"="+appData)
 
 	runCmd := cmd.NewCmdOptions(cmd.Options{
 		Buffered:  false,
 		Streaming: true,
-	}, exePath, "-unixsock", sockPath)
-	runCmd.Dir = a.AppDir
+	}, exe, args...)
+	runCmd.Dir = appDir
+	runCmd.Env = env
 
 	a.stdout = newTopic()
 	go func() {
 		for line := range runCmd.Stdout {
-			a.stdout.Publish(line)
+			a.metrics.logLine(STREAM_STDOUT, a.stdout.Publish(line))
 		}
 	}()
 
 	a.stderr = newTopic()
 	go func() {
 		for line := range runCmd.Stderr {
-			a.stderr.Publish(line)
+			a.metrics.logLine(STREAM_STDERR, a.stderr.Publish(line))
 		}
 	}()
 
+	var audit *seccompAudit
+	if len(syscalls) > 0 {
+		audit = auditSeccomp(exePath)
+	}
+
 	runCmd.Start()
-	<-time.After(100 * time.Millisecond) // give a little time for PID to be ready
+	err = waitLaunched(spec.Report, runCmd)
+	if err != nil {
+		_ = runCmd.Stop()
+		<-runCmd.Done()
+		audit.close()
+		a.cgroups.release(cgroup)
+		a.stdout.Close()
+		a.stderr.Close()
+
+		return a.releaseFailed("start", fmt.Errorf("failed to launch the app: %w", err))
+	}
 
 	a.proc = runCmd
+	a.running = a.current
+	if !a.startedAt.IsZero() {
+		a.restarts++
+	}
+	a.startedAt = time.Now()
+
 	targetURL, err := url.Parse("http://sock")
 	if err != nil {
-		return err
+		return a.releaseFailed("start", err)
 	}
 
-	a.proxy = httputil.NewSingleHostReverseProxy(targetURL)
-	a.proxy.Transport = &http.Transport{
+	proxy := httputil.NewSingleHostReverseProxy(targetURL)
+	sockPath := path.Join(runDir, "sock")
+	proxy.Transport = &http.Transport{
 		DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
 			return net.Dial("unix", sockPath)
 		}}
+	a.proxy.Store(proxy)
+
+	pid := runCmd.Status().PID
+	reason := fmt.Sprintf("started with pid %d", pid)
+	if len(skipped) > 0 {
+		reason += "; limits not applied: " + strings.Join(skipped, "; ")
+		a.log.Warn().Msgf("app started without some of its limits: %s. app=%s", strings.Join(skipped, "; "), a.Name)
+	}
+
+	if len(unsandboxed) > 0 {
+		reason += "; sandbox not applied: " + strings.Join(unsandboxed, "; ")
+		a.log.Warn().Msgf("app started without some of its sandbox: %s. app=%s", strings.Join(unsandboxed, "; "), a.Name)
+	}
+
+	if audit != nil {
+		// the launcher, which executes the app unless it is isolated
+		audit.pid = pid
+	}
+
+	a.mustTransition(STATE_RUNNING, reason)
+	a.startSampling(pid)
+	a.publish(EVENT_PROCESS_STARTED, a.current.ID, fmt.Sprintf("pid %d", pid))
+	a.lastErr = nil
+	go a.watch(runCmd, cgroup, audit)
+
+	return nil
+}
+
+// watch marks the app crashed when proc exits without being stopped, and removes the cgroup of proc. audit is
+// non-nil if the syscalls of the app are filtered, and tells the syscall it is killed for.
+func (a *GoApp) watch(proc *cmd.Cmd, cgroup string, audit *seccompAudit) {
+	<-proc.Done()
+
+	oom := oomKilled(cgroup)
+	a.cgroups.release(cgroup)
+
+	violation := ""
+	if err := proc.Status().Error; audit != nil && err != nil && err.Error() == "signal: "+syscall.SIGSYS.String() {
+		violation = audit.violation()
+	}
+	audit.close()
+
+	a.Lock()
+	defer a.Unlock()
+
+	if a.proc != proc || a.currentState() != STATE_RUNNING {
+		// stopped
+		return
+	}
+
+	status := proc.Status()
+	reason := fmt.Sprintf("exited with %d", status.Exit)
+	if status.Error != nil {
+		reason = fmt.Sprintf("exited: %s", status.Error)
+	}
+
+	if oom {
+		reason = "killed by the OOM killer, " + reason
+	}
+
+	if violation != "" {
+		reason = violation + ", " + reason
+		a.publish(EVENT_SANDBOX_VIOLATION, a.running.ID, violation)
+	}
+
+	a.lastErr = errors.New("app " + reason)
+	a.stopSampling()
+	a.mustTransition(STATE_CRASHED, reason)
+	a.publish(EVENT_PROCESS_CRASHED, a.running.ID, reason)
+	a.log.Warn().Msgf("app crashed, %s. app=%s", reason, a.Name)
+}
+
+// Build builds the current release, unless it is built already. The running release is not affected, and the app
+// is not locked while the build waits for a slot and runs, so it keeps serving meanwhile.
+func (a *GoApp) Build() error {
+	a.Lock()
+	rel := a.current
+	if rel == nil {
+		defer a.Unlock()
+		return a.releaseFailed("build", errors.New("app has no release to build"))
+	}
+
+	if rel.Source == SOURCE_ARTIFACT || a.built == rel {
+		// prebuilt, nothing to build
+		a.Unlock()
+		return nil
+	}
+
+	a.preparing(STATE_BUILDING, fmt.Sprintf("building release %d", rel.ID))
+	a.Unlock()
+
+	err := a.build(rel)
 
-	a.Status = "STARTED"
+	a.Lock()
+	defer a.Unlock()
+
+	if err != nil {
+		return a.releaseFailed("build", err)
+	}
+
+	a.built = rel
+
+	return nil
+}
+
+// build runs go build for rel when the scheduler gives it a slot. The app must not be locked.
+func (a *GoApp) build(rel *Release) error {
+	releaseDir := a.releaseDir(rel)
+	subdir := rel.Subdir
+
+	// buildCmd := exec.Command("go", "build", "-o", a.Name)
+	buildCmd := cmd.NewCmd("go", "build", "-o", a.Name)
+	buildCmd.Dir = path.Join(releaseDir, subdir)
+	buildCmd.Env = a.goCmdEnv(releaseDir, subdir)
+
+	err := a.sched.Run(a.Name, JOB_BUILD, 0, func(ctx context.Context) error {
+		done := a.goenv.use()
+		defer done()
+
+		started := time.Now()
+		status := runCmd(ctx, buildCmd)
+		a.metrics.build(time.Since(started))
+		if status.Error != nil {
+			return status.Error
+		}
+
+		if status.Exit != 0 {
+			return fmt.Errorf("go build exited with %d: %s", status.Exit, strings.Join(status.Stderr, "\n"))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	a.goenv.collect()
 
 	return nil
 }
 
-func (a *GoApp) attach(repo *git.Repository) error {
+// attach records the checked out commit of repo in rel.
+func (a *GoApp) attach(repo *git.Repository, rel *Release) error {
 	head, err := repo.Head()
 	if err != nil {
-		a.Status = "ERR:GITLOG"
-		a.lastErr = err
 		return err
 	}
 
 	commit, err := repo.CommitObject(head.Hash())
 	if err != nil {
-		a.Status = "ERR:GITLOG"
-		a.lastErr = err
 		return err
 	}
 
-	hash := head.Hash().String()[0:7]
-	a.gitCommit = fmt.Sprintf("%s %s by %s at %s",
-		hash, strings.TrimRight(commit.Message, "\n"),
-		commit.Author.String(), commit.Author.When.String())
+	if head.Name().IsBranch() {
+		rel.Branch = head.Name().Short()
+	}
 
-	remote, err := repo.Remote("origin")
-	a.GitURL = remote.Config().URLs[0]
+	rel.GitHash = head.Hash().String()
+	rel.GitCommit = fmt.Sprintf("%s %s by %s at %s",
+		rel.GitHash[0:7], strings.TrimRight(commit.Message, "\n"),
+		commit.Author.String(), commit.Author.When.String())
 
 	return nil
 }
 
+// Reattach loads the app record saved in the app dir.
 func (a *GoApp) Reattach() error {
 	a.Lock()
 	defer a.Unlock()
 
-	repo, err := git.PlainOpen(a.AppDir)
+	a.register("found in " + a.AppDir)
+
+	err := a.load()
 	if err != nil {
-		return err
+		return a.releaseFailed("reattach", err)
+	}
+
+	if a.uid != 0 {
+		// reserved before any app starts, unless it is not in the range anymore
+		_, _ = a.users.allocate(a.Name, a.uid)
+	}
+
+	return nil
+}
+
+// Releases returns the release history of the app, oldest first.
+func (a *GoApp) Releases() []Release {
+	a.Lock()
+	defer a.Unlock()
+
+	releases := make([]Release, 0, len(a.releases))
+	for _, r := range a.releases {
+		releases = append(releases, *r)
 	}
 
-	return a.attach(repo)
+	return releases
 }
 
 func (a *GoApp) Stop() (retErr error) {
 	a.Lock()
 	defer a.Unlock()
 
-	if a.Status == "STARTED" {
+	switch state := a.currentState(); state {
+	case STATE_RUNNING:
+		pid, release := a.proc.Status().PID, a.running.ID
+		a.mustTransition(STATE_STOPPING, fmt.Sprintf("stopping pid %d", pid))
 		retErr = a.proc.Stop()
-		a.proc = nil
-		a.stdout.Close()
-		a.stderr.Close()
-		a.Status = "STOPPED"
+		a.exited()
+		a.mustTransition(STATE_STOPPED, "stopped")
+		a.publish(EVENT_PROCESS_EXITED, release, fmt.Sprintf("pid %d stopped", pid))
 
 		return
+	case STATE_CRASHED:
+		a.exited()
+		a.mustTransition(STATE_STOPPED, "cleaned up after the crash")
+
+		return nil
+	default:
+		return errors.New("app not started: state=" + string(state))
 	}
+}
 
-	return errors.New("app not started: status=" + a.Status)
+// exited lets go of the process of the app. The app must be locked.
+func (a *GoApp) exited() {
+	a.stopSampling()
+	a.proc = nil
+	a.running = nil
+	a.stdout.Close()
+	a.stderr.Close()
 }
 
+// ServeHTTP proxies req to the app, and counts it in the metrics of the app.
 func (a *GoApp) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	started := time.Now()
+	rec := &responseRecorder{ResponseWriter: rw, code: http.StatusOK}
+	var body *countingReader
+	if req.Body != nil && req.Body != http.NoBody {
+		body = &countingReader{ReadCloser: req.Body}
+		req.Body = body
+	}
+
+	proxy, ok := a.proxy.Load().(*httputil.ReverseProxy)
+	if !ok {
+		http.Error(rw, "app not started", http.StatusBadGateway)
+		return
+	}
+
 	req.URL.Path = strings.TrimPrefix(req.URL.Path, "/"+a.Name)
-	a.proxy.ServeHTTP(rw, req)
+	proxy.ServeHTTP(rec, req)
+
+	var read uint64
+	if body != nil {
+		read = body.read
+	}
+
+	a.metrics.request(rec.code, read, rec.written, time.Since(started))
+}
+
+// TrackedBranch returns the branch the app is deployed from, which is the remote HEAD when no branch was given.
+// It is "" if the app has never been cloned from git.
+func (a *GoApp) TrackedBranch() string {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.Branch != "" || a.current == nil {
+		return a.Branch
+	}
+
+	return a.current.Branch
+}
+
+// SetBranch changes the branch the app is deployed from. It takes effect on the next Rebuild.
+func (a *GoApp) SetBranch(branch string) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.Branch = branch
 }
 
 func (a *GoApp) Pull() {
@@ -264,21 +876,71 @@ func (a *GoApp) MarshalJSON() ([]byte, error) {
 		Exit: -1,
 	}
 
+	var gitHash, gitCommit string
+	branch := a.Branch
+	if a.current != nil {
+		gitHash, gitCommit = a.current.GitHash, a.current.GitCommit
+		if branch == "" {
+			branch = a.current.Branch
+		}
+	}
+
+	var op *operation
+	a.opLock.Lock()
+	if a.op != nil {
+		op = &operation{Name: a.op.Name, Since: a.op.Since}
+	}
+	a.opLock.Unlock()
+
+	var poll *PollStatus
+	if a.poller != nil {
+		status := a.poller.Status()
+		poll = &status
+	}
+
+	var stateSince *time.Time
+	var timeInState int64
+	if !a.stateSince.IsZero() {
+		stateSince = &a.stateSince
+		timeInState = int64(time.Since(a.stateSince).Seconds())
+	}
+
+	var startedAt *time.Time
+	var uptime int64
 	if a.proc != nil {
 		status = a.proc.Status()
+		startedAt = &a.startedAt
+		uptime = int64(time.Since(a.startedAt).Seconds())
 	}
 
 	return json.Marshal(struct {
-		Name      string `json:"name"`
-		GitURL    string `json:"gitUrl"`
-		GitCommit string `json:"gitCommit"`
-		Status    string `json:"status"`
-		AppDir    string `json:"appDir"`
-		LastErr   string `json:"lastError"`
-		PID       int    `json:"pid"`
-		Exit      int    `json:"exit"`
+		Name        string        `json:"name"`
+		GitURL      string        `json:"gitUrl"`
+		Branch      string        `json:"branch"`
+		Config      AppConfig     `json:"config"`
+		GitHash     string        `json:"gitHash"`
+		GitCommit   string        `json:"gitCommit"`
+		State       State         `json:"state"`
+		StateSince  *time.Time    `json:"stateSince,omitempty"`
+		TimeInState int64         `json:"timeInState"`
+		Events      []Event       `json:"events"`
+		AppDir      string        `json:"appDir"`
+		LastErr     string        `json:"lastError"`
+		PID         int           `json:"pid"`
+		Exit        int           `json:"exit"`
+		Release     *Release      `json:"release,omitempty"`
+		StartedAt   *time.Time    `json:"startedAt,omitempty"`
+		Uptime      int64         `json:"uptime"`
+		Restarts    int           `json:"restarts"`
+		Poll        *PollStatus   `json:"poll,omitempty"`
+		Operation   *operation    `json:"operation,omitempty"`
+		Usage       *Usage        `json:"usage,omitempty"`
+		Cgroup      *CgroupUsage  `json:"cgroup,omitempty"`
+		Process     *ProcIdentity `json:"process,omitempty"`
 	}{
-		a.Name, a.GitURL, a.gitCommit, a.Status, a.AppDir, errMsg,
+		a.Name, a.GitURL, branch, a.config, gitHash, gitCommit,
+		a.currentState(), stateSince, timeInState, a.lastEvents(EVENTS_SHOWN), a.AppDir, errMsg,
 		status.PID, status.Exit,
+		a.current, startedAt, uptime, a.restarts, poll, op, a.lastUsage(), a.cgroupUsage(), a.processIdentity(),
 	})
 }
@@ -14,7 +14,7 @@ func TestCanMarshalIntoJSON(t *testing.T) {
 		Mutex:   sync.Mutex{},
 		Name:    "hello-world",
 		GitURL:  "git@test.git",
-		Status:  "ERR:GITCLONE",
+		state:   STATE_FAILED,
 		AppDir:  "./",
 		lastErr: errors.New("testError"),
 	}
@@ -0,0 +1,405 @@
+package core
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	CACHE_DIRNAME      = "cache"
+	GOCACHE_DIRNAME    = "go-build"
+	GOMODCACHE_DIRNAME = "mod"
+	MODMIRROR_DIRNAME  = "modmirror"
+)
+
+const defaultGoProxy = "https://proxy.golang.org,direct"
+
+// GoEnv is the environment go-runner runs the go tool in for builds and gates:
+// a build and module cache shared by all apps, and where modules come from.
+type GoEnv struct {
+	// builds hold a read lock on the caches, and GC the write lock, so nothing is collected in the middle of a build.
+	sync.RWMutex
+	cacheDir    string
+	modCacheDir string
+	mirrorDir   string
+	goProxy     string
+	goModFlag   string
+	cacheMax    int64
+	modCacheMax int64
+	collecting  int32
+	lastGC      *GCResult
+	log         *zerolog.Logger
+}
+
+// CacheInfo is the size of a cache.
+type CacheInfo struct {
+	Dir  string `json:"dir"`
+	Size int64  `json:"size"`
+	// Max is the size it is collected down to, 0 if there is no limit
+	Max int64 `json:"max"`
+}
+
+// GCResult is what a GC of the caches freed.
+type GCResult struct {
+	At              time.Time `json:"at"`
+	BuildCacheFreed int64     `json:"buildCacheFreed"`
+	ModCacheFreed   int64     `json:"modCacheFreed"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// GoEnvInfo is how go-runner runs the go tool.
+type GoEnvInfo struct {
+	BuildCache CacheInfo `json:"buildCache"`
+	ModCache   CacheInfo `json:"modCache"`
+	ModMirror  string    `json:"modMirror,omitempty"`
+	GoProxy    string    `json:"goProxy"`
+	GoModFlag  string    `json:"goModFlag,omitempty"`
+	LastGC     *GCResult `json:"lastGC,omitempty"`
+}
+
+func newGoEnv(wd string, opts Options, log *zerolog.Logger) *GoEnv {
+	cacheDir := path.Join(wd, CACHE_DIRNAME)
+	mirror := opts.ModMirror
+	if mirror == "" {
+		mirror = path.Join(wd, MODMIRROR_DIRNAME)
+	}
+
+	if abs, err := filepath.Abs(mirror); err == nil {
+		mirror = abs
+	}
+
+	if abs, err := filepath.Abs(cacheDir); err == nil {
+		cacheDir = abs
+	}
+
+	modFlag := opts.GoModFlag
+	switch modFlag {
+	case "", "mod", "readonly", "vendor":
+	default:
+		log.Warn().Msgf("ignoring invalid -mod flag for builds: %s", modFlag)
+		modFlag = ""
+	}
+
+	return &GoEnv{
+		cacheDir:    path.Join(cacheDir, GOCACHE_DIRNAME),
+		modCacheDir: path.Join(cacheDir, GOMODCACHE_DIRNAME),
+		mirrorDir:   mirror,
+		goProxy:     opts.GoProxy,
+		goModFlag:   modFlag,
+		cacheMax:    opts.BuildCacheMax,
+		modCacheMax: opts.ModCacheMax,
+		log:         log,
+	}
+}
+
+// Env returns the env vars to run the go tool with, on top of the env of go-runner.
+func (e *GoEnv) Env() []string {
+	if e == nil {
+		return nil
+	}
+
+	env := []string{
+		"GOCACHE=" + e.cacheDir,
+		"GOMODCACHE=" + e.modCacheDir,
+		"GOPROXY=" + e.proxy(),
+	}
+
+	if e.goModFlag != "" {
+		env = append(env, "GOFLAGS="+withModFlag(os.Getenv("GOFLAGS"), e.goModFlag))
+	}
+
+	return env
+}
+
+// proxy returns the GOPROXY of builds: the module mirror if it exists, then the configured proxy,
+// or the one go-runner runs with.
+func (e *GoEnv) proxy() string {
+	proxy := e.goProxy
+	if proxy == "" {
+		proxy = os.Getenv("GOPROXY")
+	}
+
+	if proxy == "" {
+		proxy = defaultGoProxy
+	}
+
+	if info, err := os.Stat(e.mirrorDir); err != nil || !info.IsDir() {
+		return proxy
+	}
+
+	mirror := "file://" + filepath.ToSlash(e.mirrorDir)
+	if proxy == "off" {
+		return mirror
+	}
+
+	return mirror + "," + proxy
+}
+
+// withModFlag replaces the -mod flag in goflags with -mod=flag.
+func withModFlag(goflags, flag string) string {
+	var flags []string
+	for _, f := range strings.Fields(goflags) {
+		if !strings.HasPrefix(f, "-mod=") && !strings.HasPrefix(f, "--mod=") {
+			flags = append(flags, f)
+		}
+	}
+
+	return strings.Join(append(flags, "-mod="+flag), " ")
+}
+
+// use holds the caches for a build. Call the returned func when the build is done.
+func (e *GoEnv) use() func() {
+	if e == nil {
+		return func() {}
+	}
+
+	e.RLock()
+
+	return e.RUnlock
+}
+
+// collect runs GC in the background, unless it is running already.
+func (e *GoEnv) collect() {
+	if e == nil || e.cacheMax <= 0 && e.modCacheMax <= 0 {
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&e.collecting, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&e.collecting, 0)
+		_ = e.GC()
+	}()
+}
+
+// GC removes the least recently used entries of the build cache, and the oldest module versions in the module cache,
+// until they are no bigger than their max size. It waits for the builds in progress.
+func (e *GoEnv) GC() GCResult {
+	e.Lock()
+	defer e.Unlock()
+
+	result := GCResult{At: time.Now()}
+
+	var errs []string
+	if e.cacheMax > 0 {
+		freed, err := gcBuildCache(e.cacheDir, e.cacheMax)
+		result.BuildCacheFreed = freed
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if e.modCacheMax > 0 {
+		freed, err := gcModCache(e.modCacheDir, e.modCacheMax)
+		result.ModCacheFreed = freed
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	result.Error = strings.Join(errs, "; ")
+	if result.Error != "" {
+		e.log.Warn().Msgf("failed to collect go caches: %s", result.Error)
+	} else if result.BuildCacheFreed > 0 || result.ModCacheFreed > 0 {
+		e.log.Info().Msgf("collected go caches. buildCacheFreed=%d, modCacheFreed=%d", result.BuildCacheFreed, result.ModCacheFreed)
+	}
+
+	e.lastGC = &result
+
+	return result
+}
+
+// Info returns the sizes of the caches and where modules come from.
+func (e *GoEnv) Info() GoEnvInfo {
+	e.RLock()
+	defer e.RUnlock()
+
+	info := GoEnvInfo{
+		BuildCache: CacheInfo{Dir: e.cacheDir, Size: dirSize(e.cacheDir), Max: e.cacheMax},
+		ModCache:   CacheInfo{Dir: e.modCacheDir, Size: dirSize(e.modCacheDir), Max: e.modCacheMax},
+		GoProxy:    e.proxy(),
+		GoModFlag:  e.goModFlag,
+		LastGC:     e.lastGC,
+	}
+
+	if strings.HasPrefix(info.GoProxy, "file://") {
+		info.ModMirror = e.mirrorDir
+	}
+
+	return info
+}
+
+// cacheEntry is something removed from a cache as a whole.
+type cacheEntry struct {
+	paths   []string
+	size    int64
+	modTime time.Time
+}
+
+// gcBuildCache removes the least recently used files of GOCACHE until it is no bigger than max.
+// The go tool updates the mtime of the entries it uses.
+func gcBuildCache(dir string, max int64) (int64, error) {
+	var entries []cacheEntry
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		// the top level files, i.e. README and trim.txt, are not entries
+		if d.IsDir() || path.Dir(p) == dir {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		entries = append(entries, cacheEntry{[]string{p}, info.Size(), info.ModTime()})
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return evict(entries, max, os.Remove)
+}
+
+// gcModCache removes the oldest module versions from GOMODCACHE until it is no bigger than max.
+// A module version is its extracted dir, and its files in the download cache.
+func gcModCache(dir string, max int64) (int64, error) {
+	download := path.Join(dir, "cache", "download")
+
+	var entries []cacheEntry
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if !d.IsDir() || p == dir {
+			return nil
+		}
+
+		rel := strings.TrimPrefix(p, dir+"/")
+		if rel == "cache" {
+			return fs.SkipDir
+		}
+
+		if !strings.Contains(d.Name(), "@") {
+			return nil
+		}
+
+		// e.g. github.com/go-git/go-git/v5@v5.3.0, matching cache/download/github.com/go-git/go-git/v5/@v/v5.3.0.*
+		at := strings.LastIndex(rel, "@")
+		downloads, _ := filepath.Glob(path.Join(download, rel[:at], "@v", rel[at+1:]+".*"))
+
+		entry := cacheEntry{paths: append([]string{p}, downloads...)}
+		for _, f := range entry.paths {
+			entry.size += dirSize(f)
+		}
+
+		if info, err := d.Info(); err == nil {
+			entry.modTime = info.ModTime()
+		}
+
+		entries = append(entries, entry)
+
+		return fs.SkipDir
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var versions int64
+	for _, e := range entries {
+		versions += e.size
+	}
+
+	// the files not counted in any module version, e.g. the vcs and sumdb caches, stay
+	return evict(entries, max-(dirSize(dir)-versions), removeReadOnly)
+}
+
+// evict removes the oldest entries until the rest take no more than max.
+func evict(entries []cacheEntry, max int64, remove func(string) error) (int64, error) {
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	var freed int64
+	for _, e := range entries {
+		if total-freed <= max {
+			break
+		}
+
+		for _, p := range e.paths {
+			err := remove(p)
+			if err != nil && !os.IsNotExist(err) {
+				return freed, err
+			}
+		}
+
+		freed += e.size
+	}
+
+	return freed, nil
+}
+
+// removeReadOnly removes a file or dir in the module cache, which the go tool makes read-only.
+func removeReadOnly(p string) error {
+	_ = filepath.WalkDir(p, func(f string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			_ = os.Chmod(f, 0755)
+		}
+
+		return nil
+	})
+
+	return os.RemoveAll(p)
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return size
+}
+
+// goCmdEnv returns the env to run the go tool with for the app at subdir of releaseDir.
+func (a *GoApp) goCmdEnv(releaseDir, subdir string) []string {
+	return append(buildEnv(releaseDir, subdir), a.goenv.Env()...)
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+func writeCacheFile(t *testing.T, file string, size int, age time.Duration) {
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(file, make([]byte, size), 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Now().Add(-age)
+	if err := os.Chtimes(file, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGoEnvPutsTheMirrorFirst(t *testing.T) {
+	expect := util.NewExpect(t)
+	wd := t.TempDir()
+	t.Setenv("GOFLAGS", "-mod=mod -trimpath")
+
+	goenv := newGoEnv(wd, Options{GoProxy: "off", GoModFlag: "vendor"}, &log.Logger)
+	expect.Equal([]string{
+		"GOCACHE=" + path.Join(wd, CACHE_DIRNAME, GOCACHE_DIRNAME),
+		"GOMODCACHE=" + path.Join(wd, CACHE_DIRNAME, GOMODCACHE_DIRNAME),
+		"GOPROXY=off",
+		"GOFLAGS=-trimpath -mod=vendor",
+	}, goenv.Env())
+
+	expect.Nil(os.Mkdir(path.Join(wd, MODMIRROR_DIRNAME), 0755))
+	expect.Equal("file://"+path.Join(wd, MODMIRROR_DIRNAME), goenv.proxy())
+
+	goenv = newGoEnv(wd, Options{GoProxy: "https://proxy.internal", GoModFlag: "bogus"}, &log.Logger)
+	expect.Equal("file://"+path.Join(wd, MODMIRROR_DIRNAME)+",https://proxy.internal", goenv.proxy())
+	expect.Equal(3, len(goenv.Env()))
+
+	var none *GoEnv
+	expect.Equal(0, len(none.Env()))
+	none.use()()
+	none.collect()
+}
+
+func TestGCRemovesTheOldestFirst(t *testing.T) {
+	expect := util.NewExpect(t)
+	wd := t.TempDir()
+	goenv := newGoEnv(wd, Options{BuildCacheMax: 150, ModCacheMax: 150}, &log.Logger)
+
+	writeCacheFile(t, path.Join(goenv.cacheDir, "README"), 10, 0)
+	writeCacheFile(t, path.Join(goenv.cacheDir, "0a", "old-a"), 100, 3*time.Hour)
+	writeCacheFile(t, path.Join(goenv.cacheDir, "0b", "used-a"), 100, time.Minute)
+	writeCacheFile(t, path.Join(goenv.cacheDir, "0c", "older-d"), 100, 2*time.Hour)
+
+	mod := func(version string, age time.Duration) {
+		writeCacheFile(t, path.Join(goenv.modCacheDir, "example.com", "lib@"+version, "lib.go"), 50, age)
+		writeCacheFile(t, path.Join(goenv.modCacheDir, "cache", "download", "example.com", "lib", "@v", version+".zip"), 50, age)
+		at := time.Now().Add(-age)
+		expect.Nil(os.Chtimes(path.Join(goenv.modCacheDir, "example.com", "lib@"+version), at, at))
+		expect.Nil(os.Chmod(path.Join(goenv.modCacheDir, "example.com", "lib@"+version), 0555))
+	}
+	mod("v1.0.0", 3*time.Hour)
+	mod("v1.1.0", 2*time.Hour)
+	mod("v1.2.0", time.Minute)
+	writeCacheFile(t, path.Join(goenv.modCacheDir, "cache", "download", "sumdb", "tile"), 20, 0)
+
+	result := goenv.GC()
+	expect.Equal("", result.Error)
+	expect.Equal(int64(200), result.BuildCacheFreed)
+	expect.Equal(int64(200), result.ModCacheFreed)
+
+	_, err := os.Stat(path.Join(goenv.cacheDir, "0b", "used-a"))
+	expect.Nil(err)
+	_, err = os.Stat(path.Join(goenv.cacheDir, "0c", "older-d"))
+	expect.True(os.IsNotExist(err))
+
+	_, err = os.Stat(path.Join(goenv.modCacheDir, "example.com", "lib@v1.1.0"))
+	expect.True(os.IsNotExist(err))
+	_, err = os.Stat(path.Join(goenv.modCacheDir, "cache", "download", "example.com", "lib", "@v", "v1.1.0.zip"))
+	expect.True(os.IsNotExist(err))
+	_, err = os.Stat(path.Join(goenv.modCacheDir, "example.com", "lib@v1.2.0", "lib.go"))
+	expect.Nil(err)
+
+	info := goenv.Info()
+	expect.Equal(int64(110), info.BuildCache.Size)
+	expect.Equal(int64(120), info.ModCache.Size)
+	expect.True(info.LastGC != nil)
+
+	// read-only dirs, as go leaves them
+	expect.Nil(removeReadOnly(goenv.modCacheDir))
+}
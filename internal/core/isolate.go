@@ -0,0 +1,88 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// SANDBOX_APP is where the release dir of an isolated app is mounted, read-only.
+	SANDBOX_APP = "/app"
+	// SANDBOX_DATA is where the data dir of an isolated app is mounted, which is kept across releases.
+	SANDBOX_DATA = "/data"
+	// SANDBOX_RUN is where the dir of the unix socket of an isolated app is mounted.
+	SANDBOX_RUN = "/run/go-runner"
+	// DATA_DIR_ENV tells an app where its data dir is.
+	DATA_DIR_ENV = "GORUNNER_DATA_DIR"
+)
+
+// SANDBOX_SYSTEM_DIRS are mounted read-only into the root of an isolated app, if they exist on the host,
+// for the shared libraries, certificates and timezones the app may need.
+var SANDBOX_SYSTEM_DIRS = []string{"/bin", "/etc", "/lib", "/lib32", "/lib64", "/sbin", "/usr"}
+
+// SANDBOX_DEVICES are bound from the host into the /dev of an isolated app.
+var SANDBOX_DEVICES = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// isolation is the namespaces an app runs in: new pid, mount, ipc and uts namespaces, and a user namespace
+// when go-runner is not root. The app sees a root of its own with only the system dirs, its release, its data
+// dir and a tmpfs /tmp.
+type isolation struct {
+	// Root is the empty dir the root of the app is set up on
+	Root string `json:"root"`
+	// Release, Data and Run are the dirs mounted at SANDBOX_APP, SANDBOX_DATA and SANDBOX_RUN
+	Release string `json:"release"`
+	Data    string `json:"data"`
+	Run     string `json:"run"`
+	// Dir is the working dir of the app in its root
+	Dir      string `json:"dir"`
+	Hostname string `json:"hostname"`
+	// UserNS maps the user of go-runner to root in a new user namespace, which is needed to set up the others
+	// without privileges
+	UserNS bool `json:"userNS,omitempty"`
+}
+
+// isolate prepares the dirs to run the executable exe of the release in releaseDir in isolation, from dir.
+// It returns the isolation and where the executable is in it.
+func (a *GoApp) isolate(releaseDir, exe, dir string) (*isolation, string, error) {
+	iso := &isolation{
+		Root:     path.Join(a.AppDir, "root"),
+		Release:  releaseDir,
+		Data:     path.Join(a.AppDir, DATA_DIRNAME),
+		Run:      path.Join(a.AppDir, RUN_DIRNAME),
+		Hostname: a.Name,
+		UserNS:   os.Geteuid() != 0,
+	}
+
+	err := os.MkdirAll(iso.Root, 0755)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sandboxed := func(p string) (string, error) {
+		rel, err := filepath.Rel(releaseDir, p)
+		if err != nil {
+			return "", err
+		}
+
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			return "", fmt.Errorf("%s is not in the release dir", p)
+		}
+
+		return path.Join(SANDBOX_APP, rel), nil
+	}
+
+	sandboxExe, err := sandboxed(exe)
+	if err != nil {
+		return nil, "", err
+	}
+
+	iso.Dir, err = sandboxed(dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return iso, sandboxExe, nil
+}
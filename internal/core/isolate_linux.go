@@ -0,0 +1,411 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// FORWARDED_SIGNALS are passed on by the launchers of an isolated app down to the app.
+var FORWARDED_SIGNALS = []os.Signal{syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// launchIsolated starts the launcher again in the namespaces of the app, as their init, and waits for it,
+// passing on the signals it gets. It exits like the init does.
+func launchIsolated(spec launchSpec, exe string, args []string) error {
+	inner := spec
+	inner.Cgroup, inner.Init = "", true
+	encoded, err := json.Marshal(inner)
+	if err != nil {
+		return err
+	}
+
+	// the parent death signal is sent when the thread that started the init exits, rather than the process
+	runtime.LockOSThread()
+
+	child := &exec.Cmd{
+		Path:   "/proc/self/exe",
+		Args:   append([]string{os.Args[0], LAUNCH_ARG, exe}, args[1:]...),
+		Env:    append(os.Environ(), LAUNCH_ENV+"="+string(encoded)),
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		SysProcAttr: &syscall.SysProcAttr{
+			Cloneflags: syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+			// signals to the process group of go-runner reach the app through the launchers only
+			Setpgid:   true,
+			Pdeathsig: syscall.SIGKILL,
+		},
+	}
+
+	if spec.NoNetwork {
+		child.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+
+	if spec.Isolation.UserNS {
+		child.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		child.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+		child.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	}
+
+	err = child.Start()
+	if err != nil {
+		return fmt.Errorf("failed to create the namespaces: %w", err)
+	}
+
+	forwardSignals(child.Process)
+	_ = child.Wait()
+
+	// the init exits with 128+n for the app killed by the signal n, as it cannot be killed by it itself
+	status := child.ProcessState.Sys().(syscall.WaitStatus)
+	if code := status.ExitStatus(); code > 128 && code <= 128+64 {
+		status = syscall.WaitStatus(code - 128)
+	}
+
+	exitLike(status)
+
+	return nil
+}
+
+// launchInit sets up the root of the app, then launches the app and waits for it as the init of its pid namespace,
+// which reaps the orphans in it. It exits like the app does, which kills the rest of the namespace. The app is
+// launched by go-runner once more, so that its confinement doesn't apply to the init.
+func launchInit(spec launchSpec, r *report, args []string) error {
+	iso := spec.Isolation
+	if iso == nil {
+		return errors.New("no isolation to set up")
+	}
+
+	err := syscall.Sethostname([]byte(iso.Hostname))
+	if err != nil {
+		return fmt.Errorf("failed to set the hostname: %w", err)
+	}
+
+	if spec.NoNetwork {
+		err = upLoopback()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = setupRoot(iso)
+	if err != nil {
+		return err
+	}
+
+	final := spec
+	final.Isolation, final.Init, final.Report, final.NoNetwork = nil, false, "", false
+	app := &exec.Cmd{
+		// go-runner, which is not in the root of the app
+		Path:   "/proc/self/exe",
+		Args:   append([]string{os.Args[0], LAUNCH_ARG}, args...),
+		Dir:    iso.Dir,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+
+	if r != nil {
+		// the report file is out of the root too
+		app.ExtraFiles = []*os.File{r.f}
+		final.ReportFD = 3
+	}
+
+	encoded, err := json.Marshal(final)
+	if err != nil {
+		return err
+	}
+
+	app.Env = append(os.Environ(), LAUNCH_ENV+"="+string(encoded))
+	err = app.Start()
+	if err != nil {
+		return err
+	}
+
+	if r != nil {
+		// the lock the launcher of the app takes on the report is only let go of once no one has it open
+		_ = r.f.Close()
+	}
+
+	forwardSignals(app.Process)
+
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to wait for the app: %w", err)
+		}
+
+		if pid == app.Process.Pid {
+			exitLike(status)
+		}
+	}
+}
+
+// setupRoot makes the mount namespace a root of its own for the app and pivots into it.
+func setupRoot(iso *isolation) error {
+	root := iso.Root
+
+	// keep the mounts below from propagating back to the host
+	err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+	if err != nil {
+		return fmt.Errorf("failed to make the mounts private: %w", err)
+	}
+
+	err = syscall.Mount("tmpfs", root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755")
+	if err != nil {
+		return fmt.Errorf("failed to mount the root: %w", err)
+	}
+
+	for _, dir := range SANDBOX_SYSTEM_DIRS {
+		err = bindSystemDir(dir, path.Join(root, dir))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, m := range []struct {
+		src, dst string
+		readOnly bool
+	}{
+		{iso.Release, SANDBOX_APP, true},
+		{iso.Data, SANDBOX_DATA, false},
+		{iso.Run, SANDBOX_RUN, false},
+	} {
+		err = bind(m.src, path.Join(root, m.dst), m.readOnly)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = mountTmpfs(path.Join(root, "tmp"), "mode=1777")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(path.Join(root, "proc"), 0555)
+	if err == nil {
+		err = syscall.Mount("proc", path.Join(root, "proc"), "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to mount /proc: %w", err)
+	}
+
+	err = setupDev(path.Join(root, "dev"))
+	if err != nil {
+		return err
+	}
+
+	old := path.Join(root, ".old")
+	err = os.Mkdir(old, 0700)
+	if err == nil {
+		err = syscall.PivotRoot(root, old)
+	}
+
+	if err == nil {
+		err = os.Chdir("/")
+	}
+
+	if err == nil {
+		err = syscall.Unmount("/.old", syscall.MNT_DETACH)
+	}
+
+	if err == nil {
+		err = os.Remove("/.old")
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to pivot into the root: %w", err)
+	}
+
+	err = syscall.Mount("", "/", "", syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, "")
+	if err != nil {
+		return fmt.Errorf("failed to make the root read-only: %w", err)
+	}
+
+	return nil
+}
+
+// bindSystemDir binds the system dir of the host read-only at dst, or links it like the host does if it is
+// a symlink, e.g. /lib to /usr/lib.
+func bindSystemDir(dir, dst string) error {
+	fi, err := os.Lstat(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(dir)
+		if err != nil {
+			return err
+		}
+
+		return os.Symlink(target, dst)
+	}
+
+	return bind(dir, dst, true)
+}
+
+// bind mounts src at dst, creating dst like src.
+func bind(src, dst string, readOnly bool) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		err = os.MkdirAll(dst, 0755)
+	} else {
+		err = os.MkdirAll(path.Dir(dst), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(dst, nil, 0644)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = syscall.Mount(src, dst, "", syscall.MS_BIND|syscall.MS_REC, "")
+	if err != nil {
+		return fmt.Errorf("failed to bind %s: %w", src, err)
+	}
+
+	if !readOnly {
+		return nil
+	}
+
+	// a read-only remount has to keep the flags of the mount it is bound from, or it is not permitted in a user namespace
+	var st syscall.Statfs_t
+	err = syscall.Statfs(src, &st)
+	if err != nil {
+		return err
+	}
+
+	flags := uintptr(syscall.MS_REMOUNT | syscall.MS_BIND | syscall.MS_RDONLY)
+	for stFlag, msFlag := range map[int64]uintptr{
+		ST_NOSUID:     syscall.MS_NOSUID,
+		ST_NODEV:      syscall.MS_NODEV,
+		ST_NOEXEC:     syscall.MS_NOEXEC,
+		ST_NOATIME:    syscall.MS_NOATIME,
+		ST_NODIRATIME: syscall.MS_NODIRATIME,
+		ST_RELATIME:   syscall.MS_RELATIME,
+	} {
+		if int64(st.Flags)&stFlag != 0 {
+			flags |= msFlag
+		}
+	}
+
+	err = syscall.Mount("", dst, "", flags, "")
+	if err != nil {
+		return fmt.Errorf("failed to make %s read-only: %w", src, err)
+	}
+
+	return nil
+}
+
+// the flags of statfs, see statvfs(3)
+const (
+	ST_NOSUID     = 0x2
+	ST_NODEV      = 0x4
+	ST_NOEXEC     = 0x8
+	ST_NOATIME    = 0x400
+	ST_NODIRATIME = 0x800
+	ST_RELATIME   = 0x1000
+)
+
+func mountTmpfs(dir, options string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err == nil {
+		err = syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, options)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to mount a tmpfs at %s: %w", dir, err)
+	}
+
+	return nil
+}
+
+// setupDev sets up a minimal /dev at dev, with the devices bound from the host, as they cannot be created
+// in a user namespace.
+func setupDev(dev string) error {
+	err := mountTmpfs(dev, "mode=0755")
+	if err != nil {
+		return err
+	}
+
+	for _, device := range SANDBOX_DEVICES {
+		if _, err := os.Stat(path.Join("/dev", device)); os.IsNotExist(err) {
+			continue
+		}
+
+		err = bind(path.Join("/dev", device), path.Join(dev, device), false)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = mountTmpfs(path.Join(dev, "shm"), "mode=1777")
+	if err != nil {
+		return err
+	}
+
+	for link, target := range map[string]string{
+		"fd":     "/proc/self/fd",
+		"stdin":  "/proc/self/fd/0",
+		"stdout": "/proc/self/fd/1",
+		"stderr": "/proc/self/fd/2",
+	} {
+		err = os.Symlink(target, path.Join(dev, link))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// forwardSignals passes the FORWARDED_SIGNALS on to p.
+func forwardSignals(p *os.Process) {
+	signals := make(chan os.Signal, len(FORWARDED_SIGNALS))
+	signal.Notify(signals, FORWARDED_SIGNALS...)
+	go func() {
+		for sig := range signals {
+			_ = p.Signal(sig)
+		}
+	}()
+}
+
+// exitLike exits the way the process of status did: with the same code, or killed by the same signal, without
+// dumping the core of the launcher.
+func exitLike(status syscall.WaitStatus) {
+	if status.Signaled() {
+		sig := status.Signal()
+		_ = syscall.Setrlimit(syscall.RLIMIT_CORE, &syscall.Rlimit{})
+		// rather than signal.Reset, after which the Go runtime crashes on e.g. SIGSYS instead of being killed by it
+		var dfl [4]uint64
+		_, _, _ = syscall.RawSyscall6(syscall.SYS_RT_SIGACTION, uintptr(sig), uintptr(unsafe.Pointer(&dfl)), 0, 8, 0, 0)
+		_ = syscall.Kill(os.Getpid(), sig)
+		// the signal is ignored, e.g. SIGTERM by the init of a pid namespace
+		os.Exit(128 + int(sig))
+	}
+
+	os.Exit(status.ExitStatus())
+}
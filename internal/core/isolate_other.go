@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package core
+
+import "errors"
+
+var errIsolationUnsupported = errors.New("isolation is only supported on Linux")
+
+func launchIsolated(spec launchSpec, exe string, args []string) error {
+	return errIsolationUnsupported
+}
+
+func launchInit(spec launchSpec, r *report, args []string) error {
+	return errIsolationUnsupported
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+// seeIsolation writes what the isolated app sees into seen in its data dir.
+func seeIsolation(dataDir string) {
+	hostname, _ := os.Hostname()
+	cwd, _ := os.Getwd()
+	_, hostErr := os.Stat(os.Getenv("GORUNNER_TEST_APP_DIR"))
+	appErr := ioutil.WriteFile(path.Join(SANDBOX_APP, "written"), nil, 0644)
+
+	seen := fmt.Sprintf("ppid=%d hostname=%s cwd=%s args=%s appDirHidden=%t releaseReadOnly=%t",
+		os.Getppid(), hostname, cwd, strings.Join(os.Args[1:], " "), os.IsNotExist(hostErr), appErr != nil)
+	_ = ioutil.WriteFile(path.Join(dataDir, "seen"), []byte(seen), 0644)
+}
+
+func TestAppRunsIsolated(t *testing.T) {
+	expect := util.NewExpect(t)
+	goapp := &GoApp{Name: "prebuilt", AppDir: path.Join(t.TempDir(), "prebuilt"), log: &log.Logger}
+	binary := anElf(t)
+	t.Setenv("GORUNNER_TEST_APP_DIR", goapp.AppDir)
+
+	expect.Nil(goapp.SetConfig(AppConfig{Isolate: true}))
+	expect.Nil(goapp.UnpackArtifact(bytes.NewReader(binary), sha256Of(binary), ""))
+	err := goapp.Start()
+	if err != nil && strings.Contains(err.Error(), "failed to create the namespaces") {
+		t.Skip(err)
+	}
+
+	expect.Nil(err)
+	expect.Equal(STATE_RUNNING, goapp.State())
+
+	var content []byte
+	for i := 0; i < 500 && len(content) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		content, _ = ioutil.ReadFile(path.Join(goapp.AppDir, "data", "seen"))
+	}
+
+	// the parent of the app is the launcher, which waits for it as the init of its namespaces
+	expect.Equal("ppid=1 hostname=prebuilt cwd=/app args=-unixsock /run/go-runner/sock appDirHidden=true releaseReadOnly=true", string(content))
+
+	expect.Nil(goapp.Stop())
+	expect.Equal(STATE_STOPPED, goapp.State())
+}
+
+func TestIsolationFailsStart(t *testing.T) {
+	expect := util.NewExpect(t)
+	// too long a hostname
+	name := strings.Repeat("x", 100)
+	goapp := &GoApp{Name: name, AppDir: path.Join(t.TempDir(), name), log: &log.Logger}
+	binary := anElf(t)
+
+	expect.Nil(goapp.SetConfig(AppConfig{Isolate: true}))
+	expect.Nil(goapp.UnpackArtifact(bytes.NewReader(binary), sha256Of(binary), ""))
+	err := goapp.Start()
+	expect.True(err != nil)
+	if strings.Contains(err.Error(), "failed to create the namespaces") {
+		t.Skip(err)
+	}
+
+	expect.Equal("failed to launch the app: failed to set the hostname: invalid argument", err.Error())
+	expect.Equal(STATE_FAILED, goapp.State())
+	expect.True(goapp.proc == nil)
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// the syscalls of Landlock, whose numbers are the same on all platforms, see landlock(7)
+const (
+	SYS_LANDLOCK_CREATE_RULESET = 444
+	SYS_LANDLOCK_ADD_RULE       = 445
+	SYS_LANDLOCK_RESTRICT_SELF  = 446
+
+	LANDLOCK_CREATE_RULESET_VERSION = 1
+	LANDLOCK_RULE_PATH_BENEATH      = 1
+)
+
+// O_PATH is missing from package syscall.
+const O_PATH = 0x200000
+
+// the access rights to files of Landlock, by the ABI version that brought them
+const (
+	LANDLOCK_ACCESS_FS_EXECUTE     = 1 << 0
+	LANDLOCK_ACCESS_FS_WRITE_FILE  = 1 << 1
+	LANDLOCK_ACCESS_FS_READ_FILE   = 1 << 2
+	LANDLOCK_ACCESS_FS_READ_DIR    = 1 << 3
+	LANDLOCK_ACCESS_FS_REMOVE_DIR  = 1 << 4
+	LANDLOCK_ACCESS_FS_REMOVE_FILE = 1 << 5
+	LANDLOCK_ACCESS_FS_MAKE_CHAR   = 1 << 6
+	LANDLOCK_ACCESS_FS_MAKE_DIR    = 1 << 7
+	LANDLOCK_ACCESS_FS_MAKE_REG    = 1 << 8
+	LANDLOCK_ACCESS_FS_MAKE_SOCK   = 1 << 9
+	LANDLOCK_ACCESS_FS_MAKE_FIFO   = 1 << 10
+	LANDLOCK_ACCESS_FS_MAKE_BLOCK  = 1 << 11
+	LANDLOCK_ACCESS_FS_MAKE_SYM    = 1 << 12
+	// ABI 2
+	LANDLOCK_ACCESS_FS_REFER = 1 << 13
+	// ABI 3
+	LANDLOCK_ACCESS_FS_TRUNCATE = 1 << 14
+	// ABI 5
+	LANDLOCK_ACCESS_FS_IOCTL_DEV = 1 << 15
+)
+
+const (
+	landlockReadAccess = LANDLOCK_ACCESS_FS_EXECUTE | LANDLOCK_ACCESS_FS_READ_FILE | LANDLOCK_ACCESS_FS_READ_DIR
+	// landlockFileAccess are the rights that apply to files, rather than to dirs
+	landlockFileAccess = LANDLOCK_ACCESS_FS_EXECUTE | LANDLOCK_ACCESS_FS_WRITE_FILE | LANDLOCK_ACCESS_FS_READ_FILE |
+		LANDLOCK_ACCESS_FS_TRUNCATE | LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+type landlockRulesetAttr struct {
+	handledAccessFS uint64
+}
+
+// landlockPathBeneathAttr is packed in C, which makes no difference to its first 12 bytes the kernel reads.
+type landlockPathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+}
+
+// landlockABI returns the version of Landlock the kernel supports.
+func landlockABI() (int, error) {
+	abi, _, errno := syscall.Syscall(SYS_LANDLOCK_CREATE_RULESET, 0, 0, LANDLOCK_CREATE_RULESET_VERSION)
+	switch errno {
+	case 0:
+		return int(abi), nil
+	case syscall.ENOSYS:
+		return 0, errors.New("not supported by the kernel")
+	case syscall.EOPNOTSUPP:
+		return 0, errors.New("disabled in the kernel")
+	default:
+		return 0, errno
+	}
+}
+
+func landlockSupported() error {
+	_, err := landlockABI()
+	return err
+}
+
+// landlockHandled returns the access rights Landlock handles in abi, which are denied unless a rule allows them.
+func landlockHandled(abi int) uint64 {
+	handled := uint64(LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1)
+	if abi >= 2 {
+		handled |= LANDLOCK_ACCESS_FS_REFER
+	}
+
+	if abi >= 3 {
+		handled |= LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+
+	if abi >= 5 {
+		handled |= LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+
+	return handled
+}
+
+// restrictFiles limits the files the calling thread and what it executes may access to the rules. The thread has to
+// be locked to its goroutine and to have no_new_privs.
+func restrictFiles(rules *landlockRules) error {
+	if rules == nil {
+		return nil
+	}
+
+	abi, err := landlockABI()
+	if err != nil {
+		return fmt.Errorf("landlock is %w", err)
+	}
+
+	handled := landlockHandled(abi)
+	attr := landlockRulesetAttr{handledAccessFS: handled}
+	ruleset, _, errno := syscall.Syscall(SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create the landlock ruleset: %w", errno)
+	}
+	defer syscall.Close(int(ruleset))
+
+	for access, paths := range map[uint64][]string{landlockReadAccess: rules.ReadOnly, handled: rules.ReadWrite} {
+		for _, p := range paths {
+			err = addLandlockRule(int(ruleset), p, access)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, _, errno = syscall.Syscall(SYS_LANDLOCK_RESTRICT_SELF, ruleset, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to restrict the files: %w", errno)
+	}
+
+	return nil
+}
+
+// addLandlockRule allows access beneath p, or to p if it is not a dir, unless it doesn't exist.
+func addLandlockRule(ruleset int, p string, access uint64) error {
+	fd, err := syscall.Open(p, O_PATH|syscall.O_CLOEXEC, 0)
+	if err == syscall.ENOENT {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to open %s for landlock: %w", p, err)
+	}
+	defer syscall.Close(fd)
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		access &= landlockFileAccess
+	}
+
+	attr := landlockPathBeneathAttr{allowedAccess: access, parentFd: int32(fd)}
+	_, _, errno := syscall.Syscall6(SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&attr)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to allow %s in landlock: %w", p, errno)
+	}
+
+	return nil
+}
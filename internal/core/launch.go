@@ -0,0 +1,279 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-cmd/cmd"
+)
+
+const (
+	// LAUNCH_ARG is the first arg of go-runner re-executed to launch an app: go-runner launch <exe> <args...>
+	LAUNCH_ARG = "launch"
+	// LAUNCH_ENV carries the launchSpec of the app to the launcher.
+	LAUNCH_ENV = "GORUNNER_LAUNCH"
+	// LAUNCH_FAILED is the exit code of a launcher that failed to set up the app.
+	LAUNCH_FAILED = 127
+	// LAUNCH_EXECUTING is what the launcher reports once it holds the lock on the report and is about to execute the
+	// app. The lock goes with the report, which is closed on exec, so that the app is executed once it is let go of.
+	LAUNCH_EXECUTING = "executing"
+	// LAUNCH_TIMEOUT is how long go-runner waits for the launcher to set up an app.
+	LAUNCH_TIMEOUT = 10 * time.Second
+	// LAUNCH_REPORT_FILE is the file in the app dir the launcher reports to.
+	LAUNCH_REPORT_FILE = "launch.status"
+)
+
+// launchSpec is how the process of an app is set up before the app is executed in it.
+type launchSpec struct {
+	// Cgroup is the dir of the cgroup the process joins
+	Cgroup string `json:"cgroup,omitempty"`
+	// Isolation is the namespaces the app runs in, if any
+	Isolation *isolation `json:"isolation,omitempty"`
+	// Report is the file the launcher writes LAUNCH_EXECUTING to before it executes the app, or else why it failed
+	Report string `json:"report,omitempty"`
+	// ReportFD is the report file inherited by the launcher instead, when the file is out of its reach
+	ReportFD int `json:"reportFd,omitempty"`
+	// User is who the app runs as, instead of go-runner
+	User *AppUser `json:"user,omitempty"`
+	// Rlimits are the resource limits of the app, by RLIMIT_NAMES
+	Rlimits map[string]uint64 `json:"rlimits,omitempty"`
+	// NoNewPrivs keeps the app and its children from gaining privileges, e.g. by setuid binaries
+	NoNewPrivs bool `json:"noNewPrivs,omitempty"`
+	// Seccomp is the syscalls the app may make, if they are filtered
+	Seccomp []string `json:"seccomp,omitempty"`
+	// Landlock is the files the app may access, if they are restricted
+	Landlock *landlockRules `json:"landlock,omitempty"`
+	// NoNetwork puts the app in a network namespace of its own, with only loopback
+	NoNetwork bool `json:"noNetwork,omitempty"`
+	// Init is set for the launcher in the namespaces of the app
+	Init bool `json:"init,omitempty"`
+}
+
+func (s launchSpec) empty() bool {
+	return s.Cgroup == "" && s.Isolation == nil && s.User == nil && len(s.Rlimits) == 0 && !s.NoNewPrivs &&
+		len(s.Seccomp) == 0 && s.Landlock == nil && !s.NoNetwork
+}
+
+// IsLaunch tells if go-runner is run to launch an app, in which case main should call Launch first thing.
+func IsLaunch() bool {
+	return len(os.Args) > 2 && os.Args[1] == LAUNCH_ARG && os.Getenv(LAUNCH_ENV) != ""
+}
+
+// Launch sets up the process as per the spec in LAUNCH_ENV, then executes the app in it, so that the app is
+// confined from its first instruction. It only returns by exiting with LAUNCH_FAILED.
+func Launch() {
+	// no_new_privs, seccomp and landlock confine the thread they are applied by, which executes the app
+	runtime.LockOSThread()
+
+	var spec launchSpec
+	err := json.Unmarshal([]byte(os.Getenv(LAUNCH_ENV)), &spec)
+	if err == nil {
+		err = os.Unsetenv(LAUNCH_ENV)
+	}
+
+	r := openReport(spec)
+	if err == nil {
+		err = launch(spec, r, os.Args[2], os.Args[2:])
+	}
+
+	r.failed(err)
+	fmt.Fprintf(os.Stderr, "go-runner: failed to launch %s: %s\n", os.Args[2], err)
+	os.Exit(LAUNCH_FAILED)
+}
+
+// launch only returns if it fails.
+func launch(spec launchSpec, r *report, exe string, args []string) error {
+	if spec.Init {
+		return launchInit(spec, r, args)
+	}
+
+	if spec.Cgroup != "" {
+		err := writeCgroupFile(spec.Cgroup, "cgroup.procs", strconv.Itoa(os.Getpid()))
+		if err != nil {
+			return fmt.Errorf("failed to join cgroup %s: %w", spec.Cgroup, err)
+		}
+	}
+
+	if spec.Isolation != nil {
+		return launchIsolated(spec, exe, args)
+	}
+
+	err := r.executing()
+	if err != nil {
+		return err
+	}
+
+	if spec.NoNetwork {
+		err = unshareNetwork()
+		if err != nil {
+			return err
+		}
+	}
+
+	filter, err := compileSeccomp(spec.Seccomp)
+	if err != nil {
+		return err
+	}
+
+	err = confine(spec)
+	if err != nil {
+		return err
+	}
+
+	err = restrictFiles(spec.Landlock)
+	if err != nil {
+		return err
+	}
+
+	err = switchUser(spec.User)
+	if err != nil {
+		return err
+	}
+
+	// last, as the filter may not allow the launcher anything but to execute the app
+	err = filter.install()
+	if err != nil {
+		return err
+	}
+
+	return syscall.Exec(exe, args, os.Environ())
+}
+
+// report is the file a launcher reports to. The file is opened first thing, as it may not be reachable
+// once the launcher is isolated.
+type report struct {
+	f *os.File
+}
+
+func openReport(spec launchSpec) *report {
+	if spec.ReportFD > 0 {
+		syscall.CloseOnExec(spec.ReportFD)
+		return &report{os.NewFile(uintptr(spec.ReportFD), "report")}
+	}
+
+	if spec.Report == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(spec.Report, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil
+	}
+
+	return &report{f}
+}
+
+// executing locks the report and writes LAUNCH_EXECUTING to it. The launcher holds the lock until the report is
+// closed on exec, or until it exits, having reported why it failed.
+func (r *report) executing() error {
+	if r == nil {
+		return nil
+	}
+
+	err := syscall.Flock(int(r.f.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return fmt.Errorf("failed to lock the launch report: %w", err)
+	}
+
+	r.write(LAUNCH_EXECUTING)
+	return nil
+}
+
+func (r *report) failed(err error) {
+	if r == nil {
+		return
+	}
+
+	r.write(strings.ReplaceAll(err.Error(), "\n", " "))
+}
+
+// write puts msg on the first line of the report with a single pwrite64, as the seccomp filter of the launcher may
+// allow nothing else. Whatever follows the first line is left from before.
+func (r *report) write(msg string) {
+	_, _ = r.f.WriteAt([]byte(msg+"\n"), 0)
+}
+
+// readReport returns the first line of the report.
+func readReport(file string) string {
+	content, _ := ioutil.ReadFile(file)
+	return strings.TrimSpace(strings.SplitN(string(content), "\n", 2)[0])
+}
+
+// reportLocked tells if a launcher holds the lock on the report.
+func reportLocked(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB) == syscall.EWOULDBLOCK
+}
+
+// waitLaunched waits for the launcher of proc to execute the app, i.e. to let go of its lock on the report in file
+// having written LAUNCH_EXECUTING, or to report why it failed.
+func waitLaunched(file string, proc *cmd.Cmd) error {
+	deadline := time.After(LAUNCH_TIMEOUT)
+	for {
+		switch msg := readReport(file); msg {
+		case LAUNCH_EXECUTING:
+			if !reportLocked(file) {
+				// read again, as the launcher may have failed to execute the app and exited since
+				return launchResult(readReport(file))
+			}
+		case "":
+		default:
+			return errors.New(msg)
+		}
+
+		select {
+		case <-proc.Done():
+			msg := readReport(file)
+			if msg == "" {
+				return fmt.Errorf("launcher exited with %d", proc.Status().Exit)
+			}
+
+			// LAUNCH_EXECUTING if the app exited right away, which watch tells
+			return launchResult(msg)
+		case <-deadline:
+			return fmt.Errorf("launcher didn't report in %s", LAUNCH_TIMEOUT)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func launchResult(msg string) error {
+	if msg == LAUNCH_EXECUTING {
+		return nil
+	}
+
+	return errors.New(msg)
+}
+
+// launcher returns the command that launches exe with args as per spec, which is go-runner itself unless there is
+// nothing to set up, and its env, which is the one of go-runner either way.
+func launcher(spec launchSpec, exe string, args ...string) (string, []string, []string, error) {
+	if spec.empty() {
+		return exe, args, os.Environ(), nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return self, append([]string{LAUNCH_ARG, exe}, args...), append(os.Environ(), LAUNCH_ENV+"="+string(encoded)), nil
+}
@@ -0,0 +1,159 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidTransition = errors.New("invalid state transition")
+
+// State is where an app is in its lifecycle.
+type State string
+
+const (
+	STATE_REGISTERED State = "registered"
+	// STATE_CLONING is getting the source of a new release, from git or an upload.
+	STATE_CLONING State = "cloning"
+	// STATE_BUILDING is checking a new release with its gates, and building it.
+	STATE_BUILDING State = "building"
+	STATE_STARTING State = "starting"
+	STATE_RUNNING  State = "running"
+	STATE_STOPPING State = "stopping"
+	STATE_STOPPED  State = "stopped"
+	// STATE_FAILED is a release that failed to be prepared, built or started, while the app is not running.
+	STATE_FAILED State = "failed"
+	// STATE_CRASHED is a process that exited without being stopped.
+	STATE_CRASHED State = "crashed"
+	STATE_DELETED State = "deleted"
+)
+
+const (
+	// EVENTS_TO_KEEP is how many transitions of an app are kept in its event log.
+	EVENTS_TO_KEEP = 100
+	// EVENTS_SHOWN is how many of the last transitions are shown with the status of an app.
+	EVENTS_SHOWN = 10
+)
+
+// transitions are the states an app can go to from each state.
+var transitions = map[State][]State{
+	STATE_REGISTERED: {STATE_CLONING, STATE_BUILDING, STATE_STARTING, STATE_FAILED, STATE_DELETED},
+	STATE_CLONING:    {STATE_BUILDING, STATE_STARTING, STATE_FAILED, STATE_DELETED},
+	STATE_BUILDING:   {STATE_STARTING, STATE_FAILED, STATE_DELETED},
+	STATE_STARTING:   {STATE_RUNNING, STATE_FAILED},
+	STATE_RUNNING:    {STATE_STOPPING, STATE_CRASHED},
+	STATE_STOPPING:   {STATE_STOPPED},
+	STATE_STOPPED:    {STATE_CLONING, STATE_BUILDING, STATE_STARTING, STATE_FAILED, STATE_DELETED},
+	STATE_FAILED:     {STATE_CLONING, STATE_BUILDING, STATE_STARTING, STATE_DELETED},
+	STATE_CRASHED:    {STATE_CLONING, STATE_BUILDING, STATE_STARTING, STATE_STOPPED, STATE_FAILED, STATE_DELETED},
+	STATE_DELETED:    {},
+}
+
+// Event is a transition of an app from one state to another.
+type Event struct {
+	From   State     `json:"from,omitempty"`
+	To     State     `json:"to"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason"`
+}
+
+// State returns the state of the app. It doesn't take the lock of the app, which long steps like clones hold, so
+// that requests to the app are proxied meanwhile.
+func (a *GoApp) State() State {
+	if s, ok := a.stateNow.Load().(State); ok {
+		return s
+	}
+
+	return STATE_REGISTERED
+}
+
+// currentState is the state of the app, registered for an app that has yet to go anywhere. The app must be locked.
+func (a *GoApp) currentState() State {
+	if a.state == "" {
+		return STATE_REGISTERED
+	}
+
+	return a.state
+}
+
+// register records the app as registered, the first event in its log. The app must be locked.
+func (a *GoApp) register(reason string) {
+	a.state = STATE_REGISTERED
+	a.stateNow.Store(a.state)
+	a.stateSince = time.Now()
+	a.record(Event{To: STATE_REGISTERED, At: a.stateSince, Reason: reason})
+}
+
+// transition moves the app to the state to, and records why. Moving to the state it is in does nothing.
+// It fails with ErrInvalidTransition if the app can't go there from its state. The app must be locked.
+func (a *GoApp) transition(to State, reason string) error {
+	from := a.currentState()
+	if from == to {
+		return nil
+	}
+
+	if !canTransition(from, to) {
+		return fmt.Errorf("%w: %s to %s, app=%s", ErrInvalidTransition, from, to, a.Name)
+	}
+
+	a.state = to
+	a.stateNow.Store(to)
+	a.stateSince = time.Now()
+	a.record(Event{From: from, To: to, At: a.stateSince, Reason: reason})
+
+	a.log.Debug().Msgf("app %s -> %s: %s. app=%s", from, to, reason, a.Name)
+
+	return nil
+}
+
+// mustTransition is transition for the steps that happen whatever state the app is in. A transition
+// that isn't valid is logged, and the state is left alone. The app must be locked.
+func (a *GoApp) mustTransition(to State, reason string) {
+	err := a.transition(to, reason)
+	if err != nil {
+		a.log.Warn().Err(err).Msgf("state not changed: %s", reason)
+	}
+}
+
+// preparing moves the app to the state of a step of preparing a release, unless the app is running,
+// as the running release is not affected. The app must be locked.
+func (a *GoApp) preparing(to State, reason string) {
+	if a.currentState() != STATE_RUNNING {
+		a.mustTransition(to, reason)
+	}
+}
+
+func (a *GoApp) record(e Event) {
+	a.events = append(a.events, e)
+	if len(a.events) > EVENTS_TO_KEEP {
+		a.events = a.events[len(a.events)-EVENTS_TO_KEEP:]
+	}
+}
+
+// Events returns the event log of the app, oldest first.
+func (a *GoApp) Events() []Event {
+	a.Lock()
+	defer a.Unlock()
+
+	return a.lastEvents(EVENTS_TO_KEEP)
+}
+
+// lastEvents returns the last n events of the app. The app must be locked.
+func (a *GoApp) lastEvents(n int) []Event {
+	events := a.events
+	if len(events) > n {
+		events = events[len(events)-n:]
+	}
+
+	return append([]Event{}, events...)
+}
+
+func canTransition(from, to State) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+// TestMain lets the test binary stand in for an app: run with -unixsock, it waits to be stopped.
+// Isolated, it writes what it sees into its data dir first.
+// It stands in for go-runner launching an app too.
+func TestMain(m *testing.M) {
+	if IsLaunch() {
+		Launch()
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "-unixsock" {
+		switch dataDir := os.Getenv(DATA_DIR_ENV); os.Getenv("GORUNNER_TEST_SANDBOX") {
+		case "see":
+			seeSandbox(dataDir)
+		case "violate":
+			violateSandbox()
+		case "clone":
+			cloneSandbox(dataDir)
+		case "network":
+			seeNetwork(dataDir)
+		default:
+			if dataDir != "" {
+				seeIsolation(dataDir)
+			}
+		}
+
+		time.Sleep(time.Minute)
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func statesOf(events []Event) []State {
+	states := make([]State, 0, len(events))
+	for _, e := range events {
+		states = append(states, e.To)
+	}
+
+	return states
+}
+
+func TestLifecycle(t *testing.T) {
+	expect := util.NewExpect(t)
+	goapp := &GoApp{Name: "prebuilt", AppDir: path.Join(t.TempDir(), "prebuilt"), log: &log.Logger}
+	goapp.register("registered for test")
+	binary := anElf(t)
+
+	expect.Nil(goapp.UnpackArtifact(bytes.NewReader(binary), sha256Of(binary), ""))
+	expect.Equal(STATE_CLONING, goapp.State())
+
+	expect.Nil(goapp.Start())
+	expect.Equal(STATE_RUNNING, goapp.State())
+	expect.True(goapp.Start() != nil)
+	expect.Equal(STATE_RUNNING, goapp.State())
+
+	// a new release that fails doesn't affect the running one
+	err := goapp.UnpackArtifact(bytes.NewReader(binary), "", "")
+	expect.True(err != nil)
+	expect.Equal(STATE_RUNNING, goapp.State())
+
+	expect.Nil(goapp.Stop())
+	expect.Equal(STATE_STOPPED, goapp.State())
+	expect.True(goapp.Stop() != nil)
+
+	expect.Nil(goapp.Start())
+	pid := goapp.proc.Status().PID
+	expect.Nil(syscall.Kill(pid, syscall.SIGKILL))
+	for i := 0; i < 100 && goapp.State() != STATE_CRASHED; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	expect.Equal(STATE_CRASHED, goapp.State())
+	expect.Nil(goapp.Stop())
+	expect.Nil(goapp.Purge())
+
+	err = goapp.Start()
+	expect.True(errors.Is(err, ErrInvalidTransition))
+
+	events := goapp.Events()
+	expect.Equal([]State{
+		STATE_REGISTERED, STATE_CLONING, STATE_STARTING, STATE_RUNNING, STATE_STOPPING, STATE_STOPPED,
+		STATE_STARTING, STATE_RUNNING, STATE_CRASHED, STATE_STOPPED, STATE_DELETED,
+	}, statesOf(events))
+	expect.Equal(State(""), events[0].From)
+	expect.Equal(STATE_RUNNING, events[8].From)
+	expect.Equal("exited: signal: killed", events[8].Reason)
+}
+
+func TestStartAfterCrashClosesTheStreams(t *testing.T) {
+	expect := util.NewExpect(t)
+	goapp := &GoApp{Name: "prebuilt", AppDir: path.Join(t.TempDir(), "prebuilt"), log: &log.Logger}
+	binary := anElf(t)
+
+	expect.Nil(goapp.UnpackArtifact(bytes.NewReader(binary), sha256Of(binary), ""))
+	expect.Nil(goapp.Start())
+	stdout, stderr := make(chan string, 10), make(chan string, 10)
+	goapp.StdoutTo(stdout)
+	goapp.StderrTo(stderr)
+
+	expect.Nil(syscall.Kill(goapp.proc.Status().PID, syscall.SIGKILL))
+	for i := 0; i < 100 && goapp.State() != STATE_CRASHED; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	expect.Equal(STATE_CRASHED, goapp.State())
+	expect.Nil(goapp.Start())
+	expect.Equal(STATE_RUNNING, goapp.State())
+	for _, c := range []chan string{stdout, stderr} {
+		closed := false
+		for !closed {
+			select {
+			case _, ok := <-c:
+				closed = !ok
+			case <-time.After(time.Second):
+				t.Fatal("the stream of the crashed process is still open")
+			}
+		}
+	}
+
+	expect.Nil(goapp.Stop())
+}
+
+func TestTransitionsAreValidated(t *testing.T) {
+	expect := util.NewExpect(t)
+	goapp := &GoApp{Name: "hello", log: &log.Logger}
+
+	expect.Nil(goapp.transition(STATE_CLONING, "cloning"))
+	expect.Nil(goapp.transition(STATE_CLONING, "cloning again"))
+	expect.True(errors.Is(goapp.transition(STATE_RUNNING, "running"), ErrInvalidTransition))
+	expect.Equal(STATE_CLONING, goapp.currentState())
+	expect.Equal(1, len(goapp.events))
+
+	for i := 0; i < EVENTS_TO_KEEP; i++ {
+		expect.Nil(goapp.transition(STATE_FAILED, "failed"))
+		expect.Nil(goapp.transition(STATE_BUILDING, "building"))
+	}
+
+	expect.Equal(EVENTS_TO_KEEP, len(goapp.Events()))
+	expect.Equal(EVENTS_SHOWN, len(goapp.lastEvents(EVENTS_SHOWN)))
+	expect.Equal(STATE_BUILDING, goapp.lastEvents(1)[0].To)
+}
@@ -0,0 +1,238 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/mod/modfile"
+)
+
+// ErrNoChanges is returned by Rebuild when nothing the app is built from changed since the current release.
+var ErrNoChanges = errors.New("no changes")
+
+// findGoWork returns the go.work nearest to the dir of the app in the repo, or "" if there is none.
+// go.work files outside of the repo are never used.
+func findGoWork(root, subdir string) string {
+	dir, ok := findUp(root, subdir, "go.work")
+	if !ok {
+		return ""
+	}
+
+	return path.Join(root, dir, "go.work")
+}
+
+// findUp returns the nearest dir from subdir up to the repo root that has the file.
+func findUp(root, subdir, file string) (string, bool) {
+	dir := subdir
+	for {
+		if _, err := os.Stat(path.Join(root, dir, file)); err == nil {
+			return dir, true
+		}
+
+		if dir == "" {
+			return "", false
+		}
+
+		dir = path.Dir(dir)
+		if dir == "." {
+			dir = ""
+		}
+	}
+}
+
+// buildEnv pins GOWORK to the go.work of the repo, so go never picks up one from the dirs above the release.
+func buildEnv(root, subdir string) []string {
+	goWork := findGoWork(root, subdir)
+	if goWork == "" {
+		goWork = "off"
+	}
+
+	return append(os.Environ(), "GOWORK="+goWork)
+}
+
+// moduleDeps returns the paths in the repo the app at subdir is built from: its own dir, the modules of the repo it
+// requires, directly or not, through go.work or local replace directives, and the go.work files.
+// An app without its own go.mod depends on the whole module it is in.
+func moduleDeps(root, subdir string) ([]string, error) {
+	module := moduleDir(root, subdir)
+	deps := map[string]bool{subdir: true, module: true}
+
+	// module path -> dir in the repo
+	workspace := map[string]string{}
+	goWork := findGoWork(root, subdir)
+	if goWork != "" {
+		workDir := relDir(root, path.Dir(goWork))
+		deps[path.Join(workDir, "go.work")] = true
+		deps[path.Join(workDir, "go.work.sum")] = true
+
+		data, err := ioutil.ReadFile(goWork)
+		if err != nil {
+			return nil, err
+		}
+
+		work, err := modfile.ParseWork(goWork, data, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, use := range work.Use {
+			dir, ok := inRepo(workDir, use.Path)
+			if !ok {
+				continue
+			}
+
+			modPath, err := modulePath(root, dir)
+			if err != nil {
+				continue
+			}
+
+			workspace[modPath] = dir
+		}
+
+		for _, replace := range work.Replace {
+			if dir, ok := inRepo(workDir, replace.New.Path); ok && modfile.IsDirectoryPath(replace.New.Path) {
+				workspace[replace.Old.Path] = dir
+			}
+		}
+	}
+
+	toVisit := []string{module}
+	for len(toVisit) > 0 {
+		dir := toVisit[0]
+		toVisit = toVisit[1:]
+
+		mod, err := readModFile(root, dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		local := map[string]string{}
+		for _, replace := range mod.Replace {
+			if !modfile.IsDirectoryPath(replace.New.Path) {
+				continue
+			}
+
+			if replaced, ok := inRepo(dir, replace.New.Path); ok {
+				local[replace.Old.Path] = replaced
+			}
+		}
+
+		for _, req := range mod.Require {
+			dep, ok := local[req.Mod.Path]
+			if !ok {
+				dep, ok = workspace[req.Mod.Path]
+			}
+
+			if ok && !deps[dep] {
+				deps[dep] = true
+				toVisit = append(toVisit, dep)
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(deps))
+	for p := range deps {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// fingerprint hashes what is at the paths in the tree of a commit. It changes only when something under them does.
+func fingerprint(tree *object.Tree, paths []string) (string, error) {
+	hash := sha256.New()
+	for _, p := range paths {
+		var entry string
+		if p == "" {
+			entry = tree.Hash.String()
+		} else {
+			e, err := tree.FindEntry(p)
+			switch {
+			case err == nil:
+				entry = e.Hash.String()
+			case errors.Is(err, object.ErrEntryNotFound), errors.Is(err, object.ErrDirectoryNotFound):
+				entry = "-"
+			default:
+				return "", err
+			}
+		}
+
+		fmt.Fprintf(hash, "%s %s\n", p, entry)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// moduleDir returns the dir of the module subdir is in, or subdir itself if it is in none.
+func moduleDir(root, subdir string) string {
+	dir, ok := findUp(root, subdir, "go.mod")
+	if !ok {
+		return subdir
+	}
+
+	return dir
+}
+
+func readModFile(root, dir string) (*modfile.File, error) {
+	goMod := path.Join(root, dir, "go.mod")
+	data, err := ioutil.ReadFile(goMod)
+	if err != nil {
+		return nil, err
+	}
+
+	return modfile.Parse(goMod, data, nil)
+}
+
+func modulePath(root, dir string) (string, error) {
+	mod, err := readModFile(root, dir)
+	if err != nil {
+		return "", err
+	}
+
+	if mod.Module == nil {
+		return "", fmt.Errorf("no module in %s", path.Join(dir, "go.mod"))
+	}
+
+	return mod.Module.Mod.Path, nil
+}
+
+// inRepo resolves rel against dir, both relative to the repo root. It is false for paths outside of the repo.
+func inRepo(dir, rel string) (string, bool) {
+	if path.IsAbs(rel) {
+		return "", false
+	}
+
+	p := path.Join(dir, rel)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", false
+	}
+
+	if p == "." {
+		p = ""
+	}
+
+	return p, true
+}
+
+func relDir(root, dir string) string {
+	rel := strings.TrimPrefix(strings.TrimPrefix(dir, root), "/")
+	if rel == "." {
+		return ""
+	}
+
+	return rel
+}
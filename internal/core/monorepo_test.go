@@ -0,0 +1,150 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/rs/zerolog/log"
+)
+
+type testRepo struct {
+	t    *testing.T
+	dir  string
+	repo *git.Repository
+}
+
+func newTestRepo(t *testing.T, files map[string]string) *testRepo {
+	dir := path.Join(t.TempDir(), "repo")
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &testRepo{t: t, dir: dir, repo: repo}
+	r.commit(files)
+
+	return r
+}
+
+// commit writes the files and commits them, returning the hash of the commit.
+func (r *testRepo) commit(files map[string]string) string {
+	tree, err := r.repo.Worktree()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+
+	for name, content := range files {
+		file := path.Join(r.dir, name)
+		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+			r.t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			r.t.Fatal(err)
+		}
+
+		if _, err := tree.Add(name); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+
+	hash, err := tree.Commit("change", &git.CommitOptions{
+		Author: &object.Signature{Name: "t", Email: "t@t", When: time.Now()},
+	})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+
+	return hash.String()
+}
+
+var monorepo = map[string]string{
+	"go.work":        "go 1.18\n\nuse (\n\t./apps/a\n\t./apps/b\n\t./lib\n)\n",
+	"apps/a/go.mod":  "module example.com/a\n\ngo 1.18\n\nrequire example.com/lib v0.0.0\n",
+	"apps/a/main.go": "package main\n",
+	"apps/b/go.mod":  "module example.com/b\n\ngo 1.18\n",
+	"apps/b/main.go": "package main\n",
+	"lib/go.mod":     "module example.com/lib\n\ngo 1.18\n\nrequire example.com/util v0.0.0\n\nreplace example.com/util => ../util\n",
+	"lib/lib.go":     "package lib\n",
+	"util/go.mod":    "module example.com/util\n\ngo 1.18\n",
+	"util/util.go":   "package util\n",
+	"docs/README.md": "monorepo\n",
+}
+
+func TestModuleDepsFollowGoWorkAndReplace(t *testing.T) {
+	expect := util.NewExpect(t)
+	repo := newTestRepo(t, monorepo)
+
+	deps, err := moduleDeps(repo.dir, "apps/a")
+	expect.Nil(err)
+	expect.Equal([]string{"apps/a", "go.work", "go.work.sum", "lib", "util"}, deps)
+
+	deps, err = moduleDeps(repo.dir, "apps/b")
+	expect.Nil(err)
+	expect.Equal([]string{"apps/b", "go.work", "go.work.sum"}, deps)
+
+	single := newTestRepo(t, map[string]string{
+		"go.mod":        "module example.com/single\n\ngo 1.18\n",
+		"cmd/a/main.go": "package main\n",
+		"internal/x.go": "package internal\n",
+	})
+	deps, err = moduleDeps(single.dir, "cmd/a")
+	expect.Nil(err)
+	expect.Equal([]string{"", "cmd/a"}, deps)
+
+	expect.Equal(path.Join(repo.dir, "go.work"), findGoWork(repo.dir, "apps/a"))
+	expect.Equal("", findGoWork(repo.dir+"/apps", "a"))
+}
+
+func TestRebuildSubdirOnlyWhenItsFilesChanged(t *testing.T) {
+	expect := util.NewExpect(t)
+	if _, err := exec.LookPath("git-upload-pack"); err != nil {
+		t.Skip("git-upload-pack not found")
+	}
+
+	repo := newTestRepo(t, monorepo)
+	goapp := &GoApp{
+		Name:   "a",
+		GitURL: repo.dir,
+		AppDir: path.Join(t.TempDir(), "a"),
+		log:    &log.Logger,
+	}
+	expect.Nil(goapp.SetConfig(AppConfig{Subdir: "./apps/a/"}))
+	expect.Equal("apps/a", goapp.Config().Subdir)
+
+	expect.Nil(goapp.Rebuild())
+	expect.Equal(1, goapp.current.ID)
+	expect.Equal("apps/a", goapp.current.Subdir)
+
+	head := repo.commit(map[string]string{"apps/b/main.go": "package main\n\nfunc main() {}\n"})
+	err := goapp.Rebuild()
+	expect.True(errors.Is(err, ErrNoChanges))
+	expect.Equal(1, goapp.current.ID)
+	expect.Equal(head, goapp.current.GitHash)
+
+	repo.commit(map[string]string{"util/util.go": "package util\n\nconst Version = 2\n"})
+	expect.Nil(goapp.Rebuild())
+	expect.Equal(2, goapp.current.ID)
+
+	repo.commit(map[string]string{"apps/a/main.go": "package main\n\nfunc main() {}\n"})
+	expect.Nil(goapp.Rebuild())
+	expect.Equal(3, goapp.current.ID)
+
+	expect.Nil(goapp.SetConfig(AppConfig{Subdir: "apps/missing"}))
+	expect.True(goapp.Rebuild() != nil)
+	expect.Equal(3, goapp.current.ID)
+
+	expect.True(errors.Is(goapp.SetConfig(AppConfig{Subdir: "../outside"}), ErrInvalidConfig))
+
+	reattached := &GoApp{Name: "a", AppDir: goapp.AppDir, log: &log.Logger}
+	expect.Nil(reattached.Reattach())
+	expect.Equal("apps/missing", reattached.Config().Subdir)
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// NETWORK_HOST is the network policy of an app that shares the network of the host, which is the default.
+	NETWORK_HOST = "host"
+	// NETWORK_NONE is the network policy of an app in a network namespace of its own, with only loopback. It still
+	// serves its unix socket, which go-runner dials from the host.
+	NETWORK_NONE = "none"
+	// NETWORK_ALLOWLIST is the network policy of an app that shares the network of the host, but may only connect
+	// and send to the destinations it allows, and loopback.
+	NETWORK_ALLOWLIST = "allowlist"
+)
+
+// Network is what of the network an app may reach.
+type Network struct {
+	// Policy is NETWORK_HOST, NETWORK_NONE or NETWORK_ALLOWLIST
+	Policy string `json:"policy"`
+	// Allow are the destinations of NETWORK_ALLOWLIST, CIDRs or addresses with an optional port, e.g. 10.0.0.0/8,
+	// 192.168.1.10:5432 or [2001:db8::/32]:443
+	Allow []string `json:"allow,omitempty"`
+}
+
+func (n *Network) validate() error {
+	if n == nil {
+		return nil
+	}
+
+	switch n.Policy {
+	case NETWORK_HOST, NETWORK_NONE:
+		if len(n.Allow) > 0 {
+			return fmt.Errorf("%w: network.allow needs the %s policy", ErrInvalidConfig, NETWORK_ALLOWLIST)
+		}
+	case NETWORK_ALLOWLIST:
+		if len(n.Allow) == 0 {
+			return fmt.Errorf("%w: network.allow must not be empty, or the policy be %s", ErrInvalidConfig, NETWORK_NONE)
+		}
+	default:
+		return fmt.Errorf("%w: network.policy must be %s, %s or %s", ErrInvalidConfig, NETWORK_HOST, NETWORK_NONE, NETWORK_ALLOWLIST)
+	}
+
+	for _, allow := range n.Allow {
+		if _, err := parseEgressRule(allow); err != nil {
+			return fmt.Errorf("%w: network.allow: %s", ErrInvalidConfig, err)
+		}
+	}
+
+	return nil
+}
+
+// enforcement returns if the app gets a network namespace of its own, and where it may connect and send to if that
+// is filtered.
+func (n *Network) enforcement(isolated bool) (bool, []egressRule, error) {
+	if n == nil {
+		return false, nil, nil
+	}
+
+	switch n.Policy {
+	case NETWORK_NONE:
+		if !isolated && os.Geteuid() != 0 {
+			return false, nil, errors.New("the network policy none needs go-runner to run as root, or the app to be isolated")
+		}
+
+		return true, nil, nil
+	case NETWORK_ALLOWLIST:
+		rules := append([]egressRule{}, EGRESS_LOOPBACK...)
+		for _, allow := range n.Allow {
+			rule, err := parseEgressRule(allow)
+			if err != nil {
+				return false, nil, err
+			}
+
+			rules = append(rules, rule)
+		}
+
+		return false, rules, nil
+	}
+
+	return false, nil, nil
+}
+
+// egressRule is a destination an app may connect and send to: the addresses in Net, on Port or any port if it is 0.
+type egressRule struct {
+	Net  net.IPNet
+	Port int
+}
+
+// EGRESS_LOOPBACK is allowed by every allowlist.
+var EGRESS_LOOPBACK = []egressRule{
+	{Net: net.IPNet{IP: net.IPv4(127, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)}},
+	{Net: net.IPNet{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)}},
+}
+
+// parseEgressRule parses a CIDR or an address, with an optional port, e.g. 10.0.0.0/8:443, or [2001:db8::/32]:443
+// for IPv6.
+func parseEgressRule(s string) (egressRule, error) {
+	host, port := s, ""
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]")
+		if end < 0 || (end+1 < len(s) && s[end+1] != ':') {
+			return egressRule{}, fmt.Errorf("invalid destination %q, expected e.g. [2001:db8::/32]:443", s)
+		}
+
+		host, port = s[1:end], strings.TrimPrefix(s[end+1:], ":")
+	} else if strings.Count(s, ":") == 1 {
+		// IPv4 with a port, as IPv6 has more colons
+		host, port = s[:strings.Index(s, ":")], s[strings.Index(s, ":")+1:]
+	}
+
+	var rule egressRule
+	if strings.Contains(host, "/") {
+		ip, ipNet, err := net.ParseCIDR(host)
+		if err != nil || !ip.Equal(ipNet.IP) {
+			return egressRule{}, fmt.Errorf("invalid CIDR %q, expected e.g. 10.0.0.0/8", host)
+		}
+
+		rule.Net = *ipNet
+	} else {
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return egressRule{}, fmt.Errorf("invalid address %q, expected e.g. 10.0.0.1", host)
+		}
+
+		if ip4 := ip.To4(); ip4 != nil {
+			rule.Net = net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+		} else {
+			rule.Net = net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+		}
+	}
+
+	if port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return egressRule{}, fmt.Errorf("invalid port %q in %s", port, s)
+		}
+
+		rule.Port = n
+	}
+
+	return rule, nil
+}
+
+// filterEgress limits where the processes in the cgroup run of the app may connect and send to, to the rules.
+func (a *GoApp) filterEgress(run string, rules []egressRule) error {
+	if run == "" {
+		reason := a.cgroups.Status().Reason
+		if reason == "" {
+			reason = "the cgroup of the app is not prepared"
+		}
+
+		return fmt.Errorf("the network allowlist needs cgroups: %s", reason)
+	}
+
+	err := attachEgressFilter(run, rules)
+	if err != nil {
+		return fmt.Errorf("failed to filter the network of the app: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// ifreqFlags is struct ifreq for SIOCGIFFLAGS and SIOCSIFFLAGS, see netdevice(7).
+type ifreqFlags struct {
+	name  [syscall.IFNAMSIZ]byte
+	flags uint16
+	_     [22]byte
+}
+
+// unshareNetwork moves the calling thread, which has to be locked to its goroutine, and what it executes into a
+// network namespace of its own, with only loopback.
+func unshareNetwork() error {
+	err := syscall.Unshare(syscall.CLONE_NEWNET)
+	if err != nil {
+		return fmt.Errorf("failed to create the network namespace: %w", err)
+	}
+
+	return upLoopback()
+}
+
+// upLoopback brings up lo, which is down in a new network namespace.
+func upLoopback() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to bring up loopback: %w", err)
+	}
+	defer syscall.Close(fd)
+
+	var req ifreqFlags
+	copy(req.name[:], "lo")
+	for _, op := range []uintptr{syscall.SIOCGIFFLAGS, syscall.SIOCSIFFLAGS} {
+		if op == syscall.SIOCSIFFLAGS {
+			req.flags |= syscall.IFF_UP
+		}
+
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), op, uintptr(unsafe.Pointer(&req)))
+		if errno != 0 {
+			return fmt.Errorf("failed to bring up loopback: %w", errno)
+		}
+	}
+
+	return nil
+}
+
+// procNetwork tells the network policy the process pid is under: NETWORK_NONE if it is in a network namespace
+// other than go-runner's, NETWORK_ALLOWLIST if its connects are filtered, or else NETWORK_HOST.
+func procNetwork(pid int) string {
+	own, err1 := os.Readlink(fmt.Sprintf("/proc/%d/ns/net", pid))
+	host, err2 := os.Readlink("/proc/self/ns/net")
+	if err1 == nil && err2 == nil && own != host {
+		return NETWORK_NONE
+	}
+
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return NETWORK_HOST
+	}
+
+	mount, err := cgroup2Mount()
+	if err != nil {
+		return NETWORK_HOST
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "0::") && egressFiltered(path.Join(mount, strings.TrimPrefix(line, "0::"))) {
+			return NETWORK_ALLOWLIST
+		}
+	}
+
+	return NETWORK_HOST
+}
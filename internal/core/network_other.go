@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package core
+
+import "errors"
+
+func unshareNetwork() error {
+	return errors.New("network namespaces are only supported on Linux")
+}
+
+func upLoopback() error {
+	return nil
+}
+
+func procNetwork(pid int) string {
+	return NETWORK_HOST
+}
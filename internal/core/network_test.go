@@ -0,0 +1,221 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+// seeNetwork writes what of the network the app may reach into seen in its data dir: if it has a network of its own,
+// if its loopback works, and if it may connect to the addresses in GORUNNER_TEST_ALLOWED and GORUNNER_TEST_DENIED.
+func seeNetwork(dataDir string) {
+	interfaces, _ := net.Interfaces()
+	own := len(interfaces) == 1 && interfaces[0].Name == "lo"
+
+	loopback := false
+	if l, err := net.Listen("tcp", "127.0.0.1:0"); err == nil {
+		conn, err := net.DialTimeout("tcp", l.Addr().String(), time.Second)
+		loopback = err == nil
+		if conn != nil {
+			_ = conn.Close()
+		}
+		_ = l.Close()
+	}
+
+	reached := func(addr string) bool {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			return false
+		}
+
+		_ = conn.Close()
+
+		return true
+	}
+
+	seen := fmt.Sprintf("ownNetwork=%t loopback=%t allowed=%t denied=%t", own, loopback,
+		reached(os.Getenv("GORUNNER_TEST_ALLOWED")), reached(os.Getenv("GORUNNER_TEST_DENIED")))
+	_ = ioutil.WriteFile(path.Join(dataDir, "seen"), []byte(seen), 0644)
+}
+
+func TestNetworkIsValidated(t *testing.T) {
+	expect := util.NewExpect(t)
+
+	for _, invalid := range []*Network{
+		{},
+		{Policy: "bridge"},
+		{Policy: NETWORK_NONE, Allow: []string{"10.0.0.0/8"}},
+		{Policy: NETWORK_ALLOWLIST},
+		{Policy: NETWORK_ALLOWLIST, Allow: []string{"example.com:443"}},
+		{Policy: NETWORK_ALLOWLIST, Allow: []string{"10.0.0.1/8"}},
+		{Policy: NETWORK_ALLOWLIST, Allow: []string{"10.0.0.1:65536"}},
+		{Policy: NETWORK_ALLOWLIST, Allow: []string{"[2001:db8::/32]443"}},
+	} {
+		err := (&AppConfig{Network: invalid}).validate()
+		expect.True(errors.Is(err, ErrInvalidConfig), err)
+	}
+
+	network := &Network{Policy: NETWORK_ALLOWLIST, Allow: []string{"10.0.0.0/8", "192.168.1.10:5432", "[2001:db8::/32]:443", "::1"}}
+	expect.Nil((&AppConfig{Network: network}).validate())
+
+	noNetwork, rules, err := network.enforcement(false)
+	expect.Nil(err)
+	expect.True(!noNetwork)
+	// loopback first
+	expect.Equal(len(EGRESS_LOOPBACK)+4, len(rules))
+	expect.Equal("10.0.0.0/8", rules[2].Net.String())
+	expect.Equal(0, rules[2].Port)
+	expect.Equal("192.168.1.10/32", rules[3].Net.String())
+	expect.Equal(5432, rules[3].Port)
+	expect.Equal("2001:db8::/32", rules[4].Net.String())
+	expect.Equal(443, rules[4].Port)
+	expect.Equal("::1/128", rules[5].Net.String())
+
+	noNetwork, rules, err = (&Network{Policy: NETWORK_NONE}).enforcement(true)
+	expect.Nil(err)
+	expect.True(noNetwork && rules == nil)
+}
+
+// hostAddress returns a non-loopback IPv4 address of the host, which the tests listen on.
+func hostAddress(t *testing.T) string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil && !ipNet.IP.IsLoopback() {
+			return ipNet.IP.String()
+		}
+	}
+
+	t.Skip("the host has no address but loopback")
+
+	return ""
+}
+
+// listen accepts and closes the connections to a port of host until the test ends.
+func listen(t *testing.T, host string) string {
+	l, err := net.Listen("tcp", host+":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func runNetworkApp(t *testing.T, goapp *GoApp, config AppConfig) string {
+	expect := util.NewExpect(t)
+	binary := anElf(t)
+	t.Setenv("GORUNNER_TEST_SANDBOX", "network")
+
+	expect.Nil(goapp.SetConfig(config))
+	expect.Nil(goapp.UnpackArtifact(bytes.NewReader(binary), sha256Of(binary), ""))
+	err := goapp.Start()
+	if err != nil && config.Isolate && strings.Contains(err.Error(), "failed to create the namespaces") {
+		t.Skip(err)
+	}
+
+	expect.Nil(err)
+	t.Cleanup(func() { _ = goapp.Stop() })
+
+	var content []byte
+	for i := 0; i < 500 && len(content) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		content, _ = ioutil.ReadFile(path.Join(goapp.AppDir, DATA_DIRNAME, "seen"))
+	}
+
+	return string(content)
+}
+
+func TestAppRunsWithoutNetwork(t *testing.T) {
+	host := hostAddress(t)
+	t.Setenv("GORUNNER_TEST_ALLOWED", listen(t, host))
+	t.Setenv("GORUNNER_TEST_DENIED", listen(t, host))
+
+	for _, isolate := range []bool{false, true} {
+		expect := util.NewExpect(t)
+		goapp := &GoApp{Name: "prebuilt", AppDir: path.Join(t.TempDir(), "prebuilt"), log: &log.Logger}
+		config := AppConfig{Isolate: isolate, Network: &Network{Policy: NETWORK_NONE}}
+		if !isolate && os.Geteuid() != 0 {
+			_, _, err := config.Network.enforcement(isolate)
+			expect.True(err != nil)
+			continue
+		}
+
+		expect.Equal("ownNetwork=true loopback=true allowed=false denied=false", runNetworkApp(t, goapp, config))
+
+		goapp.Lock()
+		id := goapp.processIdentity()
+		goapp.Unlock()
+		expect.True(id != nil && id.Network == NETWORK_NONE)
+		expect.Nil(goapp.Stop())
+	}
+}
+
+func TestAppRunsWithAllowlist(t *testing.T) {
+	host := hostAddress(t)
+	allowed, denied := listen(t, host), listen(t, host)
+	t.Setenv("GORUNNER_TEST_ALLOWED", allowed)
+	t.Setenv("GORUNNER_TEST_DENIED", denied)
+
+	expect := util.NewExpect(t)
+	mount, err := cgroup2Mount()
+	if err != nil || os.Geteuid() != 0 {
+		t.Skip("the egress filter needs root and cgroup v2")
+	}
+
+	// a cgroup with no controllers does for the filter
+	parent := path.Join(mount, fmt.Sprintf("go-runner-test-%d", os.Getpid()))
+	expect.Nil(os.Mkdir(parent, 0755))
+	t.Cleanup(func() {
+		_ = os.Remove(path.Join(parent, "prebuilt"))
+		_ = os.Remove(parent)
+	})
+
+	cgroups := &Cgroups{parent: parent, controllers: map[string]bool{}, log: &log.Logger}
+	goapp := &GoApp{Name: "prebuilt", AppDir: path.Join(t.TempDir(), "prebuilt"), cgroups: cgroups, log: &log.Logger}
+	config := AppConfig{Network: &Network{Policy: NETWORK_ALLOWLIST, Allow: []string{allowed}}}
+
+	expect.Equal("ownNetwork=false loopback=true allowed=true denied=false", runNetworkApp(t, goapp, config))
+
+	goapp.Lock()
+	id := goapp.processIdentity()
+	goapp.Unlock()
+	expect.True(id != nil && id.Network == NETWORK_ALLOWLIST)
+	expect.Nil(goapp.Stop())
+}
+
+func TestAllowlistNeedsCgroups(t *testing.T) {
+	expect := util.NewExpect(t)
+	goapp := &GoApp{Name: "prebuilt", AppDir: path.Join(t.TempDir(), "prebuilt"), cgroups: setupCgroups("", &log.Logger), log: &log.Logger}
+	binary := anElf(t)
+
+	expect.Nil(goapp.SetConfig(AppConfig{Network: &Network{Policy: NETWORK_ALLOWLIST, Allow: []string{"10.0.0.0/8"}}}))
+	expect.Nil(goapp.UnpackArtifact(bytes.NewReader(binary), sha256Of(binary), ""))
+
+	err := goapp.Start()
+	expect.True(err != nil && strings.Contains(err.Error(), "the network allowlist needs cgroups: no cgroup parent given"), err)
+	expect.Equal(STATE_FAILED, goapp.State())
+}
@@ -0,0 +1,196 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+var (
+	ErrBusy = errors.New("app is busy")
+	// ErrCoalesced is returned by DeployCoalesced when the deploy is left to one that is already waiting.
+	ErrCoalesced = errors.New("deploy coalesced into a pending one")
+	ErrDeleted   = errors.New("app is deleted")
+)
+
+const (
+	OP_DEPLOY  = "deploy"
+	OP_RESTART = "restart"
+	OP_DELETE  = "delete"
+)
+
+// operation is a lifecycle operation in progress on an app. Only one runs at a time.
+type operation struct {
+	Name  string    `json:"name"`
+	Since time.Time `json:"since"`
+	done  chan struct{}
+}
+
+// begin starts the operation name on the app. It fails with ErrBusy if another one is in progress.
+// Call end when the operation is done.
+func (a *GoApp) begin(name string) (end func(), err error) {
+	a.opLock.Lock()
+	defer a.opLock.Unlock()
+
+	if a.op != nil {
+		return nil, a.conflict()
+	}
+
+	return a.take(name), nil
+}
+
+// await waits for the operation in progress, if any, then starts the operation name on the app.
+func (a *GoApp) await(name string) (end func(), err error) {
+	for {
+		a.opLock.Lock()
+		if a.op == nil {
+			end := a.take(name)
+			a.opLock.Unlock()
+
+			return end, nil
+		}
+
+		if a.op.Name == OP_DELETE {
+			a.opLock.Unlock()
+			return nil, ErrDeleted
+		}
+
+		done := a.op.done
+		a.opLock.Unlock()
+
+		<-done
+	}
+}
+
+// take makes name the operation in progress. a.opLock must be held.
+func (a *GoApp) take(name string) func() {
+	op := &operation{Name: name, Since: time.Now(), done: make(chan struct{})}
+	a.op = op
+
+	return func() {
+		a.opLock.Lock()
+		defer a.opLock.Unlock()
+
+		a.op = nil
+		close(op.done)
+	}
+}
+
+// conflict is the error of starting an operation while another is in progress. a.opLock must be held.
+func (a *GoApp) conflict() error {
+	if a.op.Name == OP_DELETE {
+		return ErrDeleted
+	}
+
+	return fmt.Errorf("%w: %s in progress since %s", ErrBusy, a.op.Name, a.op.Since.Format(time.RFC3339))
+}
+
+// Operation returns the name of the operation in progress, "" if none.
+func (a *GoApp) Operation() string {
+	a.opLock.Lock()
+	defer a.opLock.Unlock()
+
+	if a.op == nil {
+		return ""
+	}
+
+	return a.op.Name
+}
+
+// DeployCoalesced is Deploy for triggers that may fire while a deploy is in progress, e.g. webhooks.
+// It waits for the deploy in progress and deploys again after it. If another call is already waiting to,
+// it returns ErrCoalesced at once, as that deploy will pick up whatever this one would.
+func (a *GoApp) DeployCoalesced(prepare func() error) error {
+	a.opLock.Lock()
+	if a.op != nil && a.op.Name == OP_DELETE {
+		a.opLock.Unlock()
+		return ErrDeleted
+	}
+
+	if a.op != nil && a.pendingDeploy {
+		a.opLock.Unlock()
+		return ErrCoalesced
+	}
+
+	a.pendingDeploy = a.op != nil
+	a.opLock.Unlock()
+
+	end, err := a.await(OP_DEPLOY)
+
+	a.opLock.Lock()
+	a.pendingDeploy = false
+	a.opLock.Unlock()
+
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	return a.deploy(prepare)
+}
+
+// Restart stops the app and starts it again from the current release.
+func (a *GoApp) Restart() error {
+	end, err := a.begin(OP_RESTART)
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	err = a.Stop()
+	if err != nil {
+		return err
+	}
+
+	return a.Start()
+}
+
+// Delete stops the app and removes its dir, once the operation in progress is done.
+// Every operation after it fails with ErrDeleted.
+func (a *GoApp) Delete() error {
+	a.Lock()
+	a.stopPolling()
+	a.Unlock()
+
+	_, err := a.await(OP_DELETE)
+	if err != nil {
+		return err
+	}
+
+	if state := a.State(); state == STATE_RUNNING || state == STATE_CRASHED {
+		err = a.Stop()
+		if err != nil {
+			a.log.Warn().Err(err).Msgf("failed to stop app before deleting, continue anyway. app=%s", a.Name)
+		}
+	}
+
+	return a.Purge()
+}
+
+// Purge removes the dir and the credentials of the app, and frees its uid.
+func (a *GoApp) Purge() error {
+	a.Lock()
+	defer a.Unlock()
+
+	a.mustTransition(STATE_DELETED, "deleted")
+	a.publish(EVENT_APP_DELETED, 0, "")
+	a.stopPolling()
+	a.cgroups.remove(a.Name)
+	if uid := a.users.release(a.Name); uid != 0 {
+		err := revokeTraverse(path.Dir(a.AppDir), uid)
+		if err != nil {
+			return fmt.Errorf("failed to revoke uid %d passing through the dir of the apps: %w", uid, err)
+		}
+	}
+
+	if a.creds != nil {
+		err := a.creds.DeleteApp(a.Name)
+		if err != nil {
+			return fmt.Errorf("failed to delete the credentials of the app: %w", err)
+		}
+	}
+
+	return os.RemoveAll(a.AppDir)
+}
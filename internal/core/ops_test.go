@@ -0,0 +1,107 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+var errPrepared = errors.New("prepared, nothing to start")
+
+// blockingDeploy starts a deploy that holds the app until release is closed.
+func blockingDeploy(goapp *GoApp, release chan struct{}) chan error {
+	started := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- goapp.Deploy(func() error {
+			close(started)
+			<-release
+			return errPrepared
+		})
+	}()
+
+	<-started
+
+	return result
+}
+
+func TestOperationsDontInterleave(t *testing.T) {
+	expect := util.NewExpect(t)
+	goapp := &GoApp{Name: "hello", AppDir: path.Join(t.TempDir(), "hello"), log: &log.Logger}
+	expect.Nil(os.MkdirAll(goapp.AppDir, 0770))
+
+	release := make(chan struct{})
+	deploying := blockingDeploy(goapp, release)
+	expect.Equal(OP_DEPLOY, goapp.Operation())
+
+	err := goapp.Deploy(func() error { return nil })
+	expect.True(errors.Is(err, ErrBusy))
+	expect.True(errors.Is(goapp.Restart(), ErrBusy))
+
+	deleted := make(chan error, 1)
+	go func() {
+		deleted <- goapp.Delete()
+	}()
+
+	select {
+	case <-deleted:
+		t.Fatal("delete didn't wait for the deploy")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_, err = os.Stat(goapp.AppDir)
+	expect.Nil(err)
+
+	close(release)
+	expect.True(errors.Is(<-deploying, errPrepared))
+	expect.Nil(<-deleted)
+
+	_, err = os.Stat(goapp.AppDir)
+	expect.True(os.IsNotExist(err))
+	expect.True(errors.Is(goapp.Deploy(func() error { return nil }), ErrDeleted))
+	expect.True(errors.Is(goapp.Delete(), ErrDeleted))
+}
+
+func TestDeployCoalescedRunsOnceMore(t *testing.T) {
+	expect := util.NewExpect(t)
+	goapp := &GoApp{Name: "hello", AppDir: path.Join(t.TempDir(), "hello"), log: &log.Logger}
+
+	release := make(chan struct{})
+	deploying := blockingDeploy(goapp, release)
+
+	prepared := 0
+	prepare := func() error {
+		prepared++
+		return errPrepared
+	}
+
+	waiting := make(chan error, 1)
+	go func() {
+		waiting <- goapp.DeployCoalesced(prepare)
+	}()
+
+	for i := 0; i < 100; i++ {
+		goapp.opLock.Lock()
+		pending := goapp.pendingDeploy
+		goapp.opLock.Unlock()
+		if pending {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	expect.True(errors.Is(goapp.DeployCoalesced(prepare), ErrCoalesced))
+	expect.Equal(0, prepared)
+
+	close(release)
+	expect.True(errors.Is(<-deploying, errPrepared))
+	expect.True(errors.Is(<-waiting, errPrepared))
+	expect.Equal(1, prepared)
+	expect.Equal("", goapp.Operation())
+}
@@ -0,0 +1,286 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+const (
+	MIN_POLL_INTERVAL = 10 * time.Second
+	MAX_POLL_BACKOFF  = time.Hour
+	// POLL_JITTER is how much the poll interval varies either way, so apps polling the same server spread out.
+	POLL_JITTER = 0.1
+)
+
+// PollStatus is how polling the tracked branch of an app is going.
+type PollStatus struct {
+	Interval   string     `json:"interval"`
+	LastCheck  *time.Time `json:"lastCheck,omitempty"`
+	LastResult string     `json:"lastResult,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+	RemoteHead string     `json:"remoteHead,omitempty"`
+	Failures   int        `json:"failures"`
+	NextCheck  *time.Time `json:"nextCheck,omitempty"`
+}
+
+// poller checks the tracked branch of an app at an interval, and deploys the app when the branch moves.
+type poller struct {
+	sync.Mutex
+	interval time.Duration
+	stop     chan struct{}
+	status   PollStatus
+	// failedHead is the last commit that failed to deploy, it is not tried again until the branch moves
+	failedHead string
+	deploy     func() error
+}
+
+func newPoller(interval time.Duration, deploy func() error) *poller {
+	return &poller{
+		interval: interval,
+		stop:     make(chan struct{}),
+		status:   PollStatus{Interval: interval.String()},
+		deploy:   deploy,
+	}
+}
+
+// startPolling starts polling if the app is configured to.
+func (a *GoApp) startPolling() {
+	a.Lock()
+	defer a.Unlock()
+
+	a.restartPolling()
+}
+
+// restartPolling replaces the poller of the app as per its config. The app must be locked.
+func (a *GoApp) restartPolling() {
+	a.stopPolling()
+
+	if a.config.PollInterval == "" {
+		return
+	}
+
+	interval, err := time.ParseDuration(a.config.PollInterval)
+	if err != nil {
+		a.log.Warn().Err(err).Msgf("invalid poll interval, not polling. app=%s", a.Name)
+		return
+	}
+
+	a.poller = newPoller(interval, func() error {
+		return a.Deploy(a.Rebuild)
+	})
+
+	go a.poll(a.poller)
+}
+
+// stopPolling stops the poller of the app, if any. The app must be locked.
+func (a *GoApp) stopPolling() {
+	if a.poller != nil {
+		close(a.poller.stop)
+		a.poller = nil
+	}
+}
+
+func (a *GoApp) poll(p *poller) {
+	a.log.Info().Msgf("polling git. app=%s, interval=%s", a.Name, p.interval)
+	for {
+		delay := p.nextDelay()
+		select {
+		case <-p.stop:
+			a.log.Info().Msgf("polling stopped. app=%s", a.Name)
+			return
+		case <-time.After(delay):
+		}
+
+		a.pollOnce(p)
+	}
+}
+
+// pollOnce checks the remote head of the tracked branch, and deploys the app if it moved.
+func (a *GoApp) pollOnce(p *poller) {
+	head, err := a.remoteHead()
+	if err != nil {
+		a.log.Warn().Err(err).Msgf("failed to poll git. app=%s", a.Name)
+		p.checked(head, "check failed", err)
+		return
+	}
+
+	a.Lock()
+	var current string
+	if a.current != nil {
+		current = a.current.GitHash
+	}
+	a.Unlock()
+
+	p.Lock()
+	failedHead := p.failedHead
+	p.Unlock()
+
+	switch head {
+	case current:
+		p.checked(head, "up to date", nil)
+		return
+	case failedHead:
+		p.checked(head, fmt.Sprintf("%s failed to deploy before, waiting for the next commit", head[0:7]), nil)
+		return
+	}
+
+	a.log.Info().Msgf("remote head moved, deploying. app=%s, head=%s", a.Name, head)
+	err = p.deploy()
+	switch {
+	case err == nil:
+		p.checked(head, fmt.Sprintf("deployed %s", head[0:7]), nil)
+	case errors.Is(err, ErrNoChanges):
+		p.checked(head, fmt.Sprintf("%s has no changes for the app", head[0:7]), nil)
+	case errors.Is(err, ErrBusy), errors.Is(err, ErrDeleted):
+		p.checked(head, fmt.Sprintf("%s not deployed, %s", head[0:7], err), nil)
+	default:
+		a.log.Error().Err(err).Msgf("failed to deploy polled commit. app=%s, head=%s", a.Name, head)
+		p.deployFailed(head, err)
+	}
+}
+
+// remoteHead returns the commit the tracked branch, or the remote HEAD without one, points to, like git ls-remote.
+func (a *GoApp) remoteHead() (string, error) {
+	branch := a.TrackedBranch()
+	refs, err := a.listRemote()
+	if err != nil {
+		return "", err
+	}
+
+	name := plumbing.HEAD
+	if branch != "" {
+		name = plumbing.NewBranchReferenceName(branch)
+	}
+
+	for i := 0; i < 2; i++ {
+		for _, ref := range refs {
+			if ref.Name() != name {
+				continue
+			}
+
+			if ref.Type() == plumbing.HashReference {
+				return ref.Hash().String(), nil
+			}
+
+			// HEAD -> refs/heads/main
+			name = ref.Target()
+		}
+	}
+
+	return "", fmt.Errorf("%s not found in remote", name)
+}
+
+// DefaultBranch returns the branch the remote HEAD of the app points to, which the app is deployed from when no
+// branch was given.
+func (a *GoApp) DefaultBranch() (string, error) {
+	refs, err := a.listRemote()
+	if err != nil {
+		return "", err
+	}
+
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			return ref.Target().Short(), nil
+		}
+	}
+
+	return "", errors.New("HEAD of the remote is not a branch")
+}
+
+// listRemote lists the refs of the remote of the app, with its url rewritten.
+func (a *GoApp) listRemote() ([]*plumbing.Reference, error) {
+	a.Lock()
+	gitURL := a.GitURL
+	a.Unlock()
+
+	if gitURL == "" {
+		return nil, errors.New("app has no gitUrl")
+	}
+
+	rewrites, err := loadURLRewrites(gitConfigFiles(a.opts.GitConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	cloneURL := rewriteURL(gitURL, rewrites)
+	auth, err := a.gitAuth(cloneURL)
+	if err != nil {
+		return nil, err
+	}
+
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{cloneURL},
+	})
+
+	return remote.List(&git.ListOptions{Auth: auth})
+}
+
+// nextDelay returns how long to wait for the next check: the interval with jitter,
+// doubled for every check that failed in a row, up to MAX_POLL_BACKOFF.
+func (p *poller) nextDelay() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+
+	delay := p.interval
+	for i := 0; i < p.status.Failures && delay < MAX_POLL_BACKOFF; i++ {
+		delay *= 2
+	}
+
+	if delay > MAX_POLL_BACKOFF && p.interval < MAX_POLL_BACKOFF {
+		delay = MAX_POLL_BACKOFF
+	}
+
+	delay += time.Duration((rand.Float64()*2 - 1) * POLL_JITTER * float64(delay))
+
+	next := time.Now().Add(delay)
+	p.status.NextCheck = &next
+
+	return delay
+}
+
+func (p *poller) checked(head, result string, err error) {
+	p.Lock()
+	defer p.Unlock()
+
+	now := time.Now()
+	p.status.LastCheck = &now
+	p.status.LastResult = result
+	p.status.LastError = ""
+	if head != "" {
+		p.status.RemoteHead = head
+	}
+
+	if err != nil {
+		p.status.Failures++
+		p.status.LastError = err.Error()
+	} else {
+		p.status.Failures = 0
+	}
+}
+
+// deployFailed records head failed to deploy. The check itself went fine, so it doesn't back off.
+func (p *poller) deployFailed(head string, err error) {
+	p.checked(head, fmt.Sprintf("%s failed to deploy", head[0:7]), nil)
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.failedHead = head
+	p.status.LastError = err.Error()
+}
+
+func (p *poller) Status() PollStatus {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.status
+}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"errors"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/rs/zerolog/log"
+)
+
+// newBareRemote clones repo into a bare repo, and makes it the origin repo pushes to.
+func newBareRemote(t *testing.T, repo *testRepo) string {
+	bare := path.Join(t.TempDir(), "remote.git")
+	_, err := git.PlainClone(bare, true, &git.CloneOptions{URL: repo.dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{bare}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bare
+}
+
+func (r *testRepo) push() {
+	err := r.repo.Push(&git.PushOptions{RemoteName: "origin"})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func TestPollDeploysWhenTheBranchMoves(t *testing.T) {
+	expect := util.NewExpect(t)
+	for _, bin := range []string{"git-upload-pack", "git-receive-pack"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s not found", bin)
+		}
+	}
+
+	repo := newTestRepo(t, map[string]string{"go.mod": "module example.com/hello\n\ngo 1.17\n"})
+	goapp := &GoApp{
+		Name:   "hello",
+		GitURL: newBareRemote(t, repo),
+		AppDir: path.Join(t.TempDir(), "hello"),
+		log:    &log.Logger,
+	}
+	expect.Nil(goapp.Rebuild())
+
+	p := newPoller(time.Minute, goapp.Rebuild)
+	goapp.pollOnce(p)
+	expect.Equal("up to date", p.Status().LastResult)
+	expect.Equal(goapp.current.GitHash, p.Status().RemoteHead)
+	expect.Equal(1, goapp.current.ID)
+
+	head := repo.commit(map[string]string{"main.go": "package main\n"})
+	goapp.pollOnce(p)
+	expect.Equal("up to date", p.Status().LastResult)
+
+	repo.push()
+	goapp.pollOnce(p)
+	expect.Equal(head, goapp.current.GitHash)
+	expect.Equal(2, goapp.current.ID)
+	expect.Equal("deployed "+head[0:7], p.Status().LastResult)
+
+	deploys := 0
+	p.deploy = func() error {
+		deploys++
+		return errors.New("build failed")
+	}
+
+	head = repo.commit(map[string]string{"main.go": "package main\n\nfunc main() {}\n"})
+	repo.push()
+	goapp.pollOnce(p)
+	goapp.pollOnce(p)
+	expect.Equal(1, deploys)
+	expect.Equal(0, p.Status().Failures)
+	expect.Equal(head, p.Status().RemoteHead)
+
+	head = repo.commit(map[string]string{"main.go": "package main\n\nfunc main() { println() }\n"})
+	repo.push()
+	p.deploy = goapp.Rebuild
+	goapp.pollOnce(p)
+	expect.Equal(head, goapp.current.GitHash)
+	expect.Equal("", p.Status().LastError)
+}
+
+func TestDefaultBranchIsTheRemoteHead(t *testing.T) {
+	expect := util.NewExpect(t)
+	if _, err := exec.LookPath("git-upload-pack"); err != nil {
+		t.Skip("git-upload-pack not found")
+	}
+
+	repo := newTestRepo(t, map[string]string{"go.mod": "module example.com/hello\n\ngo 1.17\n"})
+	head, err := repo.repo.Head()
+	expect.Nil(err)
+
+	// never cloned, so it tracks no branch yet
+	goapp := &GoApp{Name: "hello", GitURL: newBareRemote(t, repo), AppDir: path.Join(t.TempDir(), "hello"), log: &log.Logger}
+	expect.Equal("", goapp.TrackedBranch())
+	branch, err := goapp.DefaultBranch()
+	expect.Nil(err)
+	expect.Equal(head.Name().Short(), branch)
+
+	_, err = (&GoApp{Name: "hello", log: &log.Logger}).DefaultBranch()
+	expect.True(err != nil)
+}
+
+func TestPollBacksOffOnErrors(t *testing.T) {
+	expect := util.NewExpect(t)
+	goapp := &GoApp{
+		Name:   "gone",
+		GitURL: path.Join(t.TempDir(), "gone.git"),
+		AppDir: path.Join(t.TempDir(), "gone"),
+		log:    &log.Logger,
+	}
+
+	p := newPoller(time.Minute, func() error {
+		t.Fatal("should not deploy")
+		return nil
+	})
+
+	for i := 0; i < 3; i++ {
+		goapp.pollOnce(p)
+	}
+
+	status := p.Status()
+	expect.Equal(3, status.Failures)
+	expect.Equal("check failed", status.LastResult)
+	expect.True(status.LastError != "")
+
+	delay := p.nextDelay()
+	expect.True(delay >= 8*time.Minute*9/10 && delay <= 8*time.Minute*11/10, delay)
+
+	p.status.Failures = 20
+	expect.True(p.nextDelay() <= MAX_POLL_BACKOFF*11/10)
+
+	expect.True(errors.Is(goapp.SetConfig(AppConfig{PollInterval: "1s"}), ErrInvalidConfig))
+	expect.True(errors.Is(goapp.SetConfig(AppConfig{PollInterval: "often"}), ErrInvalidConfig))
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CLOCK_TICKS is USER_HZ, the unit of the cpu times in /proc, which is 100 on about every Linux.
+const CLOCK_TICKS = 100
+
+// ProcStat is the resource usage of a process, read from /proc/<pid>/stat.
+type ProcStat struct {
+	// CPUSeconds is the user and system cpu time the process has taken
+	CPUSeconds float64 `json:"cpuSeconds"`
+	// RSS is the resident memory of the process in bytes
+	RSS     int64 `json:"rss"`
+	Threads int   `json:"threads"`
+	// tree is the processes summed up, if it is of a process tree, and cpu the cpu seconds of each
+	tree []int
+	cpu  map[int]float64
+}
+
+// ReadProcStat reads the usage of the process pid from /proc.
+func ReadProcStat(pid int) (ProcStat, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return ProcStat{}, err
+	}
+
+	return parseProcStat(string(content))
+}
+
+// parseProcStat reads the content of /proc/<pid>/stat. The command name in it may have spaces and parentheses,
+// so the fields are counted from the last ")".
+func parseProcStat(stat string) (ProcStat, error) {
+	end := strings.LastIndex(stat, ")")
+	if end < 0 {
+		return ProcStat{}, fmt.Errorf("malformed /proc stat: %q", stat)
+	}
+
+	// from field 3, state
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 22 {
+		return ProcStat{}, fmt.Errorf("malformed /proc stat: %q", stat)
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return ProcStat{}, err
+	}
+
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return ProcStat{}, err
+	}
+
+	threads, err := strconv.Atoi(fields[17])
+	if err != nil {
+		return ProcStat{}, err
+	}
+
+	rss, err := strconv.ParseInt(fields[21], 10, 64)
+	if err != nil {
+		return ProcStat{}, err
+	}
+
+	return ProcStat{
+		CPUSeconds: float64(utime+stime) / CLOCK_TICKS,
+		RSS:        rss * int64(os.Getpagesize()),
+		Threads:    threads,
+	}, nil
+}
+
+// readProcFDs counts the open file descriptors of the process pid.
+func readProcFDs(pid int) (int, error) {
+	fds, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(fds), nil
+}
+
+// readProcIO returns the bytes the process pid has read from and written to storage, from /proc/<pid>/io.
+func readProcIO(pid int) (read, written uint64, err error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/io", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "read_bytes":
+			read, err = strconv.ParseUint(strings.TrimSpace(kv[1]), 10, 64)
+		case "write_bytes":
+			written, err = strconv.ParseUint(strings.TrimSpace(kv[1]), 10, 64)
+		}
+
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return read, written, nil
+}
+
+// procTree returns pid and its descendants, e.g. an isolated app under its launchers.
+func procTree(pid int) []int {
+	children := procChildren()
+	tree := []int{pid}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i]]...)
+	}
+
+	return tree
+}
+
+// procChildren returns the children of every process, by the parent pids in /proc, the oldest first.
+func procChildren() map[int][]int {
+	children := make(map[int][]int)
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return children
+	}
+
+	for _, e := range entries {
+		child, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", child))
+		if err != nil {
+			// exited since
+			continue
+		}
+
+		stat := string(content)
+		fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+		if len(fields) < 2 {
+			continue
+		}
+
+		parent, err := strconv.Atoi(fields[1])
+		if err == nil {
+			children[parent] = append(children[parent], child)
+		}
+	}
+
+	for _, c := range children {
+		sort.Ints(c)
+	}
+
+	return children
+}
+
+// appProcess returns the process of the app launched as pid, which is under the launchers if the app is isolated.
+func appProcess(pid int) int {
+	for {
+		cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+		if err != nil {
+			return pid
+		}
+
+		args := strings.Split(string(cmdline), "\x00")
+		if len(args) < 2 || args[1] != LAUNCH_ARG {
+			return pid
+		}
+
+		children := procChildren()[pid]
+		if len(children) == 0 {
+			// yet to execute the app
+			return pid
+		}
+
+		pid = children[0]
+	}
+}
+
+// readProcTreeStat sums the usage of the process pid and its descendants.
+func readProcTreeStat(pid int) (ProcStat, error) {
+	total, err := ReadProcStat(pid)
+	if err != nil {
+		return ProcStat{}, err
+	}
+
+	total.tree = procTree(pid)
+	total.cpu = map[int]float64{pid: total.CPUSeconds}
+	for _, p := range total.tree[1:] {
+		stat, err := ReadProcStat(p)
+		if err != nil {
+			continue
+		}
+
+		total.cpu[p] = stat.CPUSeconds
+		total.CPUSeconds += stat.CPUSeconds
+		total.RSS += stat.RSS
+		total.Threads += stat.Threads
+	}
+
+	return total, nil
+}
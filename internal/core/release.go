@@ -0,0 +1,320 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+)
+
+const (
+	RELEASES_DIRNAME = "releases"
+	RELEASES_TO_KEEP = 10
+	APP_RECORD_FILE  = "app.json"
+	// DATA_DIRNAME is the dir an app keeps its data in across releases, RUN_DIRNAME the one of its unix socket.
+	DATA_DIRNAME = "data"
+	RUN_DIRNAME  = "run"
+)
+
+const (
+	SOURCE_GIT      = "git"
+	SOURCE_UPLOAD   = "upload"
+	SOURCE_ARTIFACT = "artifact"
+)
+
+// Release is a version of an app deployed into its own dir under the app's releases dir.
+// A Rejected release failed a gate. Its files are removed, and it can't be started.
+type Release struct {
+	ID          int          `json:"id"`
+	Source      string       `json:"source"`
+	Branch      string       `json:"branch,omitempty"`
+	GitHash     string       `json:"gitHash,omitempty"`
+	GitCommit   string       `json:"gitCommit,omitempty"`
+	Uncommitted bool         `json:"uncommitted,omitempty"`
+	Subdir      string       `json:"subdir,omitempty"`
+	Fingerprint string       `json:"fingerprint,omitempty"`
+	Binary      string       `json:"binary,omitempty"`
+	Checksum    string       `json:"checksum,omitempty"`
+	Rejected    bool         `json:"rejected,omitempty"`
+	Gates       []GateResult `json:"gates,omitempty"`
+	CreatedAt   time.Time    `json:"createdAt"`
+}
+
+// appRecord is what is persisted in the app dir to bring the app back after go-runner restarts.
+type appRecord struct {
+	Name     string     `json:"name"`
+	GitURL   string     `json:"gitUrl"`
+	Branch   string     `json:"branch,omitempty"`
+	Config   AppConfig  `json:"config"`
+	Current  int        `json:"current"`
+	Releases []*Release `json:"releases"`
+	// UID is the uid allocated to the app, if any
+	UID int `json:"uid,omitempty"`
+}
+
+func (a *GoApp) releaseDir(r *Release) string {
+	return path.Join(a.AppDir, RELEASES_DIRNAME, strconv.Itoa(r.ID))
+}
+
+// newRelease creates an empty dir for the next release. The release is not added to the history until addRelease.
+func (a *GoApp) newRelease(source string) (*Release, error) {
+	id := 1
+	if n := len(a.releases); n > 0 {
+		id = a.releases[n-1].ID + 1
+	}
+
+	r := &Release{
+		ID:        id,
+		Source:    source,
+		CreatedAt: time.Now(),
+	}
+
+	dir := a.releaseDir(r)
+	err := os.RemoveAll(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(dir, 0770)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// addRelease makes r the current release, drops the oldest releases beyond RELEASES_TO_KEEP, and saves the app record.
+// The running release is never dropped.
+func (a *GoApp) addRelease(r *Release) error {
+	a.current = r
+
+	return a.keepRelease(r)
+}
+
+// rejectRelease records r failed a gate with err, without changing the current release.
+// Only the gate results are kept, not the files of the release.
+func (a *GoApp) rejectRelease(r *Release, err error) error {
+	_ = os.RemoveAll(a.releaseDir(r))
+	r.Rejected = true
+
+	saveErr := a.keepRelease(r)
+	if saveErr != nil {
+		a.log.Warn().Err(saveErr).Msgf("failed to save rejected release. app=%s, release=%d", a.Name, r.ID)
+	}
+
+	return a.releaseFailed("gates", err)
+}
+
+// keepRelease adds r to the history, drops the oldest releases beyond RELEASES_TO_KEEP, and saves the app record.
+func (a *GoApp) keepRelease(r *Release) error {
+	a.releases = append(a.releases, r)
+
+	kept := make([]*Release, 0, len(a.releases))
+	toDrop := len(a.releases) - RELEASES_TO_KEEP
+	for _, rel := range a.releases {
+		if toDrop > 0 && rel != a.running && rel != a.current {
+			toDrop--
+			err := os.RemoveAll(a.releaseDir(rel))
+			if err != nil {
+				a.log.Warn().Err(err).Msgf("failed to remove old release. app=%s, release=%d", a.Name, rel.ID)
+			}
+
+			continue
+		}
+
+		kept = append(kept, rel)
+	}
+
+	a.releases = kept
+
+	return a.save()
+}
+
+// Rollback makes an earlier release the current one. id 0 means the one before the current release.
+// It takes effect on the next Start.
+func (a *GoApp) Rollback(id int) error {
+	a.Lock()
+	defer a.Unlock()
+
+	var target *Release
+	if id == 0 {
+		for _, r := range a.releases {
+			if r == a.current {
+				break
+			}
+
+			if !r.Rejected {
+				target = r
+			}
+		}
+
+		if target == nil {
+			return a.releaseFailed("rollback", errors.New("no earlier release to roll back to"))
+		}
+	} else {
+		target = a.findRelease(id)
+		if target == nil {
+			return a.releaseFailed("rollback", fmt.Errorf("release %d not found", id))
+		}
+
+		if target.Rejected {
+			return a.releaseFailed("rollback", fmt.Errorf("release %d failed a gate", id))
+		}
+	}
+
+	a.current = target
+	err := a.save()
+	if err != nil {
+		return a.releaseFailed("rollback", err)
+	}
+
+	return nil
+}
+
+// releaseFailed records an error of preparing a release, in the step it failed.
+// The state is left alone if the app is running, as the running release is not affected.
+func (a *GoApp) releaseFailed(step string, err error) error {
+	a.lastErr = err
+	a.preparing(STATE_FAILED, fmt.Sprintf("%s failed: %s", step, err))
+
+	return err
+}
+
+func (a *GoApp) findRelease(id int) *Release {
+	for _, r := range a.releases {
+		if r.ID == id {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// save writes the app record into the app dir.
+func (a *GoApp) save() error {
+	rec := appRecord{
+		Name:     a.Name,
+		GitURL:   a.GitURL,
+		Branch:   a.Branch,
+		Config:   a.config,
+		Releases: a.releases,
+		UID:      a.uid,
+	}
+
+	if a.current != nil {
+		rec.Current = a.current.ID
+	}
+
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path.Join(a.AppDir, APP_RECORD_FILE+".tmp")
+	err = ioutil.WriteFile(tmp, data, 0660)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path.Join(a.AppDir, APP_RECORD_FILE))
+}
+
+// load reads the app record from the app dir.
+func (a *GoApp) load() error {
+	data, err := ioutil.ReadFile(path.Join(a.AppDir, APP_RECORD_FILE))
+	if os.IsNotExist(err) {
+		return a.migrate()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var rec appRecord
+	err = json.Unmarshal(data, &rec)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", APP_RECORD_FILE, err)
+	}
+
+	a.GitURL = rec.GitURL
+	a.Branch = rec.Branch
+	a.config = rec.Config
+	a.releases = rec.Releases
+	a.current = a.findRelease(rec.Current)
+	a.uid = rec.UID
+
+	return nil
+}
+
+// migrate turns the app dir of an older go-runner, which was the git checkout of the app, into the first release of
+// the app, and saves the app record.
+func (a *GoApp) migrate() error {
+	_, err := git.PlainOpen(a.AppDir)
+	if err != nil {
+		return fmt.Errorf("neither %s nor a git checkout found: %w", APP_RECORD_FILE, err)
+	}
+
+	entries, err := ioutil.ReadDir(a.AppDir)
+	if err != nil {
+		return err
+	}
+
+	// moved aside first, as the checkout may have a dir of the name of the releases dir
+	checkout, err := ioutil.TempDir(a.AppDir, ".migrating")
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		switch entry.Name() {
+		case "sock":
+			// the unix socket of the app, which is in the run dir now
+			err = os.Remove(path.Join(a.AppDir, entry.Name()))
+		default:
+			err = os.Rename(path.Join(a.AppDir, entry.Name()), path.Join(checkout, entry.Name()))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	rel, err := a.newRelease(SOURCE_GIT)
+	if err != nil {
+		return err
+	}
+
+	// in place of the empty dir of the release
+	err = os.Remove(a.releaseDir(rel))
+	if err == nil {
+		err = os.Rename(checkout, a.releaseDir(rel))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	repo, err := git.PlainOpen(a.releaseDir(rel))
+	if err != nil {
+		return err
+	}
+
+	err = a.attach(repo, rel)
+	if err != nil {
+		return err
+	}
+
+	if remote, err := repo.Remote("origin"); err == nil && len(remote.Config().URLs) > 0 {
+		a.GitURL = remote.Config().URLs[0]
+	}
+
+	a.log.Info().Msgf("app dir of an older go-runner migrated to release %d. app=%s, gitUrl=%s", rel.ID, a.Name, a.GitURL)
+
+	return a.addRelease(rel)
+}
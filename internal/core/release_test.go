@@ -0,0 +1,118 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/rs/zerolog/log"
+)
+
+func sourceTarball(t *testing.T, content string) *bytes.Buffer {
+	src := t.TempDir()
+	err := ioutil.WriteFile(path.Join(src, "main.go"), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = util.TarGz(&buf, src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &buf
+}
+
+func TestUnpackKeepsReleaseHistory(t *testing.T) {
+	expect := util.NewExpect(t)
+	appDir := path.Join(t.TempDir(), "hello")
+	goapp := &GoApp{Name: "hello", AppDir: appDir, log: &log.Logger}
+
+	for i := 0; i < RELEASES_TO_KEEP+2; i++ {
+		expect.Nil(goapp.Unpack(sourceTarball(t, "package main"), "0123456789abcdef"))
+	}
+
+	releases := goapp.Releases()
+	expect.Equal(RELEASES_TO_KEEP, len(releases))
+	expect.Equal(3, releases[0].ID)
+	expect.Equal(RELEASES_TO_KEEP+2, goapp.current.ID)
+	expect.True(releases[0].Uncommitted)
+	expect.Equal("uncommitted changes on 0123456", releases[0].GitCommit)
+
+	_, err := os.Stat(path.Join(appDir, RELEASES_DIRNAME, "2"))
+	expect.True(os.IsNotExist(err))
+
+	content, err := ioutil.ReadFile(path.Join(goapp.releaseDir(goapp.current), "main.go"))
+	expect.Nil(err)
+	expect.Equal("package main", string(content))
+
+	reattached := &GoApp{Name: "hello", AppDir: appDir, log: &log.Logger}
+	expect.Nil(reattached.Reattach())
+	expect.Equal(goapp.current.ID, reattached.current.ID)
+	expect.Equal(RELEASES_TO_KEEP, len(reattached.Releases()))
+}
+
+func TestConfigIsKeptBeforeTheFirstRelease(t *testing.T) {
+	expect := util.NewExpect(t)
+	appDir := path.Join(t.TempDir(), "hello")
+	goapp := &GoApp{Name: "hello", AppDir: appDir, log: &log.Logger}
+	config := AppConfig{Subdir: "apps/hello", Submodules: true, PollInterval: "1m"}
+	expect.Nil(goapp.SetConfig(config))
+	goapp.Lock()
+	goapp.stopPolling()
+	goapp.Unlock()
+
+	// as if the first deploy failed and go-runner restarted
+	reattached := &GoApp{Name: "hello", AppDir: appDir, log: &log.Logger}
+	expect.Nil(reattached.Reattach())
+	expect.Equal(config, reattached.Config())
+	expect.True(reattached.current == nil)
+}
+
+func TestReattachMigratesTheCheckoutOfAnOlderGoRunner(t *testing.T) {
+	expect := util.NewExpect(t)
+	repo := newTestRepo(t, map[string]string{"main.go": "package main\n", "releases/notes.md": "v1\n"})
+	_, err := repo.repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{"https://example.com/hello.git"}})
+	expect.Nil(err)
+	head, err := repo.repo.Head()
+	expect.Nil(err)
+
+	// as built and run by an older go-runner, in the checkout
+	expect.Nil(ioutil.WriteFile(path.Join(repo.dir, "repo"), []byte("binary"), 0755))
+	expect.Nil(ioutil.WriteFile(path.Join(repo.dir, "sock"), nil, 0644))
+
+	goapp := &GoApp{Name: "repo", AppDir: repo.dir, log: &log.Logger}
+	expect.Nil(goapp.Reattach())
+	expect.Equal("https://example.com/hello.git", goapp.GitURL)
+	expect.True(goapp.current != nil && goapp.current.ID == 1)
+	expect.Equal(SOURCE_GIT, goapp.current.Source)
+	expect.Equal(head.Hash().String(), goapp.current.GitHash)
+	expect.Equal(STATE_REGISTERED, goapp.State())
+
+	for _, file := range []string{"main.go", "releases/notes.md", ".git/HEAD"} {
+		_, err = os.Stat(path.Join(goapp.releaseDir(goapp.current), file))
+		expect.Nil(err)
+	}
+
+	entries, err := ioutil.ReadDir(repo.dir)
+	expect.Nil(err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	expect.Equal([]string{APP_RECORD_FILE, RELEASES_DIRNAME}, names)
+
+	reattached := &GoApp{Name: "repo", AppDir: repo.dir, log: &log.Logger}
+	expect.Nil(reattached.Reattach())
+	expect.Equal(1, reattached.current.ID)
+	expect.Equal("https://example.com/hello.git", reattached.GitURL)
+
+	expect.True(errors.Is((&GoApp{Name: "empty", AppDir: t.TempDir(), log: &log.Logger}).Reattach(), git.ErrRepositoryNotExists))
+}
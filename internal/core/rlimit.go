@@ -0,0 +1,164 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// RLIMIT_NAMES are the rlimits an app may be given, by the name in its config.
+var RLIMIT_NAMES = []string{"nofile", "nproc", "core", "as", "cpu"}
+
+// Rlimits are the resource limits of the process of an app, which it inherits from go-runner if not given.
+// Each is both the soft and the hard limit.
+type Rlimits struct {
+	// NoFile is how many files the app may open
+	NoFile *uint64 `json:"nofile,omitempty"`
+	// NProc is how many processes the user of the app may have, so it is best with a user of its own
+	NProc *uint64 `json:"nproc,omitempty"`
+	// Core and AS are sizes in bytes, e.g. "0" not to dump cores or "2G" of address space
+	Core string `json:"core,omitempty"`
+	AS   string `json:"as,omitempty"`
+	// CPU is the cpu seconds the app may take before it is killed
+	CPU *uint64 `json:"cpu,omitempty"`
+}
+
+func (r *Rlimits) validate() error {
+	_, err := r.values()
+
+	return err
+}
+
+// values returns the limits given, by RLIMIT_NAMES.
+func (r *Rlimits) values() (map[string]uint64, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	values := make(map[string]uint64)
+	for name, v := range map[string]*uint64{"nofile": r.NoFile, "nproc": r.NProc, "cpu": r.CPU} {
+		if v != nil {
+			values[name] = *v
+		}
+	}
+
+	for name, size := range map[string]string{"core": r.Core, "as": r.AS} {
+		switch size {
+		case "":
+		case "0":
+			values[name] = 0
+		default:
+			n, err := parseSize(size)
+			if err != nil {
+				return nil, fmt.Errorf("%w: rlimits.%s: %s", ErrInvalidConfig, name, err)
+			}
+
+			values[name] = uint64(n)
+		}
+	}
+
+	if v, ok := values["nofile"]; ok && v == 0 {
+		return nil, fmt.Errorf("%w: rlimits.nofile must be at least 1", ErrInvalidConfig)
+	}
+
+	return values, nil
+}
+
+// ProcIdentity is who the process of an app runs as and the limits it runs with, read from /proc.
+type ProcIdentity struct {
+	PID        int  `json:"pid"`
+	UID        int  `json:"uid"`
+	GID        int  `json:"gid"`
+	NoNewPrivs bool `json:"noNewPrivs"`
+	// Seccomp tells if the syscalls of the process are filtered
+	Seccomp bool `json:"seccomp"`
+	// Network is the network policy the process is under, NETWORK_HOST, NETWORK_NONE or NETWORK_ALLOWLIST
+	Network string `json:"network"`
+	// Rlimits are the soft limits by RLIMIT_NAMES, "unlimited" or a number in the unit of /proc/<pid>/limits
+	Rlimits map[string]string `json:"rlimits"`
+}
+
+// procLimitNames are the names of RLIMIT_NAMES in /proc/<pid>/limits.
+var procLimitNames = map[string]string{
+	"Max open files":     "nofile",
+	"Max processes":      "nproc",
+	"Max core file size": "core",
+	"Max address space":  "as",
+	"Max cpu time":       "cpu",
+}
+
+// readProcIdentity reads the effective uid and gid, no_new_privs, seccomp mode, network policy and rlimits of the
+// process pid.
+func readProcIdentity(pid int) (*ProcIdentity, error) {
+	status, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	id := &ProcIdentity{PID: pid, Network: procNetwork(pid), Rlimits: make(map[string]string)}
+	for _, line := range strings.Split(string(status), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "Uid:", "Gid:":
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("malformed /proc status: %q", line)
+			}
+
+			// real, effective, saved and filesystem
+			effective, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, err
+			}
+
+			if fields[0] == "Uid:" {
+				id.UID = effective
+			} else {
+				id.GID = effective
+			}
+		case "NoNewPrivs:":
+			id.NoNewPrivs = fields[1] == "1"
+		case "Seccomp:":
+			// 2 is SECCOMP_MODE_FILTER
+			id.Seccomp = fields[1] == "2"
+		}
+	}
+
+	limits, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/limits", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	// Limit                     Soft Limit           Hard Limit           Units
+	for _, line := range strings.Split(string(limits), "\n") {
+		for prefix, name := range procLimitNames {
+			if strings.HasPrefix(line, prefix+" ") {
+				fields := strings.Fields(line[len(prefix):])
+				if len(fields) > 0 {
+					id.Rlimits[name] = fields[0]
+				}
+			}
+		}
+	}
+
+	return id, nil
+}
+
+// processIdentity reads who the running process of the app runs as, or nil if it is not running.
+// The app must be locked.
+func (a *GoApp) processIdentity() *ProcIdentity {
+	if a.proc == nil || a.currentState() != STATE_RUNNING {
+		return nil
+	}
+
+	id, err := readProcIdentity(appProcess(a.proc.Status().PID))
+	if err != nil {
+		return nil
+	}
+
+	return id
+}
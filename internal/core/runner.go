@@ -10,25 +10,94 @@ import (
 	"os"
 	"path"
 	"sync"
+	"time"
 )
 
+// Options are the settings of a GoRunner. Empty ones take the defaults.
+type Options struct {
+	// GitConfig is go-runner's own git config, default to GITCONFIG_FILE in the working dir
+	GitConfig string
+	// GoProxy is the GOPROXY of builds, e.g. "off" on an air-gapped host. Default to the one go-runner runs with.
+	GoProxy string
+	// GoModFlag is the -mod flag of builds, i.e. mod, readonly or vendor. Default to what go decides.
+	GoModFlag string
+	// ModMirror is a dir of modules in the layout of a module proxy, tried before GoProxy.
+	// Default to MODMIRROR_DIRNAME in the working dir. It is not used if it doesn't exist.
+	ModMirror string
+	// BuildCacheMax and ModCacheMax are the sizes in bytes the go caches are collected down to. 0 means no limit.
+	BuildCacheMax int64
+	ModCacheMax   int64
+	// MaxBuilds is how many builds run at a time, default to DEFAULT_MAX_BUILDS.
+	// BuildTimeout is how long a build may take, default to DEFAULT_BUILD_TIMEOUT.
+	MaxBuilds    int
+	BuildTimeout time.Duration
+	// SampleInterval is how often the resource usage of the apps is sampled, default to DEFAULT_SAMPLE_INTERVAL.
+	SampleInterval time.Duration
+	// CgroupParent is the cgroup v2 the cgroups of the apps are created in, or CGROUP_AUTO for the one of go-runner
+	// if it is delegated to it.
+	// The apps are not put into cgroups if it is empty.
+	CgroupParent string
+	// AppUIDs is the range of uids allocated to the apps, one each, that they run as unless their config tells
+	// a user. The apps run as go-runner if it is empty.
+	AppUIDs UIDRange
+}
+
 func NewGoRunner(wd string) *GoRunner {
+	return NewGoRunnerWithOptions(wd, Options{})
+}
+
+func NewGoRunnerWithOptions(wd string, opts Options) *GoRunner {
+	if opts.GitConfig == "" {
+		opts.GitConfig = path.Join(wd, GITCONFIG_FILE)
+	}
+
 	return &GoRunner{
-		wd:  wd,
-		log: &log.Logger,
+		wd:      wd,
+		opts:    opts,
+		creds:   NewCredentialStore(wd),
+		goenv:   newGoEnv(wd, opts, &log.Logger),
+		sched:   NewScheduler(opts.MaxBuilds, opts.BuildTimeout),
+		cgroups: setupCgroups(opts.CgroupParent, &log.Logger),
+		users:   newUsers(opts.AppUIDs),
+		events:  NewEventBus(),
+		log:     &log.Logger,
 	}
 }
 
 type GoRunner struct {
-	_    struct{}
-	apps sync.Map
-	wd   string
-	log  *zerolog.Logger
+	_       struct{}
+	apps    sync.Map
+	wd      string
+	opts    Options
+	creds   *CredentialStore
+	goenv   *GoEnv
+	sched   *Scheduler
+	cgroups *Cgroups
+	users   *Users
+	events  *EventBus
+	log     *zerolog.Logger
 }
 
 const APPS_DIRNAME = "goapps"
 
+// reservedAppNames are taken by the api routes.
+var reservedAppNames = map[string]bool{
+	"apps":          true,
+	"health":        true,
+	"credentials":   true,
+	"goenv":         true,
+	"jobs":          true,
+	"events":        true,
+	"notifications": true,
+	"hooks":         true,
+	"metrics":       true,
+}
+
 func (r *GoRunner) NewApp(appName, gitUrl string) (*GoApp, error) {
+	if reservedAppNames[appName] {
+		return nil, fmt.Errorf("app name [%s] is reserved", appName)
+	}
+
 	appDir := path.Join(r.wd, APPS_DIRNAME, appName)
 
 	if _, err := os.Stat(appDir); os.IsNotExist(err) {
@@ -38,13 +107,25 @@ func (r *GoRunner) NewApp(appName, gitUrl string) (*GoApp, error) {
 	}
 
 	app := &GoApp{
-		Name:   appName,
-		GitURL: gitUrl,
-		AppDir: appDir,
-		log:    r.log,
+		Name:    appName,
+		GitURL:  gitUrl,
+		AppDir:  appDir,
+		opts:    r.opts,
+		creds:   r.creds,
+		goenv:   r.goenv,
+		sched:   r.sched,
+		cgroups: r.cgroups,
+		users:   r.users,
+		bus:     r.events,
+		log:     r.log,
+	}
+	app.register("registered with gitUrl " + gitUrl)
+
+	if _, loaded := r.apps.LoadOrStore(appName, app); loaded {
+		return nil, fmt.Errorf("%w: app [%s] is already registered", ErrBusy, appName)
 	}
 
-	r.apps.Store(appName, app)
+	r.events.Publish(AppEvent{Type: EVENT_APP_REGISTERED, App: appName, Message: gitUrl})
 
 	return app, nil
 }
@@ -95,24 +176,70 @@ func (r *GoRunner) Rehydrate() error {
 		}
 	}
 
+	r.goenv.collect()
+
+	reattached := make([]*GoApp, 0, len(dirs))
 	for _, dir := range dirs {
 		if dir.IsDir() {
 			appDir := path.Join(appsDir, dir.Name())
 			app := &GoApp{
-				Name:   dir.Name(),
-				AppDir: appDir,
+				Name:    dir.Name(),
+				AppDir:  appDir,
+				opts:    r.opts,
+				creds:   r.creds,
+				goenv:   r.goenv,
+				sched:   r.sched,
+				cgroups: r.cgroups,
+				users:   r.users,
+				bus:     r.events,
+				log:     r.log,
 			}
 
-			_ = app.Reattach()
-			_ = app.Start()
+			err := app.Reattach()
+			if err != nil {
+				r.log.Warn().Err(err).Msgf("failed to reattach app. app=%s", app.Name)
+			} else {
+				reattached = append(reattached, app)
+			}
 
 			r.apps.Store(app.Name, app)
 		}
 	}
 
+	// started once all are reattached, so that no app is allocated the uid of another one
+	for _, app := range reattached {
+		_ = app.Start()
+		app.startPolling()
+	}
+
 	return nil
 }
 
+// Scheduler returns the scheduler of the builds of the apps.
+func (r *GoRunner) Scheduler() *Scheduler {
+	return r.sched
+}
+
+// Cgroups returns the cgroups the apps are put into.
+func (r *GoRunner) Cgroups() *Cgroups {
+	return r.cgroups
+}
+
+// Events returns the events of all apps.
+func (r *GoRunner) Events() *EventBus {
+	return r.events
+}
+
+// GoEnv returns the environment the apps are built in.
+func (r *GoRunner) GoEnv() *GoEnv {
+	return r.goenv
+}
+
+// Credentials returns the credentials for cloning private git repos.
+func (r *GoRunner) Credentials() *CredentialStore {
+	return r.creds
+}
+
 func (r *GoRunner) ListApps() []*GoApp {
 	apps := make([]*GoApp, 0)
 	r.apps.Range(func(_, app interface{}) bool {
@@ -126,6 +253,14 @@ func (r *GoRunner) ListApps() []*GoApp {
 func (r *GoRunner) Stop(c context.Context) error {
 	r.apps.Range(func(key, value interface{}) bool {
 		a := value.(*GoApp)
+		a.Lock()
+		a.stopPolling()
+		a.Unlock()
+
+		if !a.IsRunning() {
+			return true
+		}
+
 		err := a.Stop()
 
 		if err != nil {
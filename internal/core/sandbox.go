@@ -0,0 +1,256 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// SECCOMP_DEFAULT is the seccomp profile of the syscalls Go http servers make, plus the Syscalls of the sandbox.
+	SECCOMP_DEFAULT = "default"
+	// SECCOMP_CUSTOM is the seccomp profile of the Syscalls of the sandbox only.
+	SECCOMP_CUSTOM = "custom"
+	// KMSG is where the kernel logs the syscalls seccomp kills a process for, unless auditd takes them.
+	KMSG = "/dev/kmsg"
+)
+
+// SECCOMP_DEFAULT_SYSCALLS are what the Go runtime, the dynamic loader and the net, os and os/exec packages need.
+// The ones a platform doesn't have are left out.
+var SECCOMP_DEFAULT_SYSCALLS = []string{
+	// memory
+	"brk", "mmap", "mprotect", "munmap", "mremap", "madvise", "mincore", "msync",
+	// threads, signals and time
+	"clone", "clone3", "futex", "futex_waitv", "set_robust_list", "get_robust_list", "set_tid_address", "rseq",
+	"arch_prctl", "prctl", "sched_yield", "sched_getaffinity", "getcpu", "rt_sigaction", "rt_sigprocmask",
+	"rt_sigreturn", "rt_sigsuspend", "rt_sigtimedwait", "sigaltstack", "nanosleep", "clock_nanosleep",
+	"clock_gettime", "clock_getres", "gettimeofday", "time", "timer_create", "timer_settime", "timer_gettime",
+	"timer_delete", "setitimer", "getitimer", "restart_syscall",
+	// processes
+	"execve", "exit", "exit_group", "wait4", "waitid", "kill", "tkill", "tgkill", "pidfd_open", "pidfd_send_signal",
+	"getpid", "getppid", "gettid", "getuid", "geteuid", "getgid", "getegid", "getgroups", "getpgrp", "getpgid",
+	"getsid", "getrlimit", "prlimit64", "getrusage", "uname", "sysinfo", "times", "getrandom",
+	// files
+	"read", "write", "readv", "writev", "pread64", "pwrite64", "preadv", "pwritev", "open", "openat", "close",
+	"close_range", "creat", "stat", "fstat", "lstat", "newfstatat", "statx", "statfs", "fstatfs", "lseek", "access",
+	"faccessat", "faccessat2", "ioctl", "fcntl", "flock", "fsync", "fdatasync", "truncate", "ftruncate", "fallocate",
+	"fadvise64", "getdents", "getdents64", "getcwd", "chdir", "fchdir", "mkdir", "mkdirat", "rmdir", "rename",
+	"renameat", "renameat2", "unlink", "unlinkat", "link", "linkat", "symlink", "symlinkat", "readlink",
+	"readlinkat", "chmod", "fchmod", "fchmodat", "fchmodat2", "utimensat", "umask", "dup", "dup2", "dup3", "pipe",
+	"pipe2", "sendfile", "splice", "tee", "copy_file_range", "inotify_init1", "inotify_add_watch",
+	"inotify_rm_watch",
+	// polling
+	"select", "pselect6", "poll", "ppoll", "epoll_create", "epoll_create1", "epoll_ctl", "epoll_wait",
+	"epoll_pwait", "epoll_pwait2", "eventfd", "eventfd2",
+	// network
+	"socket", "socketpair", "connect", "accept", "accept4", "bind", "listen", "getsockname", "getpeername",
+	"setsockopt", "getsockopt", "sendto", "recvfrom", "sendmsg", "recvmsg", "sendmmsg", "recvmmsg", "shutdown",
+}
+
+// SECCOMP_LAUNCH_SYSCALLS are allowed by every profile, as the launcher makes them between filtering itself and
+// executing the app, or reporting why it couldn't.
+var SECCOMP_LAUNCH_SYSCALLS = []string{
+	"execve", "futex", "rt_sigreturn", "rt_sigprocmask", "sigaltstack", "sched_yield", "nanosleep", "mmap", "munmap",
+	"madvise", "getpid", "gettid", "tgkill", "exit", "exit_group", "pwrite64",
+}
+
+// Sandbox is the syscalls and the files an app may use.
+type Sandbox struct {
+	// Seccomp is the profile of the syscalls the app may make, SECCOMP_DEFAULT or SECCOMP_CUSTOM. Any other syscall
+	// kills the app. The syscalls are not filtered if it is empty.
+	Seccomp string `json:"seccomp,omitempty"`
+	// Syscalls are allowed on top of the default profile, or are the custom profile. Namespaces need unshare among
+	// them, as clone may not create any and clone3 fails with ENOSYS otherwise.
+	Syscalls []string `json:"syscalls,omitempty"`
+	// Landlock limits the files the app may access to its release and the system dirs, read-only, and its data dir,
+	// the dir of its socket, /tmp and a few devices
+	Landlock bool `json:"landlock,omitempty"`
+	// ReadPaths and WritePaths are more files and dirs the app may read, or write too, under Landlock, as it sees them
+	ReadPaths  []string `json:"readPaths,omitempty"`
+	WritePaths []string `json:"writePaths,omitempty"`
+}
+
+func (s *Sandbox) validate() error {
+	if s == nil {
+		return nil
+	}
+
+	switch s.Seccomp {
+	case "":
+		if len(s.Syscalls) > 0 {
+			return fmt.Errorf("%w: sandbox.syscalls need a seccomp profile", ErrInvalidConfig)
+		}
+	case SECCOMP_DEFAULT:
+	case SECCOMP_CUSTOM:
+		if len(s.Syscalls) == 0 {
+			return fmt.Errorf("%w: sandbox.syscalls are the custom seccomp profile, and must not be empty", ErrInvalidConfig)
+		}
+	default:
+		return fmt.Errorf("%w: sandbox.seccomp must be %s or %s", ErrInvalidConfig, SECCOMP_DEFAULT, SECCOMP_CUSTOM)
+	}
+
+	for _, name := range s.Syscalls {
+		if _, ok := syscallNumbers[name]; syscallNumbers != nil && !ok {
+			return fmt.Errorf("%w: sandbox.syscalls: unknown syscall %s", ErrInvalidConfig, name)
+		}
+	}
+
+	paths := append(append([]string{}, s.ReadPaths...), s.WritePaths...)
+	if len(paths) > 0 && !s.Landlock {
+		return fmt.Errorf("%w: sandbox.readPaths and writePaths need landlock", ErrInvalidConfig)
+	}
+
+	for _, p := range paths {
+		if !path.IsAbs(p) {
+			return fmt.Errorf("%w: sandbox paths must be absolute: %s", ErrInvalidConfig, p)
+		}
+	}
+
+	return nil
+}
+
+// syscalls returns the syscalls the profile of s allows, or nil if they are not filtered.
+func (s *Sandbox) syscalls() []string {
+	if s == nil || s.Seccomp == "" {
+		return nil
+	}
+
+	allowed := append([]string{}, SECCOMP_LAUNCH_SYSCALLS...)
+	if s.Seccomp == SECCOMP_DEFAULT {
+		allowed = append(allowed, SECCOMP_DEFAULT_SYSCALLS...)
+	}
+
+	return append(allowed, s.Syscalls...)
+}
+
+// landlockRules are the files and dirs an app may access under Landlock, as it sees them. The ones that don't exist
+// are left out.
+type landlockRules struct {
+	ReadOnly  []string `json:"readOnly,omitempty"`
+	ReadWrite []string `json:"readWrite,omitempty"`
+}
+
+// sandbox returns the syscalls the app may make and the files it may access as per its config, given where its
+// release, data and run dirs are as it sees them, and what of the sandbox is not applied. The app must be locked.
+func (a *GoApp) sandbox(release, data, run string) ([]string, *landlockRules, []string) {
+	s := a.config.Sandbox
+	if s == nil || !s.Landlock {
+		return s.syscalls(), nil, nil
+	}
+
+	err := landlockSupported()
+	if err != nil {
+		// better running without it than not at all
+		return s.syscalls(), nil, []string{"landlock: " + err.Error()}
+	}
+
+	rules := &landlockRules{
+		ReadOnly:  append(append([]string{release, "/proc", "/sys"}, SANDBOX_SYSTEM_DIRS...), s.ReadPaths...),
+		ReadWrite: append([]string{data, run, "/tmp", "/dev/shm"}, s.WritePaths...),
+	}
+
+	for _, device := range SANDBOX_DEVICES {
+		rules.ReadWrite = append(rules.ReadWrite, path.Join("/dev", device))
+	}
+
+	return s.syscalls(), rules, nil
+}
+
+// seccompAudit reads the audit records of the syscalls seccomp kills the process of an app for from KMSG, which only
+// root may read, e.g. audit: type=1326 ... pid=4133 comm="app" exe="/app/app" sig=31 arch=c000003e syscall=101 ...
+// The records are of the process pid, or of exe as the app sees it when it is isolated in a pid namespace.
+type seccompAudit struct {
+	fd  int
+	pid int
+	exe string
+}
+
+// auditSeccomp starts reading the audit records after the last one, before the app is started. If they can't be
+// read, the violations don't tell the syscall.
+func auditSeccomp(exe string) *seccompAudit {
+	s := &seccompAudit{fd: -1, exe: exe}
+	fd, err := syscall.Open(KMSG, syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return s
+	}
+
+	_, err = syscall.Seek(fd, 0, io.SeekEnd)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return s
+	}
+
+	s.fd = fd
+
+	return s
+}
+
+// violation tells what the app did to be killed by its seccomp profile.
+func (s *seccompAudit) violation() string {
+	// kauditd logs the record after the kill, which may be after the exit is seen
+	nr, ok := s.killedFor()
+	for i := 0; i < 20 && !ok && s.fd >= 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		nr, ok = s.killedFor()
+	}
+
+	if !ok {
+		return "made a syscall outside its seccomp profile"
+	}
+
+	name := "#" + strconv.Itoa(nr)
+	for n, number := range syscallNumbers {
+		if number == nr {
+			name = n
+		}
+	}
+
+	return fmt.Sprintf("made syscall %s outside its seccomp profile", name)
+}
+
+// killedFor returns the syscall in the last audit record of the app since it started.
+func (s *seccompAudit) killedFor() (int, bool) {
+	if s.fd < 0 {
+		return 0, false
+	}
+
+	var last string
+	pid, exe := fmt.Sprintf(" pid=%d ", s.pid), fmt.Sprintf(" exe=%q ", s.exe)
+	buf := make([]byte, 8192)
+	for {
+		// a read returns one record, or EAGAIN past the last one
+		n, err := syscall.Read(s.fd, buf)
+		if err == syscall.EPIPE {
+			// overwritten while being read
+			continue
+		}
+
+		if err != nil || n <= 0 {
+			break
+		}
+
+		record := string(buf[:n])
+		if strings.Contains(record, "type=1326 ") && (strings.Contains(record, pid) || strings.Contains(record, exe)) {
+			last = record
+		}
+	}
+
+	for _, field := range strings.Fields(last) {
+		if strings.HasPrefix(field, "syscall=") {
+			nr, err := strconv.Atoi(strings.TrimPrefix(field, "syscall="))
+			return nr, err == nil
+		}
+	}
+
+	return 0, false
+}
+
+func (s *seccompAudit) close() {
+	if s != nil && s.fd >= 0 {
+		_ = syscall.Close(s.fd)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build !linux
+// +build !linux
+
+package core
+
+import "errors"
+
+// seccompProgram is nothing where there is no seccomp.
+type seccompProgram struct{}
+
+func compileSeccomp(syscalls []string) (*seccompProgram, error) {
+	if len(syscalls) > 0 {
+		return nil, errors.New("seccomp is only supported on Linux")
+	}
+
+	return nil, nil
+}
+
+func (p *seccompProgram) install() error {
+	return nil
+}
+
+func landlockSupported() error {
+	return errors.New("only supported on Linux")
+}
+
+func restrictFiles(rules *landlockRules) error {
+	if rules != nil {
+		return errors.New("landlock is only supported on Linux")
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+// seeSandbox writes what the sandboxed app may access into seen in its data dir.
+func seeSandbox(dataDir string) {
+	_, appDirErr := ioutil.ReadDir(os.Getenv("GORUNNER_TEST_APP_DIR"))
+	releaseErr := ioutil.WriteFile("written", nil, 0644)
+	tmp, tmpErr := ioutil.TempFile("", "sandbox")
+	if tmpErr == nil {
+		_ = os.Remove(tmp.Name())
+	}
+	_, etcErr := ioutil.ReadDir("/etc")
+
+	seen := fmt.Sprintf("appDirHidden=%t releaseReadOnly=%t tmpWritable=%t etcReadable=%t",
+		appDirErr != nil, releaseErr != nil, tmpErr == nil, etcErr == nil)
+	_ = ioutil.WriteFile(path.Join(dataDir, "seen"), []byte(seen), 0644)
+}
+
+// violateSandbox makes a syscall no profile allows.
+func violateSandbox() {
+	_, _, _ = syscall.RawSyscall(syscall.SYS_PTRACE, syscall.PTRACE_TRACEME, 0, 0)
+}
+
+// cloneSandbox writes into seen in its data dir how clone3 and clone with namespace flags fail. Both fail with
+// EINVAL if the profile allows them, as clone3 gets no args and clone gets CLONE_FS too.
+func cloneSandbox(dataDir string) {
+	seen := path.Join(dataDir, "seen")
+	_, _, clone3Err := syscall.RawSyscall(uintptr(syscallNumbers["clone3"]), 0, 0, 0)
+	_ = ioutil.WriteFile(seen, []byte(fmt.Sprintf("clone3=%v", clone3Err)), 0644)
+	_, _, cloneErr := syscall.RawSyscall(syscall.SYS_CLONE, syscall.CLONE_NEWUSER|syscall.CLONE_NEWNS|syscall.CLONE_FS, 0, 0)
+	_ = ioutil.WriteFile(seen, []byte(fmt.Sprintf("clone3=%v clone=%v", clone3Err, cloneErr)), 0644)
+}
+
+func TestSandboxIsValidated(t *testing.T) {
+	expect := util.NewExpect(t)
+
+	for _, invalid := range []*Sandbox{
+		{Seccomp: "strict"},
+		{Seccomp: SECCOMP_CUSTOM},
+		{Syscalls: []string{"read"}},
+		{Seccomp: SECCOMP_DEFAULT, Syscalls: []string{"no_such_syscall"}},
+		{ReadPaths: []string{"/srv"}},
+		{Landlock: true, WritePaths: []string{"srv"}},
+	} {
+		err := (&AppConfig{Sandbox: invalid}).validate()
+		expect.True(errors.Is(err, ErrInvalidConfig), err)
+	}
+
+	sandbox := &Sandbox{Seccomp: SECCOMP_DEFAULT, Syscalls: []string{"ptrace"}, Landlock: true, ReadPaths: []string{"/srv"}}
+	expect.Nil((&AppConfig{Sandbox: sandbox}).validate())
+	expect.True(contains(sandbox.syscalls(), "ptrace"))
+	expect.True(contains(sandbox.syscalls(), "accept4"))
+
+	// a custom profile only has what the launcher needs on top of its syscalls
+	custom := &Sandbox{Seccomp: SECCOMP_CUSTOM, Syscalls: []string{"read"}}
+	expect.Equal(len(SECCOMP_LAUNCH_SYSCALLS)+1, len(custom.syscalls()))
+	expect.True((*Sandbox)(nil).syscalls() == nil)
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestAppRunsSandboxed(t *testing.T) {
+	if landlockSupported() != nil || syscallNumbers == nil {
+		t.Skip("no seccomp or landlock")
+	}
+
+	for _, isolate := range []bool{false, true} {
+		expect := util.NewExpect(t)
+		// not in /tmp, which the app may write to
+		dir, err := ioutil.TempDir(".", "sandbox")
+		expect.Nil(err)
+		t.Cleanup(func() { _ = os.RemoveAll(dir) })
+		dir, err = filepath.Abs(dir)
+		expect.Nil(err)
+		if strings.HasPrefix(dir, "/tmp/") {
+			t.Skip("the package is in /tmp")
+		}
+
+		goapp := &GoApp{Name: "prebuilt", AppDir: path.Join(dir, "prebuilt"), log: &log.Logger}
+		binary := anElf(t)
+		t.Setenv("GORUNNER_TEST_APP_DIR", goapp.AppDir)
+		t.Setenv("GORUNNER_TEST_SANDBOX", "see")
+
+		expect.Nil(goapp.SetConfig(AppConfig{Isolate: isolate, Sandbox: &Sandbox{Seccomp: SECCOMP_DEFAULT, Landlock: true}}))
+		expect.Nil(goapp.UnpackArtifact(bytes.NewReader(binary), sha256Of(binary), ""))
+		err = goapp.Start()
+		if err != nil && isolate && strings.Contains(err.Error(), "failed to create the namespaces") {
+			t.Skip(err)
+		}
+
+		expect.Nil(err)
+
+		var content []byte
+		for i := 0; i < 500 && len(content) == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+			content, _ = ioutil.ReadFile(path.Join(goapp.AppDir, DATA_DIRNAME, "seen"))
+		}
+
+		expect.Equal("appDirHidden=true releaseReadOnly=true tmpWritable=true etcReadable=true", string(content))
+		expect.Equal(STATE_RUNNING, goapp.State())
+
+		goapp.Lock()
+		id := goapp.processIdentity()
+		goapp.Unlock()
+		expect.True(id != nil && id.Seccomp)
+		expect.Nil(goapp.Stop())
+	}
+}
+
+func TestSeccompViolationIsTold(t *testing.T) {
+	if syscallNumbers == nil {
+		t.Skip("no seccomp")
+	}
+
+	for _, isolate := range []bool{false, true} {
+		expect := util.NewExpect(t)
+		bus := NewEventBus()
+		goapp := &GoApp{Name: "prebuilt", AppDir: path.Join(t.TempDir(), "prebuilt"), log: &log.Logger, bus: bus}
+		binary := anElf(t)
+		t.Setenv("GORUNNER_TEST_SANDBOX", "violate")
+
+		expect.Nil(goapp.SetConfig(AppConfig{Isolate: isolate, Sandbox: &Sandbox{Seccomp: SECCOMP_DEFAULT}}))
+		expect.Nil(goapp.UnpackArtifact(bytes.NewReader(binary), sha256Of(binary), ""))
+		err := goapp.Start()
+		if err != nil && isolate && strings.Contains(err.Error(), "failed to create the namespaces") {
+			t.Skip(err)
+		}
+
+		expect.Nil(err)
+		for i := 0; i < 500 && goapp.State() != STATE_CRASHED; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		expect.Equal(STATE_CRASHED, goapp.State())
+		events, _ := bus.Since(0)
+		var violations []string
+		for _, e := range events {
+			if e.Type == EVENT_SANDBOX_VIOLATION {
+				violations = append(violations, e.Message)
+			}
+		}
+
+		expect.Equal(1, len(violations))
+		if fd, err := syscall.Open(KMSG, syscall.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+			// the audit records of the kernel tell the syscall
+			_ = syscall.Close(fd)
+			expect.Equal("made syscall ptrace outside its seccomp profile", violations[0])
+		}
+
+		appEvents := goapp.Events()
+		expect.True(strings.HasSuffix(appEvents[len(appEvents)-1].Reason, "outside its seccomp profile, exited: signal: bad system call"),
+			appEvents[len(appEvents)-1].Reason)
+		expect.Nil(goapp.Stop())
+	}
+}
+
+func TestCloneIsOnlyAllowedWithoutNamespaces(t *testing.T) {
+	if syscallNumbers == nil {
+		t.Skip("no seccomp")
+	}
+
+	t.Setenv("GORUNNER_TEST_SANDBOX", "clone")
+	for _, syscalls := range [][]string{nil, {"unshare"}} {
+		expect := util.NewExpect(t)
+		bus := NewEventBus()
+		goapp := &GoApp{Name: "prebuilt", AppDir: path.Join(t.TempDir(), "prebuilt"), log: &log.Logger, bus: bus}
+		binary := anElf(t)
+
+		expect.Nil(goapp.SetConfig(AppConfig{Sandbox: &Sandbox{Seccomp: SECCOMP_DEFAULT, Syscalls: syscalls}}))
+		expect.Nil(goapp.UnpackArtifact(bytes.NewReader(binary), sha256Of(binary), ""))
+		expect.Nil(goapp.Start())
+
+		var content []byte
+		for i := 0; i < 500 && !strings.Contains(string(content), "clone=") && goapp.State() != STATE_CRASHED; i++ {
+			time.Sleep(10 * time.Millisecond)
+			content, _ = ioutil.ReadFile(path.Join(goapp.AppDir, DATA_DIRNAME, "seen"))
+		}
+
+		if syscalls == nil {
+			// clone3 fails for the caller to fall back to clone, which kills the app with namespace flags
+			for i := 0; i < 500 && goapp.State() != STATE_CRASHED; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			expect.Equal(STATE_CRASHED, goapp.State())
+			content, _ = ioutil.ReadFile(path.Join(goapp.AppDir, DATA_DIRNAME, "seen"))
+			expect.Equal("clone3=function not implemented", string(content))
+			events, _ := bus.Since(0)
+			var violations []string
+			for _, e := range events {
+				if e.Type == EVENT_SANDBOX_VIOLATION {
+					violations = append(violations, e.Message)
+				}
+			}
+
+			expect.Equal(1, len(violations))
+			if fd, err := syscall.Open(KMSG, syscall.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+				_ = syscall.Close(fd)
+				expect.Equal("made syscall clone outside its seccomp profile", violations[0])
+			}
+		} else {
+			expect.Equal("clone3=invalid argument clone=invalid argument", string(content))
+			expect.Equal(STATE_RUNNING, goapp.State())
+		}
+
+		expect.Nil(goapp.Stop())
+	}
+}
+
+func TestAppThatCannotBeExecutedFailsStart(t *testing.T) {
+	if syscallNumbers == nil {
+		t.Skip("no seccomp")
+	}
+
+	for _, isolate := range []bool{false, true} {
+		expect := util.NewExpect(t)
+		goapp := &GoApp{Name: "prebuilt", AppDir: path.Join(t.TempDir(), "prebuilt"), log: &log.Logger}
+		binary := anElf(t)
+
+		// the launcher fails to execute the app once it has filtered itself
+		expect.Nil(goapp.SetConfig(AppConfig{Isolate: isolate, Sandbox: &Sandbox{Seccomp: SECCOMP_CUSTOM, Syscalls: []string{"read"}}}))
+		expect.Nil(goapp.UnpackArtifact(bytes.NewReader(binary), sha256Of(binary), ""))
+		expect.Nil(os.Chmod(path.Join(goapp.releaseDir(goapp.current), goapp.current.Binary), 0644))
+		err := goapp.Start()
+		expect.True(err != nil)
+		if isolate && strings.Contains(err.Error(), "failed to create the namespaces") {
+			t.Skip(err)
+		}
+
+		expect.Equal("failed to launch the app: permission denied", err.Error())
+		expect.Equal(STATE_FAILED, goapp.State())
+		expect.True(goapp.proc == nil)
+	}
+}
@@ -0,0 +1,318 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-cmd/cmd"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobFinished = errors.New("job already finished")
+	ErrJobCanceled = errors.New("job canceled")
+	ErrJobTimeout  = errors.New("job timed out")
+)
+
+const (
+	DEFAULT_MAX_BUILDS    = 2
+	DEFAULT_BUILD_TIMEOUT = 10 * time.Minute
+	// JOBS_TO_KEEP is how many finished jobs are kept to look up.
+	JOBS_TO_KEEP = 50
+)
+
+const (
+	JOB_BUILD = "build"
+	JOB_GATES = "gates"
+)
+
+const (
+	JOB_QUEUED    = "queued"
+	JOB_RUNNING   = "running"
+	JOB_DONE      = "done"
+	JOB_FAILED    = "failed"
+	JOB_CANCELED  = "canceled"
+	JOB_TIMED_OUT = "timedout"
+)
+
+// Job is a build of an app, run by the Scheduler.
+type Job struct {
+	ID    int    `json:"id"`
+	App   string `json:"app"`
+	Kind  string `json:"kind"`
+	State string `json:"state"`
+	// Position in the queue, starting from 1. 0 if the job is not queued.
+	Position   int        `json:"position,omitempty"`
+	Waiting    string     `json:"waiting,omitempty"`
+	Running    string     `json:"running,omitempty"`
+	QueuedAt   time.Time  `json:"queuedAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+type job struct {
+	Job
+	timeout time.Duration
+	// ready is closed when the job gets a slot, and ctx is set
+	ready  chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	// canceled is set if the job is canceled by Cancel, rather than timed out
+	canceled bool
+}
+
+// Scheduler runs builds, no more than max at a time. The rest wait in a queue, first come first served.
+type Scheduler struct {
+	sync.Mutex
+	max      int
+	timeout  time.Duration
+	seq      int
+	queue    []*job
+	running  []*job
+	finished []*job
+}
+
+// NewScheduler returns a Scheduler running max builds at a time, each for no longer than timeout.
+// 0 takes the defaults.
+func NewScheduler(max int, timeout time.Duration) *Scheduler {
+	if max <= 0 {
+		max = DEFAULT_MAX_BUILDS
+	}
+
+	if timeout <= 0 {
+		timeout = DEFAULT_BUILD_TIMEOUT
+	}
+
+	return &Scheduler{max: max, timeout: timeout}
+}
+
+// Run queues a job of kind for app, and runs it with run when it gets a slot. It waits for the job to finish.
+// The ctx given to run is done when the job is canceled or times out. timeout 0 takes the one of the scheduler.
+func (s *Scheduler) Run(app, kind string, timeout time.Duration, run func(ctx context.Context) error) error {
+	if s == nil {
+		return run(context.Background())
+	}
+
+	if timeout <= 0 {
+		timeout = s.timeout
+	}
+
+	j := s.enqueue(app, kind, timeout)
+
+	<-j.ready
+
+	s.Lock()
+	ctx, cancel := j.ctx, j.cancel
+	s.Unlock()
+
+	if ctx == nil {
+		return fmt.Errorf("%w: %s of %s while queued", ErrJobCanceled, kind, app)
+	}
+
+	err := run(ctx)
+	cancel()
+
+	s.Lock()
+	defer s.Unlock()
+
+	switch {
+	case j.canceled:
+		err = fmt.Errorf("%w: %s of %s", ErrJobCanceled, kind, app)
+		j.State = JOB_CANCELED
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = fmt.Errorf("%w: %s of %s after %s", ErrJobTimeout, kind, app, timeout)
+		j.State = JOB_TIMED_OUT
+	case err != nil:
+		j.State = JOB_FAILED
+	default:
+		j.State = JOB_DONE
+	}
+
+	if err != nil {
+		j.Error = err.Error()
+	}
+
+	s.finish(j)
+
+	return err
+}
+
+func (s *Scheduler) enqueue(app, kind string, timeout time.Duration) *job {
+	s.Lock()
+	defer s.Unlock()
+
+	s.seq++
+	j := &job{
+		Job: Job{
+			ID:       s.seq,
+			App:      app,
+			Kind:     kind,
+			State:    JOB_QUEUED,
+			QueuedAt: time.Now(),
+		},
+		timeout: timeout,
+		ready:   make(chan struct{}),
+	}
+
+	s.queue = append(s.queue, j)
+	s.dispatch()
+
+	return j
+}
+
+// dispatch starts the jobs at the head of the queue while there are free slots. s must be locked.
+func (s *Scheduler) dispatch() {
+	for len(s.running) < s.max && len(s.queue) > 0 {
+		j := s.queue[0]
+		s.queue = s.queue[1:]
+
+		now := time.Now()
+		j.State = JOB_RUNNING
+		j.StartedAt = &now
+		j.ctx, j.cancel = context.WithTimeout(context.Background(), j.timeout)
+		s.running = append(s.running, j)
+		close(j.ready)
+	}
+}
+
+// finish frees the slot of j, and keeps it with the finished jobs. s must be locked.
+func (s *Scheduler) finish(j *job) {
+	now := time.Now()
+	j.FinishedAt = &now
+	s.running = without(s.running, j)
+
+	s.finished = append(s.finished, j)
+	if len(s.finished) > JOBS_TO_KEEP {
+		s.finished = s.finished[len(s.finished)-JOBS_TO_KEEP:]
+	}
+
+	s.dispatch()
+}
+
+// Cancel cancels a queued job, or stops a running one.
+func (s *Scheduler) Cancel(id int) (Job, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, j := range s.queue {
+		if j.ID == id {
+			j.canceled = true
+			j.State = JOB_CANCELED
+			j.Error = ErrJobCanceled.Error()
+			s.queue = without(s.queue, j)
+			s.finish(j)
+			close(j.ready)
+
+			return j.info(0), nil
+		}
+	}
+
+	for _, j := range s.running {
+		if j.ID == id {
+			j.canceled = true
+			j.cancel()
+
+			return j.info(0), nil
+		}
+	}
+
+	for _, j := range s.finished {
+		if j.ID == id {
+			return j.info(0), ErrJobFinished
+		}
+	}
+
+	return Job{}, ErrJobNotFound
+}
+
+// Jobs returns the running jobs, then the queued jobs in the order they will run.
+func (s *Scheduler) Jobs() []Job {
+	s.Lock()
+	defer s.Unlock()
+
+	jobs := make([]Job, 0, len(s.running)+len(s.queue))
+	for _, j := range s.running {
+		jobs = append(jobs, j.info(0))
+	}
+
+	for i, j := range s.queue {
+		jobs = append(jobs, j.info(i+1))
+	}
+
+	return jobs
+}
+
+// Job returns a queued, running or recently finished job.
+func (s *Scheduler) Job(id int) (Job, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, j := range s.queue {
+		if j.ID == id {
+			return j.info(i + 1), nil
+		}
+	}
+
+	for _, list := range [][]*job{s.running, s.finished} {
+		for _, j := range list {
+			if j.ID == id {
+				return j.info(0), nil
+			}
+		}
+	}
+
+	return Job{}, ErrJobNotFound
+}
+
+func (j *job) info(position int) Job {
+	info := j.Job
+	info.Position = position
+
+	waitedUntil := time.Now()
+	if j.StartedAt != nil {
+		waitedUntil = *j.StartedAt
+	} else if j.FinishedAt != nil {
+		waitedUntil = *j.FinishedAt
+	}
+
+	info.Waiting = waitedUntil.Sub(j.QueuedAt).Round(time.Millisecond).String()
+
+	if j.StartedAt != nil {
+		until := time.Now()
+		if j.FinishedAt != nil {
+			until = *j.FinishedAt
+		}
+
+		info.Running = until.Sub(*j.StartedAt).Round(time.Millisecond).String()
+	}
+
+	return info
+}
+
+func without(jobs []*job, j *job) []*job {
+	list := make([]*job, 0, len(jobs))
+	for _, other := range jobs {
+		if other != j {
+			list = append(list, other)
+		}
+	}
+
+	return list
+}
+
+// runCmd runs c until it exits, or stops it when ctx is done.
+func runCmd(ctx context.Context, c *cmd.Cmd) cmd.Status {
+	select {
+	case status := <-c.Start():
+		return status
+	case <-ctx.Done():
+		_ = c.Stop()
+		<-c.Done()
+
+		return c.Status()
+	}
+}
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+// startJob runs a job in the background that holds its slot until release is closed.
+func startJob(s *Scheduler, app string, release chan struct{}) chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- s.Run(app, JOB_BUILD, 0, func(ctx context.Context) error {
+			select {
+			case <-release:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	return result
+}
+
+func waitForJobs(t *testing.T, s *Scheduler, n int) []Job {
+	for i := 0; i < 100; i++ {
+		if jobs := s.Jobs(); len(jobs) == n {
+			return jobs
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("expected %d jobs, got %v", n, s.Jobs())
+
+	return nil
+}
+
+func TestSchedulerQueuesBeyondMax(t *testing.T) {
+	expect := util.NewExpect(t)
+	s := NewScheduler(1, time.Minute)
+
+	first := make(chan struct{})
+	firstDone := startJob(s, "a", first)
+	waitForJobs(t, s, 1)
+
+	second := make(chan struct{})
+	secondDone := startJob(s, "b", second)
+	jobs := waitForJobs(t, s, 2)
+	expect.Equal(JOB_RUNNING, jobs[0].State)
+	expect.Equal("a", jobs[0].App)
+	expect.Equal(JOB_QUEUED, jobs[1].State)
+	expect.Equal(1, jobs[1].Position)
+	expect.True(jobs[1].Waiting != "")
+
+	close(first)
+	expect.Nil(<-firstDone)
+
+	jobs = waitForJobs(t, s, 1)
+	expect.Equal("b", jobs[0].App)
+	expect.Equal(JOB_RUNNING, jobs[0].State)
+
+	close(second)
+	expect.Nil(<-secondDone)
+
+	job, err := s.Job(jobs[0].ID)
+	expect.Nil(err)
+	expect.Equal(JOB_DONE, job.State)
+	expect.True(job.FinishedAt != nil)
+
+	_, err = s.Cancel(job.ID)
+	expect.True(errors.Is(err, ErrJobFinished))
+	_, err = s.Job(100)
+	expect.True(errors.Is(err, ErrJobNotFound))
+}
+
+func TestSchedulerCancelsAndTimesOut(t *testing.T) {
+	expect := util.NewExpect(t)
+	s := NewScheduler(1, time.Minute)
+
+	running := startJob(s, "a", nil)
+	waitForJobs(t, s, 1)
+	queued := startJob(s, "b", nil)
+	jobs := waitForJobs(t, s, 2)
+
+	job, err := s.Cancel(jobs[1].ID)
+	expect.Nil(err)
+	expect.Equal(JOB_CANCELED, job.State)
+	expect.True(errors.Is(<-queued, ErrJobCanceled))
+
+	_, err = s.Cancel(jobs[0].ID)
+	expect.Nil(err)
+	expect.True(errors.Is(<-running, ErrJobCanceled))
+
+	job, err = s.Job(jobs[0].ID)
+	expect.Nil(err)
+	expect.Equal(JOB_CANCELED, job.State)
+	expect.Equal(0, len(s.Jobs()))
+
+	err = s.Run("c", JOB_BUILD, 50*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	expect.True(errors.Is(err, ErrJobTimeout))
+
+	var none *Scheduler
+	expect.Nil(none.Run("d", JOB_BUILD, 0, func(context.Context) error { return nil }))
+}
+
+// TestAppIsNotLockedWhileItsBuildIsQueued reads the status of an app, which takes its lock, while its build waits.
+func TestAppIsNotLockedWhileItsBuildIsQueued(t *testing.T) {
+	expect := util.NewExpect(t)
+	s := NewScheduler(1, time.Minute)
+
+	other := make(chan struct{})
+	otherDone := startJob(s, "other", other)
+	waitForJobs(t, s, 1)
+
+	goapp := &GoApp{Name: "hello", AppDir: t.TempDir(), sched: s, log: &log.Logger}
+	goapp.register("registered for test")
+	goapp.current = &Release{ID: 1, Source: SOURCE_GIT}
+
+	built := make(chan error, 1)
+	go func() {
+		built <- goapp.Build()
+	}()
+	jobs := waitForJobs(t, s, 2)
+
+	status := make(chan error, 1)
+	go func() {
+		_, err := json.Marshal(goapp)
+		status <- err
+	}()
+
+	select {
+	case err := <-status:
+		expect.Nil(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the status of the app waited for its build")
+	}
+
+	expect.Equal(STATE_BUILDING, goapp.State())
+	_, err := s.Cancel(jobs[1].ID)
+	expect.Nil(err)
+	expect.True(<-built != nil)
+	expect.Equal(STATE_FAILED, goapp.State())
+
+	close(other)
+	expect.Nil(<-otherDone)
+}
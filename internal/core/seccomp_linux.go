@@ -0,0 +1,133 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"syscall"
+	"unsafe"
+)
+
+// the seccomp filters, see seccomp(2)
+const (
+	PR_SET_SECCOMP           = 22
+	SECCOMP_MODE_FILTER      = 2
+	SECCOMP_RET_KILL_PROCESS = 0x80000000
+	SECCOMP_RET_ERRNO        = 0x00050000
+	SECCOMP_RET_ALLOW        = 0x7fff0000
+	// X32_SYSCALL_BIT marks the syscalls of the x32 ABI, which bypass a filter of the x86_64 numbers
+	X32_SYSCALL_BIT = 0x40000000
+	// the offsets of the number, the arch and the lower half of the first argument of the syscall in struct
+	// seccomp_data
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArg0 = 16
+)
+
+// CLONE_NAMESPACES are the flags of clone that create namespaces, which a profile only allows with unshare.
+// CLONE_NEWTIME is left out, as clone takes the exit signal in its bits.
+const CLONE_NAMESPACES = syscall.CLONE_NEWNS | syscall.CLONE_NEWCGROUP | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC |
+	syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET
+
+// seccompProgram is a filter that kills the process on the syscalls it doesn't allow.
+type seccompProgram []syscall.SockFilter
+
+// compileSeccomp compiles the filter that only allows the syscalls, or returns nil if there are none. The syscalls
+// the platform doesn't have are left out.
+// Unless unshare is allowed too, clone is only allowed without CLONE_NAMESPACES, and clone3, whose flags are out of
+// the reach of the filter, fails with ENOSYS, for the callers to fall back to clone.
+func compileSeccomp(syscalls []string) (seccompProgram, error) {
+	if len(syscalls) == 0 {
+		return nil, nil
+	}
+
+	if syscallNumbers == nil {
+		return nil, fmt.Errorf("seccomp is not supported on %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+
+	allowed := make(map[int]bool)
+	for _, name := range syscalls {
+		if nr, ok := syscallNumbers[name]; ok {
+			allowed[nr] = true
+		}
+	}
+
+	cloneNr, hasClone := syscallNumbers["clone"]
+	clone3Nr, hasClone3 := syscallNumbers["clone3"]
+	unshareNr, hasUnshare := syscallNumbers["unshare"]
+	namespaces := hasUnshare && allowed[unshareNr]
+	filterClone := hasClone && allowed[cloneNr] && !namespaces
+	denyClone3 := hasClone3 && allowed[clone3Nr] && !namespaces
+
+	numbers := make([]int, 0, len(allowed))
+	for nr := range allowed {
+		if (nr != cloneNr || !filterClone) && (nr != clone3Nr || !denyClone3) {
+			numbers = append(numbers, nr)
+		}
+	}
+	sort.Ints(numbers)
+
+	stmt := func(code uint16, k uint32) syscall.SockFilter {
+		return syscall.SockFilter{Code: code, K: k}
+	}
+
+	// jeq jumps to the next instruction if A is k, or else skips it
+	jeq := func(k uint32) syscall.SockFilter {
+		return syscall.SockFilter{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jt: 0, Jf: 1, K: k}
+	}
+
+	kill := stmt(syscall.BPF_RET|syscall.BPF_K, SECCOMP_RET_KILL_PROCESS)
+	allow := stmt(syscall.BPF_RET|syscall.BPF_K, SECCOMP_RET_ALLOW)
+
+	prog := seccompProgram{
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataArch),
+		{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jt: 1, Jf: 0, K: AUDIT_ARCH},
+		kill,
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataNr),
+		{Code: syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K, Jt: 0, Jf: 1, K: X32_SYSCALL_BIT},
+		kill,
+	}
+
+	if denyClone3 {
+		prog = append(prog, jeq(uint32(clone3Nr)), stmt(syscall.BPF_RET|syscall.BPF_K, SECCOMP_RET_ERRNO|uint32(syscall.ENOSYS)))
+	}
+
+	if filterClone {
+		// the flags are in the lower half of the first argument, which the rest of the filter doesn't look at
+		prog = append(prog,
+			syscall.SockFilter{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jt: 0, Jf: 5, K: uint32(cloneNr)},
+			stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataArg0),
+			stmt(syscall.BPF_ALU|syscall.BPF_AND|syscall.BPF_K, CLONE_NAMESPACES),
+			jeq(0),
+			allow,
+			kill,
+		)
+	}
+
+	for _, nr := range numbers {
+		prog = append(prog, jeq(uint32(nr)), allow)
+	}
+
+	return append(prog, kill), nil
+}
+
+// install filters the syscalls of the calling thread and of what it executes, which has to be locked to its
+// goroutine and to have no_new_privs.
+func (p seccompProgram) install() error {
+	if len(p) == 0 {
+		return nil
+	}
+
+	fprog := syscall.SockFprog{Len: uint16(len(p)), Filter: &p[0]}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, PR_SET_SECCOMP, SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&fprog)))
+	if errno != 0 {
+		if errno == syscall.EACCES {
+			return errors.New("failed to filter the syscalls: no_new_privs is not set")
+		}
+
+		return fmt.Errorf("failed to filter the syscalls: %w", errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux || (!amd64 && !arm64)
+// +build !linux !amd64,!arm64
+
+package core
+
+// AUDIT_ARCH is unknown, so seccomp is not supported.
+const AUDIT_ARCH = 0
+
+// syscallNumbers are unknown, so the syscalls of a seccomp profile are not checked until the app is launched.
+var syscallNumbers map[string]int
@@ -0,0 +1,277 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_SAMPLE_INTERVAL = 10 * time.Second
+	// RECENT_USAGE is how long samples are kept as they are taken. Older ones are merged into one per USAGE_STEP.
+	RECENT_USAGE = time.Hour
+	USAGE_STEP   = time.Minute
+	// USAGE_HISTORY is how long the usage of an app is kept.
+	USAGE_HISTORY = 24 * time.Hour
+)
+
+// Usage is the resource usage of the process of an app and its children, sampled from /proc.
+// A downsampled Usage merges Samples samples: the rates are averaged, RSS, FDs and Threads are the highest,
+// and the totals are the last ones.
+type Usage struct {
+	At  time.Time `json:"at"`
+	PID int       `json:"pid"`
+	// CPU is the cpu time taken per second since the previous sample, e.g. 1.5 for one and a half cores
+	CPU        float64 `json:"cpu"`
+	CPUSeconds float64 `json:"cpuSeconds"`
+	RSS        int64   `json:"rss"`
+	FDs        int     `json:"fds"`
+	Threads    int     `json:"threads"`
+	// ReadBytes and WriteBytes are the totals of storage I/O, ReadRate and WriteRate per second since the previous sample
+	ReadBytes  uint64  `json:"readBytes"`
+	WriteBytes uint64  `json:"writeBytes"`
+	ReadRate   float64 `json:"readRate"`
+	WriteRate  float64 `json:"writeRate"`
+	Samples    int     `json:"samples,omitempty"`
+	// procs are the counters of each process summed up, for the rates of the next sample
+	procs map[int]procCounters
+}
+
+type procCounters struct {
+	cpu           float64
+	read, written uint64
+}
+
+// sampleUsage reads the usage of the process pid and its descendants. The rates are worked out from prev, if it is
+// of the same process.
+func sampleUsage(pid int, prev *Usage) (Usage, error) {
+	stat, err := readProcTreeStat(pid)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	u := Usage{
+		At:         time.Now(),
+		PID:        pid,
+		CPUSeconds: stat.CPUSeconds,
+		RSS:        stat.RSS,
+		Threads:    stat.Threads,
+		procs:      make(map[int]procCounters, len(stat.tree)),
+	}
+
+	for _, p := range stat.tree {
+		// these may not be readable, e.g. for a process of another user
+		fds, _ := readProcFDs(p)
+		read, written, _ := readProcIO(p)
+		u.FDs += fds
+		u.ReadBytes += read
+		u.WriteBytes += written
+		u.procs[p] = procCounters{cpu: stat.cpu[p], read: read, written: written}
+	}
+
+	if prev != nil && prev.PID == pid {
+		u.rates(prev)
+	}
+
+	return u, nil
+}
+
+// rates works out the rates of u since prev. A total that went down since, e.g. as a child exited, counts as no
+// usage rather than a negative one, or one wrapped around.
+// With the counters of each process, the rates are summed up from the processes instead, so that a child that exited
+// takes nothing away from the others. The ones started since count from 0.
+func (u *Usage) rates(prev *Usage) {
+	elapsed := u.At.Sub(prev.At).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	cpu := u.CPUSeconds - prev.CPUSeconds
+	read, written := counterDelta(u.ReadBytes, prev.ReadBytes), counterDelta(u.WriteBytes, prev.WriteBytes)
+	if u.procs != nil && prev.procs != nil {
+		cpu, read, written = 0, 0, 0
+		for pid, c := range u.procs {
+			p := prev.procs[pid]
+			if c.cpu > p.cpu {
+				cpu += c.cpu - p.cpu
+			}
+
+			read += counterDelta(c.read, p.read)
+			written += counterDelta(c.written, p.written)
+		}
+	}
+
+	if cpu > 0 {
+		u.CPU = cpu / elapsed
+	}
+
+	u.ReadRate = float64(read) / elapsed
+	u.WriteRate = float64(written) / elapsed
+}
+
+// counterDelta is how much the counter went up from prev to cur, or 0 if it went down.
+func counterDelta(cur, prev uint64) uint64 {
+	if cur < prev {
+		return 0
+	}
+
+	return cur - prev
+}
+
+// merge adds the sample u into the downsampled d.
+func (d *Usage) merge(u Usage) {
+	n := float64(d.Samples)
+	d.CPU = (d.CPU*n + u.CPU) / (n + 1)
+	d.ReadRate = (d.ReadRate*n + u.ReadRate) / (n + 1)
+	d.WriteRate = (d.WriteRate*n + u.WriteRate) / (n + 1)
+	d.Samples++
+
+	if u.RSS > d.RSS {
+		d.RSS = u.RSS
+	}
+
+	if u.FDs > d.FDs {
+		d.FDs = u.FDs
+	}
+
+	if u.Threads > d.Threads {
+		d.Threads = u.Threads
+	}
+
+	d.PID, d.CPUSeconds, d.ReadBytes, d.WriteBytes = u.PID, u.CPUSeconds, u.ReadBytes, u.WriteBytes
+}
+
+// usageHistory keeps the samples of the last RECENT_USAGE as they are, and older ones downsampled per USAGE_STEP.
+type usageHistory struct {
+	sync.Mutex
+	recent      []Usage
+	downsampled []Usage
+}
+
+func (h *usageHistory) add(u Usage) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.recent = append(h.recent, u)
+
+	aged := 0
+	for aged < len(h.recent) && u.At.Sub(h.recent[aged].At) > RECENT_USAGE {
+		old := h.recent[aged]
+		step := old.At.Truncate(USAGE_STEP)
+		last := len(h.downsampled) - 1
+		if last < 0 || !h.downsampled[last].At.Equal(step) {
+			h.downsampled = append(h.downsampled, Usage{At: step})
+			last++
+		}
+
+		h.downsampled[last].merge(old)
+		aged++
+	}
+	h.recent = h.recent[aged:]
+
+	expired := 0
+	for expired < len(h.downsampled) && u.At.Sub(h.downsampled[expired].At) > USAGE_HISTORY {
+		expired++
+	}
+	h.downsampled = h.downsampled[expired:]
+}
+
+// since returns the usage from t on, the oldest first.
+func (h *usageHistory) since(t time.Time) []Usage {
+	h.Lock()
+	defer h.Unlock()
+
+	usage := make([]Usage, 0)
+	for _, samples := range [][]Usage{h.downsampled, h.recent} {
+		for _, u := range samples {
+			if !u.At.Before(t) {
+				usage = append(usage, u)
+			}
+		}
+	}
+
+	return usage
+}
+
+func (h *usageHistory) last() *Usage {
+	h.Lock()
+	defer h.Unlock()
+
+	if len(h.recent) == 0 {
+		return nil
+	}
+
+	u := h.recent[len(h.recent)-1]
+
+	return &u
+}
+
+// startSampling samples the usage of the process pid until stopSampling. The app must be locked.
+func (a *GoApp) startSampling(pid int) {
+	a.stopSampling()
+
+	interval := a.opts.SampleInterval
+	if interval <= 0 {
+		interval = DEFAULT_SAMPLE_INTERVAL
+	}
+
+	a.sampling = make(chan struct{})
+	go a.sample(pid, interval, a.sampling)
+}
+
+// stopSampling stops sampling the usage of the app, if it is. The app must be locked.
+func (a *GoApp) stopSampling() {
+	if a.sampling != nil {
+		close(a.sampling)
+		a.sampling = nil
+	}
+}
+
+func (a *GoApp) sample(pid int, interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var prev *Usage
+	for {
+		u, err := sampleUsage(pid, prev)
+		if err != nil {
+			a.log.Debug().Err(err).Msgf("failed to sample usage. app=%s, pid=%d", a.Name, pid)
+		} else {
+			a.usage.add(u)
+			prev = &u
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Usage returns the last usage sampled of the running process of the app, or nil if it is not running.
+func (a *GoApp) Usage() *Usage {
+	a.Lock()
+	defer a.Unlock()
+
+	return a.lastUsage()
+}
+
+// lastUsage returns the last usage sampled of the running process. The app must be locked.
+func (a *GoApp) lastUsage() *Usage {
+	if a.proc == nil || a.currentState() != STATE_RUNNING {
+		return nil
+	}
+
+	u := a.usage.last()
+	if u == nil || u.PID != a.proc.Status().PID {
+		return nil
+	}
+
+	return u
+}
+
+// UsageSince returns the usage of the app from t on, the oldest first. It covers the last USAGE_HISTORY at most,
+// and is downsampled per USAGE_STEP before the last RECENT_USAGE.
+func (a *GoApp) UsageSince(t time.Time) []Usage {
+	return a.usage.since(t)
+}
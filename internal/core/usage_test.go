@@ -0,0 +1,144 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+)
+
+func TestUsageIsDownsampled(t *testing.T) {
+	expect := util.NewExpect(t)
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	history := usageHistory{}
+
+	// a sample every 30s for 25h
+	for i := 0; i <= 25*120; i++ {
+		history.add(Usage{
+			At:         start.Add(time.Duration(i) * 30 * time.Second),
+			CPU:        float64(i % 2),
+			CPUSeconds: float64(i),
+			RSS:        int64(i % 3),
+		})
+	}
+
+	end := start.Add(25 * time.Hour)
+	usage := history.since(time.Time{})
+	expect.Equal(end, usage[len(usage)-1].At)
+
+	recent := history.since(end.Add(-RECENT_USAGE))
+	expect.Equal(121, len(recent))
+	expect.Equal(0, recent[0].Samples)
+
+	older := usage[:len(usage)-len(recent)]
+	expect.Equal(start.Add(time.Hour), older[0].At)
+	expect.Equal(end.Add(-RECENT_USAGE-USAGE_STEP), older[len(older)-1].At)
+	// 24h in all, with the last hour as sampled
+	expect.Equal(23*60, len(older))
+	for _, u := range older {
+		expect.Equal(2, u.Samples)
+		expect.Equal(0.5, u.CPU)
+		expect.True(u.RSS >= 1)
+	}
+	expect.Equal(121.0, older[0].CPUSeconds)
+}
+
+func TestSampleUsage(t *testing.T) {
+	expect := util.NewExpect(t)
+	pid := os.Getpid()
+
+	first, err := sampleUsage(pid, nil)
+	expect.Nil(err)
+	expect.Equal(0.0, first.CPU)
+	expect.True(first.RSS > 0)
+	expect.True(first.FDs > 0)
+	expect.True(first.Threads > 0)
+
+	for busy := time.Now(); time.Since(busy) < 50*time.Millisecond; {
+	}
+
+	second, err := sampleUsage(pid, &first)
+	expect.Nil(err)
+	expect.True(second.CPU > 0, second)
+
+	_, err = sampleUsage(-1, nil)
+	expect.True(err != nil)
+}
+
+func TestUsageRatesNeverGoNegative(t *testing.T) {
+	expect := util.NewExpect(t)
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	prev := Usage{At: start, PID: 1, CPUSeconds: 10, ReadBytes: 4096, WriteBytes: 8192}
+
+	// a child that took cpu and did I/O exited
+	u := Usage{At: start.Add(2 * time.Second), PID: 1, CPUSeconds: 4, ReadBytes: 1024, WriteBytes: 8192 + 2048}
+	u.rates(&prev)
+	expect.Equal(0.0, u.CPU)
+	expect.Equal(0.0, u.ReadRate)
+	expect.Equal(1024.0, u.WriteRate)
+}
+
+func TestUsageOfAChildThatExitedIsNotTakenAway(t *testing.T) {
+	expect := util.NewExpect(t)
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	prev := Usage{At: start, PID: 1, CPUSeconds: 6, ReadBytes: 4096, procs: map[int]procCounters{
+		1: {cpu: 1, read: 1024},
+		2: {cpu: 5, read: 3072},
+	}}
+
+	// 2 exited, 3 started
+	u := Usage{At: start.Add(2 * time.Second), PID: 1, CPUSeconds: 3, ReadBytes: 3072, procs: map[int]procCounters{
+		1: {cpu: 2, read: 2048},
+		3: {cpu: 1, read: 1024},
+	}}
+	u.rates(&prev)
+	expect.Equal(1.0, u.CPU)
+	expect.Equal(1024.0, u.ReadRate)
+
+	// a busy child that is killed between the samples, then its parent busy for less
+	sh := exec.Command("sh", "-c", `sh -c 'while :; do :; done' & wait; i=0; while [ $i -lt 50000 ]; do i=$((i+1)); done; exec sleep 60`)
+	expect.Nil(sh.Start())
+	t.Cleanup(func() {
+		_ = sh.Process.Kill()
+		_ = sh.Wait()
+	})
+
+	var child int
+	var first Usage
+	for i := 0; i < 500; i++ {
+		time.Sleep(10 * time.Millisecond)
+		tree := procTree(sh.Process.Pid)
+		if len(tree) < 2 {
+			continue
+		}
+
+		child = tree[1]
+		stat, err := ReadProcStat(child)
+		if err == nil && stat.CPUSeconds >= 1 {
+			first, err = sampleUsage(sh.Process.Pid, nil)
+			expect.Nil(err)
+			break
+		}
+	}
+	expect.True(child > 0 && first.procs[child].cpu >= 1, first)
+	expect.Nil(syscall.Kill(child, syscall.SIGKILL))
+
+	for i := 0; i < 1000; i++ {
+		comm, _ := ioutil.ReadFile("/proc/" + strconv.Itoa(sh.Process.Pid) + "/comm")
+		if string(comm) == "sleep\n" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	second, err := sampleUsage(sh.Process.Pid, &first)
+	expect.Nil(err)
+	expect.True(second.CPUSeconds < first.CPUSeconds, second)
+	expect.True(second.CPU > 0, second)
+	expect.True(second.ReadRate < 1<<40 && second.WriteRate < 1<<40, second)
+}
@@ -0,0 +1,243 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// AppUser is who the process of an app runs as.
+type AppUser struct {
+	UID int `json:"uid"`
+	// GID defaults to UID
+	GID int `json:"gid,omitempty"`
+}
+
+func (u *AppUser) validate() error {
+	if u == nil {
+		return nil
+	}
+
+	if u.UID <= 0 {
+		return fmt.Errorf("%w: user.uid must be a uid other than root", ErrInvalidConfig)
+	}
+
+	if u.GID < 0 {
+		return fmt.Errorf("%w: user.gid must not be negative", ErrInvalidConfig)
+	}
+
+	if u.GID == 0 {
+		u.GID = u.UID
+	}
+
+	return nil
+}
+
+// UIDRange is the uids from First to Last.
+type UIDRange struct {
+	First int
+	Last  int
+}
+
+// ParseUIDRange parses a range of uids like 100000-100999. An empty one is no range.
+func ParseUIDRange(s string) (UIDRange, error) {
+	if s == "" {
+		return UIDRange{}, nil
+	}
+
+	bounds := strings.SplitN(s, "-", 2)
+	if len(bounds) == 2 {
+		first, err1 := strconv.Atoi(bounds[0])
+		last, err2 := strconv.Atoi(bounds[1])
+		if err1 == nil && err2 == nil && first > 0 && first <= last {
+			return UIDRange{first, last}, nil
+		}
+	}
+
+	return UIDRange{}, fmt.Errorf("invalid uid range %q, expected e.g. 100000-100999", s)
+}
+
+func (r UIDRange) empty() bool {
+	return r.First == 0 && r.Last == 0
+}
+
+// Users allocates a uid of a range to every app, whose gid is the same. A nil Users allocates none,
+// so the apps run as go-runner unless their config tells a user.
+type Users struct {
+	sync.Mutex
+	uids   UIDRange
+	owners map[int]string
+}
+
+func newUsers(uids UIDRange) *Users {
+	if uids.empty() {
+		return nil
+	}
+
+	return &Users{uids: uids, owners: make(map[int]string)}
+}
+
+// allocate returns the uid of app: prefer if it is in the range and not taken by another app, or else the one
+// the app has been allocated, or else the first free one.
+func (u *Users) allocate(app string, prefer int) (int, error) {
+	if u == nil {
+		return 0, nil
+	}
+
+	u.Lock()
+	defer u.Unlock()
+
+	if prefer >= u.uids.First && prefer <= u.uids.Last {
+		if owner, taken := u.owners[prefer]; !taken || owner == app {
+			u.owners[prefer] = app
+			return prefer, nil
+		}
+	}
+
+	for uid, owner := range u.owners {
+		if owner == app {
+			return uid, nil
+		}
+	}
+
+	for uid := u.uids.First; uid <= u.uids.Last; uid++ {
+		if _, taken := u.owners[uid]; !taken {
+			u.owners[uid] = app
+			return uid, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no uid left in %d-%d", u.uids.First, u.uids.Last)
+}
+
+// release frees the uid of app and returns it, or 0 if the app has none.
+func (u *Users) release(app string) int {
+	if u == nil {
+		return 0
+	}
+
+	u.Lock()
+	defer u.Unlock()
+
+	for uid, owner := range u.owners {
+		if owner == app {
+			delete(u.owners, uid)
+			return uid
+		}
+	}
+
+	return 0
+}
+
+// user returns who the app is to run as: the user in its config, or else the one allocated to it, which is saved
+// with the app. It is nil if the app runs as go-runner. The app must be locked.
+func (a *GoApp) user() (*AppUser, error) {
+	if a.config.User != nil {
+		return a.config.User, nil
+	}
+
+	uid, err := a.users.allocate(a.Name, a.uid)
+	if err != nil || uid == 0 {
+		return nil, err
+	}
+
+	if uid != a.uid {
+		a.uid = uid
+		err = a.save()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &AppUser{UID: uid, GID: uid}, nil
+}
+
+// grantDirs gives the user the data and run dirs of the app, and the release dir to its group, as it stays
+// go-runner's to build in. A user that is not isolated needs to pass through the dirs leading to them too: the dir
+// of the app and its releases dir by their group, which is the one of the user, and the dir of all apps by an entry
+// of its own in the ACL, so that the apps can't pass through the dirs of one another.
+func (a *GoApp) grantDirs(user *AppUser, releaseDir string, isolated bool) error {
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("running the app as uid %d needs go-runner to run as root", user.UID)
+	}
+
+	var st syscall.Stat_t
+	err := syscall.Stat(releaseDir, &st)
+	if err != nil {
+		return err
+	}
+
+	owners := map[string][2]int{
+		path.Join(a.AppDir, DATA_DIRNAME): {user.UID, user.GID},
+		path.Join(a.AppDir, RUN_DIRNAME):  {user.UID, user.GID},
+	}
+	if int(st.Gid) != user.GID {
+		owners[releaseDir] = [2]int{-1, user.GID}
+	}
+
+	for dir, owner := range owners {
+		err = filepath.Walk(dir, func(p string, _ os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			return os.Lchown(p, owner[0], owner[1])
+		})
+		if err != nil {
+			return fmt.Errorf("failed to give %s to uid %d: %w", dir, user.UID, err)
+		}
+	}
+
+	if isolated {
+		return nil
+	}
+
+	for _, dir := range []string{a.AppDir, path.Join(a.AppDir, RELEASES_DIRNAME)} {
+		err = os.Chown(dir, -1, user.GID)
+		if err != nil {
+			return err
+		}
+
+		// only passed through by the group, which may not list or change them
+		err = os.Chmod(dir, 0710)
+		if err != nil {
+			return err
+		}
+	}
+
+	appsDir := path.Dir(a.AppDir)
+	err = grantTraverse(appsDir, user.UID)
+	if err != nil {
+		return fmt.Errorf("failed to grant uid %d passing through %s by ACL, which the app needs unless it is isolated: %w",
+			user.UID, appsDir, err)
+	}
+
+	return nil
+}
+
+// switchUser makes the process run as user, without the supplementary groups of go-runner.
+func switchUser(user *AppUser) error {
+	if user == nil {
+		return nil
+	}
+
+	err := syscall.Setgroups([]int{})
+	if err == nil {
+		err = syscall.Setgid(user.GID)
+	}
+
+	if err == nil {
+		err = syscall.Setuid(user.UID)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to run as uid %d gid %d: %w", user.UID, user.GID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+func TestUsersAreAllocated(t *testing.T) {
+	expect := util.NewExpect(t)
+
+	uids, err := ParseUIDRange("1000-1002")
+	expect.Nil(err)
+	users := newUsers(uids)
+
+	uid, err := users.allocate("a", 0)
+	expect.Nil(err)
+	expect.Equal(1000, uid)
+
+	// an app keeps its uid
+	uid, err = users.allocate("a", 0)
+	expect.Nil(err)
+	expect.Equal(1000, uid)
+
+	// the uid of another app is not given away
+	uid, err = users.allocate("b", 1000)
+	expect.Nil(err)
+	expect.Equal(1001, uid)
+
+	uid, err = users.allocate("c", 1002)
+	expect.Nil(err)
+	expect.Equal(1002, uid)
+
+	_, err = users.allocate("d", 0)
+	expect.True(err != nil)
+
+	expect.Equal(1000, users.release("a"))
+	expect.Equal(0, users.release("a"))
+	uid, err = users.allocate("d", 0)
+	expect.Nil(err)
+	expect.Equal(1000, uid)
+
+	for _, invalid := range []string{"1000", "0-10", "10-1", "a-b"} {
+		_, err = ParseUIDRange(invalid)
+		expect.True(err != nil, invalid)
+	}
+
+	var none *Users
+	uid, err = none.allocate("a", 0)
+	expect.Nil(err)
+	expect.Equal(0, uid)
+}
+
+func TestRlimitsAreValidated(t *testing.T) {
+	expect := util.NewExpect(t)
+	nofile := uint64(0)
+
+	for _, invalid := range []AppConfig{
+		{Rlimits: &Rlimits{AS: "lots"}},
+		{Rlimits: &Rlimits{NoFile: &nofile}},
+		{User: &AppUser{UID: 0}},
+		{User: &AppUser{UID: 1000, GID: -1}},
+	} {
+		err := invalid.validate()
+		expect.True(errors.Is(err, ErrInvalidConfig), err)
+	}
+
+	config := AppConfig{User: &AppUser{UID: 1000}, Rlimits: &Rlimits{Core: "0", AS: "1G"}}
+	expect.Nil(config.validate())
+	expect.Equal(1000, config.User.GID)
+
+	values, err := config.Rlimits.values()
+	expect.Nil(err)
+	expect.Equal(map[string]uint64{"core": 0, "as": 1 << 30}, values)
+}
+
+func TestTraverseIsRevoked(t *testing.T) {
+	expect := util.NewExpect(t)
+	dir := t.TempDir()
+	expect.Nil(os.Chmod(dir, 0750))
+
+	err := grantTraverse(dir, 1000)
+	if err != nil {
+		t.Skip("no ACLs: ", err)
+	}
+
+	expect.Nil(grantTraverse(dir, 1001))
+	expect.Nil(revokeTraverse(dir, 1000))
+	entries, err := readACL(dir)
+	expect.Nil(err)
+	expect.Equal(5, len(entries))
+	expect.Equal(aclEntry{tag: ACL_USER, perm: ACL_EXECUTE, id: 1001}, entries[1])
+
+	// the last one takes the ACL with it
+	expect.Nil(revokeTraverse(dir, 1001))
+	expect.Nil(revokeTraverse(dir, 1001))
+	_, err = getXattr(dir, XATTR_POSIX_ACL_ACCESS)
+	expect.True(errors.Is(err, syscall.ENODATA), err)
+	fi, err := os.Stat(dir)
+	expect.Nil(err)
+	expect.Equal(os.FileMode(0750), fi.Mode().Perm())
+}
+
+func TestAppRunsAsItsUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("running apps as other users needs root")
+	}
+
+	for _, isolate := range []bool{false, true} {
+		expect := util.NewExpect(t)
+		dir := t.TempDir()
+		// as the working dir of go-runner would be
+		expect.Nil(os.Chmod(path.Dir(dir), 0755))
+		expect.Nil(os.Chmod(dir, 0755))
+
+		goapp := &GoApp{Name: "prebuilt", AppDir: path.Join(dir, "prebuilt"), log: &log.Logger}
+		binary := anElf(t)
+		nofile := uint64(256)
+
+		expect.Nil(goapp.SetConfig(AppConfig{
+			Isolate: isolate,
+			User:    &AppUser{UID: 65534},
+			Rlimits: &Rlimits{NoFile: &nofile, Core: "0"},
+		}))
+		expect.Nil(goapp.UnpackArtifact(bytes.NewReader(binary), sha256Of(binary), ""))
+		err := goapp.Start()
+		if err != nil && isolate {
+			t.Skip(err)
+		}
+
+		expect.Nil(err)
+		goapp.Lock()
+		id := goapp.processIdentity()
+		goapp.Unlock()
+
+		expect.True(id != nil)
+		expect.Equal(65534, id.UID)
+		expect.Equal(65534, id.GID)
+		expect.True(id.NoNewPrivs)
+		expect.Equal("256", id.Rlimits["nofile"])
+		expect.Equal("0", id.Rlimits["core"])
+		expect.Equal("unlimited", id.Rlimits["cpu"])
+
+		fi, err := os.Stat(path.Join(goapp.AppDir, DATA_DIRNAME))
+		expect.Nil(err)
+		expect.Equal(uint32(65534), fi.Sys().(*syscall.Stat_t).Uid)
+
+		// the release stays go-runner's, for git to tell it is not dubious
+		fi, err = os.Stat(goapp.releaseDir(goapp.current))
+		expect.Nil(err)
+		expect.Equal(uint32(0), fi.Sys().(*syscall.Stat_t).Uid)
+		expect.Equal(uint32(65534), fi.Sys().(*syscall.Stat_t).Gid)
+
+		if !isolate {
+			// passed through by the user only, not by the other apps
+			for _, dir := range []string{goapp.AppDir, path.Join(goapp.AppDir, RELEASES_DIRNAME)} {
+				fi, err = os.Stat(dir)
+				expect.Nil(err)
+				expect.Equal(os.FileMode(0710), fi.Mode().Perm())
+				expect.Equal(uint32(65534), fi.Sys().(*syscall.Stat_t).Gid)
+			}
+
+			entries, err := readACL(dir)
+			if err == nil {
+				expect.True(len(entries) == 5, entries)
+				expect.Equal(aclEntry{tag: ACL_USER, perm: ACL_EXECUTE, id: 65534}, entries[1])
+			}
+		}
+
+		expect.Nil(goapp.Stop())
+	}
+}
@@ -23,16 +23,21 @@ func (t *topic) Subscribe(subscription chan<- string) {
 	t.subscribers = append(t.subscribers, subscription)
 }
 
-func (t *topic) Publish(msg string) {
+// Publish sends msg to the subscribers that are ready for it, and returns how many of them missed it.
+func (t *topic) Publish(msg string) int {
 	t.m.Lock()
 	defer t.m.Unlock()
 
+	dropped := 0
 	for _, s := range t.subscribers {
 		select {
 		case s <- msg:
 		default:
+			dropped++
 		}
 	}
+
+	return dropped
 }
 
 func (t *topic) Unsubscribe(unsubscribe chan<- string) {
@@ -0,0 +1,154 @@
+// Package metrics keeps histograms and writes metrics in the Prometheus text format, without a client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	COUNTER   = "counter"
+	GAUGE     = "gauge"
+	HISTOGRAM = "histogram"
+)
+
+// LATENCY_BUCKETS are the upper bounds in seconds of the buckets of request latencies, the default ones of Prometheus.
+var LATENCY_BUCKETS = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// BUILD_BUCKETS are the upper bounds in seconds of the buckets of build durations.
+var BUILD_BUCKETS = []float64{1, 5, 10, 30, 60, 120, 300, 600}
+
+// Histogram counts observations in buckets. The zero value has no buckets, only the +Inf one.
+type Histogram struct {
+	sync.Mutex
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// HistogramSnapshot is a Histogram at a point in time. Counts are per bucket, not cumulative.
+type HistogramSnapshot struct {
+	Bounds []float64
+	Counts []uint64
+	Sum    float64
+	Count  uint64
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.Lock()
+	defer h.Unlock()
+
+	i := sort.SearchFloat64s(h.bounds, v)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.Lock()
+	defer h.Unlock()
+
+	return HistogramSnapshot{
+		Bounds: h.bounds,
+		Counts: append([]uint64{}, h.counts...),
+		Sum:    h.sum,
+		Count:  h.count,
+	}
+}
+
+// Labels are the labels of a sample, written in the order given.
+type Labels [][2]string
+
+// Writer writes metrics in the Prometheus text format. The first error sticks, and is returned by Err.
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Header writes the HELP and TYPE lines of a metric. It goes before its samples.
+func (w *Writer) Header(name, help, metricType string) {
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, escape(help, false), name, metricType)
+}
+
+func (w *Writer) Sample(name string, labels Labels, v float64) {
+	w.printf("%s%s %s\n", name, formatLabels(labels), formatValue(v))
+}
+
+// Histogram writes the buckets, sum and count of a histogram.
+func (w *Writer) Histogram(name string, labels Labels, h HistogramSnapshot) {
+	var cumulative uint64
+	for i, bound := range h.Bounds {
+		cumulative += h.Counts[i]
+		w.Sample(name+"_bucket", append(append(Labels{}, labels...), [2]string{"le", formatValue(bound)}), float64(cumulative))
+	}
+
+	w.Sample(name+"_bucket", append(append(Labels{}, labels...), [2]string{"le", "+Inf"}), float64(h.Count))
+	w.Sample(name+"_sum", labels, h.Sum)
+	w.Sample(name+"_count", labels, float64(h.Count))
+}
+
+func (w *Writer) Err() error {
+	return w.err
+}
+
+func (w *Writer) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+
+	_, w.err = fmt.Fprintf(w.w, format, args...)
+}
+
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l[0], escape(l[1], true)))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escape escapes backslashes and line feeds, and double quotes in label values.
+func escape(s string, quotes bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quotes {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+
+	return s
+}
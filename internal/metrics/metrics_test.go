@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	expect := util.NewExpect(t)
+	h := NewHistogram([]float64{1, 5})
+	for _, v := range []float64{0.5, 1, 3, 7} {
+		h.Observe(v)
+	}
+
+	s := h.Snapshot()
+	expect.Equal([]uint64{2, 1}, s.Counts)
+	expect.Equal(uint64(4), s.Count)
+	expect.Equal(11.5, s.Sum)
+}
+
+func TestWriterTextFormat(t *testing.T) {
+	expect := util.NewExpect(t)
+	h := NewHistogram([]float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(2)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Header("requests_total", "Requests,\nby code.", COUNTER)
+	w.Sample("requests_total", Labels{{"app", `he"l\lo`}, {"code", "200"}}, 3)
+	w.Sample("requests_total", nil, math.Inf(1))
+	w.Header("latency_seconds", "Latency.", HISTOGRAM)
+	w.Histogram("latency_seconds", Labels{{"app", "hello"}}, h.Snapshot())
+	expect.Nil(w.Err())
+
+	expect.Equal(`# HELP requests_total Requests,\nby code.
+# TYPE requests_total counter
+requests_total{app="he\"l\\lo",code="200"} 3
+requests_total +Inf
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{app="hello",le="0.1"} 1
+latency_seconds_bucket{app="hello",le="1"} 1
+latency_seconds_bucket{app="hello",le="+Inf"} 2
+latency_seconds_sum{app="hello"} 2.05
+latency_seconds_count{app="hello"} 2
+`, buf.String())
+}
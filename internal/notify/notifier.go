@@ -0,0 +1,446 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/core"
+	"github.com/rs/zerolog"
+)
+
+const NOTIFICATIONS_FILE = "notifications.json"
+
+// EXEC_SINKS_FILE keeps the exec sinks, in the working dir. As they run commands, they are only configured by who
+// runs go-runner, never through the API.
+const EXEC_SINKS_FILE = "exec-sinks.json"
+
+const (
+	// MAX_ATTEMPTS is how many times an event is sent to a sink before giving up.
+	MAX_ATTEMPTS = 5
+	// RETRY_BACKOFF is the wait before the first retry. It doubles with every retry after.
+	RETRY_BACKOFF = 2 * time.Second
+	// SEND_TIMEOUT is how long a webhook or slack sink may take to answer.
+	SEND_TIMEOUT = 10 * time.Second
+	// DELIVERIES_TO_KEEP is how many of the last deliveries are kept in the delivery log.
+	DELIVERIES_TO_KEEP = 200
+)
+
+const (
+	DELIVERY_PENDING   = "pending"
+	DELIVERY_DELIVERED = "delivered"
+	DELIVERY_FAILED    = "failed"
+)
+
+// EVENT_TEST is the type of the event sent by Test.
+const EVENT_TEST = "notification.test"
+
+var (
+	ErrSinkNotFound = errors.New("notification sink not found")
+	ErrSinkExists   = errors.New("notification sink with the same name already exists")
+)
+
+// Delivery is an event sent to a sink, and how it went.
+type Delivery struct {
+	ID         int           `json:"id"`
+	Sink       string        `json:"sink"`
+	Event      core.AppEvent `json:"event"`
+	State      string        `json:"state"`
+	Attempts   int           `json:"attempts"`
+	LastError  string        `json:"lastError,omitempty"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	FinishedAt *time.Time    `json:"finishedAt,omitempty"`
+}
+
+// Notifier sends the events published on the EventBus of go-runner to the sinks they match, and keeps a log of it.
+// The sinks are kept in NOTIFICATIONS_FILE in the working dir, only readable by go-runner, as urls may carry tokens.
+// The exec sinks are read from EXEC_SINKS_FILE, which go-runner doesn't write.
+type Notifier struct {
+	sync.Mutex
+	file       string
+	execFile   string
+	bus        *core.EventBus
+	sinks      []Sink
+	execSinks  []Sink
+	loaded     bool
+	seq        int
+	deliveries []*Delivery
+	attempts   int
+	backoff    time.Duration
+	client     *http.Client
+	stop       chan struct{}
+	log        *zerolog.Logger
+}
+
+func NewNotifier(wd string, bus *core.EventBus, log *zerolog.Logger) *Notifier {
+	return &Notifier{
+		file:     path.Join(wd, NOTIFICATIONS_FILE),
+		execFile: path.Join(wd, EXEC_SINKS_FILE),
+		bus:      bus,
+		attempts: MAX_ATTEMPTS,
+		backoff:  RETRY_BACKOFF,
+		client:   &http.Client{Timeout: SEND_TIMEOUT},
+		stop:     make(chan struct{}),
+		log:      log,
+	}
+}
+
+// Start sends the events published from now on, until Stop.
+func (n *Notifier) Start() {
+	_, _ = n.Sinks()
+	events, _ := n.bus.Since(0)
+
+	var since int64
+	if len(events) > 0 {
+		since = events[len(events)-1].Seq
+	}
+
+	go n.run(since)
+}
+
+func (n *Notifier) Stop() {
+	close(n.stop)
+}
+
+func (n *Notifier) run(since int64) {
+	for {
+		events, next := n.bus.Since(since)
+		for _, e := range events {
+			n.notify(e)
+			since = e.Seq
+		}
+
+		select {
+		case <-next:
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// notify sends e to the sinks that want it, each in the background.
+func (n *Notifier) notify(e core.AppEvent) {
+	sinks, err := n.Sinks()
+	if err != nil {
+		n.log.Error().Err(err).Msgf("failed to load notification sinks, event dropped. seq=%d, type=%s, app=%s", e.Seq, e.Type, e.App)
+		return
+	}
+
+	for i := range sinks {
+		if sinks[i].wants(e) {
+			d := n.addDelivery(sinks[i].Name, e)
+			go n.deliver(sinks[i], d, e, n.attempts)
+		}
+	}
+}
+
+// deliver sends e to sink as the delivery d, retrying with backoff up to attempts times.
+func (n *Notifier) deliver(sink Sink, d int, e core.AppEvent, attempts int) Delivery {
+	wait := n.backoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-n.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		err := sink.send(ctx, n.client, e)
+		cancel()
+
+		if err == nil {
+			return n.finish(d, attempt, DELIVERY_DELIVERED, nil)
+		}
+
+		if attempt >= attempts {
+			n.log.Warn().Err(err).Msgf("failed to notify, giving up. sink=%s, seq=%d, type=%s, app=%s, attempts=%d", sink.Name, e.Seq, e.Type, e.App, attempt)
+			return n.finish(d, attempt, DELIVERY_FAILED, err)
+		}
+
+		n.finish(d, attempt, DELIVERY_PENDING, err)
+
+		select {
+		case <-time.After(wait):
+			wait *= 2
+		case <-n.stop:
+			return n.finish(d, attempt, DELIVERY_FAILED, fmt.Errorf("go-runner stopped, last error: %w", err))
+		}
+	}
+}
+
+// Test sends a test event to a sink once, and returns how it went.
+func (n *Notifier) Test(name string) (Delivery, error) {
+	sink, err := n.Sink(name)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	e := core.AppEvent{
+		Type:    EVENT_TEST,
+		App:     "go-runner",
+		At:      time.Now(),
+		Message: "test notification to " + name,
+	}
+
+	return n.deliver(sink, n.addDelivery(name, e), e, 1), nil
+}
+
+func (n *Notifier) addDelivery(sink string, e core.AppEvent) int {
+	n.Lock()
+	defer n.Unlock()
+
+	n.seq++
+	n.deliveries = append(n.deliveries, &Delivery{
+		ID:        n.seq,
+		Sink:      sink,
+		Event:     e,
+		State:     DELIVERY_PENDING,
+		CreatedAt: time.Now(),
+	})
+
+	if len(n.deliveries) > DELIVERIES_TO_KEEP {
+		n.deliveries = n.deliveries[len(n.deliveries)-DELIVERIES_TO_KEEP:]
+	}
+
+	return n.seq
+}
+
+// finish records an attempt of a delivery.
+func (n *Notifier) finish(id, attempts int, state string, err error) Delivery {
+	n.Lock()
+	defer n.Unlock()
+
+	d := n.find(id)
+	if d == nil {
+		return Delivery{ID: id, State: state, Attempts: attempts}
+	}
+
+	d.State = state
+	d.Attempts = attempts
+	d.LastError = ""
+	if err != nil {
+		d.LastError = err.Error()
+	}
+
+	if state != DELIVERY_PENDING {
+		now := time.Now()
+		d.FinishedAt = &now
+	}
+
+	return *d
+}
+
+// Deliveries returns the delivery log, newest first.
+func (n *Notifier) Deliveries() []Delivery {
+	n.Lock()
+	defer n.Unlock()
+
+	list := make([]Delivery, 0, len(n.deliveries))
+	for i := len(n.deliveries) - 1; i >= 0; i-- {
+		list = append(list, *n.deliveries[i])
+	}
+
+	return list
+}
+
+func (n *Notifier) Delivery(id int) (Delivery, bool) {
+	n.Lock()
+	defer n.Unlock()
+
+	d := n.find(id)
+	if d == nil {
+		return Delivery{}, false
+	}
+
+	return *d, true
+}
+
+func (n *Notifier) find(id int) *Delivery {
+	for _, d := range n.deliveries {
+		if d.ID == id {
+			return d
+		}
+	}
+
+	return nil
+}
+
+// Sinks returns the sinks, in the order they were added.
+func (n *Notifier) Sinks() ([]Sink, error) {
+	n.Lock()
+	defer n.Unlock()
+
+	err := n.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]Sink{}, n.sinks...), n.execSinks...), nil
+}
+
+func (n *Notifier) Sink(name string) (Sink, error) {
+	sinks, err := n.Sinks()
+	if err != nil {
+		return Sink{}, err
+	}
+
+	for _, s := range sinks {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+
+	return Sink{}, fmt.Errorf("%w: %s", ErrSinkNotFound, name)
+}
+
+// AddSink validates and saves a new sink. Exec sinks are only configured in EXEC_SINKS_FILE.
+func (n *Notifier) AddSink(sink Sink) (Sink, error) {
+	if sink.Type == SINK_EXEC {
+		return Sink{}, fmt.Errorf("%w: exec sinks are only configured in %s", ErrInvalidSink, n.execFile)
+	}
+
+	err := sink.validate()
+	if err != nil {
+		return Sink{}, err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	err = n.load()
+	if err != nil {
+		return Sink{}, err
+	}
+
+	for _, s := range append(append([]Sink{}, n.sinks...), n.execSinks...) {
+		if s.Name == sink.Name {
+			return Sink{}, fmt.Errorf("%w: %s", ErrSinkExists, sink.Name)
+		}
+	}
+
+	sink.CreatedAt = time.Now()
+	n.sinks = append(n.sinks, sink)
+
+	err = n.save()
+	if err != nil {
+		n.sinks = n.sinks[:len(n.sinks)-1]
+		return Sink{}, err
+	}
+
+	return sink, nil
+}
+
+func (n *Notifier) DeleteSink(name string) error {
+	n.Lock()
+	defer n.Unlock()
+
+	err := n.load()
+	if err != nil {
+		return err
+	}
+
+	for i, s := range n.sinks {
+		if s.Name == name {
+			n.sinks = append(n.sinks[:i:i], n.sinks[i+1:]...)
+			return n.save()
+		}
+	}
+
+	for _, s := range n.execSinks {
+		if s.Name == name {
+			return fmt.Errorf("%w: exec sink %s is configured in %s", ErrInvalidSink, name, n.execFile)
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrSinkNotFound, name)
+}
+
+func (n *Notifier) load() error {
+	if n.loaded {
+		return nil
+	}
+
+	var sinks, execSinks []Sink
+	err := readSinks(n.file, &sinks)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(sinks); i++ {
+		if sinks[i].Type == SINK_EXEC {
+			// added through the API by an older go-runner, which is not trusted to run commands
+			n.log.Warn().Msgf("exec sink ignored, exec sinks are only configured in %s. name=%s", n.execFile, sinks[i].Name)
+			sinks = append(sinks[:i:i], sinks[i+1:]...)
+			i--
+		}
+	}
+
+	err = readSinks(n.execFile, &execSinks)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range execSinks {
+		if s.Type != SINK_EXEC {
+			return fmt.Errorf("%s: %w: %s is not an exec sink", n.execFile, ErrInvalidSink, s.Name)
+		}
+
+		err = s.validate()
+		if err != nil {
+			return fmt.Errorf("%s: %w", n.execFile, err)
+		}
+	}
+
+	n.sinks, n.execSinks = sinks, execSinks
+	n.loaded = true
+
+	return nil
+}
+
+// readSinks reads the sinks in file into sinks, if it exists.
+func readSinks(file string, sinks *[]Sink) error {
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(content, sinks)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	return nil
+}
+
+func (n *Notifier) save() error {
+	content, err := json.MarshalIndent(n.sinks, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(path.Dir(n.file), 0770)
+	if err != nil {
+		return err
+	}
+
+	tmp := n.file + ".tmp"
+	err = ioutil.WriteFile(tmp, content, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, n.file)
+}
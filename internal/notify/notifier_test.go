@@ -0,0 +1,258 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/core"
+	"github.com/JackKCWong/go-runner/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+// standIn is a local http server standing in for a webhook receiver. It fails the first failures requests.
+type standIn struct {
+	sync.Mutex
+	*httptest.Server
+	failures int
+	bodies   []string
+}
+
+func newStandIn(t *testing.T, failures int) *standIn {
+	s := &standIn{failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		s.Lock()
+		defer s.Unlock()
+
+		s.bodies = append(s.bodies, string(body))
+		if len(s.bodies) <= s.failures {
+			http.Error(w, "try again later", http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *standIn) received() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	return append([]string{}, s.bodies...)
+}
+
+func newTestNotifier(t *testing.T, wd string) (*Notifier, *core.EventBus) {
+	bus := core.NewEventBus()
+	n := NewNotifier(wd, bus, &log.Logger)
+	n.backoff = 10 * time.Millisecond
+	n.attempts = 3
+	n.Start()
+	t.Cleanup(n.Stop)
+
+	return n, bus
+}
+
+// waitForDeliveries waits for n deliveries to finish, and returns the log.
+func waitForDeliveries(t *testing.T, notifier *Notifier, n int) []Delivery {
+	for i := 0; i < 200; i++ {
+		finished := 0
+		deliveries := notifier.Deliveries()
+		for _, d := range deliveries {
+			if d.State != DELIVERY_PENDING {
+				finished++
+			}
+		}
+
+		if finished == n && len(deliveries) == n {
+			return deliveries
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("expected %d finished deliveries, got %v", n, notifier.Deliveries())
+
+	return nil
+}
+
+func TestWebhookSinkRetriesAndFilters(t *testing.T) {
+	expect := util.NewExpect(t)
+	stand := newStandIn(t, 1)
+	notifier, bus := newTestNotifier(t, t.TempDir())
+
+	_, err := notifier.AddSink(Sink{
+		Name:     "ops",
+		Type:     SINK_WEBHOOK,
+		URL:      stand.URL,
+		Template: `{"summary": {{json .Message}}, "app": "{{.App}}", "seq": {{.Seq}}}`,
+		Apps:     []string{"hello*"},
+		Events:   []string{"deploy.*"},
+	})
+	expect.Nil(err)
+
+	bus.Publish(core.AppEvent{Type: core.EVENT_DEPLOY_FAILED, App: "other", Message: "not for ops"})
+	bus.Publish(core.AppEvent{Type: core.EVENT_PROCESS_CRASHED, App: "hello", Message: "not a deploy"})
+	bus.Publish(core.AppEvent{Type: core.EVENT_DEPLOY_FAILED, App: "hello-api", Message: `go build exited with "1"`})
+
+	deliveries := waitForDeliveries(t, notifier, 1)
+	expect.Equal(DELIVERY_DELIVERED, deliveries[0].State)
+	expect.Equal(2, deliveries[0].Attempts)
+	expect.Equal("", deliveries[0].LastError)
+	expect.Equal(int64(3), deliveries[0].Event.Seq)
+
+	received := stand.received()
+	expect.Equal(2, len(received))
+	expect.Equal(`{"summary": "go build exited with \"1\"", "app": "hello-api", "seq": 3}`, received[1])
+}
+
+func TestSlackAndExecSinks(t *testing.T) {
+	expect := util.NewExpect(t)
+	wd := t.TempDir()
+	stand := newStandIn(t, 0)
+
+	out := path.Join(wd, "event.txt")
+	writeExecSinks(t, wd, Sink{
+		Name:    "script",
+		Type:    SINK_EXEC,
+		Command: `echo "$GORUNNER_EVENT_TYPE $GORUNNER_EVENT_APP $GORUNNER_EVENT_RELEASE" > ` + out + `; cat >> ` + out,
+		Events:  []string{core.EVENT_PROCESS_CRASHED},
+	})
+	notifier, bus := newTestNotifier(t, wd)
+
+	_, err := notifier.AddSink(Sink{Name: "slack", Type: SINK_SLACK, URL: stand.URL})
+	expect.Nil(err)
+
+	bus.Publish(core.AppEvent{Type: core.EVENT_PROCESS_CRASHED, App: "hello", Release: 3, Message: "exited with 2"})
+	waitForDeliveries(t, notifier, 2)
+
+	var msg struct {
+		Text string `json:"text"`
+	}
+	expect.Nil(json.Unmarshal([]byte(stand.received()[0]), &msg))
+	expect.Equal("[hello] process.crashed release 3: exited with 2", msg.Text)
+
+	content, err := ioutil.ReadFile(out)
+	expect.Nil(err)
+
+	var e core.AppEvent
+	lines := string(content)
+	expect.Equal("process.crashed hello 3\n", lines[:len("process.crashed hello 3\n")])
+	expect.Nil(json.Unmarshal([]byte(lines[len("process.crashed hello 3\n"):]), &e))
+	expect.Equal("exited with 2", e.Message)
+}
+
+func TestFailedDeliveriesGiveUp(t *testing.T) {
+	expect := util.NewExpect(t)
+	stand := newStandIn(t, 100)
+	notifier, bus := newTestNotifier(t, t.TempDir())
+
+	_, err := notifier.AddSink(Sink{Name: "down", Type: SINK_WEBHOOK, URL: stand.URL})
+	expect.Nil(err)
+
+	bus.Publish(core.AppEvent{Type: core.EVENT_PROCESS_CRASHED, App: "hello"})
+
+	deliveries := waitForDeliveries(t, notifier, 1)
+	expect.Equal(DELIVERY_FAILED, deliveries[0].State)
+	expect.Equal(3, deliveries[0].Attempts)
+	expect.Equal("503 Service Unavailable: try again later", deliveries[0].LastError)
+
+	d, err := notifier.Test("down")
+	expect.Nil(err)
+	expect.Equal(DELIVERY_FAILED, d.State)
+	expect.Equal(1, d.Attempts)
+	expect.Equal(EVENT_TEST, d.Event.Type)
+}
+
+func TestSinksAreSaved(t *testing.T) {
+	expect := util.NewExpect(t)
+	wd := t.TempDir()
+	notifier := NewNotifier(wd, core.NewEventBus(), &log.Logger)
+
+	for _, invalid := range []Sink{
+		{Name: "", Type: SINK_WEBHOOK, URL: "https://host/hook"},
+		{Name: "a", Type: "email"},
+		{Name: "a", Type: SINK_WEBHOOK, URL: "ftp://host"},
+		{Name: "a", Type: SINK_SLACK, URL: "https://hooks.slack.com/x", Template: "{{.Nope"},
+		{Name: "a", Type: SINK_WEBHOOK, URL: "https://host/hook", Events: []string{"deploy.["}},
+		{Name: "a", Type: SINK_EXEC, Command: "true"},
+	} {
+		_, err := notifier.AddSink(invalid)
+		expect.True(errors.Is(err, ErrInvalidSink), invalid)
+	}
+
+	_, err := notifier.AddSink(Sink{Name: "a", Type: SINK_WEBHOOK, URL: "https://host/a"})
+	expect.Nil(err)
+	_, err = notifier.AddSink(Sink{Name: "a", Type: SINK_WEBHOOK, URL: "https://host/b"})
+	expect.True(errors.Is(err, ErrSinkExists))
+
+	sinks, err := NewNotifier(wd, nil, &log.Logger).Sinks()
+	expect.Nil(err)
+	expect.Equal(1, len(sinks))
+	expect.Equal("https://host/a", sinks[0].URL)
+
+	expect.Nil(notifier.DeleteSink("a"))
+	expect.True(errors.Is(notifier.DeleteSink("a"), ErrSinkNotFound))
+	_, err = notifier.Test("a")
+	expect.True(errors.Is(err, ErrSinkNotFound))
+}
+
+func writeExecSinks(t *testing.T, wd string, sinks ...Sink) {
+	content, err := json.Marshal(sinks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(path.Join(wd, EXEC_SINKS_FILE), content, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExecSinksAreOnlyReadFromTheirFile(t *testing.T) {
+	expect := util.NewExpect(t)
+	wd := t.TempDir()
+
+	// as added through the API before
+	content, err := json.Marshal([]Sink{
+		{Name: "hook", Type: SINK_WEBHOOK, URL: "https://host/hook"},
+		{Name: "added", Type: SINK_EXEC, Command: "touch " + path.Join(wd, "pwned")},
+	})
+	expect.Nil(err)
+	expect.Nil(ioutil.WriteFile(path.Join(wd, NOTIFICATIONS_FILE), content, 0600))
+	writeExecSinks(t, wd, Sink{Name: "script", Type: SINK_EXEC, Command: "true"})
+
+	notifier := NewNotifier(wd, core.NewEventBus(), &log.Logger)
+	sinks, err := notifier.Sinks()
+	expect.Nil(err)
+	expect.Equal(2, len(sinks))
+	expect.Equal("hook", sinks[0].Name)
+	expect.Equal("script", sinks[1].Name)
+
+	_, err = notifier.Test("added")
+	expect.True(errors.Is(err, ErrSinkNotFound))
+	_, err = notifier.AddSink(Sink{Name: "script", Type: SINK_WEBHOOK, URL: "https://host/script"})
+	expect.True(errors.Is(err, ErrSinkExists))
+	expect.True(errors.Is(notifier.DeleteSink("script"), ErrInvalidSink))
+
+	// the exec sinks are not written back
+	expect.Nil(notifier.DeleteSink("hook"))
+	sinks, err = NewNotifier(wd, nil, &log.Logger).Sinks()
+	expect.Nil(err)
+	expect.Equal(1, len(sinks))
+	expect.Equal("script", sinks[0].Name)
+	content, err = ioutil.ReadFile(path.Join(wd, NOTIFICATIONS_FILE))
+	expect.Nil(err)
+	expect.Equal("[]", string(content))
+
+	writeExecSinks(t, wd, Sink{Name: "hook", Type: SINK_WEBHOOK, URL: "https://host/hook"})
+	_, err = NewNotifier(wd, nil, &log.Logger).Sinks()
+	expect.True(errors.Is(err, ErrInvalidSink), err)
+}
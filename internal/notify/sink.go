@@ -0,0 +1,258 @@
+// Package notify sends the events of the apps to webhooks, Slack and scripts, so people hear of failed deploys and crashes.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/core"
+)
+
+const (
+	SINK_WEBHOOK = "webhook"
+	SINK_SLACK   = "slack"
+	SINK_EXEC    = "exec"
+)
+
+// EXEC_TIMEOUT is how long the command of an exec sink may take to handle an event.
+const EXEC_TIMEOUT = 30 * time.Second
+
+var ErrInvalidSink = errors.New("invalid notification sink")
+
+// DEFAULT_EVENTS are the events a sink is sent when it doesn't say.
+var DEFAULT_EVENTS = []string{core.EVENT_DEPLOY_FAILED, core.EVENT_PROCESS_CRASHED}
+
+const (
+	// DEFAULT_SLACK_TEMPLATE is the text of the Slack message of an event.
+	DEFAULT_SLACK_TEMPLATE = "[{{.App}}] {{.Type}}{{with .Release}} release {{.}}{{end}}{{with .Message}}: {{.}}{{end}}"
+)
+
+// Sink is where the events of apps are sent.
+type Sink struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// URL of a webhook or slack sink
+	URL string `json:"url,omitempty"`
+	// Template of the JSON body of a webhook sink, or of the text of a slack sink, in text/template with the event
+	// as the data. A webhook sink sends the event as is without one. {{json .Message}} quotes a value for JSON.
+	Template string `json:"template,omitempty"`
+	// Command of an exec sink, run with sh -c. It gets the event as JSON on stdin, and in GORUNNER_EVENT_* env vars.
+	// Exec sinks are only read from EXEC_SINKS_FILE.
+	Command string `json:"command,omitempty"`
+	// Apps and Events filter what the sink is sent, in the patterns of path.Match, e.g. "deploy.*".
+	// No Apps means all apps, and no Events means DEFAULT_EVENTS.
+	Apps      []string  `json:"apps,omitempty"`
+	Events    []string  `json:"events,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (s *Sink) validate() error {
+	if s.Name == "" || strings.ContainsAny(s.Name, "/ ") {
+		return fmt.Errorf("%w: name must be given, without / or spaces", ErrInvalidSink)
+	}
+
+	switch s.Type {
+	case SINK_WEBHOOK, SINK_SLACK:
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: %s sink needs an http or https url", ErrInvalidSink, s.Type)
+		}
+
+		if s.Template != "" {
+			_, err = parseTemplate(s.Template)
+			if err != nil {
+				return fmt.Errorf("%w: template: %s", ErrInvalidSink, err)
+			}
+		}
+	case SINK_EXEC:
+		if strings.TrimSpace(s.Command) == "" {
+			return fmt.Errorf("%w: exec sink needs a command", ErrInvalidSink)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q, expected webhook, slack or exec", ErrInvalidSink, s.Type)
+	}
+
+	for _, p := range append(append([]string{}, s.Apps...), s.Events...) {
+		_, err := path.Match(p, "")
+		if err != nil {
+			return fmt.Errorf("%w: pattern %q: %s", ErrInvalidSink, p, err)
+		}
+	}
+
+	return nil
+}
+
+// wants tells if the sink is sent e.
+func (s *Sink) wants(e core.AppEvent) bool {
+	events := s.Events
+	if len(events) == 0 {
+		events = DEFAULT_EVENTS
+	}
+
+	return matchAny(s.Apps, e.App, true) && matchAny(events, e.Type, false)
+}
+
+func matchAny(patterns []string, name string, empty bool) bool {
+	if len(patterns) == 0 {
+		return empty
+	}
+
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// send sends e to the sink once.
+func (s *Sink) send(ctx context.Context, client *http.Client, e core.AppEvent) error {
+	switch s.Type {
+	case SINK_WEBHOOK:
+		body, err := s.webhookBody(e)
+		if err != nil {
+			return err
+		}
+
+		return post(ctx, client, s.URL, body)
+	case SINK_SLACK:
+		text, err := render(s.Template, DEFAULT_SLACK_TEMPLATE, e)
+		if err != nil {
+			return err
+		}
+
+		body, err := json.Marshal(struct {
+			Text string `json:"text"`
+		}{text})
+		if err != nil {
+			return err
+		}
+
+		return post(ctx, client, s.URL, body)
+	case SINK_EXEC:
+		return s.exec(ctx, e)
+	}
+
+	return fmt.Errorf("%w: unknown type %q", ErrInvalidSink, s.Type)
+}
+
+func (s *Sink) webhookBody(e core.AppEvent) ([]byte, error) {
+	if s.Template == "" {
+		return json.Marshal(e)
+	}
+
+	body, err := render(s.Template, "", e)
+	if err != nil {
+		return nil, err
+	}
+
+	if !json.Valid([]byte(body)) {
+		return nil, fmt.Errorf("template doesn't render valid JSON: %s", body)
+	}
+
+	return []byte(body), nil
+}
+
+func (s *Sink) exec(ctx context.Context, e core.AppEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, EXEC_TIMEOUT)
+	defer cancel()
+
+	input, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	c := exec.CommandContext(ctx, "sh", "-c", s.Command)
+	c.Stdin = bytes.NewReader(input)
+	c.Env = append(os.Environ(),
+		"GORUNNER_EVENT_SEQ="+strconv.FormatInt(e.Seq, 10),
+		"GORUNNER_EVENT_TYPE="+e.Type,
+		"GORUNNER_EVENT_APP="+e.App,
+		"GORUNNER_EVENT_RELEASE="+strconv.Itoa(e.Release),
+		"GORUNNER_EVENT_MESSAGE="+e.Message,
+	)
+
+	out, err := c.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", EXEC_TIMEOUT)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %s", err, lastLine(out))
+	}
+
+	return nil
+}
+
+func post(ctx context.Context, client *http.Client, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-runner")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	reply, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, lastLine(reply))
+	}
+
+	return nil
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("sink").Option("missingkey=error").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+// render renders text, or fallback if text is "", with e.
+func render(text, fallback string, e core.AppEvent) (string, error) {
+	if text == "" {
+		text = fallback
+	}
+
+	t, err := parseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf strings.Builder
+	err = t.Execute(&buf, e)
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+
+	return lines[len(lines)-1]
+}
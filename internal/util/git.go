@@ -49,3 +49,17 @@ func GetGitAuth() (transport.AuthMethod, error) {
 
 	return auth, nil
 }
+
+// GetGitAuthFor returns the auth for gitURL. Only ssh urls need auth, others return nil.
+func GetGitAuthFor(gitURL string) (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(gitURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if ep.Protocol != "ssh" {
+		return nil, nil
+	}
+
+	return GetGitAuth()
+}
@@ -0,0 +1,237 @@
+package util
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TarGz writes the files under dir to w as a gzipped tarball.
+// skip is called with the slash separated path relative to dir, and the entry is left out if it returns true.
+// A skipped dir is not walked into.
+func TarGz(w io.Writer, dir string, skip func(relPath string, isDir bool) bool) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			return nil
+		}
+
+		rel = filepath.ToSlash(rel)
+		if skip != nil && skip(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(p)
+			if err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		hdr.Name = rel
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// UntarGz extracts a gzipped tarball into dir. Entries that would end up outside of dir are rejected.
+func UntarGz(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	return Untar(gz, dir)
+}
+
+// Untar extracts a tarball into dir. Entries that would end up outside of dir are rejected, and so are entries under
+// a symlink and symlinks that go up after going down, so that no chain of the symlinks in it leads outside of dir.
+func Untar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		target, err := securePath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		err = noSymlinkParents(dir, target)
+		if err != nil {
+			return err
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, mode|0700)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(target, tr, mode)
+		case tar.TypeSymlink:
+			if filepath.IsAbs(hdr.Linkname) {
+				return fmt.Errorf("absolute symlink not allowed: %s -> %s", hdr.Name, hdr.Linkname)
+			}
+
+			if upAfterDown(hdr.Linkname) {
+				return fmt.Errorf("symlink going up after going down not allowed: %s -> %s", hdr.Name, hdr.Linkname)
+			}
+
+			_, err = securePath(dir, filepath.Join(filepath.Dir(hdr.Name), hdr.Linkname))
+			if err != nil {
+				return err
+			}
+
+			err = os.MkdirAll(filepath.Dir(target), 0700)
+			if err == nil {
+				err = os.Symlink(hdr.Linkname, target)
+			}
+		default:
+			// devices, hard links, fifos etc. have no place in an app
+			err = fmt.Errorf("unsupported tar entry type %q: %s", hdr.Typeflag, hdr.Name)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// securePath joins name to dir and makes sure the result is still inside dir.
+func securePath(dir, name string) (string, error) {
+	target := filepath.Join(dir, name)
+	if target != filepath.Clean(dir) && !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("illegal path in tarball: %s", name)
+	}
+
+	return target, nil
+}
+
+// noSymlinkParents makes sure none of the dirs between dir and target is a symlink, which could lead outside of dir.
+func noSymlinkParents(dir, target string) error {
+	rel, err := filepath.Rel(dir, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return err
+	}
+
+	p := filepath.Clean(dir)
+	for _, name := range strings.Split(rel, string(os.PathSeparator)) {
+		p = filepath.Join(p, name)
+		info, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			// created as a dir from here on
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("path through a symlink not allowed in tarball: %s", target)
+		}
+	}
+
+	return nil
+}
+
+// upAfterDown tells if the symlink target link has .. after a name, e.g. x/.., which goes up from wherever x leads,
+// and not from where the check of the lexical path assumes.
+func upAfterDown(link string) bool {
+	down := false
+	for _, name := range strings.Split(filepath.ToSlash(link), "/") {
+		switch name {
+		case "", ".":
+		case "..":
+			if down {
+				return true
+			}
+		default:
+			down = true
+		}
+	}
+
+	return false
+}
+
+func writeFile(target string, r io.Reader, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(target), 0700)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode|0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,95 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTarGzRoundTrip(t *testing.T) {
+	expect := NewExpect(t)
+
+	src := t.TempDir()
+	expect.Nil(os.MkdirAll(filepath.Join(src, "pkg", "bin"), 0755))
+	expect.Nil(ioutil.WriteFile(filepath.Join(src, "main.go"), []byte("package main"), 0644))
+	expect.Nil(ioutil.WriteFile(filepath.Join(src, "pkg", "lib.go"), []byte("package pkg"), 0644))
+	expect.Nil(ioutil.WriteFile(filepath.Join(src, "pkg", "bin", "app"), []byte("binary"), 0755))
+
+	var buf bytes.Buffer
+	err := TarGz(&buf, src, func(relPath string, isDir bool) bool {
+		return relPath == "pkg/bin"
+	})
+	expect.Nil(err)
+
+	dst := t.TempDir()
+	expect.Nil(UntarGz(&buf, dst))
+
+	content, err := ioutil.ReadFile(filepath.Join(dst, "pkg", "lib.go"))
+	expect.Nil(err)
+	expect.Equal("package pkg", string(content))
+
+	_, err = os.Stat(filepath.Join(dst, "pkg", "bin"))
+	expect.True(os.IsNotExist(err))
+}
+
+func TestUntarRejectsPathsOutsideOfDir(t *testing.T) {
+	expect := NewExpect(t)
+
+	for _, hdr := range []*tar.Header{
+		{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../../etc/passwd"},
+		{Name: "abs", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+	} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		expect.Nil(tw.WriteHeader(hdr))
+		expect.Nil(tw.Close())
+
+		err := Untar(&buf, t.TempDir())
+		expect.True(err != nil, hdr.Name)
+	}
+}
+
+func TestUntarRejectsChainedSymlinksOutsideOfDir(t *testing.T) {
+	expect := NewExpect(t)
+
+	for _, hdrs := range [][]*tar.Header{
+		{
+			{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "a/b", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "a/b/escaped", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+		{
+			{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "x/.."},
+			{Name: "x", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "b/escaped", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+	} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, hdr := range hdrs {
+			expect.Nil(tw.WriteHeader(hdr))
+		}
+		expect.Nil(tw.Close())
+
+		parent := t.TempDir()
+		dir := filepath.Join(parent, "dir")
+		err := Untar(&buf, dir)
+		expect.True(err != nil, hdrs[1].Name)
+
+		_, err = os.Lstat(filepath.Join(parent, "escaped"))
+		expect.True(os.IsNotExist(err), err)
+	}
+
+	// symlinks that stay in dir are fine
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	expect.Nil(tw.WriteHeader(&tar.Header{Name: "pkg/", Typeflag: tar.TypeDir, Mode: 0755}))
+	expect.Nil(tw.WriteHeader(&tar.Header{Name: "pkg/link", Typeflag: tar.TypeSymlink, Linkname: "../main.go"}))
+	expect.Nil(tw.WriteHeader(&tar.Header{Name: "current", Typeflag: tar.TypeSymlink, Linkname: "./pkg"}))
+	expect.Nil(tw.Close())
+	expect.Nil(Untar(&buf, t.TempDir()))
+}
@@ -1,6 +1,7 @@
 package web
 
 import (
+	"errors"
 	"github.com/JackKCWong/go-runner/internal/core"
 	"github.com/labstack/echo/v4"
 	"net/http"
@@ -8,46 +9,80 @@ import (
 
 func (server *GoRunnerWebServer) deployApp(c echo.Context, goapp *core.GoApp) error {
 	server.logger.Info().Msgf("deploying app... - app=%s, gitUrl=%s", goapp.Name, goapp.GitURL)
-	err := goapp.Stop()
-	if err != nil {
-		server.logger.Info().Err(err).Msgf("failed to stop, continue anyway. app=%s", goapp.Name)
-	}
+	return server.deploy(c, goapp, goapp.Rebuild)
+}
 
-	err = goapp.Rebuild()
-	if err != nil {
-		server.logger.Error().Err(err).Msgf("failed to build. app=%s", goapp.Name)
-		return c.JSON(http.StatusInternalServerError, errStatus{
-			goapp, err,
-		})
-	}
+func (server *GoRunnerWebServer) deploySource(c echo.Context, goapp *core.GoApp) error {
+	server.logger.Info().Msgf("deploying uploaded source... - app=%s", goapp.Name)
+	src := c.Request().Body
+	baseCommit := c.QueryParam("commit")
 
-	err = goapp.Start()
-	if err != nil {
-		server.logger.Error().Err(err).Msgf("failed to start. app=%s", goapp.Name)
-		return c.JSON(http.StatusInternalServerError, errStatus{
+	return server.deploy(c, goapp, func() error {
+		return goapp.Unpack(src, baseCommit)
+	})
+}
+
+func (server *GoRunnerWebServer) deployArtifact(c echo.Context, goapp *core.GoApp) error {
+	server.logger.Info().Msgf("deploying artifact... - app=%s", goapp.Name)
+	src := c.Request().Body
+	checksum := c.QueryParam("checksum")
+	binary := c.QueryParam("binary")
+
+	return server.deploy(c, goapp, func() error {
+		return goapp.UnpackArtifact(src, checksum, binary)
+	})
+}
+
+func (server *GoRunnerWebServer) rollbackApp(c echo.Context, goapp *core.GoApp, release int) error {
+	server.logger.Info().Msgf("rolling back app... - app=%s, release=%d", goapp.Name, release)
+
+	return server.deploy(c, goapp, func() error {
+		return goapp.Rollback(release)
+	})
+}
+
+// deploy prepares a new current release with newRelease. If that works, it restarts the app from the new release.
+// Otherwise the app keeps running the release it has.
+func (server *GoRunnerWebServer) deploy(c echo.Context, goapp *core.GoApp, newRelease func() error) error {
+	err := goapp.Deploy(newRelease)
+	switch {
+	case err == nil:
+		server.logger.Info().Msgf("app started. app=%s", goapp.Name)
+	case errors.Is(err, core.ErrNoChanges):
+		server.logger.Info().Msgf("%s, not restarting. app=%s", err, goapp.Name)
+	default:
+		server.logger.Error().Err(err).Msgf("failed to deploy. app=%s", goapp.Name)
+		return c.JSON(deployErrStatus(err), errStatus{
 			goapp, err,
 		})
 	}
 
-	server.logger.Info().Msgf("app started. app=%s", goapp.Name)
-
 	return c.JSON(http.StatusOK, goapp)
 }
 
-func (server *GoRunnerWebServer) restartApp(c echo.Context, goapp *core.GoApp) error {
-	server.logger.Info().Msgf("restarting app... - app=%s, gitUrl=%s", goapp.Name, goapp.GitURL)
-	err := goapp.Stop()
-	if err != nil {
-		server.logger.Error().Err(err).Msgf("failed to stop. app=%s", goapp.Name)
-		return c.JSON(http.StatusInternalServerError, errStatus{
-			goapp, err,
-		})
+func deployErrStatus(err error) int {
+	switch {
+	case errors.Is(err, core.ErrChecksumMismatch):
+		return http.StatusBadRequest
+	case errors.Is(err, core.ErrGateFailed):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, core.ErrJobCanceled), errors.Is(err, core.ErrBusy):
+		return http.StatusConflict
+	case errors.Is(err, core.ErrDeleted):
+		return http.StatusNotFound
+	case errors.Is(err, core.ErrJobTimeout):
+		return http.StatusGatewayTimeout
 	}
 
-	err = goapp.Start()
+	return http.StatusInternalServerError
+}
+
+func (server *GoRunnerWebServer) restartApp(c echo.Context, goapp *core.GoApp) error {
+	server.logger.Info().Msgf("restarting app... - app=%s, gitUrl=%s", goapp.Name, goapp.GitURL)
+	err := goapp.Restart()
 	if err != nil {
-		server.logger.Error().Err(err).Msgf("failed to start. app=%s", goapp.Name)
-		return c.JSON(http.StatusInternalServerError, errStatus{
+		server.logger.Error().Err(err).Msgf("failed to restart. app=%s", goapp.Name)
+		return c.JSON(deployErrStatus(err), errStatus{
 			goapp, err,
 		})
 	}
@@ -0,0 +1,101 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/JackKCWong/go-runner/internal/core"
+	"github.com/go-playground/validator"
+	"github.com/labstack/echo/v4"
+)
+
+func (server *GoRunnerWebServer) listCredentials(c echo.Context) error {
+	creds, err := server.runner.Credentials().List()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, errStatus{
+			nil, err,
+		})
+	}
+
+	return c.JSON(http.StatusOK, creds)
+}
+
+func (server *GoRunnerWebServer) getCredential(c echo.Context) error {
+	cred, err := server.runner.Credentials().Get(c.Param("name"))
+	if err != nil {
+		return c.JSON(credentialErrStatus(err), errStatus{
+			nil, err,
+		})
+	}
+
+	return c.JSON(http.StatusOK, cred)
+}
+
+// addCredential saves a credential for cloning private repos. The response has the public key of a generated ssh key.
+func (server *GoRunnerWebServer) addCredential(c echo.Context) error {
+	params := new(AddCredentialParams)
+	err := c.Bind(params)
+	if err != nil {
+		server.logger.Err(err).Msg("malformed request")
+		return c.JSON(http.StatusBadRequest, errStatus{
+			nil, err,
+		})
+	}
+
+	validate := validator.New()
+	err = validate.Struct(params)
+	if err != nil {
+		server.logger.Err(err).Msg("invalid request params")
+		return c.JSON(http.StatusBadRequest, errStatus{
+			nil, err,
+		})
+	}
+
+	cred, err := server.runner.Credentials().Add(core.CredentialSpec{
+		Name:       params.Name,
+		Type:       params.Type,
+		App:        params.App,
+		Host:       params.Host,
+		Username:   params.Username,
+		PrivateKey: params.PrivateKey,
+		Passphrase: params.Passphrase,
+		Token:      params.Token,
+	})
+	if err != nil {
+		server.logger.Err(err).Msgf("error adding credential. name=%s", params.Name)
+		return c.JSON(credentialErrStatus(err), errStatus{
+			nil, err,
+		})
+	}
+
+	server.logger.Info().Msgf("credential added. name=%s, type=%s, app=%s, host=%s", cred.Name, cred.Type, cred.App, cred.Host)
+
+	return c.JSON(http.StatusCreated, cred)
+}
+
+func (server *GoRunnerWebServer) deleteCredential(c echo.Context) error {
+	name := c.Param("name")
+	err := server.runner.Credentials().Delete(name)
+	if err != nil {
+		return c.JSON(credentialErrStatus(err), errStatus{
+			nil, err,
+		})
+	}
+
+	server.logger.Info().Msgf("credential deleted. name=%s", name)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func credentialErrStatus(err error) int {
+	switch {
+	case errors.Is(err, core.ErrCredentialNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, core.ErrCredentialExists):
+		return http.StatusConflict
+	case errors.Is(err, core.ErrInvalidCredential):
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,126 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// LONGPOLL_TIMEOUT is how long a long-poll for events waits for one by default. MAX_LONGPOLL_TIMEOUT caps ?timeout=.
+	LONGPOLL_TIMEOUT     = 30 * time.Second
+	MAX_LONGPOLL_TIMEOUT = 5 * time.Minute
+	// SSE_KEEPALIVE is how often an idle event stream gets a comment, so that proxies don't close it.
+	SSE_KEEPALIVE = 30 * time.Second
+)
+
+// listEvents returns the events of all apps after ?since=, as server-sent events if asked for with
+// Accept: text/event-stream, otherwise as a long-poll that waits for the next event if there is none yet.
+func (server *GoRunnerWebServer) listEvents(c echo.Context) error {
+	since, err := eventsSince(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errStatus{
+			nil, err,
+		})
+	}
+
+	if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/event-stream") {
+		return server.streamEvents(c, since)
+	}
+
+	timeout := LONGPOLL_TIMEOUT
+	if t := c.QueryParam("timeout"); t != "" {
+		timeout, err = time.ParseDuration(t)
+		if err != nil || timeout < 0 {
+			return c.JSON(http.StatusBadRequest, errStatus{
+				nil, fmt.Errorf("invalid timeout: %s", t),
+			})
+		}
+
+		if timeout > MAX_LONGPOLL_TIMEOUT {
+			timeout = MAX_LONGPOLL_TIMEOUT
+		}
+	}
+
+	bus := server.runner.Events()
+	events, next := bus.Since(since)
+	if len(events) == 0 && timeout > 0 {
+		select {
+		case <-next:
+			events, _ = bus.Since(since)
+		case <-time.After(timeout):
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+
+	return c.JSON(http.StatusOK, events)
+}
+
+// streamEvents sends the events after since, then every event as it is published, until the client goes away.
+func (server *GoRunnerWebServer) streamEvents(c echo.Context, since int64) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	keepalive := time.NewTicker(SSE_KEEPALIVE)
+	defer keepalive.Stop()
+
+	for {
+		events, next := server.runner.Events().Since(since)
+		for _, e := range events {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+
+			_, err = fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data)
+			if err != nil {
+				return nil
+			}
+
+			since = e.Seq
+		}
+		res.Flush()
+
+		select {
+		case <-next:
+		case <-keepalive.C:
+			_, err := fmt.Fprint(res, ": keepalive\n\n")
+			if err != nil {
+				return nil
+			}
+			res.Flush()
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+// eventsSince is the seq of the last event the client has, from ?since= or the Last-Event-ID of a reconnecting
+// event stream. 0 for all the events kept.
+func eventsSince(c echo.Context) (int64, error) {
+	since := c.QueryParam("since")
+	if since == "" {
+		since = c.Request().Header.Get("Last-Event-ID")
+	}
+
+	if since == "" {
+		return 0, nil
+	}
+
+	seq, err := strconv.ParseInt(since, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, errors.New("invalid since: " + since)
+	}
+
+	return seq, nil
+}
@@ -0,0 +1,17 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// goEnv shows the go caches the apps are built with, and where modules come from.
+func (server *GoRunnerWebServer) goEnv(c echo.Context) error {
+	return c.JSON(http.StatusOK, server.runner.GoEnv().Info())
+}
+
+// collectGoEnv collects the go caches down to their max size now, rather than after the next build.
+func (server *GoRunnerWebServer) collectGoEnv(c echo.Context) error {
+	return c.JSON(http.StatusOK, server.runner.GoEnv().GC())
+}
@@ -0,0 +1,204 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/core"
+	"github.com/JackKCWong/go-runner/internal/webhook"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	DELIVERIES_TO_KEEP = 100
+	MAX_HOOK_PAYLOAD   = 25 * 1024 * 1024
+)
+
+// receiveHook deploys the apps a push event is for. Each app verifies the delivery with its own webhook secret.
+// The deploys run in the background, their results are recorded with the delivery.
+func (server *GoRunnerWebServer) receiveHook(c echo.Context) error {
+	provider := c.Param("provider")
+	header := c.Request().Header
+
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request().Body, MAX_HOOK_PAYLOAD+1))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errStatus{
+			nil, err,
+		})
+	}
+
+	if len(body) > MAX_HOOK_PAYLOAD {
+		return c.JSON(http.StatusRequestEntityTooLarge, errStatus{
+			nil, fmt.Errorf("payload larger than %d bytes", MAX_HOOK_PAYLOAD),
+		})
+	}
+
+	push, err := webhook.Parse(provider, header, body)
+	if err != nil {
+		server.logger.Err(err).Msgf("invalid webhook delivery. provider=%s", provider)
+		status := http.StatusBadRequest
+		if errors.Is(err, webhook.ErrUnknownProvider) {
+			status = http.StatusNotFound
+		}
+
+		return c.JSON(status, errStatus{
+			nil, err,
+		})
+	}
+
+	delivery := webhook.Delivery{
+		Provider:   provider,
+		Event:      push.Event,
+		ReceivedAt: time.Now(),
+		Branch:     push.Branch,
+		Commit:     push.Commit,
+	}
+
+	if len(push.URLs) > 0 {
+		delivery.URL = push.URLs[0]
+	}
+
+	var toDeploy []*core.GoApp
+	switch {
+	case push.Branch == "":
+		delivery.Outcome = fmt.Sprintf("ignored, not a push to a branch. event=%s", push.Event)
+	case push.Deleted:
+		delivery.Outcome = "ignored, branch deleted"
+	default:
+		toDeploy = server.verifyHook(provider, header, body, push, &delivery)
+	}
+
+	delivery = server.hooks.Add(delivery)
+	server.logger.Info().Msgf("webhook delivery received. id=%d, provider=%s, outcome=%s", delivery.ID, provider, delivery.Outcome)
+
+	for _, goapp := range toDeploy {
+		go server.deployFromHook(delivery.ID, goapp)
+	}
+
+	status := http.StatusOK
+	switch {
+	case len(toDeploy) > 0:
+		status = http.StatusAccepted
+	case len(delivery.Apps) > 0:
+		status = http.StatusUnauthorized
+	}
+
+	return c.JSON(status, delivery)
+}
+
+// verifyHook returns the apps the push is for, whose webhook secret the delivery is signed with.
+func (server *GoRunnerWebServer) verifyHook(provider string, header http.Header, body []byte, push *webhook.Push, delivery *webhook.Delivery) []*core.GoApp {
+	var verified []*core.GoApp
+	for _, goapp := range server.runner.ListApps() {
+		if !server.pushedTo(goapp, push) {
+			continue
+		}
+
+		outcome := webhook.AppOutcome{App: goapp.Name, Result: webhook.RESULT_REJECTED}
+		secret, ok, err := server.runner.Credentials().WebhookSecret(goapp.Name)
+		switch {
+		case err != nil:
+			outcome.Error = err.Error()
+		case !ok:
+			outcome.Error = "app has no webhook secret"
+		default:
+			err = webhook.Verify(provider, header, body, secret)
+			if err != nil {
+				outcome.Error = err.Error()
+			} else {
+				outcome.Result = webhook.RESULT_DEPLOYING
+				verified = append(verified, goapp)
+			}
+		}
+
+		delivery.Apps = append(delivery.Apps, outcome)
+	}
+
+	switch {
+	case len(delivery.Apps) == 0:
+		delivery.Outcome = "ignored, no app deployed from the branch"
+	case len(verified) == 0:
+		delivery.Outcome = "rejected, not signed with the webhook secret of any app"
+	default:
+		delivery.Outcome = fmt.Sprintf("deploying %d app(s)", len(verified))
+	}
+
+	return verified
+}
+
+// pushedTo tells if the push is to the repo and branch an app is deployed from.
+func (server *GoRunnerWebServer) pushedTo(goapp *core.GoApp, push *webhook.Push) bool {
+	sameRepo := false
+	for _, u := range push.URLs {
+		if webhook.SameRepo(goapp.GitURL, u) {
+			sameRepo = true
+			break
+		}
+	}
+
+	if !sameRepo {
+		return false
+	}
+
+	branch := goapp.TrackedBranch()
+	if branch == "" {
+		branch = push.DefaultBranch
+	}
+
+	if branch == "" {
+		// a generic push doesn't tell the default branch of the repo
+		var err error
+		branch, err = goapp.DefaultBranch()
+		if err != nil {
+			server.logger.Warn().Err(err).Msgf("failed to get the default branch of the app. app=%s", goapp.Name)
+			return false
+		}
+	}
+
+	return branch == push.Branch
+}
+
+func (server *GoRunnerWebServer) deployFromHook(deliveryID int, goapp *core.GoApp) {
+	server.logger.Info().Msgf("deploying app from webhook... - app=%s, delivery=%d", goapp.Name, deliveryID)
+
+	// a push during a deploy is deployed after it
+	err := goapp.DeployCoalesced(goapp.Rebuild)
+	switch {
+	case err == nil:
+		server.hooks.Finish(deliveryID, goapp.Name, webhook.RESULT_DEPLOYED, nil)
+	case errors.Is(err, core.ErrNoChanges):
+		server.hooks.Finish(deliveryID, goapp.Name, webhook.RESULT_UNCHANGED, nil)
+	case errors.Is(err, core.ErrCoalesced):
+		server.hooks.Finish(deliveryID, goapp.Name, webhook.RESULT_COALESCED, nil)
+	default:
+		server.logger.Error().Err(err).Msgf("failed to deploy from webhook. app=%s, delivery=%d", goapp.Name, deliveryID)
+		server.hooks.Finish(deliveryID, goapp.Name, webhook.RESULT_FAILED, err)
+	}
+}
+
+func (server *GoRunnerWebServer) listDeliveries(c echo.Context) error {
+	return c.JSON(http.StatusOK, server.hooks.List())
+}
+
+func (server *GoRunnerWebServer) getDelivery(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errStatus{
+			nil, err,
+		})
+	}
+
+	delivery, ok := server.hooks.Get(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, errStatus{
+			nil, fmt.Errorf("delivery %d not found", id),
+		})
+	}
+
+	return c.JSON(http.StatusOK, delivery)
+}
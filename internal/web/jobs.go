@@ -0,0 +1,59 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/JackKCWong/go-runner/internal/core"
+	"github.com/labstack/echo/v4"
+)
+
+// listJobs lists the running builds, then the queued ones in the order they will run.
+func (server *GoRunnerWebServer) listJobs(c echo.Context) error {
+	return c.JSON(http.StatusOK, server.runner.Scheduler().Jobs())
+}
+
+func (server *GoRunnerWebServer) getJob(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, errStatus{
+			nil, core.ErrJobNotFound,
+		})
+	}
+
+	job, err := server.runner.Scheduler().Job(id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, errStatus{
+			nil, err,
+		})
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+// cancelJob takes a build off the queue, or stops it if it is running.
+func (server *GoRunnerWebServer) cancelJob(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, errStatus{
+			nil, core.ErrJobNotFound,
+		})
+	}
+
+	job, err := server.runner.Scheduler().Cancel(id)
+	switch {
+	case errors.Is(err, core.ErrJobNotFound):
+		return c.JSON(http.StatusNotFound, errStatus{
+			nil, err,
+		})
+	case errors.Is(err, core.ErrJobFinished):
+		return c.JSON(http.StatusConflict, errStatus{
+			nil, err,
+		})
+	}
+
+	server.logger.Info().Msgf("job canceled. job=%d, app=%s, kind=%s", job.ID, job.App, job.Kind)
+
+	return c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,202 @@
+package web
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/JackKCWong/go-runner/internal/core"
+	"github.com/JackKCWong/go-runner/internal/metrics"
+	"github.com/labstack/echo/v4"
+)
+
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// startedAt is about when the go-runner process started.
+var startedAt = time.Now()
+
+// serveMetrics writes the metrics of the apps, the proxy and go-runner itself in the Prometheus text format.
+func (server *GoRunnerWebServer) serveMetrics(c echo.Context) error {
+	apps := server.runner.ListApps()
+	sort.Slice(apps, func(i, j int) bool {
+		return apps[i].Name < apps[j].Name
+	})
+
+	snapshots := make(map[string]core.AppMetrics, len(apps))
+	for _, app := range apps {
+		snapshots[app.Name] = app.Metrics()
+	}
+
+	var buf bytes.Buffer
+	w := metrics.NewWriter(&buf)
+	writeAppMetrics(w, apps, snapshots)
+	writeRuntimeMetrics(w)
+	if w.Err() != nil {
+		return c.String(http.StatusInternalServerError, w.Err().Error())
+	}
+
+	return c.Blob(http.StatusOK, METRICS_CONTENT_TYPE, buf.Bytes())
+}
+
+func writeAppMetrics(w *metrics.Writer, apps []*core.GoApp, snapshots map[string]core.AppMetrics) {
+	each := func(f func(app string, m core.AppMetrics)) {
+		for _, app := range apps {
+			f(app.Name, snapshots[app.Name])
+		}
+	}
+
+	w.Header("gorunner_app_up", "Whether the app is running.", metrics.GAUGE)
+	each(func(app string, m core.AppMetrics) {
+		up := 0.0
+		if m.State == core.STATE_RUNNING {
+			up = 1
+		}
+
+		w.Sample("gorunner_app_up", metrics.Labels{{"app", app}}, up)
+	})
+
+	// every state has a series, so that the transitions don't make new ones
+	w.Header("gorunner_app_state", "The state the app is in, by state.", metrics.GAUGE)
+	each(func(app string, m core.AppMetrics) {
+		for _, state := range []core.State{
+			core.STATE_REGISTERED, core.STATE_CLONING, core.STATE_BUILDING, core.STATE_STARTING, core.STATE_RUNNING,
+			core.STATE_STOPPING, core.STATE_STOPPED, core.STATE_FAILED, core.STATE_CRASHED, core.STATE_DELETED,
+		} {
+			in := 0.0
+			if m.State == state {
+				in = 1
+			}
+
+			w.Sample("gorunner_app_state", metrics.Labels{{"app", app}, {"state", string(state)}}, in)
+		}
+	})
+
+	w.Header("gorunner_app_requests_total", "Requests proxied to the app, by status code.", metrics.COUNTER)
+	each(func(app string, m core.AppMetrics) {
+		codes := make([]int, 0, len(m.Requests))
+		for code := range m.Requests {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+
+		for _, code := range codes {
+			w.Sample("gorunner_app_requests_total", metrics.Labels{{"app", app}, {"code", strconv.Itoa(code)}}, float64(m.Requests[code]))
+		}
+	})
+
+	w.Header("gorunner_app_request_duration_seconds", "Latency of the requests proxied to the app.", metrics.HISTOGRAM)
+	each(func(app string, m core.AppMetrics) {
+		w.Histogram("gorunner_app_request_duration_seconds", metrics.Labels{{"app", app}}, m.RequestDuration)
+	})
+
+	w.Header("gorunner_app_request_bytes_total", "Bytes of the request bodies proxied to the app.", metrics.COUNTER)
+	each(func(app string, m core.AppMetrics) {
+		w.Sample("gorunner_app_request_bytes_total", metrics.Labels{{"app", app}}, float64(m.BytesIn))
+	})
+
+	w.Header("gorunner_app_response_bytes_total", "Bytes of the response bodies proxied from the app.", metrics.COUNTER)
+	each(func(app string, m core.AppMetrics) {
+		w.Sample("gorunner_app_response_bytes_total", metrics.Labels{{"app", app}}, float64(m.BytesOut))
+	})
+
+	w.Header("gorunner_app_restarts_total", "Restarts of the app.", metrics.COUNTER)
+	each(func(app string, m core.AppMetrics) {
+		w.Sample("gorunner_app_restarts_total", metrics.Labels{{"app", app}}, float64(m.Restarts))
+	})
+
+	w.Header("gorunner_build_duration_seconds", "Duration of the go builds of the app.", metrics.HISTOGRAM)
+	each(func(app string, m core.AppMetrics) {
+		w.Histogram("gorunner_build_duration_seconds", metrics.Labels{{"app", app}}, m.BuildDuration)
+	})
+
+	w.Header("gorunner_deploys_total", "Deploys of the app, by outcome.", metrics.COUNTER)
+	each(func(app string, m core.AppMetrics) {
+		for _, outcome := range []string{core.DEPLOY_SUCCEEDED, core.DEPLOY_UNCHANGED, core.DEPLOY_REJECTED, core.DEPLOY_FAILED} {
+			w.Sample("gorunner_deploys_total", metrics.Labels{{"app", app}, {"outcome", outcome}}, float64(m.Deploys[outcome]))
+		}
+	})
+
+	w.Header("gorunner_app_cpu_seconds_total", "User and system cpu time of the running process of the app.", metrics.COUNTER)
+	each(func(app string, m core.AppMetrics) {
+		if m.Proc != nil {
+			w.Sample("gorunner_app_cpu_seconds_total", metrics.Labels{{"app", app}}, m.Proc.CPUSeconds)
+		}
+	})
+
+	w.Header("gorunner_app_resident_memory_bytes", "Resident memory of the running process of the app.", metrics.GAUGE)
+	each(func(app string, m core.AppMetrics) {
+		if m.Proc != nil {
+			w.Sample("gorunner_app_resident_memory_bytes", metrics.Labels{{"app", app}}, float64(m.Proc.RSS))
+		}
+	})
+
+	w.Header("gorunner_app_log_lines_total", "Lines the app logged, by stream.", metrics.COUNTER)
+	each(func(app string, m core.AppMetrics) {
+		for _, stream := range []string{core.STREAM_STDOUT, core.STREAM_STDERR} {
+			w.Sample("gorunner_app_log_lines_total", metrics.Labels{{"app", app}, {"stream", stream}}, float64(m.LogLines[stream]))
+		}
+	})
+
+	w.Header("gorunner_app_log_lines_dropped_total", "Lines of the app dropped for clients streaming its logs too slowly, by stream.", metrics.COUNTER)
+	each(func(app string, m core.AppMetrics) {
+		for _, stream := range []string{core.STREAM_STDOUT, core.STREAM_STDERR} {
+			w.Sample("gorunner_app_log_lines_dropped_total", metrics.Labels{{"app", app}, {"stream", stream}}, float64(m.LogDropped[stream]))
+		}
+	})
+}
+
+// writeRuntimeMetrics writes the metrics of the go runtime and the process of go-runner itself.
+func writeRuntimeMetrics(w *metrics.Writer) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	w.Header("go_info", "Version of go that go-runner is built with.", metrics.GAUGE)
+	w.Sample("go_info", metrics.Labels{{"version", runtime.Version()}}, 1)
+	w.Header("go_goroutines", "Number of goroutines.", metrics.GAUGE)
+	w.Sample("go_goroutines", nil, float64(runtime.NumGoroutine()))
+
+	for _, m := range []struct {
+		name, help, metricType string
+		value                  float64
+	}{
+		{"go_memstats_alloc_bytes", "Bytes of allocated heap objects.", metrics.GAUGE, float64(mem.Alloc)},
+		{"go_memstats_alloc_bytes_total", "Bytes allocated for heap objects, including the freed ones.", metrics.COUNTER, float64(mem.TotalAlloc)},
+		{"go_memstats_sys_bytes", "Bytes of memory obtained from the OS.", metrics.GAUGE, float64(mem.Sys)},
+		{"go_memstats_mallocs_total", "Heap objects allocated.", metrics.COUNTER, float64(mem.Mallocs)},
+		{"go_memstats_frees_total", "Heap objects freed.", metrics.COUNTER, float64(mem.Frees)},
+		{"go_memstats_heap_alloc_bytes", "Bytes of allocated heap objects.", metrics.GAUGE, float64(mem.HeapAlloc)},
+		{"go_memstats_heap_inuse_bytes", "Bytes in in-use heap spans.", metrics.GAUGE, float64(mem.HeapInuse)},
+		{"go_memstats_heap_idle_bytes", "Bytes in idle heap spans.", metrics.GAUGE, float64(mem.HeapIdle)},
+		{"go_memstats_heap_objects", "Allocated heap objects.", metrics.GAUGE, float64(mem.HeapObjects)},
+		{"go_memstats_stack_inuse_bytes", "Bytes in stack spans.", metrics.GAUGE, float64(mem.StackInuse)},
+		{"go_memstats_next_gc_bytes", "Heap size of the next GC.", metrics.GAUGE, float64(mem.NextGC)},
+		{"go_memstats_last_gc_time_seconds", "Time of the last GC since the epoch.", metrics.GAUGE, float64(mem.LastGC) / 1e9},
+		{"go_gc_cycles_total", "Completed GC cycles.", metrics.COUNTER, float64(mem.NumGC)},
+		{"go_gc_pause_seconds_total", "Time the GC has stopped the world for.", metrics.COUNTER, float64(mem.PauseTotalNs) / 1e9},
+	} {
+		w.Header(m.name, m.help, m.metricType)
+		w.Sample(m.name, nil, m.value)
+	}
+
+	w.Header("process_start_time_seconds", "Start time of go-runner since the epoch.", metrics.GAUGE)
+	w.Sample("process_start_time_seconds", nil, float64(startedAt.Unix()))
+
+	stat, err := core.ReadProcStat(os.Getpid())
+	if err == nil {
+		w.Header("process_cpu_seconds_total", "User and system cpu time of go-runner.", metrics.COUNTER)
+		w.Sample("process_cpu_seconds_total", nil, stat.CPUSeconds)
+		w.Header("process_resident_memory_bytes", "Resident memory of go-runner.", metrics.GAUGE)
+		w.Sample("process_resident_memory_bytes", nil, float64(stat.RSS))
+	}
+
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err == nil {
+		w.Header("process_open_fds", "Open file descriptors of go-runner.", metrics.GAUGE)
+		w.Sample("process_open_fds", nil, float64(len(fds)))
+	}
+}
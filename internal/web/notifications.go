@@ -0,0 +1,124 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/JackKCWong/go-runner/internal/notify"
+	"github.com/go-playground/validator"
+	"github.com/labstack/echo/v4"
+)
+
+func (server *GoRunnerWebServer) listSinks(c echo.Context) error {
+	sinks, err := server.notifier.Sinks()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, errStatus{
+			nil, err,
+		})
+	}
+
+	return c.JSON(http.StatusOK, sinks)
+}
+
+// addSink saves a sink to send the events of the apps to.
+func (server *GoRunnerWebServer) addSink(c echo.Context) error {
+	params := new(AddSinkParams)
+	err := c.Bind(params)
+	if err != nil {
+		server.logger.Err(err).Msg("malformed request")
+		return c.JSON(http.StatusBadRequest, errStatus{
+			nil, err,
+		})
+	}
+
+	validate := validator.New()
+	err = validate.Struct(params)
+	if err != nil {
+		server.logger.Err(err).Msg("invalid request params")
+		return c.JSON(http.StatusBadRequest, errStatus{
+			nil, err,
+		})
+	}
+
+	sink, err := server.notifier.AddSink(notify.Sink{
+		Name:     params.Name,
+		Type:     params.Type,
+		URL:      params.URL,
+		Template: params.Template,
+		Apps:     params.Apps,
+		Events:   params.Events,
+	})
+	if err != nil {
+		server.logger.Err(err).Msgf("error adding notification sink. name=%s", params.Name)
+		return c.JSON(sinkErrStatus(err), errStatus{
+			nil, err,
+		})
+	}
+
+	server.logger.Info().Msgf("notification sink added. name=%s, type=%s", sink.Name, sink.Type)
+
+	return c.JSON(http.StatusCreated, sink)
+}
+
+func (server *GoRunnerWebServer) deleteSink(c echo.Context) error {
+	name := c.Param("name")
+	err := server.notifier.DeleteSink(name)
+	if err != nil {
+		return c.JSON(sinkErrStatus(err), errStatus{
+			nil, err,
+		})
+	}
+
+	server.logger.Info().Msgf("notification sink deleted. name=%s", name)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// testSink sends a test event to a sink once, and responds with the delivery.
+func (server *GoRunnerWebServer) testSink(c echo.Context) error {
+	delivery, err := server.notifier.Test(c.Param("name"))
+	if err != nil {
+		return c.JSON(sinkErrStatus(err), errStatus{
+			nil, err,
+		})
+	}
+
+	return c.JSON(http.StatusOK, delivery)
+}
+
+func (server *GoRunnerWebServer) listNotifications(c echo.Context) error {
+	return c.JSON(http.StatusOK, server.notifier.Deliveries())
+}
+
+func (server *GoRunnerWebServer) getNotification(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, errStatus{
+			nil, err,
+		})
+	}
+
+	delivery, ok := server.notifier.Delivery(id)
+	if !ok {
+		return c.JSON(http.StatusNotFound, errStatus{
+			nil, fmt.Errorf("delivery %d not found", id),
+		})
+	}
+
+	return c.JSON(http.StatusOK, delivery)
+}
+
+func sinkErrStatus(err error) int {
+	switch {
+	case errors.Is(err, notify.ErrSinkNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, notify.ErrSinkExists):
+		return http.StatusConflict
+	case errors.Is(err, notify.ErrInvalidSink):
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
@@ -2,6 +2,7 @@ package web
 
 import (
 	"fmt"
+	"github.com/JackKCWong/go-runner/internal/core"
 	"github.com/labstack/echo/v4"
 	"net/http"
 )
@@ -15,9 +16,9 @@ func (server *GoRunnerWebServer) proxyRequest(c echo.Context) error {
 		return c.String(http.StatusNotFound, fmt.Sprintf("%q", err))
 	}
 
-	if goapp.Status != "STARTED" {
-		server.logger.Debug().Msgf("app not started. - app=%s, status=%s", goapp.Name, goapp.Status)
-		return c.String(http.StatusInternalServerError, fmt.Sprintf("app not started. - app=%s, status=%s", goapp.Name, goapp.Status))
+	if state := goapp.State(); state != core.STATE_RUNNING {
+		server.logger.Debug().Msgf("app not running. - app=%s, state=%s", goapp.Name, state)
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("app not running. - app=%s, state=%s", goapp.Name, state))
 	}
 
 	request := c.Request()
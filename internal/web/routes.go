@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/JackKCWong/go-runner/internal/core"
 	"github.com/go-playground/validator"
 	"github.com/labstack/echo/v4"
 )
@@ -13,11 +15,38 @@ func (server *GoRunnerWebServer) setRoutes() {
 	// general api
 	server.echo.POST("/api/apps", server.registerApp)
 	server.echo.GET("/api/health", server.health)
+	server.echo.GET("/api/credentials", server.listCredentials)
+	server.echo.POST("/api/credentials", server.addCredential)
+	server.echo.GET("/api/credentials/:name", server.getCredential)
+	server.echo.DELETE("/api/credentials/:name", server.deleteCredential)
+	server.echo.POST("/api/hooks/:provider", server.receiveHook)
+	server.echo.GET("/api/hooks/deliveries", server.listDeliveries)
+	server.echo.GET("/api/hooks/deliveries/:id", server.getDelivery)
+	server.echo.GET("/api/goenv", server.goEnv)
+	server.echo.POST("/api/goenv/gc", server.collectGoEnv)
+	server.echo.GET("/api/jobs", server.listJobs)
+	server.echo.GET("/api/jobs/:id", server.getJob)
+	server.echo.DELETE("/api/jobs/:id", server.cancelJob)
+	server.echo.GET("/api/events", server.listEvents)
+	server.echo.GET("/api/notifications", server.listSinks)
+	server.echo.POST("/api/notifications", server.addSink)
+	server.echo.DELETE("/api/notifications/:name", server.deleteSink)
+	server.echo.POST("/api/notifications/:name/test", server.testSink)
+	server.echo.GET("/api/notifications/deliveries", server.listNotifications)
+	server.echo.GET("/api/notifications/deliveries/:id", server.getNotification)
+	server.echo.GET("/metrics", server.serveMetrics)
 
 	// per app api
 	server.echo.GET("/api/:app", server.appStatus)
 	server.echo.GET("/api/:app/stdout", server.appStdout)
 	server.echo.GET("/api/:app/stderr", server.appStderr)
+	server.echo.GET("/api/:app/releases", server.appReleases)
+	server.echo.GET("/api/:app/events", server.appEvents)
+	server.echo.GET("/api/:app/usage", server.appUsage)
+	server.echo.GET("/api/:app/config", server.appConfig)
+	server.echo.PUT("/api/:app/config", server.updateAppConfig)
+	server.echo.POST("/api/:app/source", server.uploadSource)
+	server.echo.POST("/api/:app/artifact", server.uploadArtifact)
 	server.echo.PUT("/api/:app", server.updateApp)
 	server.echo.DELETE("/api/:app", server.deleteApp)
 
@@ -34,8 +63,14 @@ func (server *GoRunnerWebServer) deleteApp(c echo.Context) error {
 		})
 	}
 
-	app.Stop()
-	err = app.Purge()
+	// waits for the deploy in progress, if any
+	err = app.Delete()
+	if errors.Is(err, core.ErrDeleted) {
+		return c.JSON(http.StatusNotFound, errStatus{
+			nil, err,
+		})
+	}
+
 	server.runner.DeleteApp(appName)
 
 	if err != nil {
@@ -59,6 +94,129 @@ func (server *GoRunnerWebServer) appStatus(c echo.Context) error {
 	return c.JSON(http.StatusOK, goapp)
 }
 
+func (server *GoRunnerWebServer) appReleases(c echo.Context) error {
+	appName := c.Param("app")
+	goapp, err := server.runner.GetApp(appName)
+	if err != nil {
+		return c.String(http.StatusNotFound, fmt.Sprintf("%q", err))
+	}
+
+	return c.JSON(http.StatusOK, goapp.Releases())
+}
+
+func (server *GoRunnerWebServer) appEvents(c echo.Context) error {
+	appName := c.Param("app")
+	goapp, err := server.runner.GetApp(appName)
+	if err != nil {
+		return c.String(http.StatusNotFound, fmt.Sprintf("%q", err))
+	}
+
+	return c.JSON(http.StatusOK, goapp.Events())
+}
+
+// appUsage responds with the resource usage of an app over the duration given by ?since=, the last hour by default.
+func (server *GoRunnerWebServer) appUsage(c echo.Context) error {
+	appName := c.Param("app")
+	goapp, err := server.runner.GetApp(appName)
+	if err != nil {
+		return c.String(http.StatusNotFound, fmt.Sprintf("%q", err))
+	}
+
+	since := time.Hour
+	if s := c.QueryParam("since"); s != "" {
+		since, err = time.ParseDuration(s)
+		if err != nil || since <= 0 {
+			return c.JSON(http.StatusBadRequest, errStatus{
+				nil, fmt.Errorf("invalid since, expected a duration like 30m: %q", s),
+			})
+		}
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		Current *core.Usage       `json:"current"`
+		Samples []core.Usage      `json:"samples"`
+		Cgroup  *core.CgroupUsage `json:"cgroup,omitempty"`
+	}{
+		goapp.Usage(),
+		goapp.UsageSince(time.Now().Add(-since)),
+		goapp.CgroupUsage(),
+	})
+}
+
+func (server *GoRunnerWebServer) appConfig(c echo.Context) error {
+	appName := c.Param("app")
+	goapp, err := server.runner.GetApp(appName)
+	if err != nil {
+		return c.String(http.StatusNotFound, fmt.Sprintf("%q", err))
+	}
+
+	return c.JSON(http.StatusOK, goapp.Config())
+}
+
+// updateAppConfig replaces the config of an app. It takes effect on the next deploy.
+func (server *GoRunnerWebServer) updateAppConfig(c echo.Context) error {
+	appName := c.Param("app")
+	goapp, err := server.runner.GetApp(appName)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, errStatus{
+			nil, err,
+		})
+	}
+
+	config := core.AppConfig{}
+	err = c.Bind(&config)
+	if err != nil {
+		server.logger.Err(err).Msg("malformed request")
+		return c.JSON(http.StatusBadRequest, errStatus{
+			goapp, err,
+		})
+	}
+
+	err = goapp.SetConfig(config)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, core.ErrInvalidConfig) {
+			status = http.StatusBadRequest
+		}
+
+		return c.JSON(status, errStatus{
+			goapp, err,
+		})
+	}
+
+	server.logger.Info().Msgf("app config updated. app=%s", appName)
+
+	return c.JSON(http.StatusOK, goapp.Config())
+}
+
+// uploadSource deploys a gzipped tarball of source code. The app is created if it doesn't exist yet.
+func (server *GoRunnerWebServer) uploadSource(c echo.Context) error {
+	return server.uploadRelease(c, server.deploySource)
+}
+
+// uploadArtifact deploys a prebuilt binary or a tarball of it. The app is created if it doesn't exist yet.
+func (server *GoRunnerWebServer) uploadArtifact(c echo.Context) error {
+	return server.uploadRelease(c, server.deployArtifact)
+}
+
+func (server *GoRunnerWebServer) uploadRelease(c echo.Context, deploy func(echo.Context, *core.GoApp) error) error {
+	appName := c.Param("app")
+	goapp, _ := server.runner.GetApp(appName)
+	if goapp == nil {
+		var err error
+		server.logger.Info().Msgf("registering app from upload... - app=%s", appName)
+		goapp, err = server.runner.NewApp(appName, "")
+		if err != nil {
+			server.logger.Err(err).Msgf("error registering app. - app=%s", appName)
+			return c.JSON(http.StatusInternalServerError, errStatus{
+				goapp, err,
+			})
+		}
+	}
+
+	return deploy(c, goapp)
+}
+
 func (server *GoRunnerWebServer) registerApp(c echo.Context) error {
 	server.logger.Info().Msg("new app...")
 	params := new(DeployAppParams)
@@ -85,14 +243,35 @@ func (server *GoRunnerWebServer) registerApp(c echo.Context) error {
 		goapp, err = server.runner.NewApp(params.App, params.GitUrl)
 		if err != nil {
 			server.logger.Err(err).Msgf("error registering app. - app=%s, gitUrl=%s", params.App, params.GitUrl)
-			return c.JSON(http.StatusInternalServerError, errStatus{
+			status := http.StatusInternalServerError
+			if errors.Is(err, core.ErrBusy) {
+				status = http.StatusConflict
+			}
+
+			return c.JSON(status, errStatus{
 				goapp, err,
 			})
 		}
+
+		err = goapp.SetConfig(core.AppConfig{
+			Subdir:       params.Subdir,
+			Submodules:   params.Submodules,
+			PollInterval: params.PollInterval,
+		})
+		if err != nil {
+			server.runner.DeleteApp(params.App)
+			return c.JSON(http.StatusBadRequest, errStatus{
+				nil, err,
+			})
+		}
 	} else {
 		server.logger.Info().Msgf("app already exist... - app=%s, gitUrl=%s", goapp.Name, goapp.GitURL)
 	}
 
+	if params.Branch != "" {
+		goapp.SetBranch(params.Branch)
+	}
+
 	return server.deployApp(c, goapp)
 }
 
@@ -126,13 +305,19 @@ func (server *GoRunnerWebServer) updateApp(c echo.Context) error {
 
 	switch params.Action {
 	case "deploy":
+		if params.Branch != "" {
+			app.SetBranch(params.Branch)
+		}
+
 		return server.deployApp(c, app)
 	case "restart":
 		return server.restartApp(c, app)
+	case "rollback":
+		return server.rollbackApp(c, app, params.Release)
 	}
 
 	err = errors.New("unknown command")
-	server.logger.Err(err).Msgf("expected: deploy|restart. action=%s", params.Action)
+	server.logger.Err(err).Msgf("expected: deploy|restart|rollback. action=%s", params.Action)
 	return c.JSON(http.StatusInternalServerError, errStatus{
 		nil, err,
 	})
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/JackKCWong/go-runner/internal/core"
+	"github.com/JackKCWong/go-runner/internal/notify"
+	"github.com/JackKCWong/go-runner/internal/webhook"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/rs/zerolog"
@@ -21,14 +23,20 @@ import (
 type GoRunnerWebServer struct {
 	_ struct{}
 	sync.Mutex
-	echo   *echo.Echo
-	runner *core.GoRunner
-	status string
-	wd     string
-	logger *zerolog.Logger
+	echo     *echo.Echo
+	runner   *core.GoRunner
+	status   string
+	wd       string
+	hooks    *webhook.Deliveries
+	notifier *notify.Notifier
+	logger   *zerolog.Logger
 }
 
 func NewGoRunnerServer(wd string) *GoRunnerWebServer {
+	return NewGoRunnerServerWithOptions(wd, core.Options{})
+}
+
+func NewGoRunnerServerWithOptions(wd string, opts core.Options) *GoRunnerWebServer {
 	e := echo.New()
 	e.HideBanner = true
 	lechologger := lecho.From(log.Logger)
@@ -40,12 +48,16 @@ func NewGoRunnerServer(wd string) *GoRunnerWebServer {
 		RequestIDKey: "",
 	}))
 
+	runner := core.NewGoRunnerWithOptions(wd, opts)
+
 	return &GoRunnerWebServer{
-		echo:   e,
-		status: "NEW",
-		wd:     wd,
-		runner: core.NewGoRunner(wd),
-		logger: &log.Logger,
+		echo:     e,
+		status:   "NEW",
+		wd:       wd,
+		runner:   runner,
+		hooks:    webhook.NewDeliveries(DELIVERIES_TO_KEEP),
+		notifier: notify.NewNotifier(wd, runner.Events(), &log.Logger),
+		logger:   &log.Logger,
 	}
 }
 
@@ -54,6 +66,9 @@ func (server *GoRunnerWebServer) Bootsrap(addr string) error {
 	server.Lock()
 	defer server.Unlock()
 
+	// before the apps come back, so that the sinks are notified of their events
+	server.notifier.Start()
+
 	server.logger.Info().Msg("rehydrating apps...")
 	err := server.runner.Rehydrate()
 	if err != nil {
@@ -94,6 +109,8 @@ func (server *GoRunnerWebServer) Stop(c context.Context) error {
 		server.logger.Info().Err(err).Msg("error during shutdown go-runner")
 	}
 
+	server.notifier.Stop()
+
 	server.logger.Info().Msg("shutting down web server...")
 	err = server.echo.Shutdown(c)
 	if err != nil {
@@ -115,13 +132,14 @@ func (server *GoRunnerWebServer) MarshalJSON() ([]byte, error) {
 	var mem runtime.MemStats
 	runtime.ReadMemStats(&mem)
 	return json.Marshal(struct {
-		Status   string        `json:"status"`
-		Wd       string        `json:"workding_dir"`
-		Addr     net.Addr      `json:"addr"`
-		MemAlloc uint64        `json:"memAllocated"`
-		MemSys   uint64        `json:"memSys"`
-		Apps     []*core.GoApp `json:"apps,omitempty"`
-		NoApps   int           `json:"no_of_apps"`
+		Status   string             `json:"status"`
+		Wd       string             `json:"workding_dir"`
+		Addr     net.Addr           `json:"addr"`
+		MemAlloc uint64             `json:"memAllocated"`
+		MemSys   uint64             `json:"memSys"`
+		Apps     []*core.GoApp      `json:"apps,omitempty"`
+		NoApps   int                `json:"no_of_apps"`
+		Cgroups  core.CgroupsStatus `json:"cgroups"`
 	}{
 		server.status,
 		server.wd,
@@ -130,6 +148,7 @@ func (server *GoRunnerWebServer) MarshalJSON() ([]byte, error) {
 		mem.Sys / mb,
 		apps,
 		len(apps),
+		server.runner.Cgroups().Status(),
 	})
 }
 
@@ -22,7 +22,13 @@ import (
 
 var tempDir string
 
-func init() {
+// TestMain clones the apps the tests deploy. Run to launch an app, it stands in for go-runner instead, which
+// launches every app, as no_new_privs is always set, and it does so before the clones.
+func TestMain(m *testing.M) {
+	if core.IsLaunch() {
+		core.Launch()
+	}
+
 	var err error
 	tempDir, err = os.MkdirTemp(os.TempDir(), "go-runner-test")
 	if err != nil {
@@ -57,6 +63,8 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+
+	os.Exit(m.Run())
 }
 
 func getExampleRepo(name string) string {
@@ -191,7 +199,7 @@ func statusIsStarted(url string) func() bool {
 			}
 
 			status := struct {
-				Status string
+				State string
 			}{}
 			err = json.Unmarshal(body, &status)
 			if err != nil {
@@ -199,7 +207,7 @@ func statusIsStarted(url string) func() bool {
 				return false
 			}
 
-			if status.Status == "STARTED" {
+			if status.State == "running" {
 				return true
 			}
 		}
@@ -10,11 +10,43 @@ type (
 	DeployAppParams struct {
 		App    string `param:"app" json:"app" form:"app" validate:"required"`
 		GitUrl string `param:"gitUrl" json:"gitUrl" form:"gitUrl" validate:"required"`
+		Branch string `param:"branch" json:"branch,omitempty" form:"branch"`
+		// Subdir of the app in a monorepo
+		Subdir     string `param:"subdir" json:"subdir,omitempty" form:"subdir"`
+		Submodules bool   `param:"submodules" json:"submodules,omitempty" form:"submodules"`
+		// PollInterval to check the branch for new commits, e.g. 1m
+		PollInterval string `param:"pollInterval" json:"pollInterval,omitempty" form:"pollInterval"`
 	}
 
 	UpdateAppParams struct {
 		App    string `param:"app" json:"app" form:"app" validate:"required"`
 		Action string `param:"action" json:"action" form:"action" validate:"required"`
+		Branch string `param:"branch" json:"branch,omitempty" form:"branch"`
+		// Release to roll back to, default to the one before the current
+		Release int `param:"release" json:"release,omitempty" form:"release"`
+	}
+
+	AddCredentialParams struct {
+		Name string `json:"name" form:"name" validate:"required"`
+		Type string `json:"type" form:"type" validate:"required,oneof=ssh token webhook"`
+		// App or Host the credential is for
+		App        string `json:"app,omitempty" form:"app"`
+		Host       string `json:"host,omitempty" form:"host"`
+		Username   string `json:"username,omitempty" form:"username"`
+		PrivateKey string `json:"privateKey,omitempty" form:"privateKey"`
+		Passphrase string `json:"passphrase,omitempty" form:"passphrase"`
+		Token      string `json:"token,omitempty" form:"token"`
+	}
+
+	AddSinkParams struct {
+		Name string `json:"name" form:"name" validate:"required"`
+		// Type is webhook or slack. Exec sinks are only configured on the server, see notify.EXEC_SINKS_FILE.
+		Type string `json:"type" form:"type" validate:"required,oneof=webhook slack"`
+		// URL of a webhook or slack sink
+		URL      string   `json:"url,omitempty" form:"url"`
+		Template string   `json:"template,omitempty" form:"template"`
+		Apps     []string `json:"apps,omitempty" form:"apps"`
+		Events   []string `json:"events,omitempty" form:"events"`
 	}
 
 	errStatus struct {
@@ -0,0 +1,128 @@
+package webhook
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	RESULT_DEPLOYING = "deploying"
+	RESULT_DEPLOYED  = "deployed"
+	RESULT_UNCHANGED = "unchanged"
+	// RESULT_COALESCED is for a push left to the deploy of a later push
+	RESULT_COALESCED = "coalesced"
+	RESULT_FAILED    = "failed"
+	RESULT_REJECTED  = "rejected"
+)
+
+// Delivery is a webhook call go-runner received, and what came out of it.
+type Delivery struct {
+	ID         int          `json:"id"`
+	Provider   string       `json:"provider"`
+	Event      string       `json:"event"`
+	ReceivedAt time.Time    `json:"receivedAt"`
+	URL        string       `json:"url,omitempty"`
+	Branch     string       `json:"branch,omitempty"`
+	Commit     string       `json:"commit,omitempty"`
+	Outcome    string       `json:"outcome"`
+	Apps       []AppOutcome `json:"apps,omitempty"`
+}
+
+// AppOutcome is what a delivery did to an app it matched.
+type AppOutcome struct {
+	App        string     `json:"app"`
+	Result     string     `json:"result"`
+	Error      string     `json:"error,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Deliveries keeps the last deliveries in memory.
+type Deliveries struct {
+	sync.Mutex
+	seq  int
+	max  int
+	list []*Delivery
+}
+
+func NewDeliveries(max int) *Deliveries {
+	return &Deliveries{max: max}
+}
+
+// Add records d with a new id, dropping the oldest delivery if there are too many.
+func (ds *Deliveries) Add(d Delivery) Delivery {
+	ds.Lock()
+	defer ds.Unlock()
+
+	ds.seq++
+	d.ID = ds.seq
+	ds.list = append(ds.list, &d)
+	if len(ds.list) > ds.max {
+		ds.list = ds.list[len(ds.list)-ds.max:]
+	}
+
+	return d.copy()
+}
+
+// List returns the deliveries, newest first.
+func (ds *Deliveries) List() []Delivery {
+	ds.Lock()
+	defer ds.Unlock()
+
+	list := make([]Delivery, 0, len(ds.list))
+	for i := len(ds.list) - 1; i >= 0; i-- {
+		list = append(list, ds.list[i].copy())
+	}
+
+	return list
+}
+
+func (ds *Deliveries) Get(id int) (Delivery, bool) {
+	ds.Lock()
+	defer ds.Unlock()
+
+	d := ds.find(id)
+	if d == nil {
+		return Delivery{}, false
+	}
+
+	return d.copy(), true
+}
+
+// Finish records the result of the deploy of an app a delivery triggered.
+func (ds *Deliveries) Finish(id int, app, result string, err error) {
+	ds.Lock()
+	defer ds.Unlock()
+
+	d := ds.find(id)
+	if d == nil {
+		return
+	}
+
+	now := time.Now()
+	for i := range d.Apps {
+		if d.Apps[i].App == app {
+			d.Apps[i].Result = result
+			d.Apps[i].FinishedAt = &now
+			if err != nil {
+				d.Apps[i].Error = err.Error()
+			}
+		}
+	}
+}
+
+func (ds *Deliveries) find(id int) *Delivery {
+	for _, d := range ds.list {
+		if d.ID == id {
+			return d
+		}
+	}
+
+	return nil
+}
+
+func (d *Delivery) copy() Delivery {
+	c := *d
+	c.Apps = append([]AppOutcome(nil), d.Apps...)
+
+	return c
+}
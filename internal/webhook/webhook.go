@@ -0,0 +1,283 @@
+// Package webhook reads the push events git servers send, so apps can be deployed on push.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	PROVIDER_GITHUB  = "github"
+	PROVIDER_GITLAB  = "gitlab"
+	PROVIDER_GITEA   = "gitea"
+	PROVIDER_GENERIC = "generic"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown webhook provider")
+	ErrBadSignature    = errors.New("bad webhook signature")
+)
+
+const zeroCommit = "0000000000000000000000000000000000000000"
+
+// Push is a push event, whichever provider it comes from.
+type Push struct {
+	// Event is the type of the event as told by the provider
+	Event string
+	// URLs are the urls of the repo pushed to
+	URLs []string
+	// Branch is "" if the event is not a push to a branch
+	Branch        string
+	DefaultBranch string
+	Commit        string
+	Deleted       bool
+}
+
+// Parse reads a push event from a delivery. Other events come back with no Branch.
+func Parse(provider string, header http.Header, body []byte) (*Push, error) {
+	switch provider {
+	case PROVIDER_GITHUB:
+		return parseGitHub(header, body)
+	case PROVIDER_GITLAB:
+		return parseGitLab(header, body)
+	case PROVIDER_GITEA:
+		return parseGitea(header, body)
+	case PROVIDER_GENERIC:
+		return parseGeneric(body)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, provider)
+}
+
+// Verify checks the delivery is signed with secret, the way the provider does it:
+// an HMAC-SHA256 of the body for GitHub, Gitea and generic, or the secret token itself for GitLab.
+func Verify(provider string, header http.Header, body []byte, secret string) error {
+	if secret == "" {
+		return fmt.Errorf("%w: no secret", ErrBadSignature)
+	}
+
+	switch provider {
+	case PROVIDER_GITHUB, PROVIDER_GENERIC:
+		return verifyHMAC(strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256="), body, secret)
+	case PROVIDER_GITEA:
+		return verifyHMAC(header.Get("X-Gitea-Signature"), body, secret)
+	case PROVIDER_GITLAB:
+		if subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+			return fmt.Errorf("%w: X-Gitlab-Token mismatch", ErrBadSignature)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrUnknownProvider, provider)
+}
+
+// Sign returns the hex HMAC-SHA256 of body, as expected in X-Hub-Signature-256 without the "sha256=" prefix.
+func Sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifyHMAC(signature string, body []byte, secret string) error {
+	if signature == "" {
+		return fmt.Errorf("%w: not signed", ErrBadSignature)
+	}
+
+	actual, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBadSignature, err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(actual, mac.Sum(nil)) {
+		return fmt.Errorf("%w: HMAC mismatch", ErrBadSignature)
+	}
+
+	return nil
+}
+
+// SameRepo tells if two git urls point to the same repo. Scheme, user, port, case and the .git suffix don't matter,
+// so the https and ssh urls of a repo are the same.
+func SameRepo(a, b string) bool {
+	return a != "" && b != "" && normalizeURL(a) == normalizeURL(b)
+}
+
+func normalizeURL(gitURL string) string {
+	u := strings.TrimSpace(gitURL)
+	u = strings.TrimSuffix(strings.TrimSuffix(u, "/"), ".git")
+
+	var host, p string
+	if strings.Contains(u, "://") {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return strings.ToLower(u)
+		}
+
+		host, p = parsed.Hostname(), parsed.Path
+	} else if i := strings.Index(u, ":"); i > 0 && !strings.Contains(u[:i], "/") {
+		// scp-like, user@host:path
+		host, p = u[:i], u[i+1:]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+	} else {
+		// local path
+		return u
+	}
+
+	return strings.ToLower(host + "/" + strings.Trim(p, "/"))
+}
+
+func branchOf(ref string) string {
+	if strings.HasPrefix(ref, "refs/heads/") {
+		return strings.TrimPrefix(ref, "refs/heads/")
+	}
+
+	return ""
+}
+
+type gitHubPush struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		CloneURL      string `json:"clone_url"`
+		SSHURL        string `json:"ssh_url"`
+		GitURL        string `json:"git_url"`
+		HTMLURL       string `json:"html_url"`
+		DefaultBranch string `json:"default_branch"`
+	} `json:"repository"`
+}
+
+func (p gitHubPush) push(event string) *Push {
+	r := p.Repository
+	return &Push{
+		Event:         event,
+		URLs:          nonEmpty(r.CloneURL, r.SSHURL, r.GitURL, r.HTMLURL),
+		Branch:        branchOf(p.Ref),
+		DefaultBranch: r.DefaultBranch,
+		Commit:        p.After,
+		Deleted:       p.Deleted || p.After == zeroCommit,
+	}
+}
+
+func parseGitHub(header http.Header, body []byte) (*Push, error) {
+	event := header.Get("X-GitHub-Event")
+	if event != "push" {
+		return &Push{Event: event}, nil
+	}
+
+	var p gitHubPush
+	err := json.Unmarshal(body, &p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid github push payload: %w", err)
+	}
+
+	return p.push(event), nil
+}
+
+func parseGitea(header http.Header, body []byte) (*Push, error) {
+	event := header.Get("X-Gitea-Event")
+	if event != "push" {
+		return &Push{Event: event}, nil
+	}
+
+	// same as github, as far as a push goes
+	var p gitHubPush
+	err := json.Unmarshal(body, &p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gitea push payload: %w", err)
+	}
+
+	return p.push(event), nil
+}
+
+func parseGitLab(header http.Header, body []byte) (*Push, error) {
+	event := header.Get("X-Gitlab-Event")
+	if event != "Push Hook" {
+		return &Push{Event: event}, nil
+	}
+
+	var p struct {
+		Ref     string `json:"ref"`
+		After   string `json:"after"`
+		Project struct {
+			GitHTTPURL    string `json:"git_http_url"`
+			GitSSHURL     string `json:"git_ssh_url"`
+			WebURL        string `json:"web_url"`
+			DefaultBranch string `json:"default_branch"`
+		} `json:"project"`
+		Repository struct {
+			GitHTTPURL string `json:"git_http_url"`
+			GitSSHURL  string `json:"git_ssh_url"`
+		} `json:"repository"`
+	}
+
+	err := json.Unmarshal(body, &p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gitlab push payload: %w", err)
+	}
+
+	return &Push{
+		Event: event,
+		URLs: nonEmpty(p.Project.GitHTTPURL, p.Project.GitSSHURL, p.Project.WebURL,
+			p.Repository.GitHTTPURL, p.Repository.GitSSHURL),
+		Branch:        branchOf(p.Ref),
+		DefaultBranch: p.Project.DefaultBranch,
+		Commit:        p.After,
+		Deleted:       p.After == zeroCommit,
+	}, nil
+}
+
+// parseGeneric reads {"gitUrl": "...", "branch": "...", "commit": "..."}. ref may be given instead of branch.
+func parseGeneric(body []byte) (*Push, error) {
+	var p struct {
+		GitURL string `json:"gitUrl"`
+		Branch string `json:"branch"`
+		Ref    string `json:"ref"`
+		Commit string `json:"commit"`
+	}
+
+	err := json.Unmarshal(body, &p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid generic push payload: %w", err)
+	}
+
+	if p.GitURL == "" {
+		return nil, errors.New("invalid generic push payload: gitUrl is required")
+	}
+
+	branch := p.Branch
+	if branch == "" {
+		branch = branchOf(p.Ref)
+	}
+
+	return &Push{
+		Event:  "push",
+		URLs:   []string{p.GitURL},
+		Branch: branch,
+		Commit: p.Commit,
+	}, nil
+}
+
+func nonEmpty(values ...string) []string {
+	var list []string
+	for _, v := range values {
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
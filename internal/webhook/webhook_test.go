@@ -0,0 +1,175 @@
+package webhook
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"testing"
+
+	"github.com/JackKCWong/go-runner/internal/util"
+)
+
+const secret = "It's a Secret to Everybody"
+
+func fixture(t *testing.T, name string) []byte {
+	body, err := ioutil.ReadFile(path.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body
+}
+
+func TestParsePushes(t *testing.T) {
+	for _, tc := range []struct {
+		provider string
+		fixture  string
+		header   http.Header
+		push     Push
+	}{
+		{
+			PROVIDER_GITHUB, "github_push.json",
+			http.Header{"X-Github-Event": {"push"}},
+			Push{
+				Event: "push",
+				URLs: []string{
+					"https://github.com/JackKCWong/go-runner-hello-world.git",
+					"git@github.com:JackKCWong/go-runner-hello-world.git",
+					"git://github.com/JackKCWong/go-runner-hello-world.git",
+					"https://github.com/JackKCWong/go-runner-hello-world",
+				},
+				Branch:        "main",
+				DefaultBranch: "main",
+				Commit:        "59b20b8d5c6ff8d09518454d4dd8b7b30f095ab5",
+			},
+		},
+		{
+			PROVIDER_GITHUB, "github_ping.json",
+			http.Header{"X-Github-Event": {"ping"}},
+			Push{Event: "ping"},
+		},
+		{
+			PROVIDER_GITLAB, "gitlab_push.json",
+			http.Header{"X-Gitlab-Event": {"Push Hook"}},
+			Push{
+				Event: "Push Hook",
+				URLs: []string{
+					"http://gitlab.example.com/mike/diaspora.git",
+					"git@gitlab.example.com:mike/diaspora.git",
+					"http://gitlab.example.com/mike/diaspora",
+					"http://gitlab.example.com/mike/diaspora.git",
+					"git@gitlab.example.com:mike/diaspora.git",
+				},
+				Branch:        "develop",
+				DefaultBranch: "master",
+				Commit:        "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+			},
+		},
+		{
+			PROVIDER_GITEA, "gitea_push.json",
+			http.Header{"X-Gitea-Event": {"push"}},
+			Push{
+				Event: "push",
+				URLs: []string{
+					"https://gitea.example.com/ops/inventory.git",
+					"ssh://git@gitea.example.com:2222/ops/inventory.git",
+					"https://gitea.example.com/ops/inventory",
+				},
+				Branch:        "main",
+				DefaultBranch: "main",
+				Commit:        "bffeb74224043ba2feb48d137756c8a9331c449a",
+			},
+		},
+		{
+			PROVIDER_GENERIC, "generic_push.json",
+			http.Header{},
+			Push{
+				Event:  "push",
+				URLs:   []string{"ssh://git@git.internal.example.com/platform/billing.git"},
+				Branch: "release",
+				Commit: "0a2f6b1e5c3d4e7f8a9b0c1d2e3f4a5b6c7d8e9f",
+			},
+		},
+	} {
+		t.Run(tc.fixture, func(t *testing.T) {
+			expect := util.NewExpect(t)
+			push, err := Parse(tc.provider, tc.header, fixture(t, tc.fixture))
+			expect.Nil(err)
+			expect.Equal(tc.push, *push)
+		})
+	}
+
+	_, err := Parse("bitbucket", http.Header{}, []byte("{}"))
+	util.NewExpect(t).True(errors.Is(err, ErrUnknownProvider))
+}
+
+func TestVerifySignatures(t *testing.T) {
+	expect := util.NewExpect(t)
+	body := fixture(t, "github_push.json")
+	signature := Sign(body, secret)
+
+	github := http.Header{"X-Hub-Signature-256": {"sha256=" + signature}}
+	expect.Nil(Verify(PROVIDER_GITHUB, github, body, secret))
+	expect.Nil(Verify(PROVIDER_GENERIC, github, body, secret))
+	expect.True(errors.Is(Verify(PROVIDER_GITHUB, github, body, "another secret"), ErrBadSignature))
+	expect.True(errors.Is(Verify(PROVIDER_GITHUB, github, append(body, ' '), secret), ErrBadSignature))
+	expect.True(errors.Is(Verify(PROVIDER_GITHUB, http.Header{}, body, secret), ErrBadSignature))
+	expect.True(errors.Is(Verify(PROVIDER_GITHUB, github, body, ""), ErrBadSignature))
+
+	gitea := http.Header{"X-Gitea-Signature": {signature}}
+	expect.Nil(Verify(PROVIDER_GITEA, gitea, body, secret))
+	expect.True(errors.Is(Verify(PROVIDER_GITEA, github, body, secret), ErrBadSignature))
+
+	gitlab := http.Header{"X-Gitlab-Token": {secret}}
+	expect.Nil(Verify(PROVIDER_GITLAB, gitlab, body, secret))
+	expect.True(errors.Is(Verify(PROVIDER_GITLAB, gitlab, body, "another secret"), ErrBadSignature))
+}
+
+func TestSameRepo(t *testing.T) {
+	expect := util.NewExpect(t)
+
+	for _, same := range [][2]string{
+		{"https://github.com/JackKCWong/go-runner.git", "git@github.com:JackKCWong/go-runner.git"},
+		{"https://github.com/JackKCWong/go-runner", "ssh://git@github.com/jackkcwong/go-runner.git"},
+		{"ssh://git@gitea.example.com:2222/ops/inventory.git", "https://gitea.example.com/ops/inventory/"},
+		{"/srv/git/hello.git", "/srv/git/hello"},
+	} {
+		expect.True(SameRepo(same[0], same[1]), same)
+	}
+
+	for _, different := range [][2]string{
+		{"https://github.com/JackKCWong/go-runner.git", "https://github.com/JackKCWong/go-runner-hello-world.git"},
+		{"https://github.com/JackKCWong/go-runner.git", "https://gitlab.com/JackKCWong/go-runner.git"},
+		{"", ""},
+	} {
+		expect.True(!SameRepo(different[0], different[1]), different)
+	}
+}
+
+func TestDeliveriesKeepTheLatest(t *testing.T) {
+	expect := util.NewExpect(t)
+	ds := NewDeliveries(2)
+
+	first := ds.Add(Delivery{Provider: PROVIDER_GITHUB, Apps: []AppOutcome{{App: "hello", Result: RESULT_DEPLOYING}}})
+	expect.Equal(1, first.ID)
+
+	ds.Finish(first.ID, "hello", RESULT_FAILED, errors.New("boom"))
+	d, ok := ds.Get(first.ID)
+	expect.True(ok)
+	expect.Equal(RESULT_FAILED, d.Apps[0].Result)
+	expect.Equal("boom", d.Apps[0].Error)
+	expect.True(d.Apps[0].FinishedAt != nil)
+	expect.Equal(RESULT_DEPLOYING, first.Apps[0].Result)
+
+	ds.Add(Delivery{Provider: PROVIDER_GITLAB})
+	ds.Add(Delivery{Provider: PROVIDER_GITEA})
+
+	_, ok = ds.Get(first.ID)
+	expect.True(!ok)
+
+	list := ds.List()
+	expect.Equal(2, len(list))
+	expect.Equal(3, list[0].ID)
+	expect.Equal(PROVIDER_GITEA, list[0].Provider)
+}
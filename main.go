@@ -9,12 +9,18 @@ import (
 	"sync"
 	"time"
 
+	"github.com/JackKCWong/go-runner/internal/core"
 	"github.com/JackKCWong/go-runner/internal/web"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
 func main() {
+	if core.IsLaunch() {
+		// re-executed to set up the process of an app
+		core.Launch()
+	}
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		return
@@ -22,11 +28,40 @@ func main() {
 
 	wd := flag.String("wd", cwd, "workding directory")
 	addr := flag.String("addr", ":8080", "local address to listen on. default to :8080")
+	gitConfig := flag.String("gitconfig", "", "git config applied to clones on top of the global one, e.g. url.<base>.insteadOf for mirrors. default to <wd>/gitconfig")
+	goProxy := flag.String("goproxy", "", "GOPROXY of builds, e.g. off on an air-gapped host. default to the GOPROXY go-runner runs with")
+	goModFlag := flag.String("mod", "", "-mod flag of builds: mod, readonly or vendor")
+	modMirror := flag.String("modmirror", "", "dir of modules in the layout of a module proxy, tried before -goproxy. default to <wd>/modmirror")
+	goCacheMax := flag.Int64("gocache-max-mb", 0, "size in MB the go build cache is collected down to. 0 means no limit")
+	modCacheMax := flag.Int64("gomodcache-max-mb", 0, "size in MB the go module cache is collected down to. 0 means no limit")
+	maxBuilds := flag.Int("max-builds", core.DEFAULT_MAX_BUILDS, "how many builds run at a time. the rest wait in a queue")
+	buildTimeout := flag.Duration("build-timeout", core.DEFAULT_BUILD_TIMEOUT, "how long a build may take")
+	cgroupParent := flag.String("cgroup-parent", "", "cgroup v2 to create the cgroups of the apps in, relative to the cgroup mount. auto for the one of go-runner, which must be delegated to it, e.g. with Delegate=yes in its systemd unit. default to none")
+	sampleInterval := flag.Duration("sample-interval", core.DEFAULT_SAMPLE_INTERVAL, "how often the cpu, memory, fds, threads and I/O of the apps are sampled")
+	appUIDs := flag.String("app-uids", "", "range of uids to run the apps as, one each, e.g. 100000-100999. empty to run them as go-runner unless their config tells a user")
 
 	flag.Parse()
 
+	uidRange, err := core.ParseUIDRange(*appUIDs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	log.Logger = zerolog.New(os.Stdout).With().Timestamp().Logger().Level(zerolog.DebugLevel)
-	runner := web.NewGoRunnerServer(*wd)
+	runner := web.NewGoRunnerServerWithOptions(*wd, core.Options{
+		GitConfig:      *gitConfig,
+		GoProxy:        *goProxy,
+		GoModFlag:      *goModFlag,
+		ModMirror:      *modMirror,
+		BuildCacheMax:  *goCacheMax << 20,
+		ModCacheMax:    *modCacheMax << 20,
+		MaxBuilds:      *maxBuilds,
+		BuildTimeout:   *buildTimeout,
+		SampleInterval: *sampleInterval,
+		CgroupParent:   *cgroupParent,
+		AppUIDs:        uidRange,
+	})
 
 	var stopWg sync.WaitGroup
 